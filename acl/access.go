@@ -0,0 +1,112 @@
+package acl
+
+import (
+	"cmp"
+	pkgPath "path"
+	"slices"
+	"strings"
+)
+
+// Access defines which files and directories are available to a user. Paths are checked
+// against allow and deny prefixes, the most specific prefix wins. If prefixes are equal,
+// deny wins. Paths that don't match any prefix are denied.
+type Access struct {
+	full  bool
+	rules []accessRule
+}
+
+type accessRule struct {
+	prefix string
+	allow  bool
+}
+
+// FullAccess returns access to all paths.
+func FullAccess() Access {
+	return Access{full: true}
+}
+
+func newAccess(allow, deny []string) Access {
+	rules := make([]accessRule, 0, len(allow)+len(deny))
+	for _, prefix := range allow {
+		rules = append(rules, accessRule{prefix: normalizePrefix(prefix), allow: true})
+	}
+	for _, prefix := range deny {
+		rules = append(rules, accessRule{prefix: normalizePrefix(prefix), allow: false})
+	}
+
+	// The most specific rules go first.
+	slices.SortFunc(rules, func(a, b accessRule) int {
+		if v := cmp.Compare(getPrefixDepth(b.prefix), getPrefixDepth(a.prefix)); v != 0 {
+			return v
+		}
+		if a.allow != b.allow {
+			if !a.allow {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.prefix, b.prefix)
+	})
+	return Access{rules: rules}
+}
+
+// IsFull reports whether all paths are allowed.
+func (a Access) IsFull() bool {
+	return a.full
+}
+
+// IsAllowed reports whether the file or the directory with all its content is allowed.
+func (a Access) IsAllowed(path string) bool {
+	if a.full {
+		return true
+	}
+
+	path = normalizePrefix(path)
+	for _, r := range a.rules {
+		if hasPathPrefix(path, r.prefix) {
+			return r.allow
+		}
+	}
+	return false
+}
+
+// IsVisible reports whether the path should be shown to the user: it is either allowed or it is
+// a directory that contains allowed paths. For example, if only "/a/b/" is allowed, "/a/" is visible,
+// but only "b/" is shown in it.
+func (a Access) IsVisible(path string, isDir bool) bool {
+	if a.IsAllowed(path) {
+		return true
+	}
+	if !isDir {
+		return false
+	}
+
+	path = normalizePrefix(path)
+	for _, r := range a.rules {
+		if r.allow && r.prefix != path && hasPathPrefix(r.prefix, path) && a.IsAllowed(r.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePrefix converts "a/b/" and "/a/b" into "/a/b".
+func normalizePrefix(prefix string) string {
+	return pkgPath.Clean("/" + prefix)
+}
+
+// hasPathPrefix reports whether the path is the prefix or is located inside it. Both paths
+// must be normalized.
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "/" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func getPrefixDepth(prefix string) int {
+	if prefix == "/" {
+		return 0
+	}
+	return strings.Count(prefix, "/")
+}
@@ -0,0 +1,133 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccess(t *testing.T) {
+	r := require.New(t)
+
+	access := newAccess(
+		[]string{"/photos/", "/docs/public", "/photos/private/shared/"},
+		[]string{"/photos/private/", "docs/public/secret.txt"},
+	)
+
+	for path, want := range map[string]bool{
+		"/":                             false,
+		"/photos":                       true,
+		"/photos/":                      true,
+		"/photos/2024/01.jpg":           true,
+		"/photos2/01.jpg":               false,
+		"/photos/private/":              false,
+		"/photos/private/01.jpg":        false,
+		"/photos/private/shared/":       true,
+		"/photos/private/shared/01.jpg": true,
+		"/docs/":                        false,
+		"/docs/public/":                 true,
+		"/docs/public/secret.txt":       false,
+		"/docs/public/secret.txt.bak":   true,
+		"/music/":                       false,
+	} {
+		r.Equal(want, access.IsAllowed(path), path)
+	}
+
+	for _, tt := range []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "/", isDir: true, want: true},
+		{path: "/docs/", isDir: true, want: true},
+		{path: "/docs/a.txt", isDir: false, want: false},
+		{path: "/docs/private/", isDir: true, want: false},
+		{path: "/photos/private/", isDir: true, want: true},
+		{path: "/photos/private/other/", isDir: true, want: false},
+		{path: "/music/", isDir: true, want: false},
+	} {
+		r.Equal(tt.want, access.IsVisible(tt.path, tt.isDir), tt.path)
+	}
+
+	t.Run("deny wins", func(t *testing.T) {
+		r := require.New(t)
+
+		access := newAccess([]string{"/a/"}, []string{"/a"})
+		r.False(access.IsAllowed("/a/b"))
+		r.False(access.IsVisible("/", true))
+	})
+
+	t.Run("full access", func(t *testing.T) {
+		r := require.New(t)
+
+		access := FullAccess()
+		r.True(access.IsFull())
+		r.True(access.IsAllowed("/a/b"))
+		r.True(access.IsVisible("/a/", true))
+
+		r.False(newAccess(nil, nil).IsAllowed("/"))
+	})
+}
+
+func TestService(t *testing.T) {
+	r := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "acl.json")
+	err := os.WriteFile(path, []byte(`{
+		"groups": {
+			"family": ["alice", "bob"]
+		},
+		"rules": [
+			{"users": ["*"], "allow": ["/public/"]},
+			{"users": ["@family"], "allow": ["/photos/"], "deny": ["/photos/private/"]},
+			{"users": ["alice"], "allow": ["/photos/private/alice/"]}
+		]
+	}`), 0600)
+	r.NoError(err)
+
+	s, err := NewService(path)
+	r.NoError(err)
+
+	for _, tt := range []struct {
+		username string
+		path     string
+		want     bool
+	}{
+		{username: "", path: "/public/a.txt", want: true},
+		{username: "", path: "/photos/a.jpg", want: false},
+		{username: "eve", path: "/public/a.txt", want: true},
+		{username: "eve", path: "/photos/a.jpg", want: false},
+		{username: "bob", path: "/public/a.txt", want: true},
+		{username: "bob", path: "/photos/a.jpg", want: true},
+		{username: "bob", path: "/photos/private/a.jpg", want: false},
+		{username: "alice", path: "/photos/private/a.jpg", want: false},
+		{username: "alice", path: "/photos/private/alice/a.jpg", want: true},
+		{username: "@family", path: "/photos/a.jpg", want: false},
+	} {
+		r.Equal(tt.want, s.GetAccess(tt.username).IsAllowed(tt.path), "%q: %s", tt.username, tt.path)
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		for _, tt := range []struct {
+			cfg     string
+			wantErr string
+		}{
+			{cfg: `{`, wantErr: "couldn't decode file"},
+			{cfg: `{}`, wantErr: "no rules"},
+			{cfg: `{"rules": [{"allow": ["/"]}]}`, wantErr: "rule #1: no users"},
+			{cfg: `{"rules": [{"users": ["@family"], "allow": ["/"]}]}`, wantErr: `rule #1: unknown group "family"`},
+			{cfg: `{"rules": [{"users": ["*"], "allow": ["/"]}, {"users": ["bob"]}]}`, wantErr: "rule #2: no paths"},
+		} {
+			t.Run(tt.wantErr, func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), "acl.json")
+				err := os.WriteFile(path, []byte(tt.cfg), 0600)
+				require.NoError(t, err)
+
+				_, err = NewService(path)
+				require.ErrorContains(t, err, tt.wantErr)
+			})
+		}
+	})
+}
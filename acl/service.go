@@ -0,0 +1,119 @@
+package acl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Config describes access rules. Users of a rule can be usernames, groups ("@family") or
+// "*" for all users, including anonymous ones. Example:
+//
+//	{
+//	  "groups": {
+//	    "family": ["alice", "bob"]
+//	  },
+//	  "rules": [
+//	    {"users": ["*"], "allow": ["/public/"]},
+//	    {"users": ["@family"], "allow": ["/photos/"], "deny": ["/photos/private/"]},
+//	    {"users": ["alice"], "allow": ["/photos/private/alice/"]}
+//	  ]
+//	}
+type Config struct {
+	Groups map[string][]string `json:"groups"`
+	Rules  []Rule              `json:"rules"`
+}
+
+type Rule struct {
+	Users []string `json:"users"`
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+const allUsers = "*"
+
+// Service provides access rules for users.
+type Service struct {
+	cfg Config
+}
+
+func NewService(path string) (*Service, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read file: %w", err)
+	}
+
+	var cfg Config
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode file: %w", err)
+	}
+	if err := cfg.check(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Service{
+		cfg: cfg,
+	}, nil
+}
+
+func (cfg Config) check() error {
+	if len(cfg.Rules) == 0 {
+		return errors.New("no rules")
+	}
+	for i, rule := range cfg.Rules {
+		if len(rule.Users) == 0 {
+			return fmt.Errorf("rule #%d: no users", i+1)
+		}
+		for _, user := range rule.Users {
+			group, isGroup := strings.CutPrefix(user, "@")
+			if isGroup {
+				if _, ok := cfg.Groups[group]; !ok {
+					return fmt.Errorf("rule #%d: unknown group %q", i+1, group)
+				}
+			}
+			if user == "" {
+				return fmt.Errorf("rule #%d: empty username", i+1)
+			}
+		}
+		if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+			return fmt.Errorf("rule #%d: no paths", i+1)
+		}
+	}
+	return nil
+}
+
+// GetAccess returns access of the user. Empty username is used for anonymous users.
+func (s *Service) GetAccess(username string) Access {
+	var allow, deny []string
+	for _, rule := range s.cfg.Rules {
+		if s.matchUser(rule, username) {
+			allow = append(allow, rule.Allow...)
+			deny = append(deny, rule.Deny...)
+		}
+	}
+	return newAccess(allow, deny)
+}
+
+func (s *Service) matchUser(rule Rule, username string) bool {
+	for _, user := range rule.Users {
+		if user == allUsers {
+			return true
+		}
+		if username == "" {
+			continue
+		}
+
+		if group, ok := strings.CutPrefix(user, "@"); ok {
+			if slices.Contains(s.cfg.Groups[group], username) {
+				return true
+			}
+		} else if user == username {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,392 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	pkgPath "path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/pkg/ranged"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+var (
+	ErrNotFound             = errors.New("not found in archive")
+	ErrUnsupportedArchive   = errors.New("unsupported archive")
+	errUnsupportedZipMethod = errors.New("unsupported compression method")
+)
+
+// maxCachedIndexes is the max number of archives with cached lists of members.
+const maxCachedIndexes = 32
+
+// Member is a file or a directory inside an archive.
+type Member struct {
+	// Path is the path inside the archive without the leading '/'. Paths of directories end with '/'.
+	Path    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+
+	// offset is the offset of the member content. It is set only for TAR archives.
+	offset int64
+}
+
+// archiveIndex contains all members of an archive.
+type archiveIndex struct {
+	members []Member
+}
+
+// CanBrowse reports whether the file is an archive that can be browsed. Only ZIP and
+// uncompressed TAR archives are supported: they can be read without downloading the entire file.
+func CanBrowse(filename string) bool {
+	switch rview.GetFileExt(filename) {
+	case ".zip", ".tar":
+		return true
+	}
+	return false
+}
+
+// SplitPath finds an archive in the path: "/backups/2024.zip/docs/a.txt" -> "/backups/2024.zip"
+// and "docs/a.txt". It returns false if the path doesn't point inside an archive.
+func (s *Service) SplitPath(ctx context.Context, path string) (archiveID rview.FileID, memberPath string, ok bool, err error) {
+	var offset int
+	for {
+		i := strings.Index(path[offset:], "/")
+		if i == -1 {
+			return rview.FileID{}, "", false, nil
+		}
+		end := offset + i
+
+		if end > 0 && CanBrowse(path[:end]) {
+			archivePath := path[:end]
+
+			// Make sure it's a file, directories can have any names.
+			dir := misc.EnsureSuffix(pkgPath.Dir(archivePath), "/")
+			info, err := s.rclone.GetDirInfo(ctx, dir, "", "")
+			if err != nil {
+				if rclone.IsNotFoundError(err) {
+					return rview.FileID{}, "", false, nil
+				}
+				return rview.FileID{}, "", false, fmt.Errorf("couldn't get info of dir %q: %w", dir, err)
+			}
+			for _, entry := range info.Entries {
+				if entry.URL == archivePath && !entry.IsDir {
+					id := rview.NewFileID(entry.URL, entry.ModTime, entry.Size)
+					return id, path[end+1:], true, nil
+				}
+			}
+		}
+		offset = end + 1
+	}
+}
+
+// GetDirInfo returns the content of the directory inside the archive. Paths of the returned entries
+// include the archive path, for example "/backups/2024.zip/docs/a.txt".
+func (s *Service) GetDirInfo(ctx context.Context, archiveID rview.FileID, dir string, sort, order string) (*rclone.DirInfo, error) {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	index, err := s.getIndex(ctx, archiveID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &rclone.DirInfo{
+		Dir:         archiveID.GetPath() + "/" + dir,
+		Breadcrumbs: []rclone.DirBreadcrumb{{Text: "/"}},
+	}
+	for part := range strings.SplitSeq(strings.Trim(info.Dir, "/"), "/") {
+		info.Breadcrumbs = append(info.Breadcrumbs, rclone.DirBreadcrumb{Text: part})
+	}
+
+	var (
+		found bool
+		// Archives don't always contain entries for directories, so we add them manually.
+		dirIndexes = make(map[string]int)
+	)
+	for _, member := range index.members {
+		rest, ok := strings.CutPrefix(member.Path, dir)
+		if !ok {
+			continue
+		}
+		found = true
+		if rest == "" {
+			// The directory itself.
+			continue
+		}
+
+		leaf, _, isDir := strings.Cut(rest, "/")
+		if isDir {
+			i, ok := dirIndexes[leaf]
+			if !ok {
+				i = len(info.Entries)
+				dirIndexes[leaf] = i
+
+				info.Entries = append(info.Entries, rclone.DirEntry{
+					URL:   info.Dir + leaf + "/",
+					Leaf:  leaf + "/",
+					IsDir: true,
+				})
+			}
+			if rest == leaf+"/" {
+				// An explicit entry of the directory.
+				info.Entries[i].ModTime = member.ModTime.Unix()
+			}
+			continue
+		}
+
+		info.Entries = append(info.Entries, rclone.DirEntry{
+			URL:     info.Dir + leaf,
+			Leaf:    leaf,
+			Size:    member.Size,
+			ModTime: member.ModTime.Unix(),
+		})
+	}
+	if !found && dir != "" {
+		return nil, fmt.Errorf("%w: dir %q", ErrNotFound, dir)
+	}
+
+	rclone.SortDirInfo(info, sort, order)
+
+	return info, nil
+}
+
+// OpenMember opens the file inside the archive. The returned reader implements [io.Seeker]
+// if the file is not compressed.
+func (s *Service) OpenMember(ctx context.Context, archiveID rview.FileID, path string) (io.ReadCloser, Member, error) {
+	index, err := s.getIndex(ctx, archiveID)
+	if err != nil {
+		return nil, Member{}, err
+	}
+	i := slices.IndexFunc(index.members, func(m Member) bool { return m.Path == path && !m.IsDir })
+	if i == -1 {
+		return nil, Member{}, fmt.Errorf("%w: file %q", ErrNotFound, path)
+	}
+	member := index.members[i]
+
+	reader := ranged.NewReader(ctx, archiveID, s.rclone.RequestFileRange)
+
+	if archiveID.GetExt() == ".tar" {
+		return newSectionReader(reader, member.offset, member.Size), member, nil
+	}
+
+	// Members of ZIP archives are not indexed, so we have to read the central directory again.
+	// It is usually cheap because the central directory is small and read with a single request.
+	zr, err := zip.NewReader(reader, archiveID.GetSize())
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return nil, Member{}, fmt.Errorf("couldn't read zip archive: %w", err)
+	}
+	i = slices.IndexFunc(zr.File, func(f *zip.File) bool {
+		path, ok := cleanMemberPath(f.Name)
+		return ok && path == member.Path
+	})
+	if i == -1 {
+		return nil, Member{}, fmt.Errorf("%w: file %q", ErrNotFound, path)
+	}
+	f := zr.File[i]
+
+	offset, err := f.DataOffset()
+	if err != nil {
+		return nil, Member{}, fmt.Errorf("couldn't get data offset: %w", err)
+	}
+	section := newSectionReader(reader, offset, int64(f.CompressedSize64)) //nolint:gosec
+
+	switch f.Method {
+	case zip.Store:
+		return section, member, nil
+	case zip.Deflate:
+		return readCloser{flate.NewReader(section), section}, member, nil
+	default:
+		return nil, Member{}, fmt.Errorf("%w: %d", errUnsupportedZipMethod, f.Method)
+	}
+}
+
+// getIndex returns the cached index of the archive or reads a new one.
+func (s *Service) getIndex(ctx context.Context, id rview.FileID) (*archiveIndex, error) {
+	s.indexesMu.Lock()
+	index, ok := s.indexes[id]
+	s.indexesMu.Unlock()
+	if ok {
+		return index, nil
+	}
+
+	reader := ranged.NewReader(ctx, id, s.rclone.RequestFileRange)
+	defer reader.Close()
+
+	var err error
+	switch id.GetExt() {
+	case ".zip":
+		index, err = readZipIndex(reader)
+	case ".tar":
+		index, err = readTarIndex(reader)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedArchive, id.GetName())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.indexesMu.Lock()
+	defer s.indexesMu.Unlock()
+
+	if _, ok := s.indexes[id]; !ok {
+		if len(s.indexOrder) >= maxCachedIndexes {
+			delete(s.indexes, s.indexOrder[0])
+			s.indexOrder = s.indexOrder[1:]
+		}
+		s.indexes[id] = index
+		s.indexOrder = append(s.indexOrder, id)
+	}
+	return index, nil
+}
+
+func readZipIndex(r *ranged.Reader) (*archiveIndex, error) {
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return nil, fmt.Errorf("couldn't read zip archive: %w", err)
+	}
+
+	index := &archiveIndex{}
+	for _, f := range zr.File {
+		path, ok := cleanMemberPath(f.Name)
+		if !ok {
+			continue
+		}
+		index.members = append(index.members, Member{
+			Path:    path,
+			IsDir:   strings.HasSuffix(path, "/"),
+			Size:    int64(f.UncompressedSize64), //nolint:gosec
+			ModTime: f.Modified,
+		})
+	}
+	return index, nil
+}
+
+func readTarIndex(r *ranged.Reader) (*archiveIndex, error) {
+	// Use io.SectionReader because it reads with ReadAt: small headers of adjacent files are
+	// loaded with a single request. Content of files is skipped with Seek.
+	sr := io.NewSectionReader(r, 0, r.Size())
+	tr := tar.NewReader(sr)
+
+	index := &archiveIndex{}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read tar header: %w", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			// Skip links and special files.
+			continue
+		}
+
+		name := header.Name
+		if header.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+		path, ok := cleanMemberPath(name)
+		if !ok {
+			continue
+		}
+
+		// The reader is positioned at the start of the file content.
+		offset, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get offset: %w", err)
+		}
+
+		index.members = append(index.members, Member{
+			Path:    path,
+			IsDir:   header.Typeflag == tar.TypeDir,
+			Size:    header.Size,
+			ModTime: header.ModTime,
+			offset:  offset,
+		})
+	}
+	return index, nil
+}
+
+// cleanMemberPath cleans the path of an archive member. Archives can contain paths like
+// "../a.txt" or "/etc/passwd", they are treated as relative to the archive root.
+func cleanMemberPath(name string) (string, bool) {
+	isDir := strings.HasSuffix(name, "/")
+
+	path := strings.TrimPrefix(pkgPath.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+	if path == "" {
+		return "", false
+	}
+	if isDir {
+		path += "/"
+	}
+	return path, true
+}
+
+// sectionReader reads a section of a remote file. Unlike [io.SectionReader], it reads
+// sequentially with a single response, see [ranged.Reader.Read].
+type sectionReader struct {
+	r      *ranged.Reader
+	offset int64
+	size   int64
+	pos    int64
+}
+
+func newSectionReader(r *ranged.Reader, offset, size int64) *sectionReader {
+	return &sectionReader{r: r, offset: offset, size: size}
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	// Seek is free if the offset is not changed.
+	_, err := s.r.Seek(s.offset+s.pos, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	p = p[:min(int64(len(p)), s.size-s.pos)]
+	n, err := s.r.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *sectionReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *sectionReader) Close() error {
+	return s.r.Close()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,240 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/stretchr/testify/require"
+)
+
+var testModTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestZip(t *testing.T) string {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct {
+		name    string
+		content string
+		method  uint16
+	}{
+		{"docs/a.txt", strings.Repeat("a", 1000), zip.Deflate},
+		{"docs/sub/b.txt", "b", zip.Deflate},
+		{"img.jpg", "jpg", zip.Store},
+		{"empty/", "", zip.Store},
+		{"../evil.txt", "evil", zip.Store},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: f.method, Modified: testModTime})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(f.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.String()
+}
+
+func newTestTar(t *testing.T) string {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "docs/"},
+		{Typeflag: tar.TypeReg, Name: "docs/a.txt", Size: 200 << 10},
+		{Typeflag: tar.TypeSymlink, Name: "docs/link", Linkname: "a.txt"},
+		{Typeflag: tar.TypeReg, Name: "docs/b.txt", Size: 5},
+	} {
+		h.ModTime = testModTime
+		require.NoError(t, tw.WriteHeader(h))
+		_, err := tw.Write(bytes.Repeat([]byte{'x'}, int(h.Size)))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.String()
+}
+
+func TestCanBrowse(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	for filename, want := range map[string]bool{
+		"a.zip":    true,
+		"a.ZIP":    true,
+		"a.tar":    true,
+		"a.tar.gz": false,
+		"a.7z":     false,
+		"a.txt":    false,
+	} {
+		r.Equal(want, CanBrowse(filename), filename)
+	}
+}
+
+func TestService_Browse(t *testing.T) {
+	t.Parallel()
+
+	rc := &testRclone{
+		files: map[string]string{
+			"/backups/a.zip":          newTestZip(t),
+			"/backups/b.tar":          newTestTar(t),
+			"/backups/dir.zip/c.txt":  "c",
+			"/backups/private/d.txt":  "d",
+			"/backups/nested/e.zip/x": "not an archive",
+		},
+	}
+	s := NewService(rc, 1)
+
+	zipID := rview.NewFileID("/backups/a.zip", 1700000000, int64(len(rc.files["/backups/a.zip"])))
+	tarID := rview.NewFileID("/backups/b.tar", 1700000000, int64(len(rc.files["/backups/b.tar"])))
+
+	t.Run("split path", func(t *testing.T) {
+		r := require.New(t)
+
+		for path, want := range map[string]struct {
+			id         rview.FileID
+			memberPath string
+		}{
+			"/backups/a.zip/docs/a.txt": {zipID, "docs/a.txt"},
+			"/backups/a.zip/docs/":      {zipID, "docs/"},
+			"/backups/a.zip/":           {zipID, ""},
+			"/backups/b.tar/docs/":      {tarID, "docs/"},
+			"/backups/a.zip":            {},
+			"/backups/dir.zip/c.txt":    {},
+			"/backups/nested/e.zip/x":   {},
+			"/backups/missing.zip/x":    {},
+			"/photos/a.jpg":             {},
+		} {
+			id, memberPath, ok, err := s.SplitPath(t.Context(), path)
+			r.NoError(err, path)
+			r.Equal(want.id != rview.FileID{}, ok, path)
+			r.Equal(want.id, id, path)
+			r.Equal(want.memberPath, memberPath, path)
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		r := require.New(t)
+
+		info, err := s.GetDirInfo(t.Context(), zipID, "", "", "")
+		r.NoError(err)
+		r.Equal("/backups/a.zip/", info.Dir)
+		r.Equal(
+			[]rclone.DirBreadcrumb{{Text: "/"}, {Text: "backups"}, {Text: "a.zip"}},
+			info.Breadcrumbs,
+		)
+		r.Equal(
+			[]rclone.DirEntry{
+				{URL: "/backups/a.zip/docs/", Leaf: "docs/", IsDir: true},
+				{URL: "/backups/a.zip/empty/", Leaf: "empty/", IsDir: true, ModTime: testModTime.Unix()},
+				{URL: "/backups/a.zip/evil.txt", Leaf: "evil.txt", Size: 4, ModTime: testModTime.Unix()},
+				{URL: "/backups/a.zip/img.jpg", Leaf: "img.jpg", Size: 3, ModTime: testModTime.Unix()},
+			},
+			info.Entries,
+		)
+
+		info, err = s.GetDirInfo(t.Context(), zipID, "docs", "size", "desc")
+		r.NoError(err)
+		r.Equal("/backups/a.zip/docs/", info.Dir)
+		r.Equal(
+			[]rclone.DirEntry{
+				{URL: "/backups/a.zip/docs/a.txt", Leaf: "a.txt", Size: 1000, ModTime: testModTime.Unix()},
+				{URL: "/backups/a.zip/docs/sub/", Leaf: "sub/", IsDir: true},
+			},
+			info.Entries,
+		)
+
+		info, err = s.GetDirInfo(t.Context(), zipID, "empty/", "", "")
+		r.NoError(err)
+		r.Empty(info.Entries)
+
+		_, err = s.GetDirInfo(t.Context(), zipID, "missing/", "", "")
+		r.ErrorIs(err, ErrNotFound)
+
+		// Compressed files.
+		file, member, err := s.OpenMember(t.Context(), zipID, "docs/a.txt")
+		r.NoError(err)
+		r.Equal(int64(1000), member.Size)
+		r.Equal(testModTime, member.ModTime.UTC())
+		_, isSeeker := file.(io.Seeker)
+		r.False(isSeeker)
+		content, err := io.ReadAll(file)
+		r.NoError(err)
+		r.Equal(strings.Repeat("a", 1000), string(content))
+		file.Close()
+
+		// Uncompressed files.
+		file, _, err = s.OpenMember(t.Context(), zipID, "img.jpg")
+		r.NoError(err)
+		seeker, isSeeker := file.(io.ReadSeeker)
+		r.True(isSeeker)
+		_, err = seeker.Seek(1, io.SeekStart)
+		r.NoError(err)
+		content, err = io.ReadAll(file)
+		r.NoError(err)
+		r.Equal("pg", string(content))
+		file.Close()
+
+		_, _, err = s.OpenMember(t.Context(), zipID, "docs/")
+		r.ErrorIs(err, ErrNotFound)
+		_, _, err = s.OpenMember(t.Context(), zipID, "../evil.txt")
+		r.ErrorIs(err, ErrNotFound)
+	})
+
+	t.Run("tar", func(t *testing.T) {
+		r := require.New(t)
+
+		before := rc.rangeRequests.Load()
+
+		info, err := s.GetDirInfo(t.Context(), tarID, "docs/", "", "")
+		r.NoError(err)
+		r.Equal(
+			[]rclone.DirEntry{
+				{URL: "/backups/b.tar/docs/a.txt", Leaf: "a.txt", Size: 200 << 10, ModTime: testModTime.Unix()},
+				{URL: "/backups/b.tar/docs/b.txt", Leaf: "b.txt", Size: 5, ModTime: testModTime.Unix()},
+			},
+			info.Entries,
+		)
+		// Content of large files must be skipped.
+		r.Equal(int32(2), rc.rangeRequests.Load()-before)
+
+		// The index is cached.
+		_, err = s.GetDirInfo(t.Context(), tarID, "", "", "")
+		r.NoError(err)
+		r.Equal(int32(2), rc.rangeRequests.Load()-before)
+
+		file, member, err := s.OpenMember(t.Context(), tarID, "docs/b.txt")
+		r.NoError(err)
+		r.Equal(int64(5), member.Size)
+		content, err := io.ReadAll(file)
+		r.NoError(err)
+		r.Equal("xxxxx", string(content))
+		file.Close()
+	})
+}
+
+func TestCleanMemberPath(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	for name, want := range map[string]string{
+		"a.txt":          "a.txt",
+		"dir/":           "dir/",
+		"dir/a.txt":      "dir/a.txt",
+		"/etc/passwd":    "etc/passwd",
+		"../../a.txt":    "a.txt",
+		`dir\win.txt`:    "dir/win.txt",
+		"./dir/../b.txt": "b.txt",
+		"":               "",
+		"/":              "",
+		"../":            "",
+	} {
+		path, ok := cleanMemberPath(name)
+		r.Equal(want != "", ok, name)
+		r.Equal(want, path, name)
+	}
+}
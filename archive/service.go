@@ -0,0 +1,280 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported archive format")
+	ErrEntryNotFound     = errors.New("entry not found")
+)
+
+type Format string
+
+const (
+	FormatZip Format = "zip"
+	FormatTar Format = "tar"
+)
+
+type Rclone interface {
+	GetDirInfo(ctx context.Context, path string, sort, order string) (*rclone.DirInfo, error)
+	OpenFile(ctx context.Context, id rview.FileID) (io.ReadCloser, error)
+	RequestFileRange(ctx context.Context, id rview.FileID, rangeStart, rangeEnd int) (io.ReadCloser, error)
+}
+
+// Service writes directories as ZIP or TAR archives and allows to browse existing archives.
+//
+// Archives are streamed: files are read one by one via [Rclone.OpenFile] and written directly
+// to the passed writer, nothing is buffered on disk. Existing archives are read with range
+// requests, so only the necessary parts are downloaded.
+type Service struct {
+	rclone Rclone
+
+	// filesSem limits the number of files read by all archives at the same time. Every archive
+	// reads only one file at a time, so a single archive can't occupy all rclone connections.
+	filesSem chan struct{}
+
+	indexesMu  sync.Mutex
+	indexes    map[rview.FileID]*archiveIndex
+	indexOrder []rview.FileID // for eviction, the oldest first
+}
+
+func NewService(rclone Rclone, maxConcurrentFiles int) *Service {
+	return &Service{
+		rclone:   rclone,
+		filesSem: make(chan struct{}, max(1, maxConcurrentFiles)),
+		indexes:  make(map[rview.FileID]*archiveIndex),
+	}
+}
+
+type Options struct {
+	Format Format
+	// Dir is the directory to archive. Paths in the archive are relative to it.
+	Dir string
+	// Names are names of the directory entries to archive, for example "a.jpg" or "photos".
+	// All entries are archived if it is empty.
+	Names []string
+	// Filter reports whether a file or a directory should be archived, optional.
+	Filter func(path string, isDir bool) bool
+}
+
+// Write writes the archive to w. Nothing is written if the directory or the requested entries
+// can't be found, so callers can still respond with an error.
+func (s *Service) Write(ctx context.Context, w io.Writer, opts Options) error {
+	var aw archiveWriter
+	switch opts.Format {
+	case FormatZip:
+		aw = zipWriter{zip.NewWriter(w)}
+	case FormatTar:
+		aw = tarWriter{tar.NewWriter(w)}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedFormat, opts.Format)
+	}
+
+	opts.Dir = misc.EnsureSuffix(misc.EnsurePrefix(opts.Dir, "/"), "/")
+
+	info, err := s.rclone.GetDirInfo(ctx, opts.Dir, "", "")
+	if err != nil {
+		return fmt.Errorf("couldn't get info of dir %q: %w", opts.Dir, err)
+	}
+
+	entries := info.Entries
+	if len(opts.Names) > 0 {
+		entries = make([]rclone.DirEntry, 0, len(opts.Names))
+		for _, name := range opts.Names {
+			i := slices.IndexFunc(info.Entries, func(entry rclone.DirEntry) bool {
+				return strings.TrimSuffix(entry.Leaf, "/") == name
+			})
+			if i == -1 || !opts.isAllowed(info.Entries[i]) {
+				return fmt.Errorf("%w: %q", ErrEntryNotFound, name)
+			}
+			entries = append(entries, info.Entries[i])
+		}
+	}
+
+	err = s.writeEntries(ctx, aw, opts, entries)
+	if err != nil {
+		return err
+	}
+	err = aw.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't finish archive: %w", err)
+	}
+	return nil
+}
+
+func (opts Options) isAllowed(entry rclone.DirEntry) bool {
+	return opts.Filter == nil || opts.Filter(entry.URL, entry.IsDir)
+}
+
+// writeEntries writes files and directories. Directories are written recursively.
+func (s *Service) writeEntries(ctx context.Context, aw archiveWriter, opts Options, entries []rclone.DirEntry) error {
+	for _, entry := range entries {
+		if !opts.isAllowed(entry) {
+			continue
+		}
+
+		name := strings.TrimPrefix(entry.URL, opts.Dir)
+		modTime := time.Unix(entry.ModTime, 0)
+
+		if !entry.IsDir {
+			err := s.writeFile(ctx, aw, name, entry)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := aw.AddDir(name, modTime)
+		if err != nil {
+			return fmt.Errorf("couldn't add dir %q: %w", entry.URL, err)
+		}
+
+		info, err := s.rclone.GetDirInfo(ctx, entry.URL, "", "")
+		if err != nil {
+			return fmt.Errorf("couldn't get info of dir %q: %w", entry.URL, err)
+		}
+		err = s.writeEntries(ctx, aw, opts, info.Entries)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) writeFile(ctx context.Context, aw archiveWriter, name string, entry rclone.DirEntry) error {
+	select {
+	case s.filesSem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.filesSem }()
+
+	rc, err := s.rclone.OpenFile(ctx, rview.NewFileID(entry.URL, entry.ModTime, entry.Size))
+	if err != nil {
+		return fmt.Errorf("couldn't open file %q: %w", entry.URL, err)
+	}
+	defer rc.Close()
+
+	err = aw.AddFile(name, time.Unix(entry.ModTime, 0), entry.Size, rc)
+	if err != nil {
+		return fmt.Errorf("couldn't add file %q: %w", entry.URL, err)
+	}
+	return nil
+}
+
+type archiveWriter interface {
+	// AddDir adds a directory. The name must end with '/'.
+	AddDir(name string, modTime time.Time) error
+	AddFile(name string, modTime time.Time, size int64, r io.Reader) error
+	Close() error
+}
+
+// zipWriter writes ZIP archives. Zip64 extensions are used automatically when an archive
+// has too many files or files are too large.
+type zipWriter struct {
+	w *zip.Writer
+}
+
+func (w zipWriter) AddDir(name string, modTime time.Time) error {
+	_, err := w.w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Modified: modTime,
+		Method:   zip.Store,
+	})
+	return err
+}
+
+func (w zipWriter) AddFile(name string, modTime time.Time, _ int64, r io.Reader) error {
+	method := zip.Deflate
+	if isCompressed(name) {
+		method = zip.Store
+	}
+
+	fw, err := w.w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Modified: modTime,
+		Method:   method,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (w zipWriter) Close() error {
+	return w.w.Close()
+}
+
+type tarWriter struct {
+	w *tar.Writer
+}
+
+func (w tarWriter) AddDir(name string, modTime time.Time) error {
+	return w.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+}
+
+func (w tarWriter) AddFile(name string, modTime time.Time, size int64, r io.Reader) error {
+	err := w.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(w.w, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		// Otherwise, the error would be returned only on the next header.
+		return fmt.Errorf("file size mismatch: expected %d bytes, got %d", size, n)
+	}
+	return nil
+}
+
+func (w tarWriter) Close() error {
+	return w.w.Close()
+}
+
+// isCompressed reports whether the file is likely already compressed. There is no point
+// in compressing such files again: it takes a lot of CPU time and doesn't reduce the size.
+func isCompressed(filename string) bool {
+	ext := rview.GetFileExt(filename)
+	switch rview.GetFileType(ext) {
+	case rview.FileTypeImage, rview.FileTypeRawImage, rview.FileTypeVideo:
+		return ext != ".bmp"
+	case rview.FileTypeAudio:
+		return ext != ".wav" && ext != ".aif"
+	case rview.FileTypeDocument:
+		// PDFs are usually compressed, other documents are ZIP archives.
+		return true
+	}
+	switch ext {
+	case ".zip", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".7z", ".rar":
+		return true
+	}
+	return false
+}
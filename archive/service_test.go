@@ -0,0 +1,281 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/stretchr/testify/require"
+)
+
+type testRclone struct {
+	// files contains content of files by path. Dirs are derived from paths.
+	files map[string]string
+
+	openFiles    atomic.Int32
+	maxOpenFiles atomic.Int32
+	// openCh, if not nil, is used to block OpenFile.
+	openCh chan struct{}
+
+	rangeRequests atomic.Int32
+}
+
+func (r *testRclone) GetDirInfo(_ context.Context, dir string, _, _ string) (*rclone.DirInfo, error) {
+	info := &rclone.DirInfo{Dir: dir}
+	seen := make(map[string]bool)
+	for path, content := range r.files {
+		rest, ok := strings.CutPrefix(path, dir)
+		if !ok {
+			continue
+		}
+		leaf, _, isDir := strings.Cut(rest, "/")
+		if isDir {
+			leaf += "/"
+		}
+		if seen[leaf] {
+			continue
+		}
+		seen[leaf] = true
+
+		entry := rclone.DirEntry{URL: dir + leaf, Leaf: leaf, IsDir: isDir, ModTime: 1700000000}
+		if !isDir {
+			entry.Size = int64(len(content))
+		}
+		info.Entries = append(info.Entries, entry)
+	}
+	if len(info.Entries) == 0 {
+		return nil, &rclone.RcloneError{StatusCode: 404}
+	}
+	return info, nil
+}
+
+func (r *testRclone) OpenFile(_ context.Context, id rview.FileID) (io.ReadCloser, error) {
+	content, ok := r.files[id.GetPath()]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+
+	n := r.openFiles.Add(1)
+	for {
+		maxOpenFiles := r.maxOpenFiles.Load()
+		if n <= maxOpenFiles || r.maxOpenFiles.CompareAndSwap(maxOpenFiles, n) {
+			break
+		}
+	}
+	if r.openCh != nil {
+		<-r.openCh
+	}
+	return testFile{Reader: strings.NewReader(content), r: r}, nil
+}
+
+func (r *testRclone) RequestFileRange(_ context.Context, id rview.FileID, rangeStart, rangeEnd int) (io.ReadCloser, error) {
+	content, ok := r.files[id.GetPath()]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	r.rangeRequests.Add(1)
+	return io.NopCloser(strings.NewReader(content[rangeStart : rangeEnd+1])), nil
+}
+
+type testFile struct {
+	io.Reader
+	r *testRclone
+}
+
+func (f testFile) Close() error {
+	f.r.openFiles.Add(-1)
+	return nil
+}
+
+func TestService(t *testing.T) {
+	t.Parallel()
+
+	rc := &testRclone{
+		files: map[string]string{
+			"/photos/a.jpg":          "jpg",
+			"/photos/b.txt":          strings.Repeat("text ", 100),
+			"/photos/2024/c.mp4":     "mp4",
+			"/photos/2024/d.txt":     "d",
+			"/photos/private/e.txt":  "e",
+			"/documents/report.docx": "docx",
+		},
+	}
+	s := NewService(rc, 2)
+
+	writeArchive := func(opts Options) ([]byte, error) {
+		var buf bytes.Buffer
+		err := s.Write(t.Context(), &buf, opts)
+		return buf.Bytes(), err
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		r := require.New(t)
+
+		data, err := writeArchive(Options{Format: FormatZip, Dir: "/photos"})
+		r.NoError(err)
+
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		r.NoError(err)
+
+		files := make(map[string]string)
+		for _, f := range zr.File {
+			fr, err := f.Open()
+			r.NoError(err)
+			content, err := io.ReadAll(fr)
+			r.NoError(err)
+			fr.Close()
+
+			files[f.Name] = string(content)
+
+			// Already compressed files must be stored as is.
+			wantMethod := zip.Deflate
+			if strings.HasSuffix(f.Name, "/") || strings.HasSuffix(f.Name, ".jpg") || strings.HasSuffix(f.Name, ".mp4") {
+				wantMethod = zip.Store
+			}
+			r.Equal(wantMethod, f.Method, f.Name)
+			r.Equal(int64(1700000000), f.Modified.Unix(), f.Name)
+		}
+		r.Equal(
+			map[string]string{
+				"a.jpg":         "jpg",
+				"b.txt":         strings.Repeat("text ", 100),
+				"2024/":         "",
+				"2024/c.mp4":    "mp4",
+				"2024/d.txt":    "d",
+				"private/":      "",
+				"private/e.txt": "e",
+			},
+			files,
+		)
+	})
+
+	t.Run("tar", func(t *testing.T) {
+		r := require.New(t)
+
+		data, err := writeArchive(Options{
+			Format: FormatTar,
+			Dir:    "/photos/",
+			Names:  []string{"2024", "b.txt"},
+			Filter: func(path string, _ bool) bool { return path != "/photos/2024/d.txt" },
+		})
+		r.NoError(err)
+
+		files := make(map[string]string)
+		tr := tar.NewReader(bytes.NewReader(data))
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			r.NoError(err)
+
+			content, err := io.ReadAll(tr)
+			r.NoError(err)
+			files[header.Name] = string(content)
+		}
+		r.Equal(
+			map[string]string{
+				"2024/":      "",
+				"2024/c.mp4": "mp4",
+				"b.txt":      strings.Repeat("text ", 100),
+			},
+			files,
+		)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		r := require.New(t)
+
+		// Nothing must be written on errors that can be returned to users.
+		data, err := writeArchive(Options{Format: FormatZip, Dir: "/videos/"})
+		r.True(rclone.IsNotFoundError(err))
+		r.Empty(data)
+
+		data, err = writeArchive(Options{Format: FormatZip, Dir: "/photos/", Names: []string{"a.jpg", "x.jpg"}})
+		r.ErrorIs(err, ErrEntryNotFound)
+		r.Empty(data)
+
+		data, err = writeArchive(Options{
+			Format: FormatZip,
+			Dir:    "/photos/",
+			Names:  []string{"private"},
+			Filter: func(path string, _ bool) bool { return !strings.HasPrefix(path, "/photos/private/") },
+		})
+		r.ErrorIs(err, ErrEntryNotFound)
+		r.Empty(data)
+
+		data, err = writeArchive(Options{Format: "rar", Dir: "/photos/"})
+		r.ErrorIs(err, ErrUnsupportedFormat)
+		r.Empty(data)
+	})
+}
+
+func TestService_Concurrency(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	files := make(map[string]string)
+	for _, path := range []string{"/a/1.txt", "/a/2.txt", "/b/1.txt", "/b/2.txt", "/c/1.txt", "/c/2.txt"} {
+		files[path] = path
+	}
+	rc := &testRclone{files: files, openCh: make(chan struct{})}
+	s := NewService(rc, 2)
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, 3)
+	)
+	for _, dir := range []string{"/a/", "/b/", "/c/"} {
+		wg.Go(func() {
+			errs <- s.Write(t.Context(), io.Discard, Options{Format: FormatZip, Dir: dir})
+		})
+	}
+
+	// Only 2 files can be opened at the same time, the third archive has to wait.
+	r.Eventually(func() bool { return rc.openFiles.Load() == 2 }, time.Second, time.Millisecond)
+	for range files {
+		rc.openCh <- struct{}{}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		r.NoError(err)
+	}
+	r.Equal(int32(2), rc.maxOpenFiles.Load())
+	r.Zero(rc.openFiles.Load())
+}
+
+func TestIsCompressed(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	for filename, want := range map[string]bool{
+		"a.jpg":    true,
+		"a.JPEG":   true,
+		"a.bmp":    false,
+		"a.mkv":    true,
+		"a.flac":   true,
+		"a.wav":    false,
+		"a.pdf":    true,
+		"a.zip":    true,
+		"a.tgz":    true,
+		"a.txt":    false,
+		"a.go":     false,
+		"Makefile": false,
+	} {
+		r.Equal(want, isCompressed(filename), filename)
+	}
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var jwtEncoding = base64.RawURLEncoding
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// parsedJWT is a JWT with not verified signature.
+type parsedJWT struct {
+	header       jwtHeader
+	payload      []byte
+	signingInput string
+	signature    []byte
+}
+
+func parseJWT(token string) (parsedJWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return parsedJWT{}, errors.New("token must have 3 parts")
+	}
+
+	rawHeader, err := jwtEncoding.DecodeString(parts[0])
+	if err != nil {
+		return parsedJWT{}, fmt.Errorf("couldn't decode header: %w", err)
+	}
+	var header jwtHeader
+	err = json.Unmarshal(rawHeader, &header)
+	if err != nil {
+		return parsedJWT{}, fmt.Errorf("couldn't unmarshal header: %w", err)
+	}
+
+	payload, err := jwtEncoding.DecodeString(parts[1])
+	if err != nil {
+		return parsedJWT{}, fmt.Errorf("couldn't decode payload: %w", err)
+	}
+	signature, err := jwtEncoding.DecodeString(parts[2])
+	if err != nil {
+		return parsedJWT{}, fmt.Errorf("couldn't decode signature: %w", err)
+	}
+
+	return parsedJWT{
+		header:       header,
+		payload:      payload,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}, nil
+}
+
+// verify checks the signature with the key. Only RS256 and ES256 are supported: RS256 must
+// be supported by all OpenID Connect providers, and ES256 is the most popular alternative.
+func (t parsedJWT) verify(key jwk) error {
+	if key.Alg != "" && key.Alg != t.header.Alg {
+		return fmt.Errorf("algorithm %q doesn't match algorithm of the key %q", t.header.Alg, key.Alg)
+	}
+
+	digest := sha256.Sum256([]byte(t.signingInput))
+
+	switch t.header.Alg {
+	case "RS256":
+		if key.rsaKey == nil {
+			return errors.New("key is not an RSA key")
+		}
+		return rsa.VerifyPKCS1v15(key.rsaKey, crypto.SHA256, digest[:], t.signature)
+
+	case "ES256":
+		if key.ecdsaKey == nil {
+			return errors.New("key is not an ECDSA key")
+		}
+		if len(t.signature) != 64 {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(t.signature[:32])
+		s := new(big.Int).SetBytes(t.signature[32:])
+		if !ecdsa.Verify(key.ecdsaKey, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported algorithm %q", t.header.Alg)
+	}
+}
+
+// jwk is a JSON Web Key, see RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	rsaKey   *rsa.PublicKey
+	ecdsaKey *ecdsa.PublicKey
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// parseJWKS parses a key set. Unsupported keys and keys that are not used for signatures are skipped.
+func parseJWKS(data []byte) ([]jwk, error) {
+	var set jwks
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal key set: %w", err)
+	}
+
+	keys := make([]jwk, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		switch key.Kty {
+		case "RSA":
+			n, err := jwtEncoding.DecodeString(key.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid modulus: %w", key.Kid, err)
+			}
+			e, err := jwtEncoding.DecodeString(key.E)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid exponent: %w", key.Kid, err)
+			}
+			exp := new(big.Int).SetBytes(e)
+			if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+				return nil, fmt.Errorf("key %q: invalid exponent", key.Kid)
+			}
+			key.rsaKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+
+		case "EC":
+			if key.Crv != "P-256" {
+				continue
+			}
+			x, err := jwtEncoding.DecodeString(key.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid x: %w", key.Kid, err)
+			}
+			y, err := jwtEncoding.DecodeString(key.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid y: %w", key.Kid, err)
+			}
+			// Use the uncompressed form to check that the point is on the curve.
+			point := append([]byte{4}, append(leftPad(x, 32), leftPad(y, 32)...)...)
+			pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid point: %w", key.Kid, err)
+			}
+			key.ecdsaKey = pub
+
+		default:
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
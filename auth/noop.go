@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var ErrNoopAuthService = errors.New("noop auth service")
+
+// NoopService is used when authentication is disabled. All requests are
+// made on behalf of an anonymous user.
+type NoopService struct{}
+
+func NewNoopService() *NoopService {
+	return &NoopService{}
+}
+
+func (NoopService) Login(string, string) (string, Session, error) {
+	return "", Session{}, ErrNoopAuthService
+}
+
+func (NoopService) GetSession(string) (Session, error) {
+	return Session{}, nil
+}
+
+func (NoopService) Logout(string) error {
+	return nil
+}
+
+func (NoopService) StartOIDCLogin(context.Context, string) (string, string, error) {
+	return "", "", ErrNoopAuthService
+}
+
+func (NoopService) FinishOIDCLogin(context.Context, string, string, string) (string, Session, string, error) {
+	return "", Session{}, "", ErrNoopAuthService
+}
+
+func (NoopService) GetProxySession(string, http.Header) (Session, error) {
+	return Session{}, ErrNoopAuthService
+}
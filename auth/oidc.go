@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCOptions contains parameters of an OpenID Connect client.
+type OIDCOptions struct {
+	// IssuerURL is used to discover endpoints of the provider: "<issuer>/.well-known/openid-configuration".
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the url of the callback endpoint, it must be registered in the provider.
+	RedirectURL string
+	// UsernameClaim is a claim of ID tokens that is used as a username, for example
+	// "preferred_username" or "email".
+	UsernameClaim string
+}
+
+// OIDCProvider performs the OpenID Connect authorization code flow with PKCE. Endpoints of
+// the provider are discovered on the first use, so the provider doesn't have to be available
+// on startup.
+type OIDCProvider struct {
+	opts   OIDCOptions
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      []jwk
+	// keysUpdatedAt is used to limit the rate of key set requests: tokens with unknown
+	// key ids shouldn't lead to a request for every token.
+	keysUpdatedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// idTokenClaims contains claims that must be verified, see OpenID Connect Core 1.0, Section 3.1.3.7.
+type idTokenClaims struct {
+	Iss   string   `json:"iss"`
+	Aud   audience `json:"aud"`
+	Exp   int64    `json:"exp"`
+	Nonce string   `json:"nonce"`
+	// Claims contains all claims, including custom ones.
+	Claims map[string]any `json:"-"`
+}
+
+// audience can be either a string or an array of strings.
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*aud = multiple
+	return nil
+}
+
+func NewOIDCProvider(opts OIDCOptions) (*OIDCProvider, error) {
+	if opts.IssuerURL == "" {
+		return nil, errors.New("issuer url can't be empty")
+	}
+	if opts.ClientID == "" {
+		return nil, errors.New("client id can't be empty")
+	}
+	if opts.RedirectURL == "" {
+		return nil, errors.New("redirect url can't be empty")
+	}
+	if opts.UsernameClaim == "" {
+		return nil, errors.New("username claim can't be empty")
+	}
+
+	return &OIDCProvider{
+		opts: opts,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// AuthCodeURL returns the url of the provider's login page.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.opts.ClientID)
+	query.Set("redirect_uri", p.opts.RedirectURL)
+	query.Set("scope", "openid profile email")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", getCodeChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func getCodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return jwtEncoding.EncodeToString(hash[:])
+}
+
+// Exchange exchanges the authorization code for an ID token, verifies it and returns
+// the username.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.opts.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.opts.ClientSecret == "" {
+		// Public client.
+		form.Set("client_id", p.opts.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("couldn't prepare request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.opts.ClientSecret != "" {
+		// "client_secret_basic" requires form encoding of the credentials, see RFC 6749, Section 2.3.1.
+		req.SetBasicAuth(url.QueryEscape(p.opts.ClientID), url.QueryEscape(p.opts.ClientSecret))
+	}
+
+	var tokenResp oidcTokenResponse
+	err = p.doRequest(req, &tokenResp)
+	if err != nil {
+		if tokenResp.Error != "" {
+			return "", fmt.Errorf("token request failed: %s: %s", tokenResp.Error, tokenResp.ErrorDescription)
+		}
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	if tokenResp.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, tokenResp.IDToken, discovery.Issuer)
+	if err != nil {
+		return "", fmt.Errorf("invalid id token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return "", errors.New("invalid id token: nonce mismatch")
+	}
+
+	username, _ := claims.Claims[p.opts.UsernameClaim].(string)
+	if username == "" {
+		return "", fmt.Errorf("id token has no claim %q", p.opts.UsernameClaim)
+	}
+	return username, nil
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, rawToken, issuer string) (idTokenClaims, error) {
+	token, err := parseJWT(rawToken)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+
+	key, err := p.getKey(ctx, token.header.Kid)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+	err = token.verify(key)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("couldn't verify signature: %w", err)
+	}
+
+	var claims idTokenClaims
+	err = json.Unmarshal(token.payload, &claims)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("couldn't unmarshal claims: %w", err)
+	}
+	err = json.Unmarshal(token.payload, &claims.Claims)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("couldn't unmarshal claims: %w", err)
+	}
+
+	// Allow small clock skew.
+	const leeway = time.Minute
+
+	switch {
+	case claims.Iss != issuer:
+		return idTokenClaims{}, fmt.Errorf("unexpected issuer %q", claims.Iss)
+	case !slices.Contains(claims.Aud, p.opts.ClientID):
+		return idTokenClaims{}, fmt.Errorf("token is not issued for client %q", p.opts.ClientID)
+	case time.Now().Add(-leeway).After(time.Unix(claims.Exp, 0)):
+		return idTokenClaims{}, errors.New("token is expired")
+	}
+	return claims, nil
+}
+
+// getKey returns the key with the passed id. The key set is requested again if the key is
+// not found, because providers rotate keys.
+func (p *OIDCProvider) getKey(ctx context.Context, kid string) (jwk, error) {
+	const minKeysUpdateInterval = time.Minute
+
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return jwk{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	findKey := func() (jwk, bool) {
+		for _, key := range p.keys {
+			// Key id is optional if there is only one key.
+			if key.Kid == kid || (kid == "" && len(p.keys) == 1) {
+				return key, true
+			}
+		}
+		return jwk{}, false
+	}
+
+	if key, ok := findKey(); ok {
+		return key, nil
+	}
+	if time.Since(p.keysUpdatedAt) < minKeysUpdateInterval {
+		return jwk{}, fmt.Errorf("unknown key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JwksURI, nil)
+	if err != nil {
+		return jwk{}, fmt.Errorf("couldn't prepare request: %w", err)
+	}
+	var rawKeys json.RawMessage
+	err = p.doRequest(req, &rawKeys)
+	if err != nil {
+		return jwk{}, fmt.Errorf("couldn't get key set: %w", err)
+	}
+	keys, err := parseJWKS(rawKeys)
+	if err != nil {
+		return jwk{}, fmt.Errorf("couldn't parse key set: %w", err)
+	}
+	p.keys = keys
+	p.keysUpdatedAt = time.Now()
+
+	if key, ok := findKey(); ok {
+		return key, nil
+	}
+	return jwk{}, fmt.Errorf("unknown key %q", kid)
+}
+
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return *p.discovery, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.opts.IssuerURL, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return oidcDiscovery{}, fmt.Errorf("couldn't prepare discovery request: %w", err)
+	}
+	var discovery oidcDiscovery
+	err = p.doRequest(req, &discovery)
+	if err != nil {
+		return oidcDiscovery{}, fmt.Errorf("couldn't discover provider endpoints: %w", err)
+	}
+
+	// See OpenID Connect Discovery 1.0, Section 4.3.
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(p.opts.IssuerURL, "/") {
+		return oidcDiscovery{}, fmt.Errorf("issuer %q doesn't match issuer url %q", discovery.Issuer, p.opts.IssuerURL)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return oidcDiscovery{}, errors.New("provider metadata misses required endpoints")
+	}
+
+	p.discovery = &discovery
+	return discovery, nil
+}
+
+// doRequest sends the request and decodes the JSON response. The response is decoded even
+// for unsuccessful requests because they can contain error details.
+func (p *OIDCProvider) doRequest(req *http.Request, dst any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("couldn't read response body: %w", err)
+	}
+	decodeErr := json.Unmarshal(body, dst)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("couldn't decode response: %w", decodeErr)
+	}
+	return nil
+}
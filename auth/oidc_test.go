@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider. Users are logged in immediately
+// after redirect to the authorization endpoint.
+type mockOIDCProvider struct {
+	server *httptest.Server
+
+	clientID     string
+	clientSecret string
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	kid   string
+	codes map[string]mockAuthRequest
+	// modifyClaims can be used to issue invalid tokens.
+	modifyClaims func(claims map[string]any)
+}
+
+type mockAuthRequest struct {
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	p := &mockOIDCProvider{
+		clientID:     "rview",
+		clientSecret: "secret",
+		codes:        make(map[string]mockAuthRequest),
+	}
+	p.rotateKey(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("client_id") != p.clientID || query.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		code := rand.Text()
+		p.mu.Lock()
+		p.codes[code] = mockAuthRequest{
+			nonce:         query.Get("nonce"),
+			codeChallenge: query.Get("code_challenge"),
+			redirectURI:   query.Get("redirect_uri"),
+		}
+		p.mu.Unlock()
+
+		redirectURL, _ := url.Parse(query.Get("redirect_uri"))
+		redirectURL.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		writeError := func(code string) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": code})
+		}
+
+		if id, secret, _ := r.BasicAuth(); id != p.clientID || secret != p.clientSecret {
+			writeError("invalid_client")
+			return
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		code := r.PostFormValue("code")
+		req, ok := p.codes[code]
+		delete(p.codes, code)
+		if !ok || req.redirectURI != r.PostFormValue("redirect_uri") {
+			writeError("invalid_grant")
+			return
+		}
+		hash := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if jwtEncoding.EncodeToString(hash[:]) != req.codeChallenge {
+			writeError("invalid_grant")
+			return
+		}
+
+		claims := map[string]any{
+			"iss":                p.server.URL,
+			"sub":                "1",
+			"aud":                p.clientID,
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              req.nonce,
+			"preferred_username": "alice",
+		}
+		if p.modifyClaims != nil {
+			p.modifyClaims(claims)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     p.signToken(t, claims),
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, _ *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": p.kid,
+					"alg": "RS256",
+					"use": "sig",
+					"n":   jwtEncoding.EncodeToString(p.key.N.Bytes()),
+					"e":   jwtEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+				},
+			},
+		})
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *mockOIDCProvider) rotateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.key = key
+	p.kid = rand.Text()
+}
+
+// signToken must be called with locked mutex.
+func (p *mockOIDCProvider) signToken(t *testing.T, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": p.kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signingInput := jwtEncoding.EncodeToString(header) + "." + jwtEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	return signingInput + "." + jwtEncoding.EncodeToString(signature)
+}
+
+func TestService_OIDC(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+
+	oidc, err := NewOIDCProvider(OIDCOptions{
+		IssuerURL:     mock.server.URL,
+		ClientID:      mock.clientID,
+		ClientSecret:  mock.clientSecret,
+		RedirectURL:   "http://localhost:8080/auth/oidc/callback",
+		UsernameClaim: "preferred_username",
+	})
+	require.NoError(t, err)
+
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	require.NoError(t, err)
+
+	s, err := NewService(Options{SessionTTL: time.Hour, OIDC: oidc}, dirRoot)
+	require.NoError(t, err)
+
+	// authorize emulates the redirect to the provider and returns the authorization code
+	// and the state passed to the callback.
+	authorize := func(t *testing.T, authURL string) (code, state string) {
+		client := &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, authURL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+
+		location, err := resp.Location()
+		require.NoError(t, err)
+		require.Equal(t, "/auth/oidc/callback", location.Path)
+
+		return location.Query().Get("code"), location.Query().Get("state")
+	}
+
+	t.Run("success", func(t *testing.T) {
+		r := require.New(t)
+
+		authURL, stateToken, err := s.StartOIDCLogin(t.Context(), "/ui/photos/")
+		r.NoError(err)
+
+		code, state := authorize(t, authURL)
+
+		token, session, next, err := s.FinishOIDCLogin(t.Context(), stateToken, state, code)
+		r.NoError(err)
+		r.Equal("/ui/photos/", next)
+		r.Equal("alice", session.Username)
+		r.Equal(MethodOIDC, session.Method)
+
+		gotSession, err := s.GetSession(token)
+		r.NoError(err)
+		r.Equal(session, gotSession)
+
+		// The code can be used only once.
+		_, _, _, err = s.FinishOIDCLogin(t.Context(), stateToken, state, code)
+		r.ErrorContains(err, "invalid_grant")
+	})
+
+	t.Run("invalid state", func(t *testing.T) {
+		r := require.New(t)
+
+		authURL, stateToken, err := s.StartOIDCLogin(t.Context(), "/")
+		r.NoError(err)
+		code, state := authorize(t, authURL)
+
+		_, _, _, err = s.FinishOIDCLogin(t.Context(), stateToken, state+"x", code)
+		r.ErrorContains(err, "state mismatch")
+
+		_, _, _, err = s.FinishOIDCLogin(t.Context(), "x"+stateToken, state, code)
+		r.ErrorContains(err, "invalid state token")
+	})
+
+	t.Run("invalid id token", func(t *testing.T) {
+		for _, tt := range []struct {
+			name         string
+			modifyClaims func(map[string]any)
+			wantErr      string
+		}{
+			{
+				name:         "nonce",
+				modifyClaims: func(claims map[string]any) { claims["nonce"] = "abc" },
+				wantErr:      "nonce mismatch",
+			},
+			{
+				name:         "audience",
+				modifyClaims: func(claims map[string]any) { claims["aud"] = []string{"other-client"} },
+				wantErr:      `token is not issued for client "rview"`,
+			},
+			{
+				name:         "issuer",
+				modifyClaims: func(claims map[string]any) { claims["iss"] = "http://example.com" },
+				wantErr:      `unexpected issuer "http://example.com"`,
+			},
+			{
+				name:         "expired",
+				modifyClaims: func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+				wantErr:      "token is expired",
+			},
+			{
+				name:         "no username",
+				modifyClaims: func(claims map[string]any) { delete(claims, "preferred_username") },
+				wantErr:      `id token has no claim "preferred_username"`,
+			},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				r := require.New(t)
+
+				mock.mu.Lock()
+				mock.modifyClaims = tt.modifyClaims
+				mock.mu.Unlock()
+				defer func() {
+					mock.mu.Lock()
+					mock.modifyClaims = nil
+					mock.mu.Unlock()
+				}()
+
+				authURL, stateToken, err := s.StartOIDCLogin(t.Context(), "/")
+				r.NoError(err)
+				code, state := authorize(t, authURL)
+
+				_, _, _, err = s.FinishOIDCLogin(t.Context(), stateToken, state, code)
+				r.ErrorContains(err, tt.wantErr)
+			})
+		}
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		r := require.New(t)
+
+		mock.rotateKey(t)
+
+		authURL, stateToken, err := s.StartOIDCLogin(t.Context(), "/")
+		r.NoError(err)
+		code, state := authorize(t, authURL)
+
+		// Keys were requested recently, so the new key is not requested yet.
+		_, _, _, err = s.FinishOIDCLogin(t.Context(), stateToken, state, code)
+		r.ErrorContains(err, "unknown key")
+
+		oidc.mu.Lock()
+		oidc.keysUpdatedAt = time.Time{}
+		oidc.mu.Unlock()
+
+		authURL, stateToken, err = s.StartOIDCLogin(t.Context(), "/")
+		r.NoError(err)
+		code, state = authorize(t, authURL)
+
+		_, session, _, err := s.FinishOIDCLogin(t.Context(), stateToken, state, code)
+		r.NoError(err)
+		r.Equal("alice", session.Username)
+	})
+
+	t.Run("forged signature", func(t *testing.T) {
+		r := require.New(t)
+
+		mock.mu.Lock()
+		kid := mock.kid
+		mock.mu.Unlock()
+
+		// Sign a token with another key, but with the same key id.
+		mock.rotateKey(t)
+		mock.mu.Lock()
+		mock.kid = kid
+		mock.mu.Unlock()
+
+		authURL, stateToken, err := s.StartOIDCLogin(t.Context(), "/")
+		r.NoError(err)
+		code, state := authorize(t, authURL)
+
+		_, _, _, err = s.FinishOIDCLogin(t.Context(), stateToken, state, code)
+		r.ErrorContains(err, "couldn't verify signature")
+	})
+}
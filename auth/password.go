@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The following password hash formats are supported:
+//
+//   - argon2id in the PHC format: "$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>".
+//     It is used by [HashPassword].
+//   - bcrypt: "$2a$<cost>$...", "$2b$<cost>$...", "$2y$<cost>$...". Such hashes can be generated
+//     with "htpasswd -nbB <username> <password>".
+//   - pbkdf2: "pbkdf2-sha256$<iterations>$<salt>$<key>".
+//
+// Salts and keys of argon2id and pbkdf2 hashes are encoded with unpadded base64.
+const (
+	argon2idAlgorithm = "argon2id"
+	bcryptAlgorithm   = "bcrypt"
+	pbkdf2Algorithm   = "pbkdf2-sha256"
+
+	// Parameters of argon2id recommended by OWASP:
+	// https://cheatsheetseries.owasp.org/cheatsheets/Password_Storage_Cheat_Sheet.html#argon2id
+	defaultArgon2Memory  = 19 * 1024 // in KiB
+	defaultArgon2Time    = 2
+	defaultArgon2Threads = 1
+
+	minIterations = 1000
+
+	saltSize = 16
+	keySize  = 32
+)
+
+var passwordEncoding = base64.RawStdEncoding
+
+type passwordHash interface {
+	algorithm() string
+	// cost is the cost of checking a password. Costs of different algorithms can't be compared.
+	cost() int
+	check(password string) bool
+	// newDummy returns a hash of a random password with the same algorithm and parameters.
+	newDummy() (passwordHash, error)
+}
+
+// HashPassword returns an argon2id hash of the password that can be used in the users file.
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password can't be empty")
+	}
+	hash, err := newArgon2idHash(password, defaultArgon2Memory, defaultArgon2Time, defaultArgon2Threads)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// CheckPassword reports whether the password matches the hash. The hash can have any
+// supported format, see [HashPassword].
+func CheckPassword(hash, password string) bool {
+	parsedHash, err := parsePasswordHash(hash)
+	if err != nil {
+		return false
+	}
+	return parsedHash.check(password)
+}
+
+func parsePasswordHash(hash string) (passwordHash, error) {
+	switch {
+	case strings.HasPrefix(hash, "$"+argon2idAlgorithm+"$"):
+		return parseArgon2idHash(hash)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return parseBcryptHash(hash)
+	case strings.HasPrefix(hash, pbkdf2Algorithm+"$"):
+		return parsePBKDF2Hash(hash)
+	default:
+		algorithm, _, _ := strings.Cut(strings.TrimPrefix(hash, "$"), "$")
+		return nil, fmt.Errorf(
+			"unsupported algorithm %q, only %q, %q and %q are supported",
+			algorithm, argon2idAlgorithm, bcryptAlgorithm, pbkdf2Algorithm,
+		)
+	}
+}
+
+// selectDummyHash returns a hash that should be checked for unknown users. It has the algorithm
+// used by most users, and it must be as slow as the slowest real hash of this algorithm.
+func selectDummyHash(hashes map[string]passwordHash) (passwordHash, error) {
+	var (
+		counts  = make(map[string]int)
+		slowest = make(map[string]passwordHash)
+	)
+	for _, hash := range hashes {
+		alg := hash.algorithm()
+		counts[alg]++
+		if h, ok := slowest[alg]; !ok || h.cost() < hash.cost() {
+			slowest[alg] = hash
+		}
+	}
+
+	var alg string
+	for a, count := range counts {
+		if count > counts[alg] || (count == counts[alg] && a < alg) {
+			alg = a
+		}
+	}
+	if alg == "" {
+		return nil, errors.New("no hashes")
+	}
+	return slowest[alg].newDummy()
+}
+
+func generateSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+// argon2id
+
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func newArgon2idHash(password string, memory, time uint32, threads uint8) (argon2idHash, error) {
+	salt, err := generateSalt()
+	if err != nil {
+		return argon2idHash{}, err
+	}
+	return argon2idHash{
+		memory:  memory,
+		time:    time,
+		threads: threads,
+		salt:    salt,
+		key:     argon2.IDKey([]byte(password), salt, time, memory, threads, keySize),
+	}, nil
+}
+
+func parseArgon2idHash(hash string) (res argon2idHash, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return argon2idHash{}, errors.New(`hash must have format "$argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>"`)
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return argon2idHash{}, fmt.Errorf("invalid version: %w", err)
+	}
+	if version != argon2.Version {
+		return argon2idHash{}, fmt.Errorf("unsupported version %d, only %d is supported", version, argon2.Version)
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &res.memory, &res.time, &res.threads)
+	if err != nil {
+		return argon2idHash{}, fmt.Errorf("invalid parameters: %w", err)
+	}
+	if res.memory == 0 || res.time == 0 || res.threads == 0 {
+		return argon2idHash{}, errors.New("parameters must be positive")
+	}
+	res.salt, err = passwordEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2idHash{}, fmt.Errorf("invalid salt: %w", err)
+	}
+	res.key, err = passwordEncoding.DecodeString(parts[5])
+	if err != nil {
+		return argon2idHash{}, fmt.Errorf("invalid key: %w", err)
+	}
+	if len(res.key) == 0 {
+		return argon2idHash{}, errors.New("key can't be empty")
+	}
+	return res, nil
+}
+
+func (h argon2idHash) String() string {
+	return fmt.Sprintf(
+		"$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idAlgorithm, argon2.Version, h.memory, h.time, h.threads,
+		passwordEncoding.EncodeToString(h.salt), passwordEncoding.EncodeToString(h.key),
+	)
+}
+
+func (argon2idHash) algorithm() string {
+	return argon2idAlgorithm
+}
+
+func (h argon2idHash) cost() int {
+	return int(h.memory) * int(h.time)
+}
+
+func (h argon2idHash) check(password string) bool {
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key))) //nolint:gosec
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+func (h argon2idHash) newDummy() (passwordHash, error) {
+	return newArgon2idHash(rand.Text(), h.memory, h.time, h.threads)
+}
+
+// bcrypt
+
+type bcryptHash []byte
+
+func parseBcryptHash(hash string) (bcryptHash, error) {
+	// Cost checks the format of the hash.
+	_, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+	return bcryptHash(hash), nil
+}
+
+func (bcryptHash) algorithm() string {
+	return bcryptAlgorithm
+}
+
+func (h bcryptHash) cost() int {
+	cost, _ := bcrypt.Cost(h)
+	return cost
+}
+
+func (h bcryptHash) check(password string) bool {
+	return bcrypt.CompareHashAndPassword(h, []byte(password)) == nil
+}
+
+func (h bcryptHash) newDummy() (passwordHash, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(rand.Text()), h.cost())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't generate bcrypt hash: %w", err)
+	}
+	return bcryptHash(hash), nil
+}
+
+// pbkdf2
+
+type pbkdf2Hash struct {
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+func newPBKDF2Hash(password string, iterations int) (pbkdf2Hash, error) {
+	salt, err := generateSalt()
+	if err != nil {
+		return pbkdf2Hash{}, err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, keySize)
+	if err != nil {
+		return pbkdf2Hash{}, fmt.Errorf("couldn't derive key: %w", err)
+	}
+	return pbkdf2Hash{
+		iterations: iterations,
+		salt:       salt,
+		key:        key,
+	}, nil
+}
+
+func parsePBKDF2Hash(hash string) (res pbkdf2Hash, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 {
+		return pbkdf2Hash{}, errors.New(`hash must have format "pbkdf2-sha256$<iterations>$<salt>$<key>"`)
+	}
+
+	res.iterations, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return pbkdf2Hash{}, fmt.Errorf("invalid number of iterations: %w", err)
+	}
+	if res.iterations < minIterations {
+		return pbkdf2Hash{}, fmt.Errorf("number of iterations must be >= %d", minIterations)
+	}
+	res.salt, err = passwordEncoding.DecodeString(parts[2])
+	if err != nil {
+		return pbkdf2Hash{}, fmt.Errorf("invalid salt: %w", err)
+	}
+	res.key, err = passwordEncoding.DecodeString(parts[3])
+	if err != nil {
+		return pbkdf2Hash{}, fmt.Errorf("invalid key: %w", err)
+	}
+	if len(res.key) == 0 {
+		return pbkdf2Hash{}, errors.New("key can't be empty")
+	}
+	return res, nil
+}
+
+func (h pbkdf2Hash) String() string {
+	return fmt.Sprintf(
+		"%s$%d$%s$%s",
+		pbkdf2Algorithm, h.iterations, passwordEncoding.EncodeToString(h.salt), passwordEncoding.EncodeToString(h.key),
+	)
+}
+
+func (pbkdf2Hash) algorithm() string {
+	return pbkdf2Algorithm
+}
+
+func (h pbkdf2Hash) cost() int {
+	return h.iterations
+}
+
+func (h pbkdf2Hash) check(password string) bool {
+	key, err := pbkdf2.Key(sha256.New, password, h.salt, h.iterations, len(h.key))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+func (h pbkdf2Hash) newDummy() (passwordHash, error) {
+	return newPBKDF2Hash(rand.Text(), h.iterations)
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	r := require.New(t)
+
+	rawHash, err := HashPassword("qwerty")
+	r.NoError(err)
+	r.True(strings.HasPrefix(rawHash, "$argon2id$v=19$m=19456,t=2,p=1$"), rawHash)
+
+	r.True(CheckPassword(rawHash, "qwerty"))
+	r.False(CheckPassword(rawHash, "qwerty1"))
+	r.False(CheckPassword(rawHash, ""))
+
+	otherRawHash, err := HashPassword("qwerty")
+	r.NoError(err)
+	r.NotEqual(rawHash, otherRawHash, "salt must be random")
+
+	_, err = HashPassword("")
+	r.Error(err)
+}
+
+func TestPasswordHash(t *testing.T) {
+	argon2idHash, err := newArgon2idHash("qwerty", 64, 1, 1)
+	require.NoError(t, err)
+	rawBcryptHash, err := bcrypt.GenerateFromPassword([]byte("qwerty"), bcrypt.MinCost)
+	require.NoError(t, err)
+	pbkdf2Hash, err := newPBKDF2Hash("qwerty", minIterations)
+	require.NoError(t, err)
+
+	for alg, rawHash := range map[string]string{
+		argon2idAlgorithm: argon2idHash.String(),
+		bcryptAlgorithm:   string(rawBcryptHash),
+		pbkdf2Algorithm:   pbkdf2Hash.String(),
+		// htpasswd generates bcrypt hashes with prefix "$2y$".
+		bcryptAlgorithm + "-2y":      "$2y$05$e9BbYMxuZ.P6q2zUyCSsU.iNSO7xRQgttUhL3GiZ/qLTP2dw8r7ba",
+		argon2idAlgorithm + "-fixed": "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$6CjckbqW6CPzT/LDpuDs6jM4Z4brkUOMRolOOMgKtN4",
+	} {
+		t.Run(alg, func(t *testing.T) {
+			r := require.New(t)
+
+			hash, err := parsePasswordHash(rawHash)
+			r.NoError(err)
+			r.True(strings.HasPrefix(alg, hash.algorithm()))
+			r.True(hash.check("qwerty"))
+			r.False(hash.check("qwerty1"))
+			r.False(hash.check(""))
+
+			dummy, err := hash.newDummy()
+			r.NoError(err)
+			r.Equal(hash.algorithm(), dummy.algorithm())
+			r.Equal(hash.cost(), dummy.cost())
+			r.False(dummy.check("qwerty"))
+		})
+	}
+}
+
+func TestSelectDummyHash(t *testing.T) {
+	r := require.New(t)
+
+	var (
+		hashes = make(map[string]passwordHash)
+		err    error
+	)
+	hashes["alice"], err = newPBKDF2Hash("qwerty", minIterations)
+	r.NoError(err)
+	hashes["bob"], err = newPBKDF2Hash("qwerty", 2*minIterations)
+	r.NoError(err)
+	hashes["eve"], err = newArgon2idHash("qwerty", 64, 1, 1)
+	r.NoError(err)
+
+	// The algorithm used by most users and the slowest parameters.
+	dummy, err := selectDummyHash(hashes)
+	r.NoError(err)
+	r.Equal(pbkdf2Algorithm, dummy.algorithm())
+	r.Equal(2*minIterations, dummy.cost())
+}
+
+func TestParsePasswordHash(t *testing.T) {
+	for _, tt := range []struct {
+		hash    string
+		wantErr string
+	}{
+		{hash: "pbkdf2-sha256$1000$c2FsdA$a2V5", wantErr: ""},
+		{hash: "pbkdf2-sha256$1000$c2FsdA", wantErr: "hash must have format"},
+		{hash: "bcrypt$1000$c2FsdA$a2V5", wantErr: `unsupported algorithm "bcrypt"`},
+		{hash: "$1$abcdefgh$abcdefghijklmnopqrstuv", wantErr: `unsupported algorithm "1"`},
+		{hash: "pbkdf2-sha256$1k$c2FsdA$a2V5", wantErr: "invalid number of iterations"},
+		{hash: "pbkdf2-sha256$10$c2FsdA$a2V5", wantErr: "number of iterations must be >= 1000"},
+		{hash: "pbkdf2-sha256$1000$!!!$a2V5", wantErr: "invalid salt"},
+		{hash: "pbkdf2-sha256$1000$c2FsdA$", wantErr: "key can't be empty"},
+		//
+		{hash: "$2y$10$abcdefghijklmnopqrstuv", wantErr: "invalid bcrypt hash"},
+		{hash: "$2b$04$abcdefghijklmnopqrstuuXIhbB/pVDxDnCU4Ix/5yvWkaSzGWKge", wantErr: ""},
+		//
+		{hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$a2V5", wantErr: ""},
+		{hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", wantErr: "hash must have format"},
+		{hash: "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5", wantErr: "unsupported version 16"},
+		{hash: "$argon2id$v=19$m=64,p=1$c2FsdA$a2V5", wantErr: "invalid parameters"},
+		{hash: "$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5", wantErr: "parameters must be positive"},
+		{hash: "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5", wantErr: "invalid salt"},
+		{hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$", wantErr: "key can't be empty"},
+		{hash: "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5", wantErr: `unsupported algorithm "argon2i"`},
+	} {
+		t.Run(tt.hash, func(t *testing.T) {
+			_, err := parsePasswordHash(tt.hash)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
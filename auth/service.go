@@ -0,0 +1,460 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/signed"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidSession     = errors.New("invalid session")
+	ErrMethodDisabled     = errors.New("authentication method is disabled")
+	ErrUntrustedProxy     = errors.New("request is not made through a trusted proxy")
+)
+
+// Method is an authentication method.
+type Method string
+
+const (
+	MethodPassword Method = "password"
+	MethodOIDC     Method = "oidc"
+	MethodProxy    Method = "proxy"
+)
+
+type Session struct {
+	Username  string
+	Method    Method
+	ExpiresAt time.Time
+	// CSRFToken must be passed with all requests that can change state.
+	CSRFToken string
+}
+
+type Options struct {
+	// UsersFile is a path to the file with users for password authentication, optional.
+	UsersFile  string
+	SessionTTL time.Duration
+
+	// OIDC is used for authentication with an OpenID Connect provider, optional.
+	OIDC *OIDCProvider
+
+	// ProxyHeader is a header with a username set by a reverse proxy, optional. The header
+	// is trusted only for requests made by TrustedProxies.
+	ProxyHeader    string
+	TrustedProxies []netip.Prefix
+}
+
+// Service authenticates users with passwords, with an OpenID Connect provider, or by a header
+// set by a trusted reverse proxy. Sessions are stored in cookies signed with a secret key.
+// The key is saved on disk, so sessions survive restarts. Sessions revoked on logout are
+// saved on disk until they expire.
+type Service struct {
+	users map[string]passwordHash
+	// dummyHash is used to check passwords of unknown users. It makes it harder
+	// to find out whether a user exists by measuring response time.
+	dummyHash passwordHash
+
+	oidc *OIDCProvider
+
+	proxyHeader    string
+	trustedProxies []netip.Prefix
+
+	signer     *signed.Signer
+	sessionTTL time.Duration
+
+	// dir is used to save revoked sessions. It is nil in tests.
+	dir *os.Root
+
+	mu sync.Mutex
+	// revokedSessions contains nonces of revoked sessions and their expiration times.
+	revokedSessions map[string]int64
+}
+
+type sessionData struct {
+	Username string `json:"username"`
+	// Method is empty for sessions created before the introduction of other methods.
+	Method    Method `json:"method,omitempty"`
+	ExpiresAt int64  `json:"expires_at"`
+	Nonce     string `json:"nonce"`
+}
+
+// oidcStateData is stored in a cookie during the OpenID Connect authorization flow.
+type oidcStateData struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	Next         string `json:"next"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+// NewService prepares a new service. Users for password authentication are loaded from
+// the file. Every line of the file must have format "<username>:<password hash>", see
+// [HashPassword]. Empty lines and lines starting with '#' are ignored.
+func NewService(opts Options, dirRoot *os.Root) (*Service, error) {
+	var users map[string]passwordHash
+	if opts.UsersFile != "" {
+		var err error
+		users, err = loadUsers(opts.UsersFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load users: %w", err)
+		}
+	}
+	if opts.ProxyHeader != "" && len(opts.TrustedProxies) == 0 {
+		return nil, errors.New("trusted proxies must be set when proxy header is used")
+	}
+
+	err := dirRoot.Mkdir("auth", 0700)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("couldn't create 'auth' subdirectory: %w", err)
+	}
+	authDirRoot, err := dirRoot.OpenRoot("auth")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open root: %w", err)
+	}
+
+	secret, err := signed.LoadOrCreateSecret(authDirRoot, "secret.key")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't prepare secret key: %w", err)
+	}
+
+	revokedSessions := make(map[string]int64)
+	data, err := authDirRoot.ReadFile(revokedSessionsFilename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// No sessions have been revoked yet.
+	case err != nil:
+		return nil, fmt.Errorf("couldn't read revoked sessions: %w", err)
+	default:
+		err = json.Unmarshal(data, &revokedSessions)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't unmarshal revoked sessions from %q: %w", revokedSessionsFilename, err)
+		}
+	}
+
+	s, err := newService(opts, users, signed.NewSigner(secret))
+	if err != nil {
+		return nil, err
+	}
+	s.dir = authDirRoot
+	s.revokedSessions = revokedSessions
+	return s, nil
+}
+
+func newService(opts Options, users map[string]passwordHash, signer *signed.Signer) (*Service, error) {
+	s := &Service{
+		users:           users,
+		oidc:            opts.OIDC,
+		proxyHeader:     opts.ProxyHeader,
+		trustedProxies:  opts.TrustedProxies,
+		signer:          signer,
+		sessionTTL:      opts.SessionTTL,
+		revokedSessions: make(map[string]int64),
+	}
+
+	if len(users) > 0 {
+		var err error
+		s.dummyHash, err = selectDummyHash(users)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't prepare dummy hash: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+func loadUsers(path string) (map[string]passwordHash, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseUsers(data)
+}
+
+func parseUsers(data []byte) (map[string]passwordHash, error) {
+	users := make(map[string]passwordHash)
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(text, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf(`line %d: line must have format "<username>:<password hash>"`, line)
+		}
+		if _, ok := users[username]; ok {
+			return nil, fmt.Errorf("line %d: duplicate user %q", line, username)
+		}
+		parsedHash, err := parsePasswordHash(hash)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid password hash of user %q: %w", line, username, err)
+		}
+		users[username] = parsedHash
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		return nil, errors.New("no users")
+	}
+	return users, nil
+}
+
+// Login checks the credentials and returns a new session. The returned token should be stored
+// in a cookie and passed to [Service.GetSession].
+func (s *Service) Login(username, password string) (token string, _ Session, _ error) {
+	if len(s.users) == 0 {
+		return "", Session{}, ErrMethodDisabled
+	}
+
+	hash, ok := s.users[username]
+	if !ok {
+		s.dummyHash.check(password)
+		return "", Session{}, ErrInvalidCredentials
+	}
+	if !hash.check(password) {
+		return "", Session{}, ErrInvalidCredentials
+	}
+
+	return s.createSession(username, MethodPassword)
+}
+
+// StartOIDCLogin returns the url of the OpenID Connect provider the user should be redirected
+// to. The returned state token should be stored in a cookie and passed to [Service.FinishOIDCLogin].
+// next is the url to redirect the user to after login.
+func (s *Service) StartOIDCLogin(ctx context.Context, next string) (authURL, stateToken string, _ error) {
+	if s.oidc == nil {
+		return "", "", ErrMethodDisabled
+	}
+
+	// The state is used to protect against CSRF, and the nonce - against replay attacks.
+	// The code verifier is used for PKCE.
+	state, nonce, codeVerifier := generateRandomString(), generateRandomString(), generateRandomString()
+
+	authURL, err := s.oidc.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't prepare auth url: %w", err)
+	}
+
+	const stateTTL = 10 * time.Minute
+
+	stateToken, err = s.signer.EncodeToken("oidc-state", oidcStateData{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		Next:         next,
+		ExpiresAt:    time.Now().Add(stateTTL).Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, stateToken, nil
+}
+
+// FinishOIDCLogin checks the state, exchanges the authorization code for an ID token and returns
+// a new session and the url passed to [Service.StartOIDCLogin].
+func (s *Service) FinishOIDCLogin(
+	ctx context.Context, stateToken, state, code string,
+) (token string, _ Session, next string, _ error) {
+
+	if s.oidc == nil {
+		return "", Session{}, "", ErrMethodDisabled
+	}
+
+	var data oidcStateData
+	err := s.signer.DecodeToken("oidc-state", stateToken, &data)
+	if err != nil {
+		return "", Session{}, "", errors.New("invalid state token")
+	}
+	if time.Now().After(time.Unix(data.ExpiresAt, 0)) {
+		return "", Session{}, "", errors.New("state token is expired")
+	}
+	if subtle.ConstantTimeCompare([]byte(data.State), []byte(state)) != 1 {
+		return "", Session{}, "", errors.New("state mismatch")
+	}
+
+	username, err := s.oidc.Exchange(ctx, code, data.CodeVerifier, data.Nonce)
+	if err != nil {
+		return "", Session{}, "", err
+	}
+
+	token, session, err := s.createSession(username, MethodOIDC)
+	if err != nil {
+		return "", Session{}, "", err
+	}
+	return token, session, data.Next, nil
+}
+
+// GetProxySession returns the session of a user authenticated by a trusted reverse proxy.
+// remoteAddr is the address of the client, see [http.Request.RemoteAddr].
+func (s *Service) GetProxySession(remoteAddr string, header http.Header) (Session, error) {
+	if s.proxyHeader == "" {
+		return Session{}, ErrMethodDisabled
+	}
+	username := header.Get(s.proxyHeader)
+	if username == "" {
+		return Session{}, ErrInvalidSession
+	}
+	if !IsTrustedProxy(remoteAddr, s.trustedProxies) {
+		return Session{}, ErrUntrustedProxy
+	}
+
+	return Session{
+		Username: username,
+		Method:   MethodProxy,
+		// Proxies usually use cookies, so CSRF protection is still required.
+		CSRFToken: s.signer.Sign("csrf", "proxy:"+username),
+	}, nil
+}
+
+// IsTrustedProxy reports whether the request is made from one of the trusted addresses.
+// remoteAddr is the address of the client, see [http.Request.RemoteAddr].
+func IsTrustedProxy(remoteAddr string, trustedProxies []netip.Prefix) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) createSession(username string, method Method) (token string, _ Session, _ error) {
+	data := sessionData{
+		Username:  username,
+		Method:    method,
+		ExpiresAt: time.Now().Add(s.sessionTTL).Unix(),
+		Nonce:     generateRandomString(),
+	}
+	token, err := s.signer.EncodeToken("session", data)
+	if err != nil {
+		return "", Session{}, err
+	}
+	return token, s.newSession(data), nil
+}
+
+// GetSession checks the session token and returns the session. Password sessions of users
+// removed from the users file and sessions revoked with [Service.Logout] are considered invalid.
+func (s *Service) GetSession(token string) (Session, error) {
+	var data sessionData
+	err := s.signer.DecodeToken("session", token, &data)
+	if err != nil {
+		return Session{}, ErrInvalidSession
+	}
+
+	s.mu.Lock()
+	_, revoked := s.revokedSessions[data.Nonce]
+	s.mu.Unlock()
+	if revoked {
+		return Session{}, ErrInvalidSession
+	}
+
+	switch data.Method {
+	case MethodPassword, "":
+		if _, ok := s.users[data.Username]; !ok {
+			return Session{}, ErrInvalidSession
+		}
+	case MethodOIDC:
+		if s.oidc == nil {
+			return Session{}, ErrInvalidSession
+		}
+	default:
+		return Session{}, ErrInvalidSession
+	}
+
+	session := s.newSession(data)
+	if time.Now().After(session.ExpiresAt) {
+		return Session{}, ErrInvalidSession
+	}
+	return session, nil
+}
+
+// Logout revokes the session, so its token can't be used anymore even if it was stolen.
+// Invalid tokens are ignored.
+func (s *Service) Logout(token string) error {
+	var data sessionData
+	err := s.signer.DecodeToken("session", token, &data)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokedSessions[data.Nonce] = data.ExpiresAt
+	err = s.saveRevokedSessions()
+	if err != nil {
+		delete(s.revokedSessions, data.Nonce)
+		return err
+	}
+	return nil
+}
+
+const revokedSessionsFilename = "revoked_sessions.json"
+
+// saveRevokedSessions writes revoked sessions to the file. Expired sessions are removed
+// because they are invalid anyway. It must be called with locked mutex.
+func (s *Service) saveRevokedSessions() error {
+	now := time.Now()
+	for nonce, expiresAt := range s.revokedSessions {
+		if now.After(time.Unix(expiresAt, 0)) {
+			delete(s.revokedSessions, nonce)
+		}
+	}
+
+	if s.dir == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(s.revokedSessions)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal revoked sessions: %w", err)
+	}
+
+	// Write to a temporary file first to not lose revoked sessions on failure.
+	const tmpFilename = revokedSessionsFilename + ".tmp"
+	err = s.dir.WriteFile(tmpFilename, data, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't write revoked sessions: %w", err)
+	}
+	err = s.dir.Rename(tmpFilename, revokedSessionsFilename)
+	if err != nil {
+		return fmt.Errorf("couldn't rename file with revoked sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) newSession(data sessionData) Session {
+	return Session{
+		Username:  data.Username,
+		Method:    cmp.Or(data.Method, MethodPassword),
+		ExpiresAt: time.Unix(data.ExpiresAt, 0),
+		CSRFToken: s.signer.Sign("csrf", data.Nonce),
+	}
+}
+
+// generateRandomString returns a random string that can be used in urls.
+func generateRandomString() string {
+	return rand.Text()
+}
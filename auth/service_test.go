@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/signed"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestService(t *testing.T) {
+	r := require.New(t)
+
+	aliceHash, err := newPBKDF2Hash("alice-password", minIterations)
+	r.NoError(err)
+	bobHash, err := bcrypt.GenerateFromPassword([]byte("bob-password"), bcrypt.MinCost)
+	r.NoError(err)
+
+	usersFile := filepath.Join(t.TempDir(), "users")
+	err = os.WriteFile(usersFile, []byte("# comment\nalice:"+aliceHash.String()+"\n\nbob:"+string(bobHash)+"\n"), 0600)
+	r.NoError(err)
+
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	s, err := NewService(Options{UsersFile: usersFile, SessionTTL: time.Hour}, dirRoot)
+	r.NoError(err)
+
+	_, _, err = s.Login("alice", "bob-password")
+	r.ErrorIs(err, ErrInvalidCredentials)
+	_, _, err = s.Login("eve", "alice-password")
+	r.ErrorIs(err, ErrInvalidCredentials)
+
+	token, session, err := s.Login("alice", "alice-password")
+	r.NoError(err)
+	r.Equal("alice", session.Username)
+	r.Equal(MethodPassword, session.Method)
+	r.NotEmpty(session.CSRFToken)
+	r.WithinDuration(time.Now().Add(time.Hour), session.ExpiresAt, time.Minute)
+
+	gotSession, err := s.GetSession(token)
+	r.NoError(err)
+	r.Equal(session, gotSession)
+
+	_, bobSession, err := s.Login("bob", "bob-password")
+	r.NoError(err)
+	r.NotEqual(session.CSRFToken, bobSession.CSRFToken)
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		r := require.New(t)
+
+		for _, token := range []string{
+			"",
+			"abc",
+			token + "x",
+			"x" + token,
+		} {
+			_, err := s.GetSession(token)
+			r.ErrorIs(err, ErrInvalidSession)
+		}
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		r := require.New(t)
+
+		s, err := NewService(Options{UsersFile: usersFile, SessionTTL: time.Hour}, dirRoot)
+		r.NoError(err)
+
+		gotSession, err := s.GetSession(token)
+		r.NoError(err)
+		r.Equal(session, gotSession)
+
+		// Remove alice.
+		err = os.WriteFile(usersFile, []byte("bob:"+string(bobHash)+"\n"), 0600)
+		r.NoError(err)
+
+		s, err = NewService(Options{UsersFile: usersFile, SessionTTL: time.Hour}, dirRoot)
+		r.NoError(err)
+
+		_, err = s.GetSession(token)
+		r.ErrorIs(err, ErrInvalidSession)
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		r := require.New(t)
+
+		token, _, err := s.Login("bob", "bob-password")
+		r.NoError(err)
+		otherToken, _, err := s.Login("bob", "bob-password")
+		r.NoError(err)
+
+		r.NoError(s.Logout(token))
+		r.NoError(s.Logout("invalid token"))
+
+		_, err = s.GetSession(token)
+		r.ErrorIs(err, ErrInvalidSession)
+		// Other sessions of the user are still valid.
+		_, err = s.GetSession(otherToken)
+		r.NoError(err)
+
+		// Revoked sessions must survive restarts.
+		s, err := NewService(Options{UsersFile: usersFile, SessionTTL: time.Hour}, dirRoot)
+		r.NoError(err)
+
+		_, err = s.GetSession(token)
+		r.ErrorIs(err, ErrInvalidSession)
+		_, err = s.GetSession(otherToken)
+		r.NoError(err)
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		r := require.New(t)
+
+		s, err := newService(Options{SessionTTL: -time.Second}, s.users, s.signer)
+		r.NoError(err)
+
+		token, _, err := s.Login("bob", "bob-password")
+		r.NoError(err)
+
+		_, err = s.GetSession(token)
+		r.ErrorIs(err, ErrInvalidSession)
+	})
+}
+
+func TestService_GetProxySession(t *testing.T) {
+	r := require.New(t)
+
+	s, err := newService(
+		Options{
+			ProxyHeader:    "Remote-User",
+			TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")},
+		},
+		nil, signed.NewSigner([]byte("secret")),
+	)
+	r.NoError(err)
+
+	header := http.Header{}
+	header.Set("Remote-User", "alice")
+
+	for _, addr := range []string{"10.1.2.3:5000", "[::1]:5000", "[::ffff:10.1.2.3]:5000"} {
+		session, err := s.GetProxySession(addr, header)
+		r.NoError(err, addr)
+		r.Equal("alice", session.Username)
+		r.Equal(MethodProxy, session.Method)
+		r.NotEmpty(session.CSRFToken)
+	}
+
+	_, err = s.GetProxySession("192.168.1.1:5000", header)
+	r.ErrorIs(err, ErrUntrustedProxy)
+	_, err = s.GetProxySession("invalid", header)
+	r.ErrorIs(err, ErrUntrustedProxy)
+	_, err = s.GetProxySession("10.1.2.3:5000", http.Header{})
+	r.ErrorIs(err, ErrInvalidSession)
+
+	// Password authentication is disabled.
+	_, _, err = s.Login("alice", "qwerty")
+	r.ErrorIs(err, ErrMethodDisabled)
+}
+
+func TestParseUsers(t *testing.T) {
+	const hash = "pbkdf2-sha256$1000$c2FsdA$a2V5"
+
+	for _, tt := range []struct {
+		name      string
+		data      string
+		wantUsers []string
+		wantErr   string
+	}{
+		{name: "valid", data: "# users\nalice:" + hash + "\n  bob:" + hash + "  \n", wantUsers: []string{"alice", "bob"}},
+		{name: "no users", data: "# users\n\n", wantErr: "no users"},
+		{name: "no separator", data: "alice", wantErr: "line 1: line must have format"},
+		{name: "empty username", data: ":" + hash, wantErr: "line 1: line must have format"},
+		{name: "duplicate", data: "alice:" + hash + "\nalice:" + hash, wantErr: `line 2: duplicate user "alice"`},
+		{name: "invalid hash", data: "\nalice:qwerty", wantErr: `line 2: invalid password hash of user "alice"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := require.New(t)
+
+			users, err := parseUsers([]byte(tt.data))
+			if tt.wantErr != "" {
+				r.ErrorContains(err, tt.wantErr)
+				return
+			}
+			r.NoError(err)
+
+			var names []string
+			for name := range users {
+				names = append(names, name)
+			}
+			r.ElementsMatch(tt.wantUsers, names)
+		})
+	}
+}
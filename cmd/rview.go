@@ -8,12 +8,19 @@ import (
 	"reflect"
 	"sync"
 
+	"github.com/ShoshinNikita/rview/acl"
+	"github.com/ShoshinNikita/rview/archive"
+	"github.com/ShoshinNikita/rview/auth"
+	"github.com/ShoshinNikita/rview/duplicates"
+	"github.com/ShoshinNikita/rview/metadata"
 	"github.com/ShoshinNikita/rview/pkg/cache"
 	"github.com/ShoshinNikita/rview/pkg/rlog"
 	"github.com/ShoshinNikita/rview/rclone"
 	"github.com/ShoshinNikita/rview/rview"
 	"github.com/ShoshinNikita/rview/search"
+	"github.com/ShoshinNikita/rview/share"
 	"github.com/ShoshinNikita/rview/thumbnails"
+	"github.com/ShoshinNikita/rview/transcoding"
 	"github.com/ShoshinNikita/rview/web"
 )
 
@@ -24,8 +31,23 @@ type Rview struct {
 	thumbnailCache     *cache.DiskCache
 	originalImageCache *cache.DiskCache
 
+	transcodingService TranscodingService
+	transcodingCache   *cache.DiskCache
+
+	metadataService MetadataService
+	metadataCache   *cache.DiskCache
+
 	searchService *search.Service
 
+	duplicatesService DuplicatesService
+
+	authService web.AuthService
+	aclService  web.ACLService
+
+	shareService ShareService
+
+	archiveService *archive.Service
+
 	rcloneInstance *rclone.Rclone
 
 	server *web.Server
@@ -37,6 +59,31 @@ type ThumbnailService interface {
 	Shutdown(context.Context) error
 }
 
+type TranscodingService interface {
+	web.TranscodingService
+
+	Shutdown(context.Context) error
+}
+
+type MetadataService interface {
+	web.MetadataService
+
+	Shutdown(context.Context) error
+}
+
+type DuplicatesService interface {
+	web.DuplicatesService
+
+	Start() error
+	Shutdown(context.Context) error
+}
+
+type ShareService interface {
+	web.ShareService
+
+	Shutdown(context.Context) error
+}
+
 func NewRview(cfg rview.Config) *Rview {
 	return &Rview{
 		cfg: cfg,
@@ -53,14 +100,14 @@ func (r *Rview) Prepare() (err error) {
 	}
 
 	// Rclone
-	r.rcloneInstance, err = rclone.NewRclone(r.cfg.Rclone)
+	r.rcloneInstance, err = rclone.NewRclone(r.cfg.Rclone, dirRoot)
 	if err != nil {
 		return fmt.Errorf("couldn't prepare rclone: %w", err)
 	}
 
 	// Thumbnail Service
 	if r.cfg.ImagePreviewMode == rview.ImagePreviewModeThumbnails {
-		err := thumbnails.CheckDeps()
+		err := thumbnails.CheckDeps(r.cfg.ThumbnailsProcessVideoFiles || r.cfg.ThumbnailsProcessAudioFiles)
 		if err != nil {
 			return err
 		}
@@ -84,8 +131,15 @@ func (r *Rview) Prepare() (err error) {
 		}
 
 		r.thumbnailService = thumbnails.NewThumbnailService(
-			r.rcloneInstance, r.thumbnailCache, r.originalImageCache, r.cfg.ThumbnailsWorkersCount,
-			r.cfg.ThumbnailsFormat, r.cfg.ThumbnailsProcessRawFiles,
+			r.rcloneInstance, r.thumbnailCache, r.originalImageCache, thumbnails.Options{
+				WorkersCount:     r.cfg.ThumbnailsWorkersCount,
+				ThumbnailsFormat: r.cfg.ThumbnailsFormat,
+				ProcessRawImages: r.cfg.ThumbnailsProcessRawFiles,
+				ProcessVideos:    r.cfg.ThumbnailsProcessVideoFiles,
+				ProcessAudio:     r.cfg.ThumbnailsProcessAudioFiles,
+				ProcessDocuments: r.cfg.ThumbnailsProcessDocuments,
+				MaxDocumentSize:  r.cfg.ThumbnailsMaxDocumentSize.Bytes(),
+			},
 		)
 
 	} else {
@@ -94,14 +148,163 @@ func (r *Rview) Prepare() (err error) {
 		r.thumbnailService = thumbnails.NewNoopThumbnailService()
 	}
 
+	// Transcoding Service
+	if r.cfg.VideoTranscoding {
+		err := transcoding.CheckDeps()
+		if err != nil {
+			return err
+		}
+
+		r.transcodingCache, err = cache.NewDiskCache(
+			"transcoded-videos", filepath.Join(r.cfg.Dir, "transcoded-videos"), cache.Options{
+				MaxSize: r.cfg.VideoTranscodingCacheSize.Bytes(),
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("couldn't prepare disk cache for transcoded videos: %w", err)
+		}
+
+		r.transcodingService = transcoding.NewService(r.rcloneInstance, r.transcodingCache, r.cfg.VideoTranscodingWorkersCount)
+
+	} else {
+		rlog.Debug("transcoding service is disabled")
+
+		r.transcodingService = transcoding.NewNoopService()
+	}
+
+	// Metadata Service
+	if r.cfg.ImageMetadata {
+		err := metadata.CheckDeps()
+		if err != nil {
+			return err
+		}
+
+		r.metadataCache, err = cache.NewDiskCache(
+			"metadata", filepath.Join(r.cfg.Dir, "metadata"), cache.Options{
+				MaxSize: r.cfg.ImageMetadataCacheSize.Bytes(),
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("couldn't prepare disk cache for metadata: %w", err)
+		}
+
+		r.metadataService, err = metadata.NewService(r.rcloneInstance, r.metadataCache, dirRoot)
+		if err != nil {
+			return fmt.Errorf("couldn't prepare metadata service: %w", err)
+		}
+
+	} else {
+		rlog.Debug("metadata service is disabled")
+
+		r.metadataService = metadata.NewNoopService()
+	}
+
 	// Search Service
-	r.searchService, err = search.NewService(r.rcloneInstance, dirRoot)
+	searchOpts := search.Options{
+		ContentIndex:       r.cfg.SearchContent,
+		ContentMaxFileSize: r.cfg.SearchContentMaxFileSize.Bytes(),
+		Fuzzy:              r.cfg.SearchFuzzy,
+	}
+	if r.cfg.ImageMetadata {
+		// GPS coordinates are required for the map.
+		searchOpts.Metadata = r.metadataService
+	}
+	r.searchService, err = search.NewService(r.rcloneInstance, dirRoot, searchOpts)
 	if err != nil {
 		return fmt.Errorf("couldn't prepare search service: %w", err)
 	}
+	r.rcloneInstance.OnDirChange(r.searchService.ApplyDirChange)
+
+	// Duplicates Service
+	if r.cfg.DuplicatesDetection {
+		r.duplicatesService, err = duplicates.NewService(r.rcloneInstance, r.searchService, r.thumbnailService, dirRoot)
+		if err != nil {
+			return fmt.Errorf("couldn't prepare duplicates service: %w", err)
+		}
+
+	} else {
+		rlog.Debug("duplicates service is disabled")
+
+		r.duplicatesService = duplicates.NewNoopService()
+	}
+
+	// Auth Service
+	if r.cfg.Auth.IsEnabled() {
+		opts := auth.Options{
+			UsersFile:      r.cfg.Auth.UsersFile,
+			SessionTTL:     r.cfg.Auth.SessionTTL,
+			ProxyHeader:    r.cfg.Auth.ProxyHeader,
+			TrustedProxies: r.cfg.Auth.TrustedProxies,
+		}
+		if oidcCfg := r.cfg.Auth.OIDC; oidcCfg.IssuerURL != "" {
+			opts.OIDC, err = auth.NewOIDCProvider(auth.OIDCOptions{
+				IssuerURL:     oidcCfg.IssuerURL,
+				ClientID:      oidcCfg.ClientID,
+				ClientSecret:  oidcCfg.ClientSecret,
+				RedirectURL:   oidcCfg.RedirectURL,
+				UsernameClaim: oidcCfg.UsernameClaim,
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't prepare OpenID Connect provider: %w", err)
+			}
+		}
+
+		r.authService, err = auth.NewService(opts, dirRoot)
+		if err != nil {
+			return fmt.Errorf("couldn't prepare auth service: %w", err)
+		}
+
+	} else {
+		rlog.Debug("auth service is disabled")
+
+		r.authService = auth.NewNoopService()
+	}
+
+	// ACL Service
+	if r.cfg.ACL.File != "" {
+		r.aclService, err = acl.NewService(r.cfg.ACL.File)
+		if err != nil {
+			return fmt.Errorf("couldn't prepare acl service: %w", err)
+		}
+		if !r.cfg.Auth.IsEnabled() && len(r.cfg.Auth.TrustedProxies) == 0 {
+			rlog.Warn("authentication is disabled and no trusted proxies are set: all users are anonymous")
+		}
+
+	} else {
+		rlog.Debug("acl service is disabled")
+
+		r.aclService = acl.NewNoopService()
+	}
+
+	// Share Service
+	if r.cfg.ShareLinks {
+		r.shareService, err = share.NewService(dirRoot)
+		if err != nil {
+			return fmt.Errorf("couldn't prepare share service: %w", err)
+		}
+
+	} else {
+		rlog.Debug("share service is disabled")
+
+		r.shareService = share.NewNoopService()
+	}
+
+	// Archive Service
+	r.archiveService = archive.NewService(r.rcloneInstance, r.cfg.ArchiveMaxConcurrentFiles)
 
 	// Web Server
-	r.server = web.NewServer(r.cfg, r.rcloneInstance, r.thumbnailService, r.searchService)
+	r.server = web.NewServer(r.cfg, web.Services{
+		Rclone:             r.rcloneInstance,
+		ThumbnailService:   r.thumbnailService,
+		TranscodingService: r.transcodingService,
+		MetadataService:    r.metadataService,
+		SearchService:      r.searchService,
+		DuplicatesService:  r.duplicatesService,
+		AuthService:        r.authService,
+		ACLService:         r.aclService,
+		ShareService:       r.shareService,
+		ArchiveService:     r.archiveService,
+	})
 
 	return nil
 }
@@ -112,9 +315,10 @@ func (r *Rview) Start(onError func()) <-chan struct{} {
 	go func() {
 		var wg sync.WaitGroup
 		for name, s := range map[string]interface{ Start() error }{
-			"rclone instance": r.rcloneInstance,
-			"search service":  r.searchService,
-			"web server":      r.server,
+			"rclone instance":    r.rcloneInstance,
+			"search service":     r.searchService,
+			"duplicates service": r.duplicatesService,
+			"web server":         r.server,
 		} {
 			wg.Go(func() {
 				if err := s.Start(); err != nil {
@@ -139,10 +343,17 @@ func (r *Rview) Shutdown(ctx context.Context) error {
 		s    shutdowner
 	}{
 		{"web server", r.server},
+		// Services that depend on other services must be stopped first.
+		{"duplicates service", r.duplicatesService},
+		{"search service", r.searchService},
 		{"thumbnail service", r.thumbnailService},
 		{"thumbnail cache", r.thumbnailCache},
 		{"original image cache", r.originalImageCache},
-		{"search service", r.searchService},
+		{"transcoding service", r.transcodingService},
+		{"transcoding cache", r.transcodingCache},
+		{"metadata service", r.metadataService},
+		{"metadata cache", r.metadataCache},
+		{"share service", r.shareService},
 		{"rclone instance", r.rcloneInstance},
 	} {
 		err := safeShutdown(ctx, v.s)
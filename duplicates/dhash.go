@@ -0,0 +1,105 @@
+package duplicates
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"  // register decoder
+	_ "image/jpeg" // register decoder
+	_ "image/png"  // register decoder
+	"io"
+	"math/bits"
+	"os/exec"
+)
+
+// dHashSize is the size of the grayscale image used to calculate dHash: 9x8 pixels give
+// 8 differences per row, 64 bits in total.
+const dHashSize = 8
+
+// calculateDHash calculates the difference hash of an image: the image is converted to
+// grayscale and reduced to 9x8 pixels, every bit of the hash indicates whether a pixel
+// is brighter than its right neighbor. Similar images have hashes with a small Hamming
+// distance, see [hammingDistance].
+//
+// Read more: https://www.hackerfactor.com/blog/index.php?/archives/529-Kind-of-Like-That.html
+func calculateDHash(img image.Image) uint64 {
+	pixels := resizeGrayscale(img, dHashSize+1, dHashSize)
+
+	var hash uint64
+	for y := range dHashSize {
+		for x := range dHashSize {
+			hash <<= 1
+			if pixels[y][x] > pixels[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// resizeGrayscale converts the image to grayscale and reduces it to the passed size. Every
+// pixel is the average of the corresponding area of the original image.
+func resizeGrayscale(img image.Image, width, height int) [][]float64 {
+	bounds := img.Bounds()
+
+	res := make([][]float64, height)
+	for y := range height {
+		res[y] = make([]float64, width)
+
+		minY := bounds.Min.Y + y*bounds.Dy()/height
+		maxY := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, minY+1)
+
+		for x := range width {
+			minX := bounds.Min.X + x*bounds.Dx()/width
+			maxX := max(bounds.Min.X+(x+1)*bounds.Dx()/width, minX+1)
+
+			var sum, count float64
+			for py := minY; py < maxY; py++ {
+				for px := minX; px < maxX; px++ {
+					r, g, b, _ := img.At(px, py).RGBA()
+					// ITU-R BT.601 luma
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					count++
+				}
+			}
+			res[y][x] = sum / count
+		}
+	}
+	return res
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// decodeImage decodes the thumbnail. Formats that are not supported by the standard library
+// (for example, AVIF and WebP) are converted to PNG with vips.
+func decodeImage(ctx context.Context, r io.Reader, contentType string) (image.Image, error) {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		img, _, err := image.Decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode image: %w", err)
+		}
+		return img, nil
+	}
+
+	// Reduce the image to speed up decoding, the exact size doesn't matter.
+	cmd := exec.CommandContext(ctx, "vips", "thumbnail_source", "[descriptor=0]", ".png", "64")
+	cmd.Stdin = r
+	stdout := bytes.NewBuffer(nil)
+	cmd.Stdout = stdout
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("couldn't convert image with vips: %w, stderr: %q", err, stderr.String())
+	}
+
+	img, _, err := image.Decode(stdout)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode converted image: %w", err)
+	}
+	return img, nil
+}
@@ -0,0 +1,72 @@
+package duplicates
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newGradientImage returns an image with a horizontal gradient. The direction of the gradient
+// changes in every row of blocks, so images with different seeds are different.
+func newGradientImage(width, height int, seed uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := range height {
+		row := y * 8 / height
+		for x := range width {
+			v := x * 255 / width
+			if (int(seed)>>row)&1 == 1 {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v)}) //nolint:gosec
+		}
+	}
+	return img
+}
+
+func TestCalculateDHash(t *testing.T) {
+	t.Parallel()
+
+	original := newGradientImage(400, 300, 0b1010_1010)
+
+	// Resized image must have the same hash.
+	resized := newGradientImage(200, 150, 0b1010_1010)
+	require.Equal(t, calculateDHash(original), calculateDHash(resized))
+
+	// Slightly changed image must have a close hash.
+	changed := newGradientImage(400, 300, 0b1010_1010)
+	for x := range 50 {
+		for y := range 40 {
+			changed.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	require.LessOrEqual(t, hammingDistance(calculateDHash(original), calculateDHash(changed)), maxSimilarDistance)
+
+	// Different image must have a distant hash.
+	different := newGradientImage(400, 300, 0b0101_0101)
+	require.Greater(t, hammingDistance(calculateDHash(original), calculateDHash(different)), maxSimilarDistance)
+}
+
+func TestBKTree(t *testing.T) {
+	t.Parallel()
+
+	hashes := []uint64{
+		0b0000,
+		0b0001,
+		0b0011,
+		0b1111_1111,
+		0b1111_1110,
+		0xFFFF_FFFF,
+	}
+	var tree bkTree
+	for i, hash := range hashes {
+		tree.add(hash, i)
+	}
+
+	require.ElementsMatch(t, []int{0, 1, 2}, tree.search(0b0000, 2))
+	require.ElementsMatch(t, []int{0, 1}, tree.search(0b0000, 1))
+	require.ElementsMatch(t, []int{3, 4}, tree.search(0b1111_1111, 1))
+	require.ElementsMatch(t, []int{5}, tree.search(0xFFFF_FFFF, 0))
+	require.Empty(t, tree.search(0xFFFF_0000, 3))
+}
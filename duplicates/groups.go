@@ -0,0 +1,196 @@
+package duplicates
+
+import (
+	"cmp"
+	"slices"
+)
+
+// maxSimilarDistance is the max Hamming distance between dHashes of similar images. Resized,
+// recompressed or slightly edited copies usually have a distance of 0-4.
+const maxSimilarDistance = 6
+
+type GroupKind string
+
+const (
+	// GroupKindIdentical is used for files with the same content.
+	GroupKindIdentical GroupKind = "identical"
+	// GroupKindSimilar is used for images that look almost the same.
+	GroupKindSimilar GroupKind = "similar"
+)
+
+type Group struct {
+	Kind  GroupKind `json:"kind"`
+	Files []File    `json:"files"`
+}
+
+type File struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+}
+
+// WastedSize returns the size that can be freed by keeping only the largest file of the group.
+func (g Group) WastedSize() (res int64) {
+	var maxSize int64
+	for _, f := range g.Files {
+		res += f.Size
+		maxSize = max(maxSize, f.Size)
+	}
+	return res - maxSize
+}
+
+// findIdenticalGroups groups files by content hash. Empty files are ignored.
+func findIdenticalGroups(files []File, contentHashes map[string]string) []Group {
+	filesByHash := make(map[string][]File)
+	for _, f := range files {
+		hash, ok := contentHashes[f.Path]
+		if !ok || f.Size == 0 {
+			continue
+		}
+		filesByHash[hash] = append(filesByHash[hash], f)
+	}
+
+	var groups []Group
+	for _, files := range filesByHash {
+		if len(files) < 2 {
+			continue
+		}
+		groups = append(groups, Group{Kind: GroupKindIdentical, Files: files})
+	}
+	return groups
+}
+
+// findSimilarGroups groups images with close dHashes. Similarity is transitive: if A is similar
+// to B and B is similar to C, all 3 images are in the same group. Groups of identical files
+// are skipped because they are reported by [findIdenticalGroups].
+func findSimilarGroups(images []File, dHashes map[string]uint64, contentHashes map[string]string) []Group {
+	var (
+		tree   bkTree
+		parent = make([]int, len(images))
+	)
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	for i, img := range images {
+		parent[i] = i
+
+		hash := dHashes[img.Path]
+		for _, j := range tree.search(hash, maxSimilarDistance) {
+			parent[find(j)] = find(i)
+		}
+		tree.add(hash, i)
+	}
+
+	components := make(map[int][]File)
+	for i, img := range images {
+		root := find(i)
+		components[root] = append(components[root], img)
+	}
+
+	var groups []Group
+	for _, files := range components {
+		if len(files) < 2 || areIdentical(files, contentHashes) {
+			continue
+		}
+		groups = append(groups, Group{Kind: GroupKindSimilar, Files: files})
+	}
+	return groups
+}
+
+func areIdentical(files []File, contentHashes map[string]string) bool {
+	first, ok := contentHashes[files[0].Path]
+	if !ok {
+		return false
+	}
+	for _, f := range files[1:] {
+		if hash, ok := contentHashes[f.Path]; !ok || hash != first {
+			return false
+		}
+	}
+	return true
+}
+
+// sortGroups sorts groups by wasted size in descending order. Files are sorted by path.
+func sortGroups(groups []Group) {
+	for _, g := range groups {
+		slices.SortFunc(g.Files, func(a, b File) int {
+			return cmp.Compare(a.Path, b.Path)
+		})
+	}
+	slices.SortFunc(groups, func(a, b Group) int {
+		if v := cmp.Compare(b.WastedSize(), a.WastedSize()); v != 0 {
+			return v
+		}
+		return cmp.Compare(a.Files[0].Path, b.Files[0].Path)
+	})
+}
+
+// bkTree is a BK-tree for hashes with Hamming distance as a metric. It allows to find close
+// hashes without comparing all pairs.
+//
+// Read more: https://en.wikipedia.org/wiki/BK-tree
+type bkTree struct {
+	root *bkNode
+}
+
+type bkNode struct {
+	hash     uint64
+	values   []int
+	children map[int]*bkNode // distance -> child
+}
+
+func (t *bkTree) add(hash uint64, value int) {
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, values: []int{value}}
+		return
+	}
+
+	node := t.root
+	for {
+		dist := hammingDistance(node.hash, hash)
+		if dist == 0 {
+			node.values = append(node.values, value)
+			return
+		}
+
+		child, ok := node.children[dist]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[dist] = &bkNode{hash: hash, values: []int{value}}
+			return
+		}
+		node = child
+	}
+}
+
+// search returns values of all hashes within maxDistance.
+func (t *bkTree) search(hash uint64, maxDistance int) (res []int) {
+	if t.root == nil {
+		return nil
+	}
+
+	queue := []*bkNode{t.root}
+	for len(queue) > 0 {
+		node := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		dist := hammingDistance(node.hash, hash)
+		if dist <= maxDistance {
+			res = append(res, node.values...)
+		}
+		for childDist, child := range node.children {
+			// Triangle inequality.
+			if dist-maxDistance <= childDist && childDist <= dist+maxDistance {
+				queue = append(queue, child)
+			}
+		}
+	}
+	return res
+}
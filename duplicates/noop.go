@@ -0,0 +1,26 @@
+package duplicates
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNoopDuplicatesService = errors.New("noop duplicates service")
+
+type NoopService struct{}
+
+func NewNoopService() *NoopService {
+	return &NoopService{}
+}
+
+func (NoopService) Start() error {
+	return nil
+}
+
+func (NoopService) GetDuplicates() (Result, error) {
+	return Result{}, ErrNoopDuplicatesService
+}
+
+func (NoopService) Shutdown(context.Context) error {
+	return nil
+}
@@ -0,0 +1,404 @@
+// Package duplicates detects identical and similar files. Identical files are detected by content
+// hashes provided by rclone, similar images - by dHashes of their thumbnails.
+package duplicates
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/ShoshinNikita/rview/search"
+	"github.com/ShoshinNikita/rview/thumbnails"
+)
+
+// ErrNotReady is returned when duplicates haven't been detected yet.
+var ErrNotReady = errors.New("duplicates are not detected yet")
+
+type Service struct {
+	rclone     Rclone
+	files      Files
+	thumbnails ThumbnailService
+	dir        *os.Root
+	filename   string
+
+	mu    sync.RWMutex
+	state state
+
+	// refreshMu guards [Service.Refresh] and dHashes of the state.
+	refreshMu sync.Mutex
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stoppedCh chan struct{}
+}
+
+type Rclone interface {
+	GetHashsums(ctx context.Context) (hashType string, hashes map[string]string, err error)
+}
+
+type Files interface {
+	GetFiles(ctx context.Context, filter func(path string) bool) ([]search.Hit, error)
+}
+
+type ThumbnailService interface {
+	CanGenerateThumbnail(rview.FileID) bool
+	OpenThumbnail(context.Context, rview.FileID, thumbnails.ThumbnailSize) (rc io.ReadCloser, contentType string, err error)
+}
+
+// Result contains all found groups sorted by wasted size.
+type Result struct {
+	Groups []Group `json:"groups"`
+	// HashType is the type of content hashes, for example "md5". It is empty if the remote
+	// doesn't support hashes, and only similar images are detected.
+	HashType  string    `json:"hash_type,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type state struct {
+	Result *Result `json:"result"`
+	// DHashes contains dHashes of images, so thumbnails are not processed on every refresh.
+	DHashes map[string]dHashEntry `json:"dhashes"` // path -> entry
+}
+
+type dHashEntry struct {
+	ModTime int64  `json:"mod_time"`
+	Size    int64  `json:"size"`
+	Hash    uint64 `json:"hash,omitempty"`
+	// Failed is true if the thumbnail couldn't be processed. Such images are not processed again
+	// until they are changed.
+	Failed bool `json:"failed,omitempty"`
+}
+
+// NewService prepares a new service. Call [Service.Start] to start the detection in the background.
+func NewService(rclone Rclone, files Files, thumbnailService ThumbnailService, dirRoot *os.Root) (*Service, error) {
+	err := dirRoot.Mkdir("duplicates", 0700)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("couldn't create 'duplicates' subdirectory: %w", err)
+	}
+	duplicatesDirRoot, err := dirRoot.OpenRoot("duplicates")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open root: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Service{
+		rclone:     rclone,
+		files:      files,
+		thumbnails: thumbnailService,
+		dir:        duplicatesDirRoot,
+		filename:   "duplicates.json.gz",
+		//
+		state: state{
+			DHashes: make(map[string]dHashEntry),
+		},
+		//
+		ctx:       ctx,
+		cancel:    cancel,
+		stoppedCh: make(chan struct{}),
+	}, nil
+}
+
+// Start loads the previous result and starts the detection in the background. The detection
+// is repeated once a day.
+func (s *Service) Start() error {
+	state, err := s.loadState()
+	if err == nil {
+		s.mu.Lock()
+		s.state = state
+		s.mu.Unlock()
+
+		rlog.Info("duplicates have been loaded from the file")
+	} else if !errors.Is(err, os.ErrNotExist) {
+		rlog.Warnf("couldn't load duplicates from the file: %s", err)
+	}
+
+	go s.startBackgroundRefresh()
+
+	return nil
+}
+
+func (s *Service) startBackgroundRefresh() {
+	const (
+		checkInterval   = time.Minute
+		refreshInterval = 24 * time.Hour
+		// retryInterval is used after failed attempts. For example, the search index can be
+		// not ready yet.
+		retryInterval = 10 * time.Minute
+	)
+
+	defer close(s.stoppedCh)
+
+	var lastFailedAttempt time.Time
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+
+		case <-ticker.C:
+			s.mu.RLock()
+			result := s.state.Result
+			s.mu.RUnlock()
+
+			if result != nil && time.Since(result.CreatedAt) < refreshInterval {
+				continue
+			}
+			if time.Since(lastFailedAttempt) < retryInterval {
+				continue
+			}
+
+			err := s.Refresh(s.ctx)
+			if err != nil && s.ctx.Err() == nil {
+				rlog.Errorf("couldn't detect duplicates: %s", err)
+				lastFailedAttempt = time.Now()
+			}
+		}
+	}
+}
+
+// Shutdown stops the background detection.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.cancel()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.stoppedCh:
+		return nil
+	}
+}
+
+// GetDuplicates returns the result of the last detection. It returns [ErrNotReady] if
+// the detection hasn't finished yet.
+func (s *Service) GetDuplicates() (Result, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.state.Result == nil {
+		return Result{}, ErrNotReady
+	}
+	return *s.state.Result, nil
+}
+
+// Refresh detects duplicates among all files from the search index and saves the result.
+func (s *Service) Refresh(ctx context.Context) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	start := time.Now()
+
+	hits, err := s.files.GetFiles(ctx, func(string) bool { return true })
+	if err != nil {
+		return fmt.Errorf("couldn't get files: %w", err)
+	}
+
+	var files, images []File
+	for _, hit := range hits {
+		if hit.IsDir {
+			continue
+		}
+
+		f := File{Path: hit.Path, Size: hit.Size, ModTime: hit.ModTime}
+		files = append(files, f)
+
+		if s.canCalculateDHash(f) {
+			images = append(images, f)
+		}
+	}
+
+	hashType, contentHashes, err := s.rclone.GetHashsums(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get content hashes: %w", err)
+	}
+
+	dHashes, err := s.calculateDHashes(ctx, images)
+	if err != nil {
+		if err := s.saveState(); err != nil {
+			rlog.Errorf("couldn't save processed images: %s", err)
+		}
+		return fmt.Errorf("couldn't calculate dHashes: %w", err)
+	}
+	// Ignore images without dHashes.
+	images = slices.DeleteFunc(images, func(f File) bool {
+		_, ok := dHashes[f.Path]
+		return !ok
+	})
+
+	groups := findIdenticalGroups(files, contentHashes)
+	groups = append(groups, findSimilarGroups(images, dHashes, contentHashes)...)
+	sortGroups(groups)
+
+	result := &Result{
+		Groups:    groups,
+		HashType:  hashType,
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	s.state.Result = result
+	s.mu.Unlock()
+
+	if err := s.saveState(); err != nil {
+		return fmt.Errorf("couldn't save duplicates: %w", err)
+	}
+
+	rlog.Infof(
+		"duplicates have been detected in %s, files: %d, groups: %d",
+		time.Since(start).Round(time.Millisecond), len(files), len(groups),
+	)
+	return nil
+}
+
+func (s *Service) canCalculateDHash(f File) bool {
+	switch rview.GetFileType(rview.GetFileExt(f.Path)) {
+	case rview.FileTypeImage, rview.FileTypeRawImage:
+	default:
+		return false
+	}
+	return f.Size > 0 && s.thumbnails.CanGenerateThumbnail(rview.NewFileID(f.Path, f.ModTime, f.Size))
+}
+
+// calculateDHashes returns dHashes of images. New and changed images are processed, dHashes of
+// the other ones are reused. If the context is canceled, already processed images are kept in
+// the state, so they are not processed again.
+func (s *Service) calculateDHashes(ctx context.Context, images []File) (map[string]uint64, error) {
+	s.mu.RLock()
+	prevEntries := s.state.DHashes
+	s.mu.RUnlock()
+
+	var (
+		entries = make(map[string]dHashEntry, len(images))
+		res     = make(map[string]uint64, len(images))
+	)
+	interrupt := func(err error) error {
+		for path, entry := range prevEntries {
+			if _, ok := entries[path]; !ok {
+				entries[path] = entry
+			}
+		}
+		s.mu.Lock()
+		s.state.DHashes = entries
+		s.mu.Unlock()
+
+		return err
+	}
+	for _, img := range images {
+		entry, ok := prevEntries[img.Path]
+		if !ok || entry.ModTime != img.ModTime || entry.Size != img.Size {
+			if err := ctx.Err(); err != nil {
+				return nil, interrupt(err)
+			}
+
+			entry = dHashEntry{ModTime: img.ModTime, Size: img.Size}
+
+			hash, err := s.calculateDHash(ctx, rview.NewFileID(img.Path, img.ModTime, img.Size))
+			switch {
+			case err == nil:
+				entry.Hash = hash
+			case ctx.Err() != nil:
+				return nil, interrupt(ctx.Err())
+			default:
+				rlog.Debugf("couldn't calculate dHash of %q: %s", img.Path, err)
+				entry.Failed = true
+			}
+		}
+
+		entries[img.Path] = entry
+		if !entry.Failed {
+			res[img.Path] = entry.Hash
+		}
+	}
+
+	s.mu.Lock()
+	s.state.DHashes = entries
+	s.mu.Unlock()
+
+	return res, nil
+}
+
+func (s *Service) calculateDHash(ctx context.Context, id rview.FileID) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	rc, contentType, err := s.thumbnails.OpenThumbnail(ctx, id, thumbnails.ThumbnailSmall)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't open thumbnail: %w", err)
+	}
+	defer rc.Close()
+
+	img, err := decodeImage(ctx, rc, contentType)
+	if err != nil {
+		return 0, err
+	}
+	return calculateDHash(img), nil
+}
+
+func (s *Service) loadState() (res state, err error) {
+	f, err := s.dir.Open(s.filename)
+	if err != nil {
+		return state{}, fmt.Errorf("couldn't open file: %w", err)
+	}
+	defer f.Close()
+
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		return state{}, err
+	}
+	defer gzipReader.Close()
+
+	if err := json.NewDecoder(gzipReader).Decode(&res); err != nil {
+		return state{}, fmt.Errorf("decode error: %w", err)
+	}
+	if res.DHashes == nil {
+		res.DHashes = make(map[string]dHashEntry)
+	}
+	return res, nil
+}
+
+// saveState writes the state to a temporary file and renames it, so the file is never corrupted.
+func (s *Service) saveState() (err error) {
+	tmpFilename := s.filename + ".tmp"
+	f, err := s.dir.Create(tmpFilename)
+	if err != nil {
+		return fmt.Errorf("couldn't create file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+
+		if err != nil {
+			_ = s.dir.Remove(tmpFilename)
+		}
+	}()
+
+	gzipWriter := gzip.NewWriter(f)
+
+	s.mu.RLock()
+	err = json.NewEncoder(gzipWriter).Encode(s.state)
+	s.mu.RUnlock()
+
+	if err != nil {
+		return fmt.Errorf("couldn't encode state: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return fmt.Errorf("couldn't close gzip writer: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("couldn't close file: %w", err)
+	}
+	if err := s.dir.Rename(tmpFilename, s.filename); err != nil {
+		return fmt.Errorf("couldn't rename file: %w", err)
+	}
+	return nil
+}
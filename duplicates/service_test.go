@@ -0,0 +1,151 @@
+package duplicates
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/ShoshinNikita/rview/search"
+	"github.com/ShoshinNikita/rview/thumbnails"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRclone struct {
+	hashes map[string]string
+}
+
+func (r fakeRclone) GetHashsums(context.Context) (string, map[string]string, error) {
+	return "md5", r.hashes, nil
+}
+
+type fakeFiles struct {
+	hits []search.Hit
+}
+
+func (f fakeFiles) GetFiles(context.Context, func(string) bool) ([]search.Hit, error) {
+	return f.hits, nil
+}
+
+type fakeThumbnailService struct {
+	images map[string]image.Image
+	opened map[string]int
+}
+
+func (fakeThumbnailService) CanGenerateThumbnail(rview.FileID) bool {
+	return true
+}
+
+func (s fakeThumbnailService) OpenThumbnail(
+	_ context.Context, id rview.FileID, _ thumbnails.ThumbnailSize,
+) (io.ReadCloser, string, error) {
+
+	s.opened[id.GetPath()]++
+
+	buf := bytes.NewBuffer(nil)
+	if err := png.Encode(buf, s.images[id.GetPath()]); err != nil {
+		return nil, "", err
+	}
+	return io.NopCloser(buf), "image/png", nil
+}
+
+func TestService_Refresh(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	hits := []search.Hit{
+		{Path: "/photos", IsDir: true},
+		{Path: "/photos/a.jpg", Size: 100, ModTime: 1},
+		{Path: "/backup/a.jpg", Size: 100, ModTime: 2},
+		{Path: "/photos/b.jpg", Size: 200, ModTime: 1},
+		{Path: "/photos/b-small.jpg", Size: 50, ModTime: 1},
+		{Path: "/photos/c.jpg", Size: 300, ModTime: 1},
+		{Path: "/docs/1.txt", Size: 10, ModTime: 1},
+		{Path: "/docs/2.txt", Size: 10, ModTime: 1},
+		{Path: "/docs/3.txt", Size: 20, ModTime: 1},
+	}
+	rclone := fakeRclone{
+		hashes: map[string]string{
+			"/photos/a.jpg":       "a",
+			"/backup/a.jpg":       "a",
+			"/photos/b.jpg":       "b",
+			"/photos/b-small.jpg": "b-small",
+			"/photos/c.jpg":       "c",
+			"/docs/1.txt":         "txt",
+			"/docs/2.txt":         "txt",
+			"/docs/3.txt":         "txt-3",
+		},
+	}
+	thumbnailService := fakeThumbnailService{
+		images: map[string]image.Image{
+			"/photos/a.jpg":       newGradientImage(100, 100, 0b0000_1111),
+			"/backup/a.jpg":       newGradientImage(100, 100, 0b0000_1111),
+			"/photos/b.jpg":       newGradientImage(100, 100, 0b1010_1010),
+			"/photos/b-small.jpg": newGradientImage(50, 50, 0b1010_1010),
+			"/photos/c.jpg":       newGradientImage(100, 100, 0b0101_0101),
+		},
+		opened: make(map[string]int),
+	}
+
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	s, err := NewService(rclone, fakeFiles{hits: hits}, thumbnailService, dirRoot)
+	r.NoError(err)
+
+	_, err = s.GetDuplicates()
+	r.ErrorIs(err, ErrNotReady)
+
+	r.NoError(s.Refresh(t.Context()))
+
+	res, err := s.GetDuplicates()
+	r.NoError(err)
+	r.Equal("md5", res.HashType)
+	r.Equal(
+		[]Group{
+			{
+				Kind: GroupKindIdentical,
+				Files: []File{
+					{Path: "/backup/a.jpg", Size: 100, ModTime: 2},
+					{Path: "/photos/a.jpg", Size: 100, ModTime: 1},
+				},
+			},
+			{
+				Kind: GroupKindSimilar,
+				Files: []File{
+					{Path: "/photos/b-small.jpg", Size: 50, ModTime: 1},
+					{Path: "/photos/b.jpg", Size: 200, ModTime: 1},
+				},
+			},
+			{
+				Kind: GroupKindIdentical,
+				Files: []File{
+					{Path: "/docs/1.txt", Size: 10, ModTime: 1},
+					{Path: "/docs/2.txt", Size: 10, ModTime: 1},
+				},
+			},
+		},
+		res.Groups,
+	)
+
+	// dHashes must be reused after restart.
+	s, err = NewService(rclone, fakeFiles{hits: hits}, thumbnailService, dirRoot)
+	r.NoError(err)
+	state, err := s.loadState()
+	r.NoError(err)
+	s.state = state
+
+	r.NoError(s.Refresh(t.Context()))
+	for path, count := range thumbnailService.opened {
+		r.Equal(1, count, path)
+	}
+
+	res2, err := s.GetDuplicates()
+	r.NoError(err)
+	r.Equal(res.Groups, res2.Groups)
+}
@@ -5,6 +5,7 @@ go 1.26
 require (
 	github.com/prometheus/client_golang v1.19.0
 	github.com/stretchr/testify v1.8.2
+	golang.org/x/crypto v0.48.0
 	golang.org/x/text v0.34.0
 )
 
@@ -17,7 +18,7 @@ require (
 	github.com/prometheus/client_model v0.6.1 // indirect
 	github.com/prometheus/common v0.52.3 // indirect
 	github.com/prometheus/procfs v0.13.0 // indirect
-	golang.org/x/sys v0.19.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/ShoshinNikita/rview/auth"
 	"github.com/ShoshinNikita/rview/cmd"
 	"github.com/ShoshinNikita/rview/pkg/rlog"
 	"github.com/ShoshinNikita/rview/rview"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		hashPassword()
+		return
+	}
+
 	cfg, err := rview.ParseConfig()
 	if err != nil {
 		rlog.Errorf("invalid config: %s", err)
@@ -61,3 +69,21 @@ func main() {
 
 	<-termCtx.Done()
 }
+
+// hashPassword reads a password from stdin and prints its hash for the users file.
+func hashPassword() {
+	fmt.Fprint(os.Stderr, "Password: ")
+
+	s := bufio.NewScanner(os.Stdin)
+	if !s.Scan() {
+		rlog.Errorf("couldn't read password: %v", s.Err())
+		os.Exit(1)
+	}
+
+	hash, err := auth.HashPassword(s.Text())
+	if err != nil {
+		rlog.Errorf("couldn't hash password: %s", err)
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+}
@@ -0,0 +1,174 @@
+package metadata
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// indexVersion must be increased every time new fields are added to [indexEntry]. Otherwise,
+// the index will contain entries without new fields.
+const indexVersion = 2
+
+// index contains the metadata that is required to sort and group files by capture time.
+// It is persisted on disk, so metadata of all files is not extracted on every start.
+type index struct {
+	dir      *os.Root
+	filename string
+
+	mu      sync.RWMutex
+	entries map[string]indexEntry // path -> entry
+	changed bool
+}
+
+type indexEntry struct {
+	ModTime int64 `json:"mod_time"`
+	Size    int64 `json:"size"`
+	// TakenAt is the unix time of capture, 0 means that it is unknown.
+	TakenAt int64 `json:"taken_at,omitempty"`
+}
+
+type indexFile struct {
+	Version int                   `json:"version"`
+	Entries map[string]indexEntry `json:"entries"`
+}
+
+func newIndex(dir *os.Root, filename string) *index {
+	return &index{
+		dir:      dir,
+		filename: filename,
+		entries:  make(map[string]indexEntry),
+	}
+}
+
+// get returns the index entry for the file. Entries of files with different mod time
+// or size are ignored.
+func (idx *index) get(id rview.FileID) (indexEntry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	entry, ok := idx.entries[id.GetPath()]
+	if !ok || entry.ModTime != id.GetModTime() || entry.Size != id.GetSize() {
+		return indexEntry{}, false
+	}
+	return entry, true
+}
+
+func (idx *index) set(id rview.FileID, m Metadata) {
+	entry := indexEntry{
+		ModTime: id.GetModTime(),
+		Size:    id.GetSize(),
+	}
+	if m.TakenAt != nil {
+		entry.TakenAt = m.TakenAt.Unix()
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.entries[id.GetPath()] != entry {
+		idx.entries[id.GetPath()] = entry
+		idx.changed = true
+	}
+}
+
+func (idx *index) load() error {
+	f, err := idx.dir.Open(idx.filename)
+	if err != nil {
+		return fmt.Errorf("couldn't open file: %w", err)
+	}
+	defer f.Close()
+
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	var file indexFile
+	if err := json.NewDecoder(gzipReader).Decode(&file); err != nil {
+		return fmt.Errorf("decode error: %w", err)
+	}
+	if file.Version != indexVersion {
+		return fmt.Errorf("index version is different: %d (file) != %d (expected)", file.Version, indexVersion)
+	}
+	if file.Entries == nil {
+		return errors.New("index is empty")
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.entries = file.Entries
+	idx.changed = false
+	return nil
+}
+
+// save writes the index to a temporary file and renames it, so the index file is never corrupted.
+// It does nothing if there were no changes since the last save.
+func (idx *index) save() (err error) {
+	idx.mu.RLock()
+	changed := idx.changed
+	idx.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	tmpFilename := idx.filename + ".tmp"
+	f, err := idx.dir.Create(tmpFilename)
+	if err != nil {
+		return fmt.Errorf("couldn't create file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+
+		if err != nil {
+			_ = idx.dir.Remove(tmpFilename)
+
+			// Try again next time.
+			idx.mu.Lock()
+			idx.changed = true
+			idx.mu.Unlock()
+		}
+	}()
+
+	gzipWriter := gzip.NewWriter(f)
+
+	// Hold the lock during encoding to reset "changed" flag safely.
+	idx.mu.Lock()
+	err = json.NewEncoder(gzipWriter).Encode(indexFile{
+		Version: indexVersion,
+		Entries: idx.entries,
+	})
+	if err == nil {
+		idx.changed = false
+	}
+	idx.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("couldn't encode index: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return fmt.Errorf("couldn't close gzip writer: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("couldn't close file: %w", err)
+	}
+	if err := idx.dir.Rename(tmpFilename, idx.filename); err != nil {
+		return fmt.Errorf("couldn't rename file: %w", err)
+	}
+	return nil
+}
+
+func (e indexEntry) getTakenAt() (time.Time, bool) {
+	if e.TakenAt == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(e.TakenAt, 0).UTC(), true
+}
@@ -0,0 +1,33 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+var ErrNoopMetadataService = errors.New("noop metadata service")
+
+type NoopService struct{}
+
+func NewNoopService() *NoopService {
+	return &NoopService{}
+}
+
+func (NoopService) CanExtractMetadata(rview.FileID) bool {
+	return false
+}
+
+func (NoopService) GetMetadata(context.Context, rview.FileID) (Metadata, error) {
+	return Metadata{}, ErrNoopMetadataService
+}
+
+func (NoopService) GetCaptureTimes([]rview.FileID) map[rview.FileID]time.Time {
+	return nil
+}
+
+func (NoopService) Shutdown(context.Context) error {
+	return nil
+}
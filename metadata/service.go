@@ -0,0 +1,455 @@
+// Package metadata extracts metadata of images: camera, lens, exposure, GPS coordinates and etc.
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// Metadata contains the most useful metadata of an image. All fields are optional.
+type Metadata struct {
+	Camera string `json:"camera,omitempty"`
+	Lens   string `json:"lens,omitempty"`
+	// ExposureTime is the exposure time in seconds.
+	ExposureTime float64 `json:"exposure_time,omitempty"`
+	FNumber      float64 `json:"f_number,omitempty"`
+	ISO          int     `json:"iso,omitempty"`
+	// FocalLength is the focal length in mm.
+	FocalLength float64 `json:"focal_length,omitempty"`
+	// Width and Height are the image dimensions with respect to the orientation.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// TakenAt is the capture date. Cameras often don't save the time zone. In such
+	// cases the local time of the camera is returned as UTC.
+	TakenAt *time.Time `json:"taken_at,omitempty"`
+	GPS     *GPS       `json:"gps,omitempty"`
+}
+
+type GPS struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Altitude is the altitude in meters above sea level.
+	Altitude *float64 `json:"altitude,omitempty"`
+}
+
+type Service struct {
+	rclone Rclone
+	cache  Cache
+	index  *index
+
+	extractFn func(ctx context.Context, id rview.FileID) (Metadata, error)
+
+	// queueCh contains files which metadata should be extracted in the background.
+	queueCh  chan rview.FileID
+	queued   map[rview.FileID]struct{}
+	queuedMu sync.Mutex
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stoppedCh chan struct{}
+}
+
+type Rclone interface {
+	RequestFileRange(ctx context.Context, id rview.FileID, rangeStart, rangeEnd int) (io.ReadCloser, error)
+}
+
+type Cache interface {
+	Open(id rview.FileID) (io.ReadCloser, error)
+	Write(id rview.FileID, r io.Reader) error
+}
+
+// CheckDeps checks that all external tools are installed.
+func CheckDeps() error {
+	if err := exec.CommandContext(context.Background(), "exiftool", "-ver").Run(); err != nil {
+		return fmt.Errorf("exiftool is not installed: %w", err)
+	}
+	return nil
+}
+
+// NewService prepares a new service for metadata extraction. Extracted metadata is saved
+// to the cache, so every file is processed only once. Capture times are also saved to
+// the index in dirRoot, see [Service.GetCaptureTimes].
+func NewService(rclone Rclone, cache Cache, dirRoot *os.Root) (*Service, error) {
+	// Don't use "metadata" subdirectory because it is managed by the disk cache.
+	err := dirRoot.Mkdir("metadata_index", 0700)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("couldn't create 'metadata_index' subdirectory: %w", err)
+	}
+	indexDirRoot, err := dirRoot.OpenRoot("metadata_index")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open root: %w", err)
+	}
+
+	idx := newIndex(indexDirRoot, "index.json.gz")
+	if err := idx.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		rlog.Warnf("couldn't load metadata index, prepare a new one: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Service{
+		rclone: rclone,
+		cache:  cache,
+		index:  idx,
+		//
+		queueCh: make(chan rview.FileID, 10_000),
+		queued:  make(map[rview.FileID]struct{}),
+		//
+		ctx:       ctx,
+		cancel:    cancel,
+		stoppedCh: make(chan struct{}),
+	}
+	s.extractFn = s.extractWithExifTool
+
+	go s.startBackgroundProcessing()
+
+	return s, nil
+}
+
+// startBackgroundProcessing extracts metadata of queued files and periodically saves the index.
+func (s *Service) startBackgroundProcessing() {
+	const (
+		workersCount = 2
+		saveInterval = time.Minute
+	)
+
+	var wg sync.WaitGroup
+	for range workersCount {
+		wg.Go(func() {
+			for {
+				select {
+				case <-s.ctx.Done():
+					return
+				case id := <-s.queueCh:
+					ctx, cancel := context.WithTimeout(s.ctx, time.Minute)
+					_, err := s.GetMetadata(ctx, id)
+					cancel()
+					if err != nil && s.ctx.Err() == nil {
+						rlog.Warnf("couldn't extract metadata from %q in the background: %s", id.GetPath(), err)
+
+						// Don't try to process the broken file again.
+						s.index.set(id, Metadata{})
+					}
+
+					s.queuedMu.Lock()
+					delete(s.queued, id)
+					s.queuedMu.Unlock()
+				}
+			}
+		})
+	}
+
+	wg.Go(func() {
+		ticker := time.NewTicker(saveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.index.save(); err != nil {
+					rlog.Errorf("couldn't save metadata index: %s", err)
+				}
+			}
+		}
+	})
+
+	wg.Wait()
+	close(s.stoppedCh)
+}
+
+// Shutdown stops the background processing and saves the index.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.cancel()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.stoppedCh:
+	}
+
+	if err := s.index.save(); err != nil {
+		return fmt.Errorf("couldn't save metadata index: %w", err)
+	}
+	return nil
+}
+
+// CanExtractMetadata detects if we can extract metadata from a file based on its filename.
+func (*Service) CanExtractMetadata(id rview.FileID) bool {
+	switch rview.GetFileType(id.GetExt()) {
+	case rview.FileTypeImage, rview.FileTypeRawImage:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetMetadata returns the metadata of a file. It extracts metadata if needed.
+func (s *Service) GetMetadata(ctx context.Context, id rview.FileID) (Metadata, error) {
+	if !s.CanExtractMetadata(id) {
+		return Metadata{}, fmt.Errorf("can't extract metadata from %q", id.GetExt())
+	}
+	if fileSize := id.GetSize(); fileSize <= 0 {
+		return Metadata{}, fmt.Errorf("file id has invalid size: %d", fileSize)
+	}
+
+	cacheID := newCacheID(id)
+
+	if rc, err := s.cache.Open(cacheID); err == nil {
+		defer rc.Close()
+
+		var m Metadata
+		if err := json.NewDecoder(rc).Decode(&m); err == nil {
+			s.index.set(id, m)
+			return m, nil
+		}
+		rlog.Warnf("couldn't decode cached metadata for %q: %s", id.GetPath(), err)
+	}
+
+	m, err := s.extractFn(ctx, id)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("couldn't marshal metadata: %w", err)
+	}
+	if err := s.cache.Write(cacheID, bytes.NewReader(data)); err != nil {
+		return Metadata{}, fmt.Errorf("couldn't write metadata to the cache: %w", err)
+	}
+	s.index.set(id, m)
+
+	return m, nil
+}
+
+// GetCaptureTimes returns capture times of files from the index. Files that are missing
+// from the index are queued for metadata extraction in the background - so, the next call
+// will likely return their capture times. Files without capture times are not included.
+func (s *Service) GetCaptureTimes(ids []rview.FileID) map[rview.FileID]time.Time {
+	res := make(map[rview.FileID]time.Time)
+	for _, id := range ids {
+		if !s.CanExtractMetadata(id) || id.GetSize() <= 0 {
+			continue
+		}
+
+		entry, ok := s.index.get(id)
+		if !ok {
+			s.enqueue(id)
+			continue
+		}
+		if takenAt, ok := entry.getTakenAt(); ok {
+			res[id] = takenAt
+		}
+	}
+	return res
+}
+
+func (s *Service) enqueue(id rview.FileID) {
+	s.queuedMu.Lock()
+	defer s.queuedMu.Unlock()
+
+	if _, ok := s.queued[id]; ok {
+		return
+	}
+
+	select {
+	case s.queueCh <- id:
+		s.queued[id] = struct{}{}
+	default:
+		// The queue is full, the file will be queued next time.
+	}
+}
+
+// newCacheID returns the id of the metadata file. It keeps mod time and size of the
+// original file, so metadata is extracted again after the file change.
+func newCacheID(id rview.FileID) rview.FileID {
+	return rview.NewFileID(id.GetPath()+".metadata.json", id.GetModTime(), id.GetSize())
+}
+
+// headerSize is the number of bytes from the beginning of a file that are sent to exiftool.
+// All supported formats, including RAW files, store metadata at the beginning.
+const headerSize = 512 << 10 // 512 KiB
+
+func (s *Service) extractWithExifTool(ctx context.Context, id rview.FileID) (Metadata, error) {
+	end := min(id.GetSize(), headerSize) - 1
+	rc, err := s.rclone.RequestFileRange(ctx, id, 0, int(end))
+	if err != nil {
+		return Metadata{}, fmt.Errorf("couldn't request file header: %w", err)
+	}
+	defer rc.Close()
+
+	stderr := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(
+		ctx, "exiftool",
+		"-json",
+		"-n", // print numbers instead of human-readable values
+		"-fast",
+		"-Make", "-Model", "-LensModel", "-Lens",
+		"-ExposureTime", "-FNumber", "-ISO", "-FocalLength",
+		"-ImageWidth", "-ImageHeight", "-Orientation",
+		"-DateTimeOriginal", "-CreateDate", "-OffsetTimeOriginal",
+		// Composite tags take into account GPSLatitudeRef, GPSLongitudeRef and GPSAltitudeRef.
+		"-Composite:GPSLatitude", "-Composite:GPSLongitude", "-Composite:GPSAltitude",
+		"-",
+	)
+	cmd.Stdin = rc
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return Metadata{}, fmt.Errorf("exiftool failed: %w, stderr: %q", err, stderr.String())
+	}
+	return parseExifToolOutput(output)
+}
+
+//nolint:tagliatelle
+type exifToolResult struct {
+	Make               exifString  `json:"Make"`
+	Model              exifString  `json:"Model"`
+	LensModel          exifString  `json:"LensModel"`
+	Lens               exifString  `json:"Lens"`
+	ExposureTime       exifNumber  `json:"ExposureTime"`
+	FNumber            exifNumber  `json:"FNumber"`
+	ISO                exifNumber  `json:"ISO"`
+	FocalLength        exifNumber  `json:"FocalLength"`
+	ImageWidth         exifNumber  `json:"ImageWidth"`
+	ImageHeight        exifNumber  `json:"ImageHeight"`
+	Orientation        exifNumber  `json:"Orientation"`
+	DateTimeOriginal   exifString  `json:"DateTimeOriginal"`
+	CreateDate         exifString  `json:"CreateDate"`
+	OffsetTimeOriginal exifString  `json:"OffsetTimeOriginal"`
+	GPSLatitude        *exifNumber `json:"GPSLatitude"`
+	GPSLongitude       *exifNumber `json:"GPSLongitude"`
+	GPSAltitude        *exifNumber `json:"GPSAltitude"`
+}
+
+func parseExifToolOutput(output []byte) (Metadata, error) {
+	var resp []exifToolResult
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return Metadata{}, fmt.Errorf("couldn't decode exiftool output: %w", err)
+	}
+	if len(resp) != 1 {
+		return Metadata{}, fmt.Errorf("wrong number of items in exiftool output: expected 1, got %d", len(resp))
+	}
+	res := resp[0]
+
+	m := Metadata{
+		Camera:       joinMakeAndModel(string(res.Make), string(res.Model)),
+		Lens:         strings.TrimSpace(string(res.LensModel)),
+		ExposureTime: float64(res.ExposureTime),
+		FNumber:      float64(res.FNumber),
+		ISO:          int(res.ISO),
+		FocalLength:  float64(res.FocalLength),
+		Width:        int(res.ImageWidth),
+		Height:       int(res.ImageHeight),
+	}
+	if m.Lens == "" {
+		m.Lens = strings.TrimSpace(string(res.Lens))
+	}
+
+	// Orientations 5-8 mean that the image is rotated by 90 or 270 degrees.
+	if res.Orientation >= 5 && res.Orientation <= 8 {
+		m.Width, m.Height = m.Height, m.Width
+	}
+
+	for _, date := range []exifString{res.DateTimeOriginal, res.CreateDate} {
+		if t, ok := parseExifDate(string(date), string(res.OffsetTimeOriginal)); ok {
+			m.TakenAt = &t
+			break
+		}
+	}
+
+	if res.GPSLatitude != nil && res.GPSLongitude != nil {
+		m.GPS = &GPS{
+			Latitude:  float64(*res.GPSLatitude),
+			Longitude: float64(*res.GPSLongitude),
+		}
+		if res.GPSAltitude != nil {
+			altitude := float64(*res.GPSAltitude)
+			m.GPS.Altitude = &altitude
+		}
+	}
+	return m, nil
+}
+
+// joinMakeAndModel joins make and model of a camera. Many cameras already include
+// the make into the model: "Canon" + "Canon EOS R5".
+func joinMakeAndModel(cameraMake, model string) string {
+	cameraMake = strings.TrimSpace(cameraMake)
+	model = strings.TrimSpace(model)
+
+	switch {
+	case cameraMake == "":
+		return model
+	case model == "":
+		return cameraMake
+	case strings.HasPrefix(strings.ToLower(model), strings.ToLower(cameraMake)):
+		return model
+	default:
+		return cameraMake + " " + model
+	}
+}
+
+// parseExifDate parses dates in the exif format: "2006:01:02 15:04:05" with optional sub-seconds
+// and time zone. offset is used only if the date doesn't have a time zone.
+func parseExifDate(date, offset string) (time.Time, bool) {
+	date = strings.TrimSpace(date)
+	if date == "" || strings.HasPrefix(date, "0000") {
+		return time.Time{}, false
+	}
+	if offset != "" && len(date) == len("2006:01:02 15:04:05") {
+		date += offset
+	}
+
+	for _, layout := range []string{
+		"2006:01:02 15:04:05Z07:00",
+		"2006:01:02 15:04:05.999999999Z07:00",
+		"2006:01:02 15:04:05",
+		"2006:01:02 15:04:05.999999999",
+	} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// exifString is a string that can be represented as a number in exiftool output.
+// For example, exiftool returns model "5" as a number.
+type exifString string
+
+func (s *exifString) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		return json.Unmarshal(data, (*string)(s))
+	}
+	*s = exifString(data)
+	return nil
+}
+
+// exifNumber is a number that can be represented as a string in exiftool output. Invalid
+// values (for example, "undef" or "inf") are ignored.
+type exifNumber float64
+
+func (n *exifNumber) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	v, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		rlog.Debugf("invalid number in exiftool output: %q", data)
+		return nil
+	}
+	*n = exifNumber(v)
+	return nil
+}
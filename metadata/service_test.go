@@ -0,0 +1,218 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/cache"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_GetMetadata(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	var calls int
+	service := newTestService(t)
+	service.extractFn = func(_ context.Context, id rview.FileID) (Metadata, error) {
+		calls++
+		if id.GetPath() == "/broken.jpg" {
+			return Metadata{}, errors.New("exiftool failed")
+		}
+		return Metadata{Camera: "Canon EOS R5", ISO: 100}, nil
+	}
+
+	id := rview.NewFileID("/photo.jpg", time.Now().Unix(), 100)
+	for range 3 {
+		m, err := service.GetMetadata(t.Context(), id)
+		r.NoError(err)
+		r.Equal(Metadata{Camera: "Canon EOS R5", ISO: 100}, m)
+	}
+	r.Equal(1, calls)
+
+	// Metadata is extracted again after the file change.
+	_, err := service.GetMetadata(t.Context(), rview.NewFileID("/photo.jpg", time.Now().Unix(), 101))
+	r.NoError(err)
+	r.Equal(2, calls)
+
+	// Errors are not cached.
+	for range 2 {
+		_, err = service.GetMetadata(t.Context(), rview.NewFileID("/broken.jpg", time.Now().Unix(), 100))
+		r.Error(err)
+	}
+	r.Equal(4, calls)
+
+	// Unsupported files.
+	_, err = service.GetMetadata(t.Context(), rview.NewFileID("/a.txt", time.Now().Unix(), 100))
+	r.Error(err)
+	r.False(service.CanExtractMetadata(rview.NewFileID("/a.txt", 0, 0)))
+	r.True(service.CanExtractMetadata(rview.NewFileID("/a.NEF", 0, 0)))
+	r.Equal(4, calls)
+}
+
+func TestService_GetCaptureTimes(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	takenAt := time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC)
+
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	r.NoError(err)
+	service, err := NewService(nil, cache.NewInMemoryCache(), root)
+	r.NoError(err)
+	service.extractFn = func(_ context.Context, id rview.FileID) (Metadata, error) {
+		if id.GetPath() == "/no-date.jpg" {
+			return Metadata{}, nil
+		}
+		return Metadata{TakenAt: &takenAt}, nil
+	}
+
+	ids := []rview.FileID{
+		rview.NewFileID("/a.jpg", 1, 100),
+		rview.NewFileID("/no-date.jpg", 1, 100),
+		rview.NewFileID("/b.txt", 1, 100),
+	}
+
+	// Capture times are extracted in the background.
+	r.Empty(service.GetCaptureTimes(ids))
+	r.Eventually(func() bool {
+		return len(service.GetCaptureTimes(ids)) == 1
+	}, time.Second, 10*time.Millisecond)
+	r.Equal(map[rview.FileID]time.Time{ids[0]: takenAt}, service.GetCaptureTimes(ids))
+
+	// Index is saved on shutdown and loaded on start.
+	r.NoError(service.Shutdown(t.Context()))
+
+	root, err = os.OpenRoot(dir)
+	r.NoError(err)
+	service, err = NewService(nil, cache.NewInMemoryCache(), root)
+	r.NoError(err)
+	t.Cleanup(func() { _ = service.Shutdown(context.Background()) })
+	service.extractFn = func(context.Context, rview.FileID) (Metadata, error) {
+		panic("metadata must be loaded from the index")
+	}
+	r.Equal(map[rview.FileID]time.Time{ids[0]: takenAt}, service.GetCaptureTimes(ids))
+
+	// Changed files are not loaded from the index.
+	_, ok := service.index.get(rview.NewFileID("/a.jpg", 2, 100))
+	r.False(ok)
+}
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	root, err := os.OpenRoot(t.TempDir())
+	require.NoError(t, err)
+
+	service, err := NewService(nil, cache.NewInMemoryCache(), root)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = service.Shutdown(context.Background()) })
+
+	return service
+}
+
+func TestParseExifToolOutput(t *testing.T) {
+	t.Parallel()
+
+	floatPtr := func(v float64) *float64 { return &v }
+	timePtr := func(v time.Time) *time.Time { return &v }
+
+	for _, tt := range []struct {
+		name   string
+		output string
+		want   Metadata
+	}{
+		{
+			name: "full",
+			output: `[{
+				"SourceFile": "-",
+				"Make": "Canon",
+				"Model": "Canon EOS R5",
+				"LensModel": "RF24-105mm F4 L IS USM",
+				"ExposureTime": 0.004,
+				"FNumber": 5.6,
+				"ISO": 200,
+				"FocalLength": 35,
+				"ImageWidth": 8192,
+				"ImageHeight": 5464,
+				"Orientation": 1,
+				"DateTimeOriginal": "2024:05:01 14:30:15",
+				"OffsetTimeOriginal": "+02:00",
+				"GPSLatitude": 48.8584,
+				"GPSLongitude": -2.2945,
+				"GPSAltitude": -12.5
+			}]`,
+			want: Metadata{
+				Camera:       "Canon EOS R5",
+				Lens:         "RF24-105mm F4 L IS USM",
+				ExposureTime: 0.004,
+				FNumber:      5.6,
+				ISO:          200,
+				FocalLength:  35,
+				Width:        8192,
+				Height:       5464,
+				TakenAt:      timePtr(time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC)),
+				GPS:          &GPS{Latitude: 48.8584, Longitude: -2.2945, Altitude: floatPtr(-12.5)},
+			},
+		},
+		{
+			name: "rotated image without time zone",
+			output: `[{
+				"Make": "SONY",
+				"Model": "ILCE-7M3",
+				"Lens": "FE 50mm F1.8",
+				"ImageWidth": 6000,
+				"ImageHeight": 4000,
+				"Orientation": 6,
+				"DateTimeOriginal": "0000:00:00 00:00:00",
+				"CreateDate": "2023:12:31 23:59:59.5"
+			}]`,
+			want: Metadata{
+				Camera:  "SONY ILCE-7M3",
+				Lens:    "FE 50mm F1.8",
+				Width:   4000,
+				Height:  6000,
+				TakenAt: timePtr(time.Date(2023, 12, 31, 23, 59, 59, 5e8, time.UTC)),
+			},
+		},
+		{
+			name: "invalid values",
+			output: `[{
+				"Model": 5,
+				"ISO": "undef",
+				"FNumber": "2.8",
+				"GPSLatitude": 10
+			}]`,
+			want: Metadata{
+				Camera:  "5",
+				FNumber: 2.8,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := require.New(t)
+
+			got, err := parseExifToolOutput([]byte(tt.output))
+			r.NoError(err)
+
+			if tt.want.TakenAt != nil {
+				r.NotNil(got.TakenAt)
+				r.True(tt.want.TakenAt.Equal(*got.TakenAt), "got %s", got.TakenAt)
+				tt.want.TakenAt, got.TakenAt = nil, nil
+			}
+			r.Equal(tt.want, got)
+		})
+	}
+
+	_, err := parseExifToolOutput([]byte(`[]`))
+	require.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package highlight
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Supported text encodings.
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingWindows1252 = "windows-1252"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// IsSupportedEncoding reports whether the encoding is supported by [Decode].
+func IsSupportedEncoding(encoding string) bool {
+	switch encoding {
+	case EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingWindows1252:
+		return true
+	}
+	return false
+}
+
+// DetectEncoding detects the encoding of the text by BOM or by its content. Text that
+// is not valid UTF-8 or UTF-16 is treated as Windows-1252, the most common legacy encoding.
+// It also returns the size of BOM that should be skipped.
+func DetectEncoding(data []byte) (encoding string, bomSize int) {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return EncodingUTF8, len(bomUTF8)
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return EncodingUTF16LE, len(bomUTF16LE)
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return EncodingUTF16BE, len(bomUTF16BE)
+	}
+
+	// UTF-16 text without BOM usually contains many zero bytes: every ASCII character
+	// is encoded with a zero byte. Such text is valid UTF-8, so it must be checked first.
+	sample := data[:min(len(data), 4<<10)]
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenZeros++
+		} else {
+			oddZeros++
+		}
+	}
+	threshold := len(sample) / 4
+	switch {
+	case oddZeros > threshold && evenZeros <= oddZeros/10:
+		return EncodingUTF16LE, 0
+	case evenZeros > threshold && oddZeros <= evenZeros/10:
+		return EncodingUTF16BE, 0
+	}
+
+	// The data can end in the middle of a rune.
+	if utf8.Valid(data[:len(data)-incompleteUTF8Suffix(data)]) {
+		return EncodingUTF8, 0
+	}
+
+	return EncodingWindows1252, 0
+}
+
+// Decode converts the text to UTF-8. An incomplete character at the end of the data is not
+// decoded, the number of decoded bytes is returned. Invalid characters are replaced with U+FFFD.
+func Decode(data []byte, encoding string) (text string, n int) {
+	switch encoding {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		n = len(data) - len(data)%2
+
+		units := make([]uint16, 0, n/2)
+		for i := 0; i < n; i += 2 {
+			if encoding == EncodingUTF16LE {
+				units = append(units, uint16(data[i])|uint16(data[i+1])<<8)
+			} else {
+				units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+			}
+		}
+		// Don't split a surrogate pair.
+		if len(units) > 0 && utf16.IsSurrogate(rune(units[len(units)-1])) && units[len(units)-1] < 0xDC00 {
+			units = units[:len(units)-1]
+			n -= 2
+		}
+		return string(utf16.Decode(units)), n
+
+	case EncodingWindows1252:
+		var b strings.Builder
+		b.Grow(len(data))
+		for _, c := range data {
+			if 0x80 <= c && c < 0xA0 {
+				b.WriteRune(windows1252[c-0x80])
+			} else {
+				// Other characters match Unicode code points.
+				b.WriteRune(rune(c))
+			}
+		}
+		return b.String(), len(data)
+
+	default:
+		n = len(data) - incompleteUTF8Suffix(data)
+		return strings.ToValidUTF8(string(data[:n]), string(utf8.RuneError)), n
+	}
+}
+
+// LastLineEnd returns the size of the data up to the last line break (inclusive). It returns
+// 0 if there are no line breaks.
+func LastLineEnd(data []byte, encoding string) int {
+	switch encoding {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		newline := []byte{'\n', 0}
+		if encoding == EncodingUTF16BE {
+			newline = []byte{0, '\n'}
+		}
+		for i := len(data) - len(data)%2 - 2; i >= 0; i -= 2 {
+			if bytes.Equal(data[i:i+2], newline) {
+				return i + 2
+			}
+		}
+		return 0
+
+	default:
+		return bytes.LastIndexByte(data, '\n') + 1
+	}
+}
+
+// incompleteUTF8Suffix returns the size of an incomplete rune at the end of the data.
+func incompleteUTF8Suffix(data []byte) int {
+	for i := 1; i <= min(len(data), utf8.UTFMax-1); i++ {
+		c := data[len(data)-i]
+		if !utf8.RuneStart(c) {
+			continue
+		}
+		if c >= utf8.RuneSelf && !utf8.FullRune(data[len(data)-i:]) {
+			return i
+		}
+		return 0
+	}
+	return 0
+}
+
+// windows1252 contains characters for bytes 0x80-0x9F. Undefined bytes are mapped to
+// the corresponding control characters.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
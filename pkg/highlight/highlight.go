@@ -0,0 +1,233 @@
+// Package highlight provides a simple syntax highlighter for text previews.
+//
+// The highlighter is not a parser: it recognizes only comments, strings, numbers, keywords
+// and markup tags. It is good enough for previews and doesn't require any dependencies.
+package highlight
+
+import (
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CSS classes of highlighted tokens.
+const (
+	classComment = "hl-comment"
+	classString  = "hl-string"
+	classNumber  = "hl-number"
+	classKeyword = "hl-keyword"
+	classTag     = "hl-tag"
+)
+
+// Highlight returns highlighted text as HTML. Every line is wrapped in <span class="line">,
+// its number is stored in "data-line" attribute. Unknown languages are not highlighted,
+// but lines are still numbered.
+//
+// Text is highlighted from scratch, so the result can be incorrect if the text starts
+// in the middle of a multiline comment or string.
+func Highlight(text string, language string, firstLine int) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return ""
+	}
+
+	w := &htmlWriter{line: firstLine}
+	w.startLine()
+
+	t := &tokenizer{lang: languages[language]}
+	t.tokenize(text, w.write)
+
+	w.endLine()
+
+	return w.b.String()
+}
+
+type htmlWriter struct {
+	b    strings.Builder
+	line int
+}
+
+func (w *htmlWriter) startLine() {
+	w.b.WriteString(`<span class="line" data-line="`)
+	w.b.WriteString(strconv.Itoa(w.line))
+	w.b.WriteString(`">`)
+	w.line++
+}
+
+func (w *htmlWriter) endLine() {
+	w.b.WriteString("</span>\n")
+}
+
+// write writes the token. Tokens can span multiple lines, so they are split to keep
+// line elements valid.
+func (w *htmlWriter) write(class string, s string) {
+	for i, part := range strings.Split(s, "\n") {
+		if i > 0 {
+			w.endLine()
+			w.startLine()
+		}
+		if part == "" {
+			continue
+		}
+		if class != "" {
+			w.b.WriteString(`<span class="` + class + `">`)
+		}
+		w.b.WriteString(html.EscapeString(part))
+		if class != "" {
+			w.b.WriteString(`</span>`)
+		}
+	}
+}
+
+type tokenizer struct {
+	lang *language
+
+	// inTag indicates whether the tokenizer is inside a markup tag.
+	inTag bool
+}
+
+// tokenize splits the text into tokens. Plain text is passed with an empty class.
+func (t *tokenizer) tokenize(text string, emit func(class, s string)) {
+	if t.lang == nil {
+		emit("", text)
+		return
+	}
+
+	var plainStart int
+	for i := 0; i < len(text); {
+		end, class := t.next(text, i)
+		if class == "" {
+			i = max(end, i+1)
+			continue
+		}
+
+		if plainStart < i {
+			emit("", text[plainStart:i])
+		}
+		emit(class, text[i:end])
+		i, plainStart = end, end
+	}
+	if plainStart < len(text) {
+		emit("", text[plainStart:])
+	}
+}
+
+// next returns the end and the class of the token that starts at i. The class is empty for
+// plain text.
+func (t *tokenizer) next(text string, i int) (end int, class string) {
+	rest := text[i:]
+	lang := t.lang
+
+	for _, c := range lang.blockComments {
+		if strings.HasPrefix(rest, c[0]) {
+			return i + scanUntil(rest, len(c[0]), c[1], true), classComment
+		}
+	}
+	for _, prefix := range lang.lineComments {
+		if strings.HasPrefix(rest, prefix) {
+			return i + scanUntil(rest, len(prefix), "\n", false), classComment
+		}
+	}
+
+	if lang.markup {
+		return t.nextMarkup(text, i)
+	}
+
+	if end, ok := scanString(lang, rest); ok {
+		return i + end, classString
+	}
+
+	c := text[i]
+	switch {
+	case isDigit(c):
+		end := i + 1
+		for end < len(text) && (isIdentChar(text[end]) || text[end] == '.') {
+			end++
+		}
+		return end, classNumber
+
+	case isIdentChar(c):
+		end := i + 1
+		for end < len(text) && isIdentChar(text[end]) {
+			end++
+		}
+		if lang.isKeyword(text[i:end]) {
+			return end, classKeyword
+		}
+		return end, ""
+	}
+	return i + 1, ""
+}
+
+// nextMarkup is like [tokenizer.next], but for markup languages: only tags and strings
+// inside tags are highlighted.
+func (t *tokenizer) nextMarkup(text string, i int) (end int, class string) {
+	rest := text[i:]
+
+	if !t.inTag {
+		if len(rest) > 1 && rest[0] == '<' && (isIdentChar(rest[1]) || strings.ContainsRune("/!?", rune(rest[1]))) {
+			t.inTag = true
+
+			end := 2
+			for end < len(rest) && (isIdentChar(rest[end]) || strings.ContainsRune(":-", rune(rest[end]))) {
+				end++
+			}
+			return i + end, classTag
+		}
+		// Skip text until the next tag.
+		if next := strings.IndexByte(rest[1:], '<'); next != -1 {
+			return i + 1 + next, ""
+		}
+		return len(text), ""
+	}
+
+	for _, closing := range []string{"/>", "?>", ">"} {
+		if strings.HasPrefix(rest, closing) {
+			t.inTag = false
+			return i + len(closing), classTag
+		}
+	}
+	if end, ok := scanString(t.lang, rest); ok {
+		return i + end, classString
+	}
+	return i + 1, ""
+}
+
+// scanString returns the end of the string that starts at the beginning of the text.
+func scanString(lang *language, text string) (end int, ok bool) {
+	for _, delim := range lang.strings {
+		if strings.HasPrefix(text, delim) {
+			multiline := slices.Contains(lang.multilineStrings, delim)
+			return scanUntil(text, len(delim), delim, multiline), true
+		}
+	}
+	return 0, false
+}
+
+// scanUntil returns the end of the token that ends with the passed suffix. Backslashes escape
+// the next character. Single-line tokens end before the line break.
+func scanUntil(text string, start int, suffix string, multiline bool) int {
+	for i := start; i < len(text); i++ {
+		switch {
+		case text[i] == '\n' && !multiline:
+			return i
+		case strings.HasPrefix(text[i:], suffix):
+			return i + len(suffix)
+		case text[i] == '\\' && suffix != "\n" && i+1 < len(text) && text[i+1] != '\n':
+			i++
+		}
+	}
+	return len(text)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// isIdentChar reports whether the character can be a part of an identifier. All non-ASCII
+// characters are treated as identifier characters to not split multibyte runes.
+func isIdentChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '_' || c == '$' || c >= 0x80
+}
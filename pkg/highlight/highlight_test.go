@@ -0,0 +1,173 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHighlight(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name      string
+		text      string
+		language  string
+		firstLine int
+		want      []string
+	}{
+		{
+			name:      "go",
+			text:      "package main\n\n// main <is> \"main\"\nfunc main() {\n\ts := `a\nb` + \"x\\\"y\" + 'c'\n\treturn 0x10\n}\n",
+			language:  "go",
+			firstLine: 1,
+			want: []string{
+				`<span class="line" data-line="1"><span class="hl-keyword">package</span> main</span>`,
+				`<span class="line" data-line="2"></span>`,
+				`<span class="line" data-line="3"><span class="hl-comment">// main &lt;is&gt; &#34;main&#34;</span></span>`,
+				`<span class="line" data-line="4"><span class="hl-keyword">func</span> main() {</span>`,
+				`<span class="line" data-line="5">	s := <span class="hl-string">` + "`a</span></span>",
+				`<span class="line" data-line="6"><span class="hl-string">b` + "`</span> + " + `<span class="hl-string">&#34;x\&#34;y&#34;</span> + <span class="hl-string">&#39;c&#39;</span></span>`,
+				`<span class="line" data-line="7">	<span class="hl-keyword">return</span> <span class="hl-number">0x10</span></span>`,
+				`<span class="line" data-line="8">}</span>`,
+			},
+		},
+		{
+			name:      "unterminated string",
+			text:      "x = \"abc\r\ny = 1 # 2",
+			language:  "python",
+			firstLine: 10,
+			want: []string{
+				`<span class="line" data-line="10">x = <span class="hl-string">&#34;abc</span></span>`,
+				`<span class="line" data-line="11">y = <span class="hl-number">1</span> <span class="hl-comment"># 2</span></span>`,
+			},
+		},
+		{
+			name:      "ignore case",
+			text:      "SELECT id FROM users; -- comment",
+			language:  "sql",
+			firstLine: 1,
+			want: []string{
+				`<span class="line" data-line="1"><span class="hl-keyword">SELECT</span> id <span class="hl-keyword">FROM</span> users; <span class="hl-comment">-- comment</span></span>`,
+			},
+		},
+		{
+			name:      "markup",
+			text:      "<!-- x -->\n<a href=\"/\">it's</a>",
+			language:  "html",
+			firstLine: 1,
+			want: []string{
+				`<span class="line" data-line="1"><span class="hl-comment">&lt;!-- x --&gt;</span></span>`,
+				`<span class="line" data-line="2"><span class="hl-tag">&lt;a</span> href=<span class="hl-string">&#34;/&#34;</span><span class="hl-tag">&gt;</span>it&#39;s<span class="hl-tag">&lt;/a</span><span class="hl-tag">&gt;</span></span>`,
+			},
+		},
+		{
+			name:      "unknown language",
+			text:      "<b>\nfunc",
+			language:  "",
+			firstLine: 1,
+			want: []string{
+				`<span class="line" data-line="1">&lt;b&gt;</span>`,
+				`<span class="line" data-line="2">func</span>`,
+			},
+		},
+		{
+			name:      "empty",
+			text:      "",
+			language:  "go",
+			firstLine: 1,
+			want:      nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Highlight(tt.text, tt.language, tt.firstLine)
+
+			var want string
+			if tt.want != nil {
+				want = strings.Join(tt.want, "\n") + "\n"
+			}
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	for name, lang := range languages {
+		for _, delim := range lang.multilineStrings {
+			r.Contains(lang.strings, delim, name)
+		}
+		for i := 1; i < len(lang.strings); i++ {
+			r.GreaterOrEqual(len(lang.strings[i-1]), len(lang.strings[i]), name)
+		}
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	for _, tt := range []struct {
+		data        string
+		wantEnc     string
+		wantBOMSize int
+	}{
+		{"hello", EncodingUTF8, 0},
+		{"\xEF\xBB\xBFhello", EncodingUTF8, 3},
+		{"привет"[:5], EncodingUTF8, 0}, // incomplete rune at the end
+		{"\xFF\xFEh\x00i\x00", EncodingUTF16LE, 2},
+		{"\xFE\xFF\x00h\x00i", EncodingUTF16BE, 2},
+		{"h\x00e\x00l\x00l\x00o\x00", EncodingUTF16LE, 0},
+		{"\x00h\x00e\x00l\x00l\x00o", EncodingUTF16BE, 0},
+		{"caf\xe9 \x93quoted\x94", EncodingWindows1252, 0},
+	} {
+		enc, bomSize := DetectEncoding([]byte(tt.data))
+		r.Equal(tt.wantEnc, enc, tt.data)
+		r.Equal(tt.wantBOMSize, bomSize, tt.data)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	for _, tt := range []struct {
+		data     string
+		encoding string
+		wantText string
+		wantN    int
+	}{
+		{"hello", EncodingUTF8, "hello", 5},
+		{"при", EncodingUTF8, "при", 6},
+		{"при"[:5], EncodingUTF8, "пр", 4},
+		{"a\xffb", EncodingUTF8, "a�b", 3},
+		{"h\x00i\x00!", EncodingUTF16LE, "hi", 4},
+		{"\x00h\x00i", EncodingUTF16BE, "hi", 4},
+		{"a\x00\x3D\xD8\x00\xDE", EncodingUTF16LE, "a😀", 6},
+		{"a\x00\x3D\xD8", EncodingUTF16LE, "a", 2}, // incomplete surrogate pair
+		{"caf\xe9 \x93quoted\x94 \x80", EncodingWindows1252, "café “quoted” €", 15},
+	} {
+		text, n := Decode([]byte(tt.data), tt.encoding)
+		r.Equal(tt.wantText, text, tt.data)
+		r.Equal(tt.wantN, n, tt.data)
+	}
+}
+
+func TestLastLineEnd(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	r.Equal(4, LastLineEnd([]byte("a\nb\nc"), EncodingUTF8))
+	r.Equal(0, LastLineEnd([]byte("abc"), EncodingUTF8))
+	r.Equal(4, LastLineEnd([]byte("a\x00\n\x00b\x00"), EncodingUTF16LE))
+	r.Equal(4, LastLineEnd([]byte("\x00a\x00\n\x00b"), EncodingUTF16BE))
+	// '\n' must be aligned to 2 bytes.
+	r.Equal(0, LastLineEnd([]byte("\x0A\x0A\x00"), EncodingUTF16BE))
+}
@@ -0,0 +1,239 @@
+package highlight
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+type language struct {
+	lineComments  []string
+	blockComments [][2]string
+
+	// strings contains string delimiters. Strings end at the end of the line unless
+	// the delimiter is in multilineStrings.
+	strings          []string
+	multilineStrings []string
+
+	keywords   map[string]bool
+	ignoreCase bool
+
+	// markup languages have only tags, strings and comments.
+	markup bool
+}
+
+func (l *language) isKeyword(word string) bool {
+	if l.ignoreCase {
+		word = strings.ToLower(word)
+	}
+	return l.keywords[word]
+}
+
+// newLanguage creates a new language. Keywords are separated by spaces.
+func newLanguage(l language, keywords string) *language {
+	l.keywords = make(map[string]bool)
+	for word := range strings.FieldsSeq(keywords) {
+		if l.ignoreCase {
+			word = strings.ToLower(word)
+		}
+		l.keywords[word] = true
+	}
+
+	// Check longer delimiters first: """ must be matched before ".
+	l.strings = slices.Clone(l.strings)
+	slices.SortStableFunc(l.strings, func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+
+	return &l
+}
+
+var (
+	cStyleComments = language{
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+	}
+	hashComments = language{
+		lineComments: []string{"#"},
+	}
+)
+
+func withStrings(l language, delims []string, multiline ...string) language {
+	l.strings = delims
+	l.multilineStrings = multiline
+	return l
+}
+
+// languages contains supported languages by name, see [rview.GetLanguage].
+var languages = map[string]*language{
+	"go": newLanguage(
+		withStrings(cStyleComments, []string{`"`, `'`, "`"}, "`"),
+		`break case chan const continue default defer else fallthrough for func go goto if import
+		interface map package range return select struct switch type var
+		true false nil iota any bool byte rune string error int int8 int16 int32 int64
+		uint uint8 uint16 uint32 uint64 uintptr float32 float64 complex64 complex128`,
+	),
+	"python": newLanguage(
+		withStrings(hashComments, []string{`"""`, `'''`, `"`, `'`}, `"""`, `'''`),
+		`and as assert async await break class continue def del elif else except finally for from
+		global if import in is lambda nonlocal not or pass raise return try while with yield match case
+		True False None self`,
+	),
+	"javascript": newLanguage(
+		withStrings(cStyleComments, []string{`"`, `'`, "`"}, "`"),
+		`async await break case catch class const continue debugger default delete do else export
+		extends finally for from function if import in instanceof let new of return static super switch
+		this throw try typeof var void while with yield true false null undefined`,
+	),
+	"typescript": newLanguage(
+		withStrings(cStyleComments, []string{`"`, `'`, "`"}, "`"),
+		`async await break case catch class const continue debugger default delete do else enum export
+		extends finally for from function if implements import in instanceof interface let new of
+		private protected public readonly return static super switch this throw try type typeof var
+		void while with yield true false null undefined any boolean number string unknown never`,
+	),
+	"rust": newLanguage(
+		// Single quotes are not strings: they are used for lifetimes.
+		withStrings(cStyleComments, []string{`"`}, `"`),
+		`as async await break const continue crate dyn else enum extern fn for if impl in let loop
+		match mod move mut pub ref return self Self static struct super trait type unsafe use where
+		while true false bool char str i8 i16 i32 i64 i128 isize u8 u16 u32 u64 u128 usize f32 f64`,
+	),
+	"c": newLanguage(
+		withStrings(cStyleComments, []string{`"`, `'`}),
+		`auto break case char const continue default do double else enum extern float for goto if
+		inline int long register restrict return short signed sizeof static struct switch typedef
+		union unsigned void volatile while NULL true false bool`,
+	),
+	"cpp": newLanguage(
+		withStrings(cStyleComments, []string{`"`, `'`}),
+		`auto bool break case catch char class const constexpr continue default delete do double else
+		enum explicit extern false float for friend goto if inline int long mutable namespace new
+		noexcept nullptr operator private protected public return short signed sizeof static
+		struct switch template this throw true try typedef typename union unsigned using virtual
+		void volatile while`,
+	),
+	"java": newLanguage(
+		withStrings(cStyleComments, []string{`"""`, `"`, `'`}, `"""`),
+		`abstract assert boolean break byte case catch char class const continue default do double
+		else enum extends final finally float for if implements import instanceof int interface long
+		native new package private protected public return short static super switch synchronized
+		this throw throws try var void volatile while true false null record`,
+	),
+	"kotlin": newLanguage(
+		withStrings(cStyleComments, []string{`"""`, `"`, `'`}, `"""`),
+		`abstract as break class companion const continue data do else enum false for fun if import
+		in interface internal is lateinit null object open override package private protected public
+		return sealed super this throw true try typealias val var when while`,
+	),
+	"scala": newLanguage(
+		withStrings(cStyleComments, []string{`"""`, `"`, `'`}, `"""`),
+		`abstract case catch class def do else enum extends false final finally for given if implicit
+		import lazy match new null object override package private protected return sealed super this
+		throw trait true try type val var while with yield`,
+	),
+	"swift": newLanguage(
+		withStrings(cStyleComments, []string{`"""`, `"`}, `"""`),
+		`as associatedtype break case catch class continue default defer do else enum extension false
+		fileprivate for func guard if import in init inout internal is let nil open operator private
+		protocol public return self Self static struct subscript super switch throw throws true try
+		typealias var where while`,
+	),
+	"csharp": newLanguage(
+		withStrings(cStyleComments, []string{`"""`, `"`, `'`}, `"""`),
+		`abstract as async await base bool break byte case catch char checked class const continue
+		decimal default delegate do double else enum event explicit extern false finally fixed float
+		for foreach goto if implicit in int interface internal is lock long namespace new null object
+		operator out override params private protected public readonly ref return sbyte sealed short
+		sizeof static string struct switch this throw true try typeof uint ulong unchecked unsafe ushort
+		using var virtual void volatile while`,
+	),
+	"dart": newLanguage(
+		withStrings(cStyleComments, []string{`"""`, `'''`, `"`, `'`}, `"""`, `'''`),
+		`abstract as assert async await break case catch class const continue default do dynamic else
+		enum export extends extension factory false final finally for get if implements import in is
+		late library mixin new null on part required rethrow return set static super switch this throw
+		true try typedef var void while with yield`,
+	),
+	"ruby": newLanguage(
+		withStrings(hashComments, []string{`"`, `'`}),
+		`alias and begin break case class def defined do else elsif end ensure false for if in module
+		next nil not or redo rescue retry return self super then true undef unless until when while yield`,
+	),
+	"php": newLanguage(
+		withStrings(language{
+			lineComments:  []string{"//", "#"},
+			blockComments: [][2]string{{"/*", "*/"}},
+		}, []string{`"`, `'`}, `"`, `'`),
+		`abstract and array as break callable case catch class clone const continue declare default do
+		echo else elseif empty enddeclare endfor endforeach endif endswitch endwhile extends final
+		finally fn for foreach function global goto if implements include include_once instanceof
+		insteadof interface isset list match namespace new or print private protected public readonly
+		require require_once return static switch throw trait try unset use var while yield
+		true false null`,
+	),
+	"lua": newLanguage(
+		withStrings(language{
+			lineComments:  []string{"--"},
+			blockComments: [][2]string{{"--[[", "]]"}},
+		}, []string{`"`, `'`}),
+		`and break do else elseif end false for function goto if in local nil not or repeat return then
+		true until while`,
+	),
+	"shell": newLanguage(
+		withStrings(hashComments, []string{`"`, `'`}, `"`, `'`),
+		`if then else elif fi case esac for select while until do done in function time return exit
+		break continue export local readonly declare unset source echo`,
+	),
+	"dockerfile": newLanguage(
+		withStrings(language{lineComments: []string{"#"}, ignoreCase: true}, []string{`"`, `'`}),
+		`FROM AS RUN CMD LABEL EXPOSE ENV ADD COPY ENTRYPOINT VOLUME USER WORKDIR ARG ONBUILD
+		STOPSIGNAL HEALTHCHECK SHELL`,
+	),
+	"sql": newLanguage(
+		withStrings(language{
+			lineComments:  []string{"--"},
+			blockComments: [][2]string{{"/*", "*/"}},
+			ignoreCase:    true,
+		}, []string{`'`, `"`}, `'`),
+		`add all alter and as asc begin between by case check column commit constraint create database
+		default delete desc distinct drop else end exists foreign from full group having if in index
+		inner insert into is join key left like limit not null on or order outer primary references
+		returning right rollback select set table then transaction union unique update values view
+		when where with true false`,
+	),
+	"protobuf": newLanguage(
+		withStrings(cStyleComments, []string{`"`, `'`}),
+		`syntax package import option message enum service rpc returns repeated optional required
+		oneof map reserved extend stream true false double float int32 int64 uint32 uint64 sint32
+		sint64 fixed32 fixed64 sfixed32 sfixed64 bool string bytes`,
+	),
+	"json": newLanguage(
+		withStrings(language{}, []string{`"`}),
+		`true false null`,
+	),
+	"yaml": newLanguage(
+		withStrings(hashComments, []string{`"`, `'`}),
+		`true false null yes no on off`,
+	),
+	"toml": newLanguage(
+		withStrings(hashComments, []string{`"""`, `'''`, `"`, `'`}, `"""`, `'''`),
+		`true false`,
+	),
+	"ini": newLanguage(
+		withStrings(language{lineComments: []string{";", "#"}}, []string{`"`}),
+		`true false yes no on off`,
+	),
+	"css": newLanguage(
+		withStrings(language{blockComments: [][2]string{{"/*", "*/"}}}, []string{`"`, `'`}),
+		`important inherit initial unset none auto`,
+	),
+	"html": newLanguage(
+		withStrings(language{blockComments: [][2]string{{"<!--", "-->"}}, markup: true}, []string{`"`, `'`}, `"`, `'`),
+		``,
+	),
+	"xml": newLanguage(
+		withStrings(language{blockComments: [][2]string{{"<!--", "-->"}, {"<![CDATA[", "]]>"}}, markup: true}, []string{`"`, `'`}, `"`, `'`),
+		``,
+	),
+}
@@ -0,0 +1,194 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	htmlTagRe  = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[a-zA-Z_:][-a-zA-Z0-9_:.]*(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*)\s*/?>`)
+	htmlAttrRe = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+)
+
+// allowedTags contains allowed HTML tags and their allowed attributes. Other tags are removed,
+// but their content is kept.
+var allowedTags = map[string][]string{
+	// Inline tags.
+	"a":      {"href", "title"},
+	"abbr":   {"title"},
+	"b":      nil,
+	"br":     nil,
+	"code":   nil,
+	"del":    nil,
+	"em":     nil,
+	"i":      nil,
+	"img":    {"src", "alt", "title", "width", "height", "align"},
+	"ins":    nil,
+	"kbd":    nil,
+	"mark":   nil,
+	"q":      nil,
+	"s":      nil,
+	"small":  nil,
+	"span":   nil,
+	"strike": nil,
+	"strong": nil,
+	"sub":    nil,
+	"sup":    nil,
+	"u":      nil,
+
+	// Block tags.
+	"blockquote": nil,
+	"center":     nil,
+	"dd":         nil,
+	"details":    {"open"},
+	"div":        {"align"},
+	"dl":         nil,
+	"dt":         nil,
+	"h1":         {"align"},
+	"h2":         {"align"},
+	"h3":         {"align"},
+	"h4":         {"align"},
+	"h5":         {"align"},
+	"h6":         {"align"},
+	"hr":         nil,
+	"li":         nil,
+	"ol":         {"start"},
+	"p":          {"align"},
+	"pre":        nil,
+	"summary":    nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"align", "colspan", "rowspan"},
+	"tfoot":      nil,
+	"th":         {"align", "colspan", "rowspan"},
+	"thead":      nil,
+	"tr":         nil,
+	"ul":         nil,
+}
+
+var (
+	voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+	blockTags = map[string]bool{
+		"blockquote": true, "center": true, "dd": true, "details": true, "div": true, "dl": true,
+		"dt": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true,
+		"li": true, "ol": true, "p": true, "pre": true, "summary": true, "table": true, "tbody": true,
+		"td": true, "tfoot": true, "th": true, "thead": true, "tr": true, "ul": true,
+	}
+
+	// skippedTags are removed with their content.
+	skippedTags = map[string]bool{
+		"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+		"template": true, "textarea": true, "title": true, "noscript": true, "svg": true, "math": true,
+	}
+)
+
+// writeHTMLTag sanitizes and writes a raw HTML tag or comment that starts at s[i]. It returns
+// the number of consumed bytes or 0 if there is no tag.
+func (r *renderer) writeHTMLTag(s string, i int) int {
+	if strings.HasPrefix(s[i:], "<!--") {
+		end := strings.Index(s[i+4:], "-->")
+		if end < 0 {
+			return len(s) - i
+		}
+		return 4 + end + 3
+	}
+
+	m := htmlTagRe.FindStringSubmatch(s[i:])
+	if m == nil {
+		return 0
+	}
+	var (
+		n         = len(m[0])
+		isClosing = m[1] == "/"
+		name      = strings.ToLower(m[2])
+	)
+
+	if skippedTags[name] {
+		if isClosing {
+			return n
+		}
+		end := strings.Index(strings.ToLower(s[i+n:]), "</"+name)
+		if end < 0 {
+			return len(s) - i
+		}
+		end += i + n
+		if c := strings.IndexByte(s[end:], '>'); c >= 0 {
+			return end + c + 1 - i
+		}
+		return len(s) - i
+	}
+
+	allowedAttrs, ok := allowedTags[name]
+	if !ok {
+		return n
+	}
+
+	if isClosing {
+		// Tags opened outside the current container can't be closed.
+		for j := len(r.openTags) - 1; j >= r.containerDepth; j-- {
+			if r.openTags[j] == name {
+				r.closeTags(j, false)
+				break
+			}
+		}
+		// Unmatched closing tags are removed.
+		return n
+	}
+
+	r.b.WriteString("<" + name)
+	for _, attr := range htmlAttrRe.FindAllStringSubmatch(m[3], -1) {
+		key, value := strings.ToLower(attr[1]), html.UnescapeString(attr[2]+attr[3]+attr[4])
+		if !slices.Contains(allowedAttrs, key) {
+			continue
+		}
+		switch key {
+		case "href":
+			value = r.resolveURL(value, false)
+		case "src":
+			value = r.resolveURL(value, true)
+		}
+		if value == "" && (key == "href" || key == "src") {
+			continue
+		}
+		r.b.WriteString(" " + key + `="` + html.EscapeString(value) + `"`)
+	}
+	if name == "img" {
+		r.b.WriteString(` loading="lazy"`)
+	}
+	r.b.WriteString(">")
+
+	if !voidTags[name] {
+		r.openTags = append(r.openTags, name)
+	}
+	return n
+}
+
+// closeTags closes raw HTML tags opened after the depth. If onlyInline is true, block tags
+// are kept open: they can contain several Markdown blocks.
+func (r *renderer) closeTags(depth int, onlyInline bool) {
+	for len(r.openTags) > depth {
+		last := r.openTags[len(r.openTags)-1]
+		if onlyInline && blockTags[last] {
+			return
+		}
+		r.b.WriteString("</" + last + ">")
+		r.openTags = r.openTags[:len(r.openTags)-1]
+	}
+}
+
+// startsWithBlockTag reports whether the paragraph starts with a block HTML tag or a comment.
+// Such paragraphs are not wrapped in <p>.
+func startsWithBlockTag(text string) bool {
+	if strings.HasPrefix(text, "<!--") {
+		return true
+	}
+	m := htmlTagRe.FindStringSubmatch(text)
+	if m == nil {
+		return false
+	}
+	name := strings.ToLower(m[2])
+	return blockTags[name] || skippedTags[name]
+}
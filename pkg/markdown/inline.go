@@ -0,0 +1,455 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	autolinkRe = regexp.MustCompile(`^<((?:https?://|mailto:)[^\s<>]+)>`)
+	entityRe   = regexp.MustCompile(`^&(?:[a-zA-Z][a-zA-Z0-9]{1,31}|#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6});`)
+	schemeRe   = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]{1,31}):`)
+)
+
+// writeInline renders inline elements: code spans, emphasis, links, images, etc.
+func (r *renderer) writeInline(s string) {
+	// Inline raw HTML tags must not outlive the paragraph.
+	defer r.closeTags(len(r.openTags), true)
+
+	for i := 0; i < len(s); {
+		if n := r.writeInlineElement(s, i); n > 0 {
+			i += n
+			continue
+		}
+		writeEscapedByte(&r.b, s[i])
+		i++
+	}
+}
+
+// writeInlineElement tries to write an inline element that starts at s[i]. It returns
+// the number of consumed bytes or 0 if there is no element.
+func (r *renderer) writeInlineElement(s string, i int) int {
+	switch c := s[i]; c {
+	case '\\':
+		if i+1 < len(s) && s[i+1] == '\n' {
+			r.b.WriteString("<br>\n")
+			return 2
+		}
+		if i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			writeEscapedByte(&r.b, s[i+1])
+			return 2
+		}
+
+	case ' ':
+		// Hard line break: 2 or more spaces at the end of a line.
+		n := len(s[i:]) - len(strings.TrimLeft(s[i:], " "))
+		if i+n < len(s) && s[i+n] == '\n' {
+			if n >= 2 {
+				r.b.WriteString("<br>")
+			}
+			r.b.WriteString("\n")
+			return n + 1
+		}
+
+	case '`':
+		n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+		end := findBacktickRun(s, i+n, n)
+		if end < 0 {
+			// Unmatched backticks are written as is.
+			r.b.WriteString(s[i : i+n])
+			return n
+		}
+		code := strings.ReplaceAll(s[i+n:end], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+			code = code[1 : len(code)-1]
+		}
+		r.b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+		return end + n - i
+
+	case '!':
+		if i+1 < len(s) && s[i+1] == '[' {
+			if n := r.writeLink(s, i+1, true); n > 0 {
+				return n + 1
+			}
+		}
+
+	case '[':
+		return r.writeLink(s, i, false)
+
+	case '<':
+		if m := autolinkRe.FindStringSubmatch(s[i:]); m != nil {
+			r.writeAnchor(m[1], html.EscapeString(m[1]))
+			return len(m[0])
+		}
+		return r.writeHTMLTag(s, i)
+
+	case '&':
+		// Entities are kept as is.
+		if m := entityRe.FindString(s[i:]); m != "" {
+			r.b.WriteString(m)
+			return len(m)
+		}
+
+	case '*', '_', '~':
+		return r.writeEmphasis(s, i)
+
+	case 'h', 'w':
+		// Bare URLs.
+		if i > 0 && isAlnum(s[i-1]) {
+			return 0
+		}
+		if !strings.HasPrefix(s[i:], "https://") && !strings.HasPrefix(s[i:], "http://") && !strings.HasPrefix(s[i:], "www.") {
+			return 0
+		}
+		end := i
+		for end < len(s) && s[end] != ' ' && s[end] != '\n' && s[end] != '<' {
+			end++
+		}
+		url := strings.TrimRight(s[i:end], ".,:;!?\"'*_~")
+		if strings.HasSuffix(url, ")") && strings.Count(url, "(") < strings.Count(url, ")") {
+			url = url[:len(url)-1]
+		}
+		if url == "www." || strings.HasSuffix(url, "://") {
+			return 0
+		}
+		href := url
+		if c == 'w' {
+			href = "http://" + url
+		}
+		r.writeAnchor(href, html.EscapeString(url))
+		return len(url)
+	}
+	return 0
+}
+
+// writeEmphasis writes emphasis (*a*, _a_), strong emphasis (**a**, __a__) or strikethrough (~~a~~).
+func (r *renderer) writeEmphasis(s string, i int) int {
+	c := s[i]
+	n := len(s[i:]) - len(strings.TrimLeft(s[i:], string(c)))
+
+	// Opening delimiter must be followed by a non-space character. Intraword underscores
+	// don't start emphasis: snake_case_name.
+	if i+n >= len(s) || isSpace(s[i+n]) || (c == '_' && i > 0 && isAlnum(s[i-1])) {
+		r.b.WriteString(s[i : i+n])
+		return n
+	}
+
+	var tags []string
+	switch {
+	case c == '~' && n == 2:
+		tags = []string{"del"}
+	case c == '~':
+		r.b.WriteString(s[i : i+n])
+		return n
+	case n == 1:
+		tags = []string{"em"}
+	case n == 2:
+		tags = []string{"strong"}
+	default:
+		n = 3
+		tags = []string{"em", "strong"}
+	}
+
+	end := findEmphasisEnd(s, i+n, c, n)
+	if end < 0 && n == 3 {
+		// Try "***a**" -> "*<strong>a</strong>".
+		writeEscapedByte(&r.b, c)
+		return 1 + r.writeEmphasis(s, i+1)
+	}
+	if end < 0 {
+		r.b.WriteString(s[i : i+n])
+		return n
+	}
+
+	for _, tag := range tags {
+		r.b.WriteString("<" + tag + ">")
+	}
+	r.writeInline(s[i+n : end])
+	for _, tag := range slices.Backward(tags) {
+		r.b.WriteString("</" + tag + ">")
+	}
+	return end + n - i
+}
+
+// findEmphasisEnd returns the index of the closing delimiter run or -1.
+func findEmphasisEnd(s string, start int, c byte, n int) int {
+	for j := start; j < len(s); {
+		switch s[j] {
+		case '\\':
+			j += 2
+			continue
+		case '`':
+			m := len(s[j:]) - len(strings.TrimLeft(s[j:], "`"))
+			if end := findBacktickRun(s, j+m, m); end >= 0 {
+				j = end + m
+			} else {
+				j += m
+			}
+			continue
+		case c:
+			m := len(s[j:]) - len(strings.TrimLeft(s[j:], string(c)))
+			canClose := j > start && !isSpace(s[j-1]) && (c != '_' || j+m >= len(s) || !isAlnum(s[j+m]))
+			if canClose && (m == n || (m > n && n == 1 && m != 2)) {
+				return j
+			}
+			j += m
+			continue
+		}
+		j++
+	}
+	return -1
+}
+
+// writeLink writes a link or an image. s[open] must be '['.
+func (r *renderer) writeLink(s string, open int, isImage bool) int {
+	closing := findClosingBracket(s, open)
+	if closing < 0 {
+		return 0
+	}
+	label := s[open+1 : closing]
+	end := closing + 1
+
+	var dest, title string
+	switch {
+	case end < len(s) && s[end] == '(':
+		var ok bool
+		dest, title, end, ok = parseLinkDestination(s, end)
+		if !ok {
+			return 0
+		}
+
+	default:
+		ref := label
+		if strings.HasPrefix(s[end:], "[]") {
+			end += 2
+		} else if end < len(s) && s[end] == '[' {
+			if c := strings.IndexByte(s[end:], ']'); c > 0 {
+				ref = s[end+1 : end+c]
+				end += c + 1
+			}
+		}
+		def, ok := r.refs[normalizeLabel(ref)]
+		if !ok {
+			return 0
+		}
+		dest, title = def.dest, def.title
+	}
+
+	url := r.resolveURL(dest, isImage)
+	switch {
+	case isImage && url == "":
+		r.b.WriteString(html.EscapeString(label))
+	case isImage:
+		r.b.WriteString(`<img src="` + html.EscapeString(url) + `" alt="` + html.EscapeString(label) + `"`)
+		if title != "" {
+			r.b.WriteString(` title="` + html.EscapeString(title) + `"`)
+		}
+		r.b.WriteString(` loading="lazy">`)
+	case url == "":
+		r.writeInline(label)
+	default:
+		r.b.WriteString(`<a href="` + html.EscapeString(url) + `"`)
+		if title != "" {
+			r.b.WriteString(` title="` + html.EscapeString(title) + `"`)
+		}
+		r.b.WriteString(">")
+		r.writeInline(label)
+		r.b.WriteString("</a>")
+	}
+	return end - open
+}
+
+// writeAnchor writes a link with the already rendered text.
+func (r *renderer) writeAnchor(dest, text string) {
+	url := r.resolveURL(dest, false)
+	if url == "" {
+		r.b.WriteString(text)
+		return
+	}
+	r.b.WriteString(`<a href="` + html.EscapeString(url) + `">` + text + "</a>")
+}
+
+// resolveURL checks the scheme of the URL and passes relative URLs to [Options.ResolveURL].
+// It returns an empty string for unsafe URLs.
+func (r *renderer) resolveURL(dest string, isImage bool) string {
+	// Browsers ignore leading control characters and tabs or newlines inside URLs:
+	// "java\tscript:" is "javascript:".
+	dest = strings.TrimLeftFunc(html.UnescapeString(dest), func(r rune) bool { return r <= ' ' })
+	dest = strings.TrimSpace(strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(dest))
+	switch {
+	case dest == "":
+		return ""
+	case dest == "#":
+		return dest
+	case strings.HasPrefix(dest, "#"):
+		return "#" + r.opts.IDPrefix + dest[1:]
+	case strings.HasPrefix(dest, "//"):
+		// Protocol-relative URL.
+		return dest
+	}
+
+	if m := schemeRe.FindStringSubmatch(dest); m != nil {
+		switch strings.ToLower(m[1]) {
+		case "http", "https":
+			return dest
+		case "mailto":
+			if !isImage {
+				return dest
+			}
+		}
+		return ""
+	}
+
+	if r.opts.ResolveURL != nil {
+		return r.opts.ResolveURL(dest, isImage)
+	}
+	return dest
+}
+
+// parseLinkDestination parses "(dest "title")". s[start] must be '('.
+func parseLinkDestination(s string, start int) (dest, title string, end int, ok bool) {
+	i := skipSpaces(s, start+1)
+
+	if i < len(s) && s[i] == '<' {
+		c := strings.IndexAny(s[i:], ">\n")
+		if c < 0 || s[i+c] != '>' {
+			return "", "", 0, false
+		}
+		dest = s[i+1 : i+c]
+		i += c + 1
+	} else {
+		depth := 0
+		j := i
+	loop:
+		for ; j < len(s); j++ {
+			switch s[j] {
+			case '\\':
+				j++
+			case '(':
+				depth++
+			case ')':
+				if depth == 0 {
+					break loop
+				}
+				depth--
+			case ' ', '\n', '\t':
+				break loop
+			}
+		}
+		dest = unescapeBackslashes(s[i:min(j, len(s))])
+		i = j
+	}
+
+	i = skipSpaces(s, i)
+	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
+		closing := s[i]
+		if closing == '(' {
+			closing = ')'
+		}
+		c := strings.IndexByte(s[i+1:], closing)
+		if c < 0 {
+			return "", "", 0, false
+		}
+		title = s[i+1 : i+1+c]
+		i = skipSpaces(s, i+c+2)
+	}
+
+	if i >= len(s) || s[i] != ')' {
+		return "", "", 0, false
+	}
+	return dest, title, i + 1, true
+}
+
+// findClosingBracket returns the index of ']' that matches '[' at s[open] or -1.
+func findClosingBracket(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '`':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			if end := findBacktickRun(s, i+n, n); end >= 0 {
+				i = end + n - 1
+			} else {
+				i += n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// findBacktickRun returns the index of a run of exactly n backticks or -1.
+func findBacktickRun(s string, start, n int) int {
+	for i := start; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		m := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+		if m == n {
+			return i
+		}
+		i += m
+	}
+	return -1
+}
+
+func unescapeBackslashes(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	return i
+}
+
+func writeEscapedByte(b *strings.Builder, c byte) {
+	switch c {
+	case '<':
+		b.WriteString("&lt;")
+	case '>':
+		b.WriteString("&gt;")
+	case '&':
+		b.WriteString("&amp;")
+	case '"':
+		b.WriteString("&#34;")
+	case '\'':
+		b.WriteString("&#39;")
+	default:
+		b.WriteByte(c)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isAlnum(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
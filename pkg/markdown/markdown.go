@@ -0,0 +1,577 @@
+// Package markdown renders Markdown documents to HTML. It supports the most popular parts of
+// CommonMark and GitHub Flavored Markdown: headings, lists, code blocks, tables, blockquotes
+// with alerts, links, images and emphasis.
+//
+// The output is safe to embed into pages: raw HTML is sanitized (only allowed tags and
+// attributes are kept) and links with unsafe schemes (javascript:, data:, etc.) are removed.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ShoshinNikita/rview/pkg/highlight"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+type Options struct {
+	// ResolveURL, if not nil, is used to rewrite relative URLs of links and images, for example
+	// "docs/a.png" or "/a.png". An empty result means that the link should be removed.
+	ResolveURL func(dest string, isImage bool) string
+	// IDPrefix is added to ids of headings and to links to them ("#id") to avoid conflicts
+	// with ids of the page.
+	IDPrefix string
+}
+
+// Render renders the Markdown document.
+func Render(src string, opts Options) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\x00", "�")
+
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+
+	r := &renderer{
+		opts:       opts,
+		refs:       make(map[string]reference),
+		headingIDs: make(map[string]int),
+	}
+	lines = r.collectReferences(lines)
+	r.renderBlocks(lines, false)
+
+	return r.b.String()
+}
+
+type renderer struct {
+	opts Options
+	b    strings.Builder
+
+	refs       map[string]reference
+	headingIDs map[string]int
+
+	// openTags contains raw HTML tags that are not closed yet. Tags opened in the current
+	// container (list item, blockquote) start at containerDepth.
+	openTags       []string
+	containerDepth int
+}
+
+type reference struct {
+	dest  string
+	title string
+}
+
+var (
+	atxHeadingRe    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	setextH1Re      = regexp.MustCompile(`^ {0,3}=+[ \t]*$`)
+	setextH2Re      = regexp.MustCompile(`^ {0,3}-+[ \t]*$`)
+	thematicBreakRe = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	listItemRe      = regexp.MustCompile(`^( {0,3})([-+*]|\d{1,9}[.)])( +|$)`)
+	tableDelimRe    = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	referenceRe     = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*<?([^\s>]+)>?(?:[ \t]+(?:"([^"]*)"|'([^']*)'|\(([^)]*)\)))?[ \t]*$`)
+	alertRe         = regexp.MustCompile(`^\[!(NOTE|TIP|IMPORTANT|WARNING|CAUTION)\][ \t]*$`)
+)
+
+// collectReferences collects link reference definitions ("[label]: url") and removes them.
+func (r *renderer) collectReferences(lines []string) []string {
+	res := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		if _, _, ok := parseFence(lines[i]); ok {
+			end := min(findFenceEnd(lines, i)+1, len(lines))
+			res = append(res, lines[i:end]...)
+			i = end - 1
+			continue
+		}
+
+		m := referenceRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			res = append(res, lines[i])
+			continue
+		}
+		label := normalizeLabel(m[1])
+		if _, ok := r.refs[label]; !ok {
+			r.refs[label] = reference{dest: m[2], title: m[3] + m[4] + m[5]}
+		}
+	}
+	return res
+}
+
+// renderBlocks renders block elements. Paragraphs of tight lists are rendered without <p>.
+func (r *renderer) renderBlocks(lines []string, tight bool) {
+	// Raw HTML tags must not outlive their container.
+	prevDepth := r.containerDepth
+	r.containerDepth = len(r.openTags)
+	defer func() {
+		if len(r.openTags) > r.containerDepth {
+			r.closeTags(r.containerDepth, false)
+			r.b.WriteString("\n")
+		}
+		r.containerDepth = prevDepth
+	}()
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case isFence(line):
+			i = r.renderFencedCode(lines, i)
+		case atxHeadingRe.MatchString(line):
+			m := atxHeadingRe.FindStringSubmatch(line)
+			r.writeHeading(len(m[1]), m[2])
+			i++
+		case thematicBreakRe.MatchString(line):
+			r.b.WriteString("<hr>\n")
+			i++
+		case isBlockquote(line):
+			i = r.renderBlockquote(lines, i)
+		case listItemRe.MatchString(line):
+			i = r.renderList(lines, i)
+		case indent(line) >= 4:
+			i = r.renderIndentedCode(lines, i)
+		case isTableStart(lines, i):
+			i = r.renderTable(lines, i)
+		default:
+			i = r.renderParagraph(lines, i, tight)
+		}
+	}
+}
+
+func (r *renderer) writeHeading(level int, text string) {
+	id := slugify(text)
+	if n := r.headingIDs[id]; n > 0 {
+		r.headingIDs[id]++
+		id += "-" + strconv.Itoa(n)
+	} else {
+		r.headingIDs[id] = 1
+	}
+
+	tag := "h" + strconv.Itoa(level)
+	r.b.WriteString("<" + tag + ` id="` + html.EscapeString(r.opts.IDPrefix+id) + `">`)
+	r.writeInline(text)
+	r.b.WriteString("</" + tag + ">\n")
+}
+
+func (r *renderer) renderParagraph(lines []string, i int, tight bool) int {
+	start := i
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if setextH1Re.MatchString(line) || setextH2Re.MatchString(line) {
+			level := 1
+			if setextH2Re.MatchString(line) {
+				level = 2
+			}
+			r.writeHeading(level, joinParagraph(lines[start:i]))
+			return i + 1
+		}
+		if isBlank(line) || interruptsParagraph(line) {
+			break
+		}
+	}
+
+	text := joinParagraph(lines[start:i])
+	if tight || startsWithBlockTag(text) {
+		r.writeInline(text)
+		r.b.WriteString("\n")
+	} else {
+		r.b.WriteString("<p>")
+		r.writeInline(text)
+		r.b.WriteString("</p>\n")
+	}
+	return i
+}
+
+func (r *renderer) renderFencedCode(lines []string, i int) int {
+	_, info, _ := parseFence(lines[i])
+	fenceIndent := indent(lines[i])
+
+	end := findFenceEnd(lines, i)
+	content := lines[i+1 : end]
+	if end < len(lines) {
+		end++ // skip the closing fence
+	}
+
+	code := make([]string, 0, len(content))
+	for _, line := range content {
+		code = append(code, line[min(fenceIndent, indent(line)):])
+	}
+
+	lang, _, _ := strings.Cut(info, " ")
+	lang = strings.ToLower(lang)
+	if l := rview.GetLanguage("." + lang); l != "" {
+		lang = l
+	}
+
+	r.b.WriteString("<pre><code>")
+	r.b.WriteString(highlight.Highlight(strings.Join(code, "\n"), lang, 1))
+	r.b.WriteString("</code></pre>\n")
+
+	return end
+}
+
+func (r *renderer) renderIndentedCode(lines []string, i int) int {
+	var code []string
+	for ; i < len(lines) && (indent(lines[i]) >= 4 || isBlank(lines[i])); i++ {
+		code = append(code, strings.TrimPrefix(lines[i], "    "))
+	}
+	for len(code) > 0 && isBlank(code[len(code)-1]) {
+		code = code[:len(code)-1]
+	}
+
+	r.b.WriteString("<pre><code>")
+	r.b.WriteString(highlight.Highlight(strings.Join(code, "\n"), "", 1))
+	r.b.WriteString("</code></pre>\n")
+
+	return i
+}
+
+func (r *renderer) renderBlockquote(lines []string, i int) int {
+	var content []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isBlockquote(line) {
+			line = strings.TrimLeft(line, " ")[1:]
+			line = strings.TrimPrefix(line, " ")
+			content = append(content, line)
+			continue
+		}
+		// Lazy continuation of a paragraph.
+		if !isBlank(line) && len(content) > 0 && !isBlank(content[len(content)-1]) && !interruptsParagraph(line) {
+			content = append(content, line)
+			continue
+		}
+		break
+	}
+
+	// GitHub alerts: "> [!NOTE]".
+	if m := alertRe.FindStringSubmatch(content[0]); m != nil {
+		kind := strings.ToLower(m[1])
+		r.b.WriteString(`<blockquote class="markdown-alert markdown-alert-` + kind + `">` + "\n")
+		r.b.WriteString(`<p class="markdown-alert-title">` + m[1][:1] + kind[1:] + "</p>\n")
+		content = content[1:]
+	} else {
+		r.b.WriteString("<blockquote>\n")
+	}
+	r.renderBlocks(content, false)
+	r.b.WriteString("</blockquote>\n")
+
+	return i
+}
+
+type listMarker struct {
+	ordered bool
+	// delim is a bullet char for unordered lists or '.'/')' for ordered lists.
+	delim byte
+	start int
+	// contentIndent is the indent of the item content.
+	contentIndent int
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	m := listItemRe.FindStringSubmatch(line)
+	if m == nil || thematicBreakRe.MatchString(line) {
+		return listMarker{}, false
+	}
+
+	marker := listMarker{delim: m[2][len(m[2])-1]}
+	if len(m[2]) > 1 || (m[2][0] >= '0' && m[2][0] <= '9') {
+		marker.ordered = true
+		marker.start, _ = strconv.Atoi(m[2][:len(m[2])-1])
+	}
+
+	spaces := len(m[3])
+	if spaces == 0 || spaces > 4 {
+		// Empty item or indented code.
+		spaces = 1
+	}
+	marker.contentIndent = len(m[1]) + len(m[2]) + spaces
+	return marker, true
+}
+
+func (r *renderer) renderList(lines []string, i int) int {
+	first, _ := parseListMarker(lines[i])
+
+	var (
+		items [][]string
+		loose bool
+	)
+	for i < len(lines) {
+		marker, ok := parseListMarker(lines[i])
+		if !ok || marker.ordered != first.ordered || marker.delim != first.delim {
+			break
+		}
+
+		line := lines[i]
+		item := []string{line[min(marker.contentIndent, len(line)):]}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if isBlank(line) {
+				j := nextNonBlank(lines, i)
+				if j == len(lines) || indent(lines[j]) < marker.contentIndent {
+					break
+				}
+				for ; i < j; i++ {
+					item = append(item, "")
+				}
+				i--
+				loose = true
+				continue
+			}
+			if indent(line) >= marker.contentIndent {
+				item = append(item, line[marker.contentIndent:])
+				continue
+			}
+			// Lazy continuation of a paragraph.
+			if !isBlank(item[len(item)-1]) && !interruptsParagraph(line) && !listItemRe.MatchString(line) {
+				item = append(item, strings.TrimLeft(line, " "))
+				continue
+			}
+			break
+		}
+		items = append(items, item)
+
+		// Blank lines between items make the list loose.
+		if i < len(lines) && isBlank(lines[i]) {
+			j := nextNonBlank(lines, i)
+			next, ok := parseListMarker(safeIndex(lines, j))
+			if !ok || next.ordered != first.ordered || next.delim != first.delim {
+				break
+			}
+			loose = true
+			i = j
+		}
+	}
+
+	switch {
+	case !first.ordered:
+		r.b.WriteString("<ul>\n")
+	case first.start != 1:
+		r.b.WriteString(`<ol start="` + strconv.Itoa(first.start) + `">` + "\n")
+	default:
+		r.b.WriteString("<ol>\n")
+	}
+	for _, item := range items {
+		r.b.WriteString("<li>")
+
+		// Task lists: "- [ ] task" and "- [x] task".
+		switch {
+		case strings.HasPrefix(item[0], "[ ] "):
+			r.b.WriteString(`<input type="checkbox" disabled> `)
+			item[0] = item[0][4:]
+		case strings.HasPrefix(item[0], "[x] "), strings.HasPrefix(item[0], "[X] "):
+			r.b.WriteString(`<input type="checkbox" checked disabled> `)
+			item[0] = item[0][4:]
+		}
+
+		r.renderBlocks(item, !loose)
+		r.b.WriteString("</li>\n")
+	}
+	if first.ordered {
+		r.b.WriteString("</ol>\n")
+	} else {
+		r.b.WriteString("</ul>\n")
+	}
+
+	return i
+}
+
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") || !tableDelimRe.MatchString(lines[i+1]) {
+		return false
+	}
+	return len(splitTableRow(lines[i])) == len(splitTableRow(lines[i+1]))
+}
+
+func (r *renderer) renderTable(lines []string, i int) int {
+	header := splitTableRow(lines[i])
+
+	aligns := make([]string, len(header))
+	for j, cell := range splitTableRow(lines[i+1]) {
+		left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			aligns[j] = "center"
+		case left:
+			aligns[j] = "left"
+		case right:
+			aligns[j] = "right"
+		}
+	}
+
+	writeRow := func(cells []string, tag string) {
+		r.b.WriteString("<tr>")
+		for j := range header {
+			r.b.WriteString("<" + tag)
+			if aligns[j] != "" {
+				r.b.WriteString(` style="text-align: ` + aligns[j] + `"`)
+			}
+			r.b.WriteString(">")
+			if j < len(cells) {
+				r.writeInline(cells[j])
+			}
+			r.b.WriteString("</" + tag + ">")
+		}
+		r.b.WriteString("</tr>\n")
+	}
+
+	r.b.WriteString("<table>\n<thead>\n")
+	writeRow(header, "th")
+	r.b.WriteString("</thead>\n<tbody>\n")
+	for i += 2; i < len(lines); i++ {
+		if isBlank(lines[i]) || interruptsParagraph(lines[i]) {
+			break
+		}
+		writeRow(splitTableRow(lines[i]), "td")
+	}
+	r.b.WriteString("</tbody>\n</table>\n")
+
+	return i
+}
+
+// splitTableRow splits the table row into cells. Pipes can be escaped with a backslash.
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+
+	var (
+		cells []string
+		cell  strings.Builder
+	)
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func parseFence(line string) (fence, info string, ok bool) {
+	if indent(line) > 3 {
+		return "", "", false
+	}
+	line = strings.TrimLeft(line, " ")
+	if !strings.HasPrefix(line, "```") && !strings.HasPrefix(line, "~~~") {
+		return "", "", false
+	}
+
+	n := len(line) - len(strings.TrimLeft(line, line[:1]))
+	fence, info = line[:n], strings.TrimSpace(line[n:])
+	if fence[0] == '`' && strings.Contains(info, "`") {
+		return "", "", false
+	}
+	return fence, info, true
+}
+
+func isFence(line string) bool {
+	_, _, ok := parseFence(line)
+	return ok
+}
+
+// findFenceEnd returns the index of the closing fence or the number of lines if the code
+// block is not closed.
+func findFenceEnd(lines []string, i int) int {
+	fence, _, _ := parseFence(lines[i])
+	for i++; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if indent(lines[i]) <= 3 && strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == "" {
+			return i
+		}
+	}
+	return len(lines)
+}
+
+// interruptsParagraph reports whether the line starts a new block that can interrupt a paragraph.
+func interruptsParagraph(line string) bool {
+	if isFence(line) || atxHeadingRe.MatchString(line) || thematicBreakRe.MatchString(line) || isBlockquote(line) {
+		return true
+	}
+	// Only bullet lists and ordered lists that start with 1 can interrupt a paragraph.
+	marker, ok := parseListMarker(line)
+	return ok && !isBlank(line[min(marker.contentIndent, len(line)):]) && (!marker.ordered || marker.start == 1)
+}
+
+func isBlockquote(line string) bool {
+	return indent(line) <= 3 && strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+func joinParagraph(lines []string) string {
+	res := make([]string, 0, len(lines))
+	for _, line := range lines {
+		res = append(res, strings.TrimLeft(line, " "))
+	}
+	return strings.TrimRight(strings.Join(res, "\n"), " \t")
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func nextNonBlank(lines []string, i int) int {
+	for i < len(lines) && isBlank(lines[i]) {
+		i++
+	}
+	return i
+}
+
+func safeIndex(lines []string, i int) string {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return ""
+}
+
+// expandTabs replaces tabs in the indentation with spaces (tab stop is 4).
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			b.WriteByte(' ')
+		case '\t':
+			b.WriteString(strings.Repeat(" ", 4-b.Len()%4))
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+// slugify returns an id of a heading like GitHub does: "Hello, World!" -> "hello-world".
+func slugify(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r == ' ' || r == '-':
+			b.WriteByte('-')
+		case r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || r > 0x7F:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
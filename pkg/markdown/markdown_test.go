@@ -0,0 +1,163 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "headings",
+			src:  "# Hello, *World*!\n\nSetext\n---\n\n## Hello, World! ##",
+			want: []string{
+				`<h1 id="md-hello-world">Hello, <em>World</em>!</h1>`,
+				`<h2 id="md-setext">Setext</h2>`,
+				`<h2 id="md-hello-world-1">Hello, World!</h2>`,
+			},
+		},
+		{
+			name: "paragraphs",
+			src:  "a **b** _c_ ~~d~~ `<e>`\nsnake_case_name  \nline\n\n***",
+			want: []string{
+				"<p>a <strong>b</strong> <em>c</em> <del>d</del> <code>&lt;e&gt;</code>",
+				"snake_case_name<br>",
+				"line</p>",
+				"<hr>",
+			},
+		},
+		{
+			name: "lists",
+			src:  "- a\n- [x] b\n  - c\n\n1. d\n\n   e\n2) f",
+			want: []string{
+				"<ul>",
+				"<li>a",
+				"</li>",
+				`<li><input type="checkbox" checked disabled> b`,
+				"<ul>",
+				"<li>c",
+				"</li>",
+				"</ul>",
+				"</li>",
+				"</ul>",
+				"<ol>",
+				"<li><p>d</p>",
+				"<p>e</p>",
+				"</li>",
+				"</ol>",
+				`<ol start="2">`,
+				"<li>f",
+				"</li>",
+				"</ol>",
+			},
+		},
+		{
+			name: "code",
+			src:  "```go\nfunc main() {}\n```\n\n    <b>",
+			want: []string{
+				`<pre><code><span class="line" data-line="1"><span class="hl-keyword">func</span> main() {}</span>`,
+				"</code></pre>",
+				`<pre><code><span class="line" data-line="1">&lt;b&gt;</span>`,
+				"</code></pre>",
+			},
+		},
+		{
+			name: "blockquotes",
+			src:  "> [!WARNING]\n> a\nb\n\n> c",
+			want: []string{
+				`<blockquote class="markdown-alert markdown-alert-warning">`,
+				`<p class="markdown-alert-title">Warning</p>`,
+				"<p>a",
+				"b</p>",
+				"</blockquote>",
+				"<blockquote>",
+				"<p>c</p>",
+				"</blockquote>",
+			},
+		},
+		{
+			name: "tables",
+			src:  "| a | b |\n|:-:|---|\n| 1 \\| 2 |\n\nc",
+			want: []string{
+				"<table>",
+				"<thead>",
+				`<tr><th style="text-align: center">a</th><th>b</th></tr>`,
+				"</thead>",
+				"<tbody>",
+				`<tr><td style="text-align: center">1 | 2</td><td></td></tr>`,
+				"</tbody>",
+				"</table>",
+				"<p>c</p>",
+			},
+		},
+		{
+			name: "links",
+			src: "[a](docs/a.md \"title\") ![b](img/b.png) [![c](https://x/c.svg)](https://x) [d][ref] [ref]\n" +
+				"<https://e.com> https://f.com/(g). www.h.com [i](#top)\n\n" +
+				"[ref]: /ref.md",
+			want: []string{
+				`<p><a href="/R/docs/a.md" title="title">a</a> <img src="/I/img/b.png" alt="b" loading="lazy"> ` +
+					`<a href="https://x"><img src="https://x/c.svg" alt="c" loading="lazy"></a> <a href="/R//ref.md">d</a> <a href="/R//ref.md">ref</a>`,
+				`<a href="https://e.com">https://e.com</a> <a href="https://f.com/(g)">https://f.com/(g)</a>. ` +
+					`<a href="http://www.h.com">www.h.com</a> <a href="#md-top">i</a></p>`,
+			},
+		},
+		{
+			name: "unsafe links",
+			src:  "[a](javascript:alert(1)) [b](JaVa&#115;cript:1) [c](<java\tscript:1>) ![d](data:image/png;base64,x) [e](missing.md)",
+			want: []string{
+				"<p>a b c d e</p>",
+			},
+		},
+		{
+			name: "raw html",
+			src: `<div align="center">` + "\n\n# Title\n\n</div>\n\n" +
+				`<b onclick="x()">a</b> <i>b <script>alert(1)</script><style>*{}</style><!-- c --> <x-tag>d</x-tag> ` +
+				`<img src="img/e.png" onerror="x()"> <a href="javascript:1">f</a> </div>`,
+			want: []string{
+				`<div align="center">`,
+				`<h1 id="md-title">Title</h1>`,
+				"</div>",
+				`<p><b>a</b> <i>b  d <img src="/I/img/e.png" loading="lazy"> <a>f</a> </i></p>`,
+			},
+		},
+		{
+			name: "unclosed tags",
+			src:  "- <div><b>a\n\n<details>",
+			want: []string{
+				"<ul>",
+				"<li><div><b>a</b>",
+				"</div>",
+				"</li>",
+				"</ul>",
+				"<details>",
+				"</details>",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.src, Options{
+				IDPrefix: "md-",
+				ResolveURL: func(dest string, isImage bool) string {
+					switch {
+					case dest == "missing.md":
+						return ""
+					case isImage:
+						return "/I/" + dest
+					default:
+						return "/R/" + dest
+					}
+				},
+			})
+			require.Equal(t, strings.Join(tt.want, "\n")+"\n", got)
+		})
+	}
+}
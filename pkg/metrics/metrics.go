@@ -156,6 +156,13 @@ var (
 			Name:      "refresh_indexes_errors_total",
 		},
 	)
+	SearchContentIndexErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "search",
+			Name:      "content_index_errors_total",
+		},
+	)
 	SearchRefreshIndexesDuration = promauto.NewHistogram(
 		prometheus.HistogramOpts{
 			Namespace: namespace,
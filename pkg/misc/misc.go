@@ -35,6 +35,27 @@ func FormatModTime(modTime time.Time) string {
 	return modTime.UTC().Format("2006-01-02 15:04:05") + " UTC"
 }
 
+// FormatAge returns a rough human-readable duration: "5 minutes", "1 hour", "3 days".
+func FormatAge(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 48*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
+
 func EnsurePrefix(s, prefix string) string {
 	if strings.HasPrefix(s, prefix) {
 		return s
@@ -2,6 +2,7 @@ package misc
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -21,6 +22,16 @@ func TestFormatFileSize(t *testing.T) {
 	}
 }
 
+func TestFormatAge(t *testing.T) {
+	r := require.New(t)
+	r.Equal("less than a minute", FormatAge(30*time.Second))
+	r.Equal("1 minute", FormatAge(time.Minute+time.Second))
+	r.Equal("59 minutes", FormatAge(time.Hour-time.Second))
+	r.Equal("1 hour", FormatAge(time.Hour))
+	r.Equal("47 hours", FormatAge(48*time.Hour-time.Second))
+	r.Equal("3 days", FormatAge(80*time.Hour))
+}
+
 func TestEnsurePrefix(t *testing.T) {
 	r := require.New(t)
 	r.Equal("/hello", EnsurePrefix("hello", "/"))
@@ -0,0 +1,167 @@
+// Package ranged provides random access to remote files by requesting only the necessary byte ranges.
+package ranged
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// RequestFileRangeFn should return the content of a file in range [rangeStart; rangeEnd] (both inclusive).
+// [rclone.Rclone.RequestFileRange] satisfies this signature.
+type RequestFileRangeFn func(ctx context.Context, id rview.FileID, rangeStart, rangeEnd int) (io.ReadCloser, error)
+
+// Reader implements [io.ReadSeeker] and [io.ReaderAt] on top of [RequestFileRangeFn].
+//
+// Sequential reads (Read) are served from a single response that is opened lazily at the current
+// offset, so seeking is free until the next call to Read. Random reads (ReadAt) load chunks of at
+// least readAtChunkSize bytes - this significantly reduces the number of requests for readers like
+// [archive/zip] that read a file with small pieces.
+type Reader struct {
+	ctx          context.Context
+	id           rview.FileID
+	requestRange RequestFileRangeFn
+
+	mu     sync.Mutex
+	offset int64
+	stream io.ReadCloser
+
+	chunk      []byte
+	chunkStart int64
+}
+
+const readAtChunkSize = 64 << 10 // 64 KiB
+
+var errNegativeOffset = errors.New("negative offset")
+
+// NewReader returns a new [Reader]. The passed context is used for all requests.
+func NewReader(ctx context.Context, id rview.FileID, requestRange RequestFileRangeFn) *Reader {
+	return &Reader{
+		ctx:          ctx,
+		id:           id,
+		requestRange: requestRange,
+	}
+}
+
+// Size returns the file size.
+func (r *Reader) Size() int64 {
+	return r.id.GetSize()
+}
+
+func (r *Reader) Read(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.offset >= r.Size() {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if r.stream == nil {
+		r.stream, err = r.requestRange(r.ctx, r.id, int(r.offset), int(r.Size()-1))
+		if err != nil {
+			return 0, fmt.Errorf("couldn't request range starting at %d: %w", r.offset, err)
+		}
+	}
+
+	n, err = r.stream.Read(p)
+	r.offset += int64(n)
+	if errors.Is(err, io.EOF) && r.offset < r.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+
+	if offset != r.offset {
+		r.closeStream()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(p) > 0 {
+		if off >= r.Size() {
+			return n, io.EOF
+		}
+
+		if off < r.chunkStart || r.chunkStart+int64(len(r.chunk)) <= off {
+			if err := r.loadChunk(off, len(p)); err != nil {
+				return n, err
+			}
+		}
+
+		copied := copy(p, r.chunk[off-r.chunkStart:])
+		p = p[copied:]
+		off += int64(copied)
+		n += copied
+	}
+	return n, nil
+}
+
+func (r *Reader) loadChunk(start int64, minSize int) error {
+	size := max(int64(minSize), readAtChunkSize)
+	end := min(start+size, r.Size()) - 1 // inclusive
+
+	rc, err := r.requestRange(r.ctx, r.id, int(start), int(end))
+	if err != nil {
+		return fmt.Errorf("couldn't request range [%d; %d]: %w", start, end, err)
+	}
+	defer rc.Close()
+
+	chunk := make([]byte, end-start+1)
+	if _, err := io.ReadFull(rc, chunk); err != nil {
+		return fmt.Errorf("couldn't read range [%d; %d]: %w", start, end, err)
+	}
+
+	r.chunk = chunk
+	r.chunkStart = start
+	return nil
+}
+
+func (r *Reader) closeStream() {
+	if r.stream != nil {
+		r.stream.Close()
+		r.stream = nil
+	}
+}
+
+// Close closes the underlying response, if any.
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closeStream()
+	r.chunk = nil
+	return nil
+}
@@ -0,0 +1,120 @@
+package ranged
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/stretchr/testify/require"
+)
+
+type Range struct {
+	Start, End int
+}
+
+func newTestFile(size int) (rview.FileID, []byte, RequestFileRangeFn, *[]Range) {
+	data := bytes.Repeat([]byte("0123456789"), size/10)
+	id := rview.NewFileID("/test.bin", 0, int64(len(data)))
+
+	var requests []Range
+	fn := func(_ context.Context, _ rview.FileID, start, end int) (io.ReadCloser, error) {
+		requests = append(requests, Range{start, end})
+		return io.NopCloser(bytes.NewReader(data[start : end+1])), nil
+	}
+	return id, data, fn, &requests
+}
+
+func TestReader_ReadSeek(t *testing.T) {
+	r := require.New(t)
+
+	id, data, fn, requests := newTestFile(1000)
+
+	reader := NewReader(t.Context(), id, fn)
+	defer reader.Close()
+
+	// Seek without reads doesn't send requests.
+	_, err := reader.Seek(0, io.SeekEnd)
+	r.NoError(err)
+	_, err = reader.Seek(500, io.SeekStart)
+	r.NoError(err)
+	r.Empty(*requests)
+
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(reader, buf)
+	r.NoError(err)
+	r.Equal(data[500:510], buf)
+
+	// Sequential reads use the same response.
+	_, err = io.ReadFull(reader, buf)
+	r.NoError(err)
+	r.Equal(data[510:520], buf)
+	r.Equal([]Range{{500, 999}}, *requests)
+
+	// Read the rest after seek.
+	_, err = reader.Seek(-100, io.SeekEnd)
+	r.NoError(err)
+	rest, err := io.ReadAll(reader)
+	r.NoError(err)
+	r.Equal(data[900:], rest)
+	r.Equal([]Range{{500, 999}, {900, 999}}, *requests)
+}
+
+func TestReader_ReadAt(t *testing.T) {
+	r := require.New(t)
+
+	id, data, fn, requests := newTestFile(readAtChunkSize * 2)
+
+	reader := NewReader(t.Context(), id, fn)
+	defer reader.Close()
+
+	// Small reads are served from the same chunk.
+	buf := make([]byte, 100)
+	for _, off := range []int64{0, 100, 5000, 200} {
+		_, err := reader.ReadAt(buf, off)
+		r.NoError(err)
+		r.Equal(data[off:off+100], buf)
+	}
+	r.Equal([]Range{{0, readAtChunkSize - 1}}, *requests)
+
+	// Read across chunks.
+	*requests = nil
+	buf = make([]byte, 200)
+	_, err := reader.ReadAt(buf, readAtChunkSize-100)
+	r.NoError(err)
+	r.Equal(data[readAtChunkSize-100:readAtChunkSize+100], buf)
+	r.Equal([]Range{{readAtChunkSize, len(data) - 1}}, *requests)
+
+	// Read after EOF.
+	n, err := reader.ReadAt(buf, int64(len(data))-50)
+	r.ErrorIs(err, io.EOF)
+	r.Equal(50, n)
+}
+
+func TestServer(t *testing.T) {
+	r := require.New(t)
+
+	id, data, fn, requests := newTestFile(1000)
+
+	server, err := NewServer(t.Context(), id, fn)
+	r.NoError(err)
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL(), nil)
+	r.NoError(err)
+	req.Header.Set("Range", "bytes=100-")
+
+	resp, err := http.DefaultClient.Do(req)
+	r.NoError(err)
+	defer resp.Body.Close()
+
+	r.Equal(http.StatusPartialContent, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	r.NoError(err)
+	r.Equal(data[100:], body)
+	r.True(strings.HasSuffix(resp.Header.Get("Content-Range"), "/1000"))
+	r.Equal([]Range{{100, 999}}, *requests)
+}
@@ -0,0 +1,78 @@
+package ranged
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// Server exposes a single remote file via a local HTTP server. It allows external tools
+// (for example, ffmpeg) to download only the necessary parts of a file with 'Range' requests.
+type Server struct {
+	httpServer *http.Server
+	listener   net.Listener
+	doneCh     chan struct{}
+}
+
+// NewServer starts a new [Server] on a random local port. The server must be stopped with [Server.Close].
+func NewServer(ctx context.Context, id rview.FileID, requestRange RequestFileRangeFn) (*Server, error) {
+	listener, err := (&net.ListenConfig{}).Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start listener: %w", err)
+	}
+
+	modTime := time.Unix(id.GetModTime(), 0)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Create a new reader for every request because requests can be sent in parallel.
+		reader := NewReader(r.Context(), id, requestRange)
+		defer reader.Close()
+
+		// Set Content-Type to prevent content sniffing - it would require an extra request.
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, id.GetName(), modTime, reader)
+	})
+
+	s := &Server{
+		httpServer: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 5 * time.Second,
+			BaseContext:       func(net.Listener) context.Context { return ctx },
+		},
+		listener: listener,
+		doneCh:   make(chan struct{}),
+	}
+	go func() {
+		defer close(s.doneCh)
+
+		err := s.httpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			rlog.Errorf("ranged server for %q failed: %s", id.GetPath(), err)
+		}
+	}()
+
+	return s, nil
+}
+
+// URL returns the file url.
+func (s *Server) URL() string {
+	u := url.URL{
+		Scheme: "http",
+		Host:   s.listener.Addr().String(),
+		Path:   "/file",
+	}
+	return u.String()
+}
+
+// Close immediately closes the server and all active connections.
+func (s *Server) Close() error {
+	err := s.httpServer.Close()
+	<-s.doneCh
+	return err
+}
@@ -0,0 +1,103 @@
+package signed
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Encoding is used for tokens and signatures, they can be used in urls and cookies.
+var Encoding = base64.RawURLEncoding
+
+// Signer signs values and tokens with a secret key.
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// LoadOrCreateSecret reads a secret key from the file or generates a new one if the file
+// doesn't exist.
+func LoadOrCreateSecret(dir *os.Root, filename string) ([]byte, error) {
+	const secretSize = 32
+
+	data, err := dir.ReadFile(filename)
+	if err == nil {
+		secret, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(secret) != secretSize {
+			return nil, fmt.Errorf("file %q contains invalid secret, delete it to generate a new one", filename)
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("couldn't read file: %w", err)
+	}
+
+	secret := make([]byte, secretSize)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't generate secret: %w", err)
+	}
+	err = dir.WriteFile(filename, []byte(hex.EncodeToString(secret)), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't write file: %w", err)
+	}
+	return secret, nil
+}
+
+// Sign returns HMAC of the value encoded with [Encoding]. Purpose is used to get different
+// signatures of the same value for different purposes.
+func (s *Signer) Sign(purpose, value string) string {
+	return Encoding.EncodeToString(s.sign(purpose, value))
+}
+
+// Verify checks the signature returned by [Signer.Sign].
+func (s *Signer) Verify(purpose, value, signature string) bool {
+	rawSignature, err := Encoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(rawSignature, s.sign(purpose, value))
+}
+
+func (s *Signer) sign(purpose, value string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(purpose + ":" + value))
+	return mac.Sum(nil)
+}
+
+// EncodeToken returns a signed token with the data marshaled to JSON: "<payload>.<signature>".
+func (s *Signer) EncodeToken(purpose string, data any) (string, error) {
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("couldn't marshal token data: %w", err)
+	}
+	payload := Encoding.EncodeToString(rawData)
+	return payload + "." + s.Sign(purpose, payload), nil
+}
+
+// DecodeToken checks the signature of a token returned by [Signer.EncodeToken] and decodes its data.
+func (s *Signer) DecodeToken(purpose, token string, dst any) error {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return errors.New("invalid token format")
+	}
+	if !s.Verify(purpose, payload, signature) {
+		return errors.New("invalid signature")
+	}
+
+	rawData, err := Encoding.DecodeString(payload)
+	if err != nil {
+		return fmt.Errorf("couldn't decode payload: %w", err)
+	}
+	return json.Unmarshal(rawData, dst)
+}
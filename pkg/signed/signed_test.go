@@ -0,0 +1,61 @@
+package signed
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	secret, err := LoadOrCreateSecret(dir, "secret.key")
+	r.NoError(err)
+	r.Len(secret, 32)
+
+	// The secret is reused.
+	secret2, err := LoadOrCreateSecret(dir, "secret.key")
+	r.NoError(err)
+	r.Equal(secret, secret2)
+
+	r.NoError(dir.WriteFile("invalid.key", []byte("abc"), 0600))
+	_, err = LoadOrCreateSecret(dir, "invalid.key")
+	r.Error(err)
+
+	s := NewSigner(secret)
+
+	t.Run("sign", func(t *testing.T) {
+		r := require.New(t)
+
+		signature := s.Sign("a", "value")
+		r.True(s.Verify("a", "value", signature))
+		r.False(s.Verify("b", "value", signature))
+		r.False(s.Verify("a", "value2", signature))
+		r.False(s.Verify("a", "value", signature+"x"))
+		r.False(NewSigner([]byte("other")).Verify("a", "value", signature))
+	})
+
+	t.Run("token", func(t *testing.T) {
+		r := require.New(t)
+
+		type data struct {
+			Name string `json:"name"`
+		}
+
+		token, err := s.EncodeToken("a", data{Name: "alice"})
+		r.NoError(err)
+
+		var res data
+		r.NoError(s.DecodeToken("a", token, &res))
+		r.Equal("alice", res.Name)
+
+		r.Error(s.DecodeToken("b", token, &res))
+		r.Error(s.DecodeToken("a", token+"x", &res))
+		r.Error(s.DecodeToken("a", "x"+token, &res))
+		r.Error(s.DecodeToken("a", "abc", &res))
+	})
+}
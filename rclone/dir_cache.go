@@ -2,24 +2,67 @@ package rclone
 
 import (
 	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+)
+
+const (
+	// maxDirCacheStaleness is the max age of a listing that can be served while it is refreshed
+	// in the background. Older listings are served only when rclone is unavailable.
+	maxDirCacheStaleness = 24 * time.Hour
+
+	dirRefreshTimeout = time.Minute
+
+	// maxDirCacheFileAge is the max age of listings stored on disk. Listings are rewritten
+	// on every refresh, so only listings of directories that haven't been accessed for
+	// a long time are removed.
+	maxDirCacheFileAge      = 30 * 24 * time.Hour
+	dirCacheCleanupInterval = time.Hour
 )
 
+// dirCache caches directory listings in memory and on disk, so they survive restarts.
+// Expired listings are served while they are refreshed in the background, see [Rclone.getDirInfo].
 type dirCache struct {
 	ttl time.Duration
+	// dir is used to persist listings. It is nil if the cache is disabled.
+	dir *os.Root
 
 	mu    sync.Mutex
 	cache map[string]*dirCacheItem
 }
 
-func newDirCache(ttl time.Duration) *dirCache {
-	return &dirCache{
+func newDirCache(ttl time.Duration, dirRoot *os.Root) (*dirCache, error) {
+	c := &dirCache{
 		ttl:   ttl,
 		cache: make(map[string]*dirCacheItem),
 	}
+	if !c.Enabled() {
+		return c, nil
+	}
+
+	err := dirRoot.Mkdir("dir_cache", 0700)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("couldn't create 'dir_cache' subdirectory: %w", err)
+	}
+	c.dir, err = dirRoot.OpenRoot("dir_cache")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open root: %w", err)
+	}
+	return c, nil
 }
 
 func (c *dirCache) Enabled() bool {
@@ -32,20 +75,156 @@ func (c *dirCache) Get(path string) *dirCacheItem {
 
 	res, ok := c.cache[path]
 	if !ok {
+		hash := sha256.Sum256([]byte(path))
 		res = &dirCacheItem{
-			ttl: c.ttl,
+			dir:      c.dir,
+			filename: hex.EncodeToString(hash[:]) + ".gob",
 		}
 		c.cache[path] = res
 	}
 	return res
 }
 
+// GetRecentlyAccessed returns paths of directories accessed within the passed duration,
+// most recently accessed first.
+func (c *dirCache) GetRecentlyAccessed(within time.Duration, limit int) []string {
+	type accessedDir struct {
+		path       string
+		accessedAt int64
+	}
+
+	minAccessedAt := time.Now().Add(-within).UnixNano()
+
+	c.mu.Lock()
+	var dirs []accessedDir
+	for path, item := range c.cache {
+		if accessedAt := item.accessedAt.Load(); accessedAt >= minAccessedAt {
+			dirs = append(dirs, accessedDir{path: path, accessedAt: accessedAt})
+		}
+	}
+	c.mu.Unlock()
+
+	slices.SortFunc(dirs, func(a, b accessedDir) int {
+		return -1 * cmp.Compare(a.accessedAt, b.accessedAt)
+	})
+	dirs = dirs[:min(len(dirs), limit)]
+
+	res := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		res = append(res, dir.path)
+	}
+	return res
+}
+
+// RemoveOldFiles removes listings that haven't been updated for [maxDirCacheFileAge]
+// or ttl, whichever is greater.
+func (c *dirCache) RemoveOldFiles() (removed int, _ error) {
+	if c.dir == nil {
+		return 0, nil
+	}
+
+	entries, err := fs.ReadDir(c.dir.FS(), ".")
+	if err != nil {
+		return 0, fmt.Errorf("couldn't read dir: %w", err)
+	}
+
+	minModTime := time.Now().Add(-max(maxDirCacheFileAge, c.ttl))
+
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("couldn't get info of %q: %w", entry.Name(), err))
+			}
+			continue
+		}
+		if !info.ModTime().Before(minModTime) {
+			continue
+		}
+
+		err = c.dir.Remove(entry.Name())
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("couldn't remove %q: %w", entry.Name(), err))
+			continue
+		}
+		removed++
+	}
+	return removed, errors.Join(errs...)
+}
+
+// cleanupDirCache periodically removes old listings from disk, see [dirCache.RemoveOldFiles].
+func (r *Rclone) cleanupDirCache(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Run immediately.
+		removed, err := r.dirCache.RemoveOldFiles()
+		if err != nil {
+			rlog.Errorf("couldn't remove old files from dir cache: %s", err)
+		}
+		if removed > 0 {
+			rlog.Infof("%d old listings have been removed from dir cache", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeleteDir removes listings of the directory and all its subdirectories loaded in memory.
+func (c *dirCache) DeleteDir(dir string) {
+	items := map[string]*dirCacheItem{
+		dir: c.Get(dir),
+	}
+	c.mu.Lock()
+	for path, item := range c.cache {
+		if strings.HasPrefix(path, dir) {
+			items[path] = item
+		}
+	}
+	c.mu.Unlock()
+
+	for path, item := range items {
+		item.Lock()
+		err := item.DeleteLocked()
+		item.Unlock()
+
+		if err != nil {
+			rlog.Errorf("couldn't delete dir %q from cache: %s", path, err)
+		}
+	}
+}
+
 type dirCacheItem struct {
-	ttl time.Duration
+	dir      *os.Root
+	filename string
+
+	// accessedAt is the time of the last access in nanoseconds, see [dirCache.GetRecentlyAccessed].
+	accessedAt atomic.Int64
 
-	mu        sync.Mutex
-	gobData   []byte
-	expiresAt time.Time
+	mu sync.Mutex
+	// loaded reports whether the item has been read from disk.
+	loaded  bool
+	gobData []byte
+
+	// refreshing is true while the item is being refreshed in the background.
+	refreshing bool
+	// refreshFailed is true if the last refresh failed because rclone was unavailable.
+	refreshFailed bool
+}
+
+// persistedDirInfo is the format of both in-memory and on-disk data.
+type persistedDirInfo struct {
+	Info     *DirInfo
+	CachedAt time.Time
 }
 
 func (c *dirCacheItem) Lock() {
@@ -56,34 +235,79 @@ func (c *dirCacheItem) Unlock() {
 	c.mu.Unlock()
 }
 
-// LoadLocked tries to load [*DirInfo] from the cache. It requires that mutex remains locked
-// for the duration of the call.
-func (c *dirCacheItem) LoadLocked() (*DirInfo, error) {
-	if len(c.gobData) == 0 {
-		return nil, nil
+// HasDataLocked reports whether the item has a cached listing. It requires that mutex
+// remains locked for the duration of the call.
+func (c *dirCacheItem) HasDataLocked() bool {
+	return !c.loaded || len(c.gobData) > 0
+}
+
+// LoadLocked tries to load [*DirInfo] from the cache. It also returns the time when the
+// listing was received from rclone. It requires that mutex remains locked for the duration
+// of the call.
+func (c *dirCacheItem) LoadLocked() (*DirInfo, time.Time, error) {
+	if !c.loaded {
+		c.loaded = true
+
+		data, err := c.dir.ReadFile(c.filename)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, time.Time{}, fmt.Errorf("couldn't read file: %w", err)
+		}
+		c.gobData = data
 	}
-	if time.Now().After(c.expiresAt) {
-		return nil, nil
+	if len(c.gobData) == 0 {
+		return nil, time.Time{}, nil
 	}
 
-	var info DirInfo
-	err := gob.NewDecoder(bytes.NewReader(c.gobData)).Decode(&info)
+	var res persistedDirInfo
+	err := gob.NewDecoder(bytes.NewReader(c.gobData)).Decode(&res)
 	if err != nil {
-		return nil, fmt.Errorf("gob decode failed: %w", err)
+		// Don't try to decode corrupted data again.
+		c.gobData = nil
+		return nil, time.Time{}, fmt.Errorf("gob decode failed: %w", err)
 	}
-	return &info, nil
+	return res.Info, res.CachedAt, nil
 }
 
 // StoreLocked saves [*DirInfo] to the cache. It requires that mutex remains locked
 // for the duration of the call.
 func (c *dirCacheItem) StoreLocked(info *DirInfo) error {
+	now := time.Now()
+
 	buf := bytes.NewBuffer(nil)
-	err := gob.NewEncoder(buf).Encode(info)
+	err := gob.NewEncoder(buf).Encode(persistedDirInfo{
+		Info:     info,
+		CachedAt: now,
+	})
 	if err != nil {
 		return fmt.Errorf("gob encode failed: %w", err)
 	}
+	c.loaded = true
 	c.gobData = buf.Bytes()
-	c.expiresAt = time.Now().Add(c.ttl)
+	c.refreshFailed = false
 
+	// Write to a temporary file first to not corrupt the listing on failure.
+	tmpFilename := c.filename + ".tmp"
+	err = c.dir.WriteFile(tmpFilename, c.gobData, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't write file: %w", err)
+	}
+	err = c.dir.Rename(tmpFilename, c.filename)
+	if err != nil {
+		return fmt.Errorf("couldn't rename file: %w", err)
+	}
+	return nil
+}
+
+// DeleteLocked removes [*DirInfo] from the cache. It requires that mutex remains locked
+// for the duration of the call.
+func (c *dirCacheItem) DeleteLocked() error {
+	c.loaded = true
+	c.gobData = nil
+	c.refreshFailed = false
+
+	err := c.dir.Remove(c.filename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("couldn't remove file: %w", err)
+	}
 	return nil
 }
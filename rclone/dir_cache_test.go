@@ -0,0 +1,353 @@
+package rclone
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRclone_PersistentDirCache(t *testing.T) {
+	r := require.New(t)
+
+	var (
+		mu       sync.Mutex
+		files    = []string{"1.txt"}
+		status   = http.StatusOK
+		requests int
+	)
+	setState := func(newFiles []string, newStatus int) {
+		mu.Lock()
+		defer mu.Unlock()
+		files, status = newFiles, newStatus
+	}
+	getRequests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		requests++
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		entries := make([]string, 0, len(files))
+		for _, f := range files {
+			entries = append(entries, fmt.Sprintf(`{"leaf": %q, "size": 1, "mod_time": 1}`, f))
+		}
+		fmt.Fprintf(w, `{"dir": "/", "breadcrumbs": [{"text": "/"}], "entries": [%s]}`, strings.Join(entries, ","))
+	}))
+	defer server.Close()
+
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	newRclone := func() *Rclone {
+		rclone, err := NewRclone(rview.RcloneConfig{
+			URL:         server.URL,
+			Targets:     rview.RcloneTargets{{Target: "/data"}},
+			DirCacheTTL: time.Hour,
+		}, dirRoot)
+		r.NoError(err)
+		return rclone
+	}
+	getEntries := func(rclone *Rclone) ([]string, time.Time) {
+		info, err := rclone.GetDirInfo(t.Context(), "/", "", "")
+		r.NoError(err)
+
+		res := make([]string, 0, len(info.Entries))
+		for _, e := range info.Entries {
+			res = append(res, e.Leaf)
+		}
+		return res, info.CachedAt
+	}
+	waitForRefresh := func(item *dirCacheItem) {
+		r.Eventually(func() bool {
+			item.Lock()
+			defer item.Unlock()
+			return !item.refreshing
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	rclone := newRclone()
+	entries, cachedAt := getEntries(rclone)
+	r.Equal([]string{"1.txt"}, entries)
+	r.Zero(cachedAt)
+
+	setState([]string{"1.txt", "2.txt"}, http.StatusOK)
+	entries, _ = getEntries(rclone)
+	r.Equal([]string{"1.txt"}, entries)
+	r.Equal(1, getRequests())
+
+	// Listings survive restarts.
+	rclone = newRclone()
+	entries, _ = getEntries(rclone)
+	r.Equal([]string{"1.txt"}, entries)
+	r.Equal(1, getRequests())
+
+	t.Run("stale while revalidate", func(t *testing.T) {
+		item := rclone.dirCache.Get("/")
+		expireDirCache(t, item, 2*time.Hour)
+		setState([]string{"1.txt", "2.txt"}, http.StatusBadGateway)
+
+		// The stale listing is served immediately. Rclone is unavailable, so the refresh fails.
+		entries, cachedAt := getEntries(rclone)
+		r.Equal([]string{"1.txt"}, entries)
+		r.Zero(cachedAt)
+		waitForRefresh(item)
+
+		// The next request shows the age of the listing.
+		entries, cachedAt = getEntries(rclone)
+		r.Equal([]string{"1.txt"}, entries)
+		r.NotZero(cachedAt)
+		waitForRefresh(item)
+
+		setState([]string{"1.txt", "2.txt"}, http.StatusOK)
+		getEntries(rclone)
+		waitForRefresh(item)
+
+		entries, cachedAt = getEntries(rclone)
+		r.Equal([]string{"1.txt", "2.txt"}, entries)
+		r.Zero(cachedAt)
+	})
+
+	t.Run("rclone is unavailable", func(t *testing.T) {
+		// Too old listings are not served while rclone is available.
+		expireDirCache(t, rclone.dirCache.Get("/"), 48*time.Hour)
+		setState([]string{"3.txt"}, http.StatusOK)
+
+		entries, cachedAt := getEntries(rclone)
+		r.Equal([]string{"3.txt"}, entries)
+		r.Zero(cachedAt)
+
+		expireDirCache(t, rclone.dirCache.Get("/"), 48*time.Hour)
+		setState([]string{"4.txt"}, http.StatusServiceUnavailable)
+
+		entries, cachedAt = getEntries(rclone)
+		r.Equal([]string{"3.txt"}, entries)
+		r.WithinDuration(time.Now().Add(-48*time.Hour), cachedAt, time.Minute)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		expireDirCache(t, rclone.dirCache.Get("/"), 48*time.Hour)
+		setState(nil, http.StatusNotFound)
+
+		_, err := rclone.GetDirInfo(t.Context(), "/", "", "")
+		r.True(IsNotFoundError(err))
+
+		// The listing is removed from disk.
+		_, err = newRclone().GetDirInfo(t.Context(), "/", "", "")
+		r.True(IsNotFoundError(err))
+	})
+}
+
+func TestDirCache_RemoveOldFiles(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	dirRoot, err := os.OpenRoot(dir)
+	r.NoError(err)
+
+	c, err := newDirCache(time.Hour, dirRoot)
+	r.NoError(err)
+
+	store := func(path string, age time.Duration) string {
+		item := c.Get(path)
+		item.Lock()
+		defer item.Unlock()
+
+		r.NoError(item.StoreLocked(&DirInfo{Dir: path}))
+
+		file := dir + "/dir_cache/" + item.filename
+		modTime := time.Now().Add(-age)
+		r.NoError(os.Chtimes(file, modTime, modTime))
+		return file
+	}
+	oldFile := store("/old/", maxDirCacheFileAge+time.Hour)
+	newFile := store("/new/", maxDirCacheFileAge-time.Hour)
+
+	removed, err := c.RemoveOldFiles()
+	r.NoError(err)
+	r.Equal(1, removed)
+	r.NoFileExists(oldFile)
+	r.FileExists(newFile)
+
+	// Disabled cache.
+	c, err = newDirCache(0, dirRoot)
+	r.NoError(err)
+	removed, err = c.RemoveOldFiles()
+	r.NoError(err)
+	r.Zero(removed)
+}
+
+// expireDirCache changes the time when the listing was cached.
+func expireDirCache(t *testing.T, item *dirCacheItem, age time.Duration) {
+	r := require.New(t)
+
+	item.Lock()
+	defer item.Unlock()
+
+	info, _, err := item.LoadLocked()
+	r.NoError(err)
+	r.NotNil(info)
+
+	buf := bytes.NewBuffer(nil)
+	err = gob.NewEncoder(buf).Encode(persistedDirInfo{
+		Info:     info,
+		CachedAt: time.Now().Add(-age),
+	})
+	r.NoError(err)
+	item.gobData = buf.Bytes()
+}
+
+func TestRclone_DirChanges(t *testing.T) {
+	r := require.New(t)
+
+	var (
+		mu   sync.Mutex
+		dirs = map[string][]string{
+			"/":   {"a/", "1.txt"},
+			"/a/": {"2.txt"},
+		}
+	)
+	setDir := func(dir string, files []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if files == nil {
+			delete(dirs, dir)
+		} else {
+			dirs[dir] = files
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Path has format "/[/data]/a/".
+		_, dir, _ := strings.Cut(req.URL.Path, "]")
+		dir = misc.EnsureSuffix(dir, "/")
+
+		files, ok := dirs[dir]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		entries := make([]string, 0, len(files))
+		for _, f := range files {
+			entries = append(entries, fmt.Sprintf(`{"leaf": %q, "is_dir": %t, "size": 1, "mod_time": 1}`, f, strings.HasSuffix(f, "/")))
+		}
+		fmt.Fprintf(w, `{"dir": %q, "breadcrumbs": [{"text": "/"}], "entries": [%s]}`, dir, strings.Join(entries, ","))
+	}))
+	defer server.Close()
+
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	rclone, err := NewRclone(rview.RcloneConfig{
+		URL:                   server.URL,
+		Targets:               rview.RcloneTargets{{Target: "/data"}},
+		DirCacheTTL:           time.Hour,
+		DirChangePollInterval: 10 * time.Millisecond,
+	}, dirRoot)
+	r.NoError(err)
+
+	var (
+		changesMu sync.Mutex
+		changes   []DirChange
+	)
+	rclone.OnDirChange(func(change DirChange) {
+		changesMu.Lock()
+		defer changesMu.Unlock()
+		changes = append(changes, change)
+	})
+	popChanges := func() []DirChange {
+		changesMu.Lock()
+		defer changesMu.Unlock()
+		res := changes
+		changes = nil
+		return res
+	}
+	getLeaves := func(change DirChange) (res []string) {
+		for _, e := range change.Entries {
+			res = append(res, e.Leaf)
+		}
+		slices.Sort(res)
+		return res
+	}
+
+	for _, dir := range []string{"/", "/a/"} {
+		_, err := rclone.GetDirInfo(t.Context(), dir, "", "")
+		r.NoError(err)
+	}
+	r.Empty(popChanges(), "first listings are not changes")
+
+	t.Run("invalidate", func(t *testing.T) {
+		// Changes are reported even if the content is the same.
+		err := rclone.InvalidateDir(t.Context(), "a/2.txt")
+		r.NoError(err)
+		got := popChanges()
+		r.Len(got, 1)
+		r.Equal("/a/", got[0].Dir)
+		r.Equal([]string{"2.txt"}, getLeaves(got[0]))
+
+		setDir("/a/", []string{"2.txt", "3.txt"})
+		err = rclone.InvalidateDir(t.Context(), "/a/")
+		r.NoError(err)
+		got = popChanges()
+		r.Len(got, 1)
+		r.Equal([]string{"2.txt", "3.txt"}, getLeaves(got[0]))
+
+		// The cache is updated.
+		info, err := rclone.GetDirInfo(t.Context(), "/a/", "", "")
+		r.NoError(err)
+		r.Len(info.Entries, 2)
+	})
+
+	t.Run("poll", func(t *testing.T) {
+		r.NoError(rclone.Start())
+		defer func() {
+			r.NoError(rclone.Shutdown(t.Context()))
+		}()
+
+		setDir("/", []string{"1.txt", "4.txt"})
+		setDir("/a/", nil)
+
+		var got []DirChange
+		r.Eventually(func() bool {
+			got = append(got, popChanges()...)
+			return slices.ContainsFunc(got, func(c DirChange) bool { return c.Dir == "/" })
+		}, time.Second, 10*time.Millisecond)
+
+		for _, change := range got {
+			switch change.Dir {
+			case "/":
+				r.False(change.IsDeleted)
+				r.Equal([]string{"1.txt", "4.txt"}, getLeaves(change))
+			case "/a/":
+				r.True(change.IsDeleted)
+			default:
+				r.FailNow("unexpected change", change.Dir)
+			}
+		}
+
+		// The listing of the removed subdirectory is removed from the cache.
+		_, err := rclone.GetDirInfo(t.Context(), "/a/", "", "")
+		r.True(IsNotFoundError(err))
+	})
+}
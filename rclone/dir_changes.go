@@ -0,0 +1,202 @@
+package rclone
+
+import (
+	"cmp"
+	"context"
+	pkgPath "path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+)
+
+const (
+	// maxPolledDirIdleTime is the max time since the last access of a directory that is
+	// checked for changes. Other directories are refreshed after their cache expires.
+	maxPolledDirIdleTime = time.Hour
+	// maxPolledDirs is the max number of directories checked for changes at once.
+	maxPolledDirs = 100
+)
+
+// DirChange describes a directory which content has changed.
+type DirChange struct {
+	// Dir is the full path of the directory, with a trailing slash.
+	Dir string
+	// Entries is the new content of the directory.
+	Entries []DirEntry
+	// IsDeleted is true if the directory was deleted. Entries are empty in this case.
+	IsDeleted bool
+}
+
+// OnDirChange registers a handler that is called when the content of a directory changes,
+// see [Rclone.InvalidateDir]. Handlers must not read directories.
+//
+// Rclone doesn't report changes of backends over its API, so changes are detected by
+// comparing new listings with the cached ones: when the cache expires, when recently
+// accessed directories are polled, or when a directory is invalidated.
+func (r *Rclone) OnDirChange(fn func(DirChange)) {
+	r.dirChangeHandlersMu.Lock()
+	defer r.dirChangeHandlersMu.Unlock()
+
+	r.dirChangeHandlers = append(r.dirChangeHandlers, fn)
+}
+
+// InvalidateDir requests the directory from rclone ignoring the cache and reports its content
+// to [Rclone.OnDirChange] handlers even if it hasn't changed. A path of a file invalidates
+// its directory.
+func (r *Rclone) InvalidateDir(ctx context.Context, path string) error {
+	if !strings.HasSuffix(path, "/") {
+		path = pkgPath.Dir(path)
+	}
+	path = misc.EnsureSuffix(pkgPath.Clean(misc.EnsurePrefix(path, "/")), "/")
+
+	if r.multiRemote && path == "/" {
+		// The list of remotes can't be changed.
+		return nil
+	}
+
+	info, err := r.requestDirInfo(ctx, path)
+	if err != nil && !IsNotFoundError(err) {
+		return err
+	}
+
+	change := dirChange{path: path, newInfo: info}
+	if r.dirCache.Enabled() {
+		cacheItem := r.dirCache.Get(path)
+		cacheItem.Lock()
+		change.oldInfo = r.updateDirCacheLocked(path, cacheItem, info)
+		cacheItem.Unlock()
+	}
+
+	r.handleDirChange(change, true)
+	return nil
+}
+
+// dirChange contains the cached and the new listings of a directory.
+type dirChange struct {
+	path    string
+	oldInfo *DirInfo
+	// newInfo is nil if the directory was deleted.
+	newInfo *DirInfo
+}
+
+// updateDirCacheLocked saves the new listing or removes the cached one if info is nil.
+// It returns the previous cached listing. It requires that mutex of the item remains locked
+// for the duration of the call.
+func (r *Rclone) updateDirCacheLocked(path string, cacheItem *dirCacheItem, info *DirInfo) (oldInfo *DirInfo) {
+	oldInfo, _, err := cacheItem.LoadLocked()
+	if err != nil {
+		rlog.Errorf("couldn't load dir %q from cache: %s", path, err)
+	}
+
+	if info != nil {
+		err = cacheItem.StoreLocked(info)
+		if err != nil {
+			rlog.Errorf("couldn't save dir %q to cache: %s", path, err)
+		}
+	} else {
+		err = cacheItem.DeleteLocked()
+		if err != nil {
+			rlog.Errorf("couldn't delete dir %q from cache: %s", path, err)
+		}
+	}
+	return oldInfo
+}
+
+// handleDirChange removes cached listings of deleted subdirectories and calls handlers if the
+// content of the directory has changed. Without force, directories without previous listings
+// are skipped: there is nothing to compare with. It must be called without locked cache items.
+func (r *Rclone) handleDirChange(change dirChange, force bool) {
+	if !force && (change.oldInfo == nil || isSameDirContent(change.oldInfo, change.newInfo)) {
+		return
+	}
+
+	if r.dirCache.Enabled() && change.oldInfo != nil {
+		var newDirs map[string]bool
+		if change.newInfo != nil {
+			newDirs = make(map[string]bool)
+			for _, entry := range change.newInfo.Entries {
+				if entry.IsDir {
+					newDirs[entry.URL] = true
+				}
+			}
+		}
+		for _, entry := range change.oldInfo.Entries {
+			if entry.IsDir && !newDirs[entry.URL] {
+				r.dirCache.DeleteDir(entry.URL)
+			}
+		}
+	}
+
+	res := DirChange{
+		Dir:       change.path,
+		IsDeleted: change.newInfo == nil,
+	}
+	if change.newInfo != nil {
+		// Entries of returned listings are sorted in place.
+		res.Entries = slices.Clone(change.newInfo.Entries)
+	}
+
+	rlog.Debugf("dir %q has changed, deleted: %t", res.Dir, res.IsDeleted)
+
+	r.dirChangeHandlersMu.Lock()
+	handlers := slices.Clone(r.dirChangeHandlers)
+	r.dirChangeHandlersMu.Unlock()
+
+	for _, fn := range handlers {
+		fn(res)
+	}
+}
+
+// isSameDirContent reports whether the listings have the same entries. Listings can be nil.
+func isSameDirContent(a, b *DirInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(a.Entries) != len(b.Entries) {
+		return false
+	}
+
+	compare := func(a, b DirEntry) int {
+		return cmp.Compare(a.URL, b.URL)
+	}
+	aEntries := slices.SortedFunc(slices.Values(a.Entries), compare)
+	bEntries := slices.SortedFunc(slices.Values(b.Entries), compare)
+	return slices.Equal(aEntries, bEntries)
+}
+
+// pollDirChanges periodically requests recently accessed directories to detect changes.
+func (r *Rclone) pollDirChanges(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			paths := r.dirCache.GetRecentlyAccessed(maxPolledDirIdleTime, maxPolledDirs)
+			for _, path := range paths {
+				if ctx.Err() != nil {
+					return
+				}
+
+				cacheItem := r.dirCache.Get(path)
+				cacheItem.Lock()
+				// Skip directories that are already being refreshed or have been deleted.
+				skip := cacheItem.refreshing || !cacheItem.HasDataLocked()
+				if !skip {
+					cacheItem.refreshing = true
+				}
+				cacheItem.Unlock()
+
+				if !skip {
+					r.refreshDirInfo(ctx, path, cacheItem)
+				}
+			}
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"io"
 	"io/fs"
 	"iter"
+	"maps"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -69,14 +70,25 @@ type Rclone struct {
 	stoppedCh         chan struct{}
 
 	dirCache *dirCache
+	// dirChangePollInterval is the interval of checks of recently accessed directories for
+	// changes. 0 disables the checks.
+	dirChangePollInterval time.Duration
+	stopDirChangePolling  func()
+	dirChangePollStopped  chan struct{}
+	dirChangeHandlersMu   sync.Mutex
+	dirChangeHandlers     []func(DirChange)
+	stopDirCacheCleanup   func()
+	dirCacheCleanupDone   chan struct{}
 
 	httpClient *http.Client
 	// rcloneURL with username and password for basic auth.
-	rcloneURL    *url.URL
-	rcloneTarget string
+	rcloneURL *url.URL
+	targets   []target
+	// multiRemote is true if there are multiple named targets, see [rview.RcloneTargets].
+	multiRemote bool
 }
 
-func NewRclone(cfg rview.RcloneConfig) (_ *Rclone, err error) {
+func NewRclone(cfg rview.RcloneConfig, dirRoot *os.Root) (_ *Rclone, err error) {
 	var (
 		cmd       *exec.Cmd
 		stopCmd   func()
@@ -141,18 +153,34 @@ func NewRclone(cfg rview.RcloneConfig) (_ *Rclone, err error) {
 		}
 	}
 
+	dirCache, err := newDirCache(cfg.DirCacheTTL, dirRoot)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't prepare dir cache: %w", err)
+	}
+
+	var dirChangePollInterval time.Duration
+	if dirCache.Enabled() {
+		dirChangePollInterval = cfg.DirChangePollInterval
+	}
+
 	return &Rclone{
 		cmd:       cmd,
 		stopCmd:   stopCmd,
 		stoppedCh: nil, // created in Start
 		//
-		dirCache: newDirCache(cfg.DirCacheTTL),
+		dirCache:              dirCache,
+		dirChangePollInterval: dirChangePollInterval,
+		stopDirChangePolling:  func() {},
+		dirChangePollStopped:  nil, // created in Start
+		stopDirCacheCleanup:   func() {},
+		dirCacheCleanupDone:   nil, // created in Start
 		//
 		httpClient: &http.Client{
 			Timeout: 10 * time.Minute,
 		},
-		rcloneURL:    rcloneURL,
-		rcloneTarget: cfg.Target,
+		rcloneURL:   rcloneURL,
+		targets:     newTargets(cfg.Targets),
+		multiRemote: cfg.Targets.IsMultiRemote(),
 	}, nil
 }
 
@@ -171,6 +199,28 @@ func (r *Rclone) Start() error {
 		close(r.stoppedCh)
 	}()
 
+	if r.dirChangePollInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.stopDirChangePolling = cancel
+		r.dirChangePollStopped = make(chan struct{})
+		go func() {
+			defer close(r.dirChangePollStopped)
+
+			r.pollDirChanges(ctx, r.dirChangePollInterval)
+		}()
+	}
+
+	if r.dirCache.Enabled() {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.stopDirCacheCleanup = cancel
+		r.dirCacheCleanupDone = make(chan struct{})
+		go func() {
+			defer close(r.dirCacheCleanupDone)
+
+			r.cleanupDirCache(ctx, dirCacheCleanupInterval)
+		}()
+	}
+
 	if r.cmd == nil {
 		// We use an existing rclone instance.
 		return nil
@@ -236,23 +286,30 @@ func (r *Rclone) redirectRcloneLogs(pipe io.Reader) {
 func (r *Rclone) Shutdown(ctx context.Context) error {
 	r.stoppedByShutdown.Store(true)
 	r.stopCmd()
+	r.stopDirChangePolling()
+	r.stopDirCacheCleanup()
 
-	if r.stoppedCh == nil {
-		return nil
-	}
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-r.stoppedCh:
-		return nil
+	for _, ch := range []chan struct{}{r.stoppedCh, r.dirChangePollStopped, r.dirCacheCleanupDone} {
+		if ch == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
 	}
+	return nil
 }
 
 // OpenFile returns a file content in the form of [io.ReadCloser]. This method should be used only
 // for internal purposes (for example, to download image for resizing). Serving files to users
 // should be done via [Rclone.ProxyFileRequest].
 func (r *Rclone) OpenFile(ctx context.Context, id rview.FileID) (io.ReadCloser, error) {
-	rcloneURL := r.rcloneURL.JoinPath("["+r.rcloneTarget+"]", id.GetEscapedPath())
+	rcloneURL, err := r.getFileURL(id)
+	if err != nil {
+		return nil, err
+	}
 
 	now := time.Now()
 	body, headers, err := r.makeRequest(ctx, "GET", rcloneURL)
@@ -273,7 +330,10 @@ func (r *Rclone) OpenFile(ctx context.Context, id rview.FileID) (io.ReadCloser,
 }
 
 func (r *Rclone) RequestFileRange(ctx context.Context, id rview.FileID, rangeStart, rangeEnd int) (io.ReadCloser, error) {
-	rcloneURL := r.rcloneURL.JoinPath("["+r.rcloneTarget+"]", id.GetEscapedPath())
+	rcloneURL, err := r.getFileURL(id)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequestWithContext(ctx, "GET", rcloneURL.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't prepare request: %w", err)
@@ -304,9 +364,15 @@ func (r *Rclone) RequestFileRange(ctx context.Context, id rview.FileID, rangeSta
 func (r *Rclone) ProxyFileRequest(id rview.FileID, w http.ResponseWriter, req *http.Request) {
 	now := time.Now()
 
+	fileURL, err := r.getFileURL(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
 	proxy := httputil.ReverseProxy{
 		Rewrite: func(pr *httputil.ProxyRequest) {
-			u := r.rcloneURL.JoinPath("["+r.rcloneTarget+"]", id.GetEscapedPath())
+			u := *fileURL
 
 			// Rclone requires leading slash.
 			u.Path = misc.EnsurePrefix(u.Path, "/")
@@ -319,7 +385,7 @@ func (r *Rclone) ProxyFileRequest(id rview.FileID, w http.ResponseWriter, req *h
 			}
 
 			u.User = nil
-			pr.Out.URL = u
+			pr.Out.URL = &u
 		},
 		ModifyResponse: func(r *http.Response) error {
 			if r.StatusCode != http.StatusOK {
@@ -385,6 +451,10 @@ type DirInfo struct {
 
 	Breadcrumbs []DirBreadcrumb `json:"breadcrumbs"`
 	Entries     []DirEntry      `json:"entries"`
+
+	// CachedAt is set when rclone is unavailable and the outdated listing is served from
+	// the cache. It is the time when the listing was received from rclone.
+	CachedAt time.Time `json:"cached_at,omitzero"`
 }
 
 type DirBreadcrumb struct {
@@ -409,6 +479,14 @@ func (r *Rclone) GetDirInfo(ctx context.Context, path string, sort, order string
 	}
 
 	// We can't use rclone's sort because we cache directory content.
+	SortDirInfo(info, sort, order)
+
+	return info, nil
+}
+
+// SortDirInfo sorts entries of the directory. Unknown sort modes are replaced with
+// "namedirfirst", unknown orders - with "asc".
+func SortDirInfo(info *DirInfo, sort, order string) {
 	sortFn := map[string]func(a, b DirEntry) int{
 		"namedirfirst": CompareDirEntryByName[DirEntry],
 		"size":         compareDirEntryBySize,
@@ -427,36 +505,141 @@ func (r *Rclone) GetDirInfo(ctx context.Context, path string, sort, order string
 	} else {
 		info.Order = "asc"
 	}
-
-	return info, nil
 }
 
 func (r *Rclone) getDirInfo(ctx context.Context, path string) (*DirInfo, error) {
-	saveToCache := func(*DirInfo) error { return nil }
-	if r.dirCache.Enabled() {
-		cacheItem := r.dirCache.Get(path)
+	if r.multiRemote && strings.Trim(path, "/") == "" {
+		return r.getRemotesDirInfo(), nil
+	}
 
-		// Prevent parallel requests for the same dir.
-		cacheItem.Lock()
-		defer cacheItem.Unlock()
+	if !r.dirCache.Enabled() {
+		return r.requestDirInfo(ctx, path)
+	}
 
-		info, err := cacheItem.LoadLocked()
-		if err != nil {
-			return nil, fmt.Errorf("couldn't load dir from cache: %w", err)
+	cacheItem := r.dirCache.Get(path)
+	cacheItem.accessedAt.Store(time.Now().UnixNano())
+
+	// Handlers must be called after the item is unlocked.
+	var change dirChange
+	defer func() {
+		r.handleDirChange(change, false)
+	}()
+
+	// Prevent parallel requests for the same dir.
+	cacheItem.Lock()
+	defer cacheItem.Unlock()
+
+	cachedInfo, cachedAt, err := cacheItem.LoadLocked()
+	if err != nil {
+		rlog.Errorf("couldn't load dir %q from cache: %s", path, err)
+	}
+	if cachedInfo != nil {
+		age := time.Since(cachedAt)
+		switch {
+		case age < r.dirCache.ttl:
+			metrics.RcloneDirsServedFromCache.Inc()
+			return cachedInfo, nil
+
+		case age < maxDirCacheStaleness:
+			// Serve the stale listing immediately and refresh it in the background.
+			if !cacheItem.refreshing {
+				cacheItem.refreshing = true
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), dirRefreshTimeout)
+					defer cancel()
+
+					r.refreshDirInfo(ctx, path, cacheItem)
+				}()
+			}
+			if cacheItem.refreshFailed {
+				cachedInfo.CachedAt = cachedAt
+			}
+			metrics.RcloneDirsServedFromCache.Inc()
+			return cachedInfo, nil
 		}
-		if info != nil {
+	}
+
+	// No data in cache or it is too old - load the directory.
+
+	info, err := r.requestDirInfo(ctx, path)
+	if err != nil {
+		if cachedInfo != nil && isRcloneUnavailableError(ctx, err) {
+			rlog.Warnf("rclone is unavailable, serve outdated dir %q cached at %s: %s", path, cachedAt.Format(time.DateTime), err)
+
+			cachedInfo.CachedAt = cachedAt
 			metrics.RcloneDirsServedFromCache.Inc()
-			return info, nil
+			return cachedInfo, nil
 		}
+		if IsNotFoundError(err) {
+			if err := cacheItem.DeleteLocked(); err != nil {
+				rlog.Errorf("couldn't delete dir %q from cache: %s", path, err)
+			}
+			change = dirChange{path: path, oldInfo: cachedInfo, newInfo: nil}
+		}
+		return nil, err
+	}
+
+	if err := cacheItem.StoreLocked(info); err != nil {
+		rlog.Errorf("couldn't save dir %q to cache: %s", path, err)
+	}
+	change = dirChange{path: path, oldInfo: cachedInfo, newInfo: info}
+	return info, nil
+}
+
+// refreshDirInfo requests the directory from rclone and updates the cache item. The caller
+// must set the refreshing flag of the item.
+func (r *Rclone) refreshDirInfo(ctx context.Context, path string, cacheItem *dirCacheItem) {
+	info, err := r.requestDirInfo(ctx, path)
+
+	cacheItem.Lock()
 
-		// No data in cache - proceed to load the directory.
+	cacheItem.refreshing = false
+
+	var change dirChange
+	switch {
+	case err == nil, IsNotFoundError(err):
+		change = dirChange{
+			path:    path,
+			oldInfo: r.updateDirCacheLocked(path, cacheItem, info),
+			newInfo: info,
+		}
+
+	case errors.Is(ctx.Err(), context.Canceled):
+		// Polling has been stopped.
+
+	default:
+		cacheItem.refreshFailed = true
+		rlog.Errorf("couldn't refresh dir %q: %s", path, err)
+	}
+
+	cacheItem.Unlock()
+
+	r.handleDirChange(change, false)
+}
+
+// isRcloneUnavailableError reports whether the error is caused by rclone being unavailable,
+// and not by the request itself: for example, "connection refused" or "bad gateway".
+func isRcloneUnavailableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var rcloneErr *RcloneError
+	if errors.As(err, &rcloneErr) {
+		return rcloneErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
 
-		saveToCache = cacheItem.StoreLocked
+// requestDirInfo requests the directory from rclone, bypassing the cache.
+func (r *Rclone) requestDirInfo(ctx context.Context, path string) (*DirInfo, error) {
+	target, targetPath, err := r.resolvePath(path)
+	if err != nil {
+		return nil, err
 	}
 
 	now := time.Now()
 
-	rcloneURL := r.rcloneURL.JoinPath("["+r.rcloneTarget+"]", path)
+	rcloneURL := r.rcloneURL.JoinPath("["+target.fs+"]", targetPath)
 	body, _, err := r.makeRequest(ctx, "GET", rcloneURL)
 	if err != nil {
 		return nil, err
@@ -484,6 +667,13 @@ func (r *Rclone) getDirInfo(ctx context.Context, path string) (*DirInfo, error)
 	for i := range rcloneInfo.Breadcrumbs {
 		rcloneInfo.Breadcrumbs[i].Text = html.UnescapeString(rcloneInfo.Breadcrumbs[i].Text)
 	}
+	if target.name != "" {
+		// The remote is a directory in the root: "/" -> "photos" -> "2024".
+		rcloneInfo.Dir = target.addPrefix(rcloneInfo.Dir)
+		rcloneInfo.Breadcrumbs = slices.Insert(
+			rcloneInfo.Breadcrumbs, min(1, len(rcloneInfo.Breadcrumbs)), DirBreadcrumb{Text: target.name},
+		)
+	}
 	for i, v := range rcloneInfo.Entries {
 		v.Leaf = html.UnescapeString(v.Leaf)
 		v.URL = pkgPath.Join(rcloneInfo.Dir, v.Leaf)
@@ -496,10 +686,6 @@ func (r *Rclone) getDirInfo(ctx context.Context, path string) (*DirInfo, error)
 		rcloneInfo.Entries[i] = v
 	}
 
-	if err := saveToCache(rcloneInfo); err != nil {
-		return nil, fmt.Errorf("couldn't save dir to cache: %w", err)
-	}
-
 	return rcloneInfo, nil
 }
 
@@ -615,17 +801,64 @@ func (r *Rclone) makeRequest(ctx context.Context, method string, url *url.URL) (
 	return resp.Body, resp.Header, nil
 }
 
-func (r *Rclone) GetAllFiles(ctx context.Context) (iter.Seq[DirEntry], error) {
+// GetAllFiles returns all files and directories of the remote, see [Rclone.GetRemotes].
+// The response is decoded while entries are consumed, so the whole listing is never kept
+// in memory. The iteration stops after the first error.
+func (r *Rclone) GetAllFiles(ctx context.Context, remote string) iter.Seq2[DirEntry, error] {
+	return func(yield func(DirEntry, error) bool) {
+		target, err := r.getTarget(remote)
+		if err != nil {
+			yield(DirEntry{}, err)
+			return
+		}
+
+		if target.name != "" {
+			// In multi-remote mode, the remote is a directory in the root.
+			if !yield(DirEntry{URL: "/" + target.name + "/", Leaf: target.name + "/", IsDir: true}, nil) {
+				return
+			}
+		}
+
+		err = r.listFiles(ctx, target, "/", true, func(entry DirEntry) bool {
+			return yield(entry, nil)
+		})
+		if err != nil {
+			yield(DirEntry{}, err)
+		}
+	}
+}
+
+// GetDirEntries returns direct children of the directory. Unlike [Rclone.GetDirInfo], it doesn't
+// use the dir cache and decodes the response while entries are consumed, just like [Rclone.GetAllFiles].
+func (r *Rclone) GetDirEntries(ctx context.Context, dir string) iter.Seq2[DirEntry, error] {
+	return func(yield func(DirEntry, error) bool) {
+		target, targetPath, err := r.resolvePath(dir)
+		if err != nil {
+			yield(DirEntry{}, err)
+			return
+		}
+
+		err = r.listFiles(ctx, target, targetPath, false, func(entry DirEntry) bool {
+			return yield(entry, nil)
+		})
+		if err != nil {
+			yield(DirEntry{}, err)
+		}
+	}
+}
+
+// listFiles lists the directory of the target with "operations/list". Paths of returned entries
+// are full paths, see [target.addPrefix].
+func (r *Rclone) listFiles(ctx context.Context, target target, dir string, recurse bool, yield func(DirEntry) bool) error {
 	// Pass parameters as a query instead of JSON to be able to forbid access to
 	// other remotes via Nginx (see 'docs/advanced_setup.md').
-
 	opt, _ := json.Marshal(map[string]any{ // error is always nil
 		"noMimeType": true,
-		"recurse":    true,
+		"recurse":    recurse,
 	})
 	query := url.Values{
-		"fs":     {r.rcloneTarget},
-		"remote": {""},
+		"fs":     {target.fs},
+		"remote": {strings.Trim(dir, "/")},
 		"opt":    {string(opt)},
 	}
 	url := r.rcloneURL.JoinPath("operations/list")
@@ -633,42 +866,229 @@ func (r *Rclone) GetAllFiles(ctx context.Context) (iter.Seq[DirEntry], error) {
 
 	body, _, err := r.makeRequest(ctx, "POST", url)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer body.Close()
 
 	//nolint:tagliatelle
-	var resp struct {
-		List []struct {
-			Path    string    `json:"Path"`
-			IsDir   bool      `json:"IsDir"`
-			Size    int64     `json:"Size"`
-			ModTime time.Time `json:"ModTime"`
-		} `json:"list"`
+	type listItem struct {
+		Path    string    `json:"Path"`
+		IsDir   bool      `json:"IsDir"`
+		Size    int64     `json:"Size"`
+		ModTime time.Time `json:"ModTime"`
 	}
-	err = json.NewDecoder(body).Decode(&resp)
+	err = decodeJSONArrayField(json.NewDecoder(body), "list", func(decode func(v any) error) (bool, error) {
+		var v listItem
+		if err := decode(&v); err != nil {
+			return false, err
+		}
+
+		v.Path = target.addPrefix(misc.EnsurePrefix(v.Path, "/"))
+		if v.IsDir {
+			v.Path = misc.EnsureSuffix(v.Path, "/")
+			v.Size = 0 // see [Rclone.getDirInfo]
+		}
+
+		entry := DirEntry{
+			URL:     v.Path,
+			Leaf:    pkgPath.Base(v.Path),
+			IsDir:   v.IsDir,
+			Size:    v.Size,
+			ModTime: v.ModTime.Unix(),
+		}
+		return yield(entry), nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("couldn't decode rclone response: %w", err)
+		return fmt.Errorf("couldn't decode rclone response: %w", err)
 	}
+	return nil
+}
 
-	return func(yield func(DirEntry) bool) {
-		for _, v := range resp.List {
-			v.Path = misc.EnsurePrefix(v.Path, "/")
-			if v.IsDir {
-				v.Path = misc.EnsureSuffix(v.Path, "/")
-				v.Size = 0 // see [Rclone.getDirInfo]
+// decodeJSONArrayField decodes elements of the array field of a JSON object one by one.
+// Other fields are skipped. fn must decode exactly one element and report whether
+// decoding should continue.
+func decodeJSONArrayField(dec *json.Decoder, field string, fn func(decode func(v any) error) (bool, error)) error {
+	expectDelim := func(want json.Delim) error {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if t != want {
+			return fmt.Errorf("unexpected token %v, expected %q", t, want)
+		}
+		return nil
+	}
+
+	if err := expectDelim('{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if t != field {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
 			}
+			continue
+		}
 
-			entry := DirEntry{
-				URL:     v.Path,
-				Leaf:    pkgPath.Base(v.Path),
-				IsDir:   v.IsDir,
-				Size:    v.Size,
-				ModTime: v.ModTime.Unix(),
+		t, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			// null
+			continue
+		}
+		if t != json.Delim('[') {
+			return fmt.Errorf("unexpected token %v, expected '['", t)
+		}
+		for dec.More() {
+			ok, err := fn(dec.Decode)
+			if err != nil {
+				return err
 			}
-			if !yield(entry) {
-				return
+			if !ok {
+				return nil
 			}
 		}
-	}, nil
+		if err := expectDelim(']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim('}')
+}
+
+// GetHashsums returns content hashes of all files (path -> hash). The hash type is chosen from
+// the hashes supported by a remote, preferably MD5 or SHA-1. It returns an empty hash type
+// if remotes don't support any hashes. In multi-remote mode, remotes can support different
+// hashes - in this case, hashes are prefixed with their type ("md5:...") and the returned hash
+// type contains all types.
+//
+// Hashes are not calculated by rclone if the remote doesn't store them. However, some backends
+// (for example, local) have to read all files to calculate hashes.
+func (r *Rclone) GetHashsums(ctx context.Context) (hashType string, hashes map[string]string, err error) {
+	var (
+		hashTypes    []string
+		targetHashes = make(map[string]map[string]string) // hash type -> path -> hash
+	)
+	for _, target := range r.targets {
+		hashType, err := r.getPreferredHashType(ctx, target)
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't get supported hashes of %q: %w", target.fs, err)
+		}
+		if hashType == "" {
+			continue
+		}
+
+		hashes, err := r.getHashsums(ctx, target, hashType)
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't get hashes of %q: %w", target.fs, err)
+		}
+
+		if targetHashes[hashType] == nil {
+			hashTypes = append(hashTypes, hashType)
+			targetHashes[hashType] = make(map[string]string, len(hashes))
+		}
+		maps.Copy(targetHashes[hashType], hashes)
+	}
+
+	switch len(hashTypes) {
+	case 0:
+		return "", nil, nil
+	case 1:
+		return hashTypes[0], targetHashes[hashTypes[0]], nil
+	}
+
+	hashes = make(map[string]string)
+	for hashType, typeHashes := range targetHashes {
+		for path, hash := range typeHashes {
+			hashes[path] = hashType + ":" + hash
+		}
+	}
+	return strings.Join(hashTypes, ", "), hashes, nil
+}
+
+func (r *Rclone) getHashsums(ctx context.Context, target target, hashType string) (map[string]string, error) {
+	// Pass parameters as a query instead of JSON, see [Rclone.GetAllFiles].
+	query := url.Values{
+		"fs":       {target.fs},
+		"hashType": {hashType},
+		"download": {"false"},
+	}
+	url := r.rcloneURL.JoinPath("operations/hashsum")
+	url.RawQuery = query.Encode()
+
+	body, _, err := r.makeRequest(ctx, "POST", url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var resp struct {
+		Hashsum []string `json:"hashsum"`
+	}
+	err = json.NewDecoder(body).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode rclone response: %w", err)
+	}
+
+	hashes := make(map[string]string, len(resp.Hashsum))
+	for _, line := range resp.Hashsum {
+		path, hash, ok := parseHashsumLine(line)
+		if !ok {
+			continue
+		}
+		hashes[target.addPrefix(path)] = hash
+	}
+	return hashes, nil
+}
+
+func (r *Rclone) getPreferredHashType(ctx context.Context, target target) (string, error) {
+	query := url.Values{
+		"fs": {target.fs},
+	}
+	url := r.rcloneURL.JoinPath("operations/fsinfo")
+	url.RawQuery = query.Encode()
+
+	body, _, err := r.makeRequest(ctx, "POST", url)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	//nolint:tagliatelle
+	var resp struct {
+		Hashes []string `json:"Hashes"`
+	}
+	err = json.NewDecoder(body).Decode(&resp)
+	if err != nil {
+		return "", fmt.Errorf("couldn't decode rclone response: %w", err)
+	}
+
+	for _, preferred := range []string{"md5", "sha1"} {
+		if slices.Contains(resp.Hashes, preferred) {
+			return preferred, nil
+		}
+	}
+	if len(resp.Hashes) > 0 {
+		return resp.Hashes[0], nil
+	}
+	return "", nil
+}
+
+// parseHashsumLine parses a line in the "md5sum" format: "<hash>  <path>". Files without
+// hashes have an empty hash, they are skipped.
+func parseHashsumLine(line string) (path, hash string, ok bool) {
+	hash, path, ok = strings.Cut(line, "  ")
+	if !ok || hash == "" || path == "" {
+		return "", "", false
+	}
+	if strings.Trim(hash, " -") == "" || strings.Contains(hash, "UNSUPPORTED") || strings.Contains(hash, "ERROR") {
+		return "", "", false
+	}
+	return misc.EnsurePrefix(path, "/"), hash, true
 }
@@ -2,10 +2,12 @@ package rclone
 
 import (
 	"context"
+	"encoding/json"
 	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -32,7 +34,7 @@ func TestRclone_DirCache(t *testing.T) {
 	t.Run("cache disabled", func(t *testing.T) {
 		dir := t.TempDir()
 		rclone := startRclone(t, rview.RcloneConfig{
-			Target:      dir,
+			Targets:     rview.RcloneTargets{{Target: dir}},
 			Port:        32142,
 			DirCacheTTL: 0,
 		})
@@ -49,7 +51,7 @@ func TestRclone_DirCache(t *testing.T) {
 	t.Run("cache enabled", func(t *testing.T) {
 		dir := t.TempDir()
 		rclone := startRclone(t, rview.RcloneConfig{
-			Target:      dir,
+			Targets:     rview.RcloneTargets{{Target: dir}},
 			Port:        32142,
 			DirCacheTTL: time.Hour,
 		})
@@ -63,14 +65,15 @@ func TestRclone_DirCache(t *testing.T) {
 		r.Equal([]string{"1.txt"}, getEntries(t, rclone)) // got data from cache
 
 		// Expire cache.
-		{
-			item := rclone.dirCache.Get("/")
-			item.expiresAt = time.Now().Add(-time.Hour)
-		}
+		expireDirCache(t, rclone.dirCache.Get("/"), 2*time.Hour)
 
 		err = os.WriteFile(filepath.Join(dir, "2.txt"), nil, 0600)
 		r.NoError(err)
-		r.Equal([]string{"1.txt", "2.txt"}, getEntries(t, rclone)) // got data from rclone
+		r.Equal([]string{"1.txt"}, getEntries(t, rclone)) // got stale data from cache
+		r.Eventually(func() bool {
+			info, err := rclone.GetDirInfo(t.Context(), "/", "", "")
+			return err == nil && len(info.Entries) == 2
+		}, time.Second, 10*time.Millisecond) // got data from rclone
 	})
 }
 
@@ -151,7 +154,10 @@ func TestRclone_SortEntries(t *testing.T) {
 func startRclone(t *testing.T, cfg rview.RcloneConfig) *Rclone {
 	r := require.New(t)
 
-	rclone, err := NewRclone(cfg)
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	rclone, err := NewRclone(cfg, dirRoot)
 	r.NoError(err)
 	go func() {
 		if err := rclone.Start(); err != nil {
@@ -164,7 +170,10 @@ func startRclone(t *testing.T, cfg rview.RcloneConfig) *Rclone {
 	})
 
 	for i := range 5 {
-		_, err = rclone.GetAllFiles(t.Context())
+		err = nil
+		for _, err = range rclone.GetAllFiles(t.Context(), "") {
+			break // the first entry or error is enough
+		}
 		if err == nil {
 			return rclone
 		}
@@ -217,3 +226,93 @@ func TestCompareStrings(t *testing.T) {
 		})
 	})
 }
+
+func TestParseHashsumLine(t *testing.T) {
+	for _, tt := range []struct {
+		line     string
+		wantPath string
+		wantHash string
+		wantOk   bool
+	}{
+		{line: "d41d8cd98f00b204e9800998ecf8427e  photos/a b.jpg", wantPath: "/photos/a b.jpg", wantHash: "d41d8cd98f00b204e9800998ecf8427e", wantOk: true},
+		{line: "d41d8cd98f00b204e9800998ecf8427e  photos/a  b.jpg", wantPath: "/photos/a  b.jpg", wantHash: "d41d8cd98f00b204e9800998ecf8427e", wantOk: true},
+		{line: "                                  photos/no-hash.jpg"},
+		{line: "UNSUPPORTED  photos/a.jpg"},
+		{line: "invalid line"},
+	} {
+		path, hash, ok := parseHashsumLine(tt.line)
+		require.Equal(t, tt.wantOk, ok, tt.line)
+		require.Equal(t, tt.wantPath, path, tt.line)
+		require.Equal(t, tt.wantHash, hash, tt.line)
+	}
+}
+
+func TestDecodeJSONArrayField(t *testing.T) {
+	for _, tt := range []struct {
+		data    string
+		limit   int
+		want    []int
+		wantErr bool
+	}{
+		{data: `{"list": [1, 2, 3]}`, limit: 10, want: []int{1, 2, 3}},
+		{data: `{"a": {"list": [0]}, "list": [1, 2], "b": [0]}`, limit: 10, want: []int{1, 2}},
+		{data: `{"list": [1, 2, 3]}`, limit: 2, want: []int{1, 2}},
+		{data: `{"list": null}`, limit: 10, want: nil},
+		{data: `{}`, limit: 10, want: nil},
+		{data: `{"list": [1, 2`, limit: 10, want: []int{1, 2}, wantErr: true},
+		{data: `{"list": [1, "2"]}`, limit: 10, want: []int{1}, wantErr: true},
+		{data: `[1, 2]`, limit: 10, want: nil, wantErr: true},
+	} {
+		var got []int
+		err := decodeJSONArrayField(json.NewDecoder(strings.NewReader(tt.data)), "list", func(decode func(v any) error) (bool, error) {
+			var v int
+			if err := decode(&v); err != nil {
+				return false, err
+			}
+			got = append(got, v)
+			return len(got) < tt.limit, nil
+		})
+		require.Equal(t, tt.wantErr, err != nil, tt.data)
+		require.Equal(t, tt.want, got, tt.data)
+	}
+}
+
+func TestRclone_MultipleRemotes(t *testing.T) {
+	r := require.New(t)
+
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	rclone, err := NewRclone(rview.RcloneConfig{
+		URL: "http://localhost:5572",
+		Targets: rview.RcloneTargets{
+			{Name: "photos", Target: "s3:photos"},
+			{Name: "docs", Target: "/data/docs"},
+		},
+	}, dirRoot)
+	r.NoError(err)
+
+	r.Equal([]string{"photos", "docs"}, rclone.GetRemotes())
+
+	fileURL, err := rclone.getFileURL(rview.NewFileID("/photos/2024/cat #1.jpg", 0, 0))
+	r.NoError(err)
+	r.Equal("http://localhost:5572/[s3:photos]/2024/cat%20%231.jpg", fileURL.String())
+
+	fileURL, err = rclone.getFileURL(rview.NewFileID("/docs/a.txt", 0, 0))
+	r.NoError(err)
+	r.Equal("http://localhost:5572/[/data/docs]/a.txt", fileURL.String())
+
+	_, err = rclone.getFileURL(rview.NewFileID("/music/a.mp3", 0, 0))
+	r.True(IsNotFoundError(err))
+
+	info, err := rclone.GetDirInfo(t.Context(), "/", "", "")
+	r.NoError(err)
+	r.Equal("/", info.Dir)
+	r.Equal(
+		[]DirEntry{
+			{URL: "/docs/", Leaf: "docs/", IsDir: true},
+			{URL: "/photos/", Leaf: "photos/", IsDir: true},
+		},
+		info.Entries,
+	)
+}
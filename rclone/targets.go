@@ -0,0 +1,102 @@
+package rclone
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// target is an rclone target. In multi-remote mode, paths of all files of a target start
+// with its name: "/photos/2024/01.jpg" is "/2024/01.jpg" of target "photos".
+type target struct {
+	name string
+	fs   string
+}
+
+func newTargets(cfg rview.RcloneTargets) []target {
+	res := make([]target, 0, len(cfg))
+	for _, t := range cfg {
+		res = append(res, target{name: t.Name, fs: t.Target})
+	}
+	return res
+}
+
+// addPrefix converts a path within the target into a full path.
+func (t target) addPrefix(path string) string {
+	if t.name == "" {
+		return path
+	}
+	return "/" + t.name + misc.EnsurePrefix(path, "/")
+}
+
+// GetRemotes returns names of all remotes. In single-remote mode, it returns a single empty name.
+func (r *Rclone) GetRemotes() []string {
+	res := make([]string, 0, len(r.targets))
+	for _, t := range r.targets {
+		res = append(res, t.name)
+	}
+	return res
+}
+
+func (r *Rclone) getTarget(remote string) (target, error) {
+	for _, t := range r.targets {
+		if t.name == remote {
+			return t, nil
+		}
+	}
+	return target{}, newUnknownRemoteError(remote)
+}
+
+// resolvePath returns the target of a path and the path within this target.
+func (r *Rclone) resolvePath(path string) (target, string, error) {
+	if !r.multiRemote {
+		return r.targets[0], path, nil
+	}
+
+	remote, targetPath, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	t, err := r.getTarget(remote)
+	if err != nil {
+		return target{}, "", err
+	}
+	return t, "/" + targetPath, nil
+}
+
+// getFileURL returns an rclone url of a file.
+func (r *Rclone) getFileURL(id rview.FileID) (*url.URL, error) {
+	t, path, err := r.resolvePath(id.GetPath())
+	if err != nil {
+		return nil, err
+	}
+	targetID := rview.NewFileID(path, id.GetModTime(), id.GetSize())
+
+	return r.rcloneURL.JoinPath("["+t.fs+"]", targetID.GetEscapedPath()), nil
+}
+
+// getRemotesDirInfo returns the root directory in multi-remote mode. It contains all remotes.
+func (r *Rclone) getRemotesDirInfo() *DirInfo {
+	info := &DirInfo{
+		Dir:         "/",
+		Breadcrumbs: []DirBreadcrumb{{Text: "/"}},
+		Entries:     make([]DirEntry, 0, len(r.targets)),
+	}
+	for _, t := range r.targets {
+		info.Entries = append(info.Entries, DirEntry{
+			URL:   "/" + t.name + "/",
+			Leaf:  t.name + "/",
+			IsDir: true,
+		})
+	}
+	return info
+}
+
+// newUnknownRemoteError returns a "not found" error, see [IsNotFoundError].
+func newUnknownRemoteError(remote string) *RcloneError {
+	return &RcloneError{
+		StatusCode: http.StatusNotFound,
+		BodyPrefix: fmt.Sprintf("unknown remote %q", remote),
+	}
+}
@@ -1,6 +1,7 @@
 package rview
 
 import (
+	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -61,3 +62,68 @@ func TestMiB(t *testing.T) {
 		})
 	}
 }
+
+func TestRcloneTargets(t *testing.T) {
+	t.Run("single target", func(t *testing.T) {
+		r := require.New(t)
+
+		var targets RcloneTargets
+		r.NoError(targets.UnmarshalText([]byte(":s3,provider=AWS:bucket")))
+		r.Equal(RcloneTargets{{Target: ":s3,provider=AWS:bucket"}}, targets)
+		r.False(targets.IsMultiRemote())
+		r.Nil(targets.GetNames())
+
+		r.Error(targets.UnmarshalText([]byte("/data")))
+	})
+
+	t.Run("multiple targets", func(t *testing.T) {
+		r := require.New(t)
+
+		var targets RcloneTargets
+		r.NoError(targets.UnmarshalText([]byte("photos=my-s3:photos")))
+		r.NoError(targets.UnmarshalText([]byte("old_docs=:sftp,host=nas:docs")))
+		r.Equal(
+			RcloneTargets{
+				{Name: "photos", Target: "my-s3:photos"},
+				{Name: "old_docs", Target: ":sftp,host=nas:docs"},
+			},
+			targets,
+		)
+		r.True(targets.IsMultiRemote())
+		r.Equal([]string{"photos", "old_docs"}, targets.GetNames())
+		r.Equal("photos=my-s3:photos, old_docs=:sftp,host=nas:docs", targets.String())
+
+		r.ErrorContains(targets.UnmarshalText([]byte("photos=/data")), "duplicate target name")
+		r.ErrorContains(targets.UnmarshalText([]byte("/data")), "all targets must be named")
+	})
+
+	t.Run("invalid targets", func(t *testing.T) {
+		r := require.New(t)
+
+		var targets RcloneTargets
+		r.Error(targets.UnmarshalText([]byte("")))
+		r.Error(targets.UnmarshalText([]byte("photos=")))
+	})
+}
+
+func TestIPPrefixes(t *testing.T) {
+	r := require.New(t)
+
+	var prefixes IPPrefixes
+	r.NoError(prefixes.UnmarshalText([]byte("10.1.2.3/8, 127.0.0.1,::1")))
+	r.Equal(
+		IPPrefixes{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("127.0.0.1/32"),
+			netip.MustParsePrefix("::1/128"),
+		},
+		prefixes,
+	)
+	r.Equal("10.0.0.0/8,127.0.0.1/32,::1/128", prefixes.String())
+
+	r.NoError(prefixes.UnmarshalText([]byte("")))
+	r.Empty(prefixes)
+
+	r.Error(prefixes.UnmarshalText([]byte("localhost")))
+	r.Error(prefixes.UnmarshalText([]byte("10.0.0.0/33")))
+}
@@ -9,12 +9,22 @@ const (
 	FileTypeAudio    FileType = "audio"
 	FileTypeVideo    FileType = "video"
 	FileTypeText     FileType = "text"
+	FileTypeDocument FileType = "document"
 )
 
 func GetFileType(ext string) FileType {
 	return fileTypesByExtension[ext]
 }
 
+// GetLanguage returns the language of a text file for syntax highlighting. It returns
+// an empty string if the language is unknown.
+func GetLanguage(ext string) string {
+	if GetFileType(ext) != FileTypeText {
+		return ""
+	}
+	return languagesByExtension[ext]
+}
+
 var fileTypesByExtension = map[string]FileType{
 	// Image
 	".bmp":  FileTypeImage,
@@ -40,6 +50,7 @@ var fileTypesByExtension = map[string]FileType{
 	".wma":  FileTypeAudio,
 	".wpl":  FileTypeAudio,
 	".ogg":  FileTypeAudio,
+	".opus": FileTypeAudio,
 	".wav":  FileTypeAudio,
 	".mp3":  FileTypeAudio,
 
@@ -52,6 +63,16 @@ var fileTypesByExtension = map[string]FileType{
 	".mp4":  FileTypeVideo,
 	".webm": FileTypeVideo,
 
+	// Document
+	".pdf":  FileTypeDocument,
+	".epub": FileTypeDocument,
+	".odt":  FileTypeDocument,
+	".ods":  FileTypeDocument,
+	".odp":  FileTypeDocument,
+	".docx": FileTypeDocument,
+	".xlsx": FileTypeDocument,
+	".pptx": FileTypeDocument,
+
 	// Text (from https://github.com/github/linguist/blob/master/lib/linguist/languages.yml)
 	".cfg":           FileTypeText,
 	".m":             FileTypeText,
@@ -377,6 +398,7 @@ var fileTypesByExtension = map[string]FileType{
 	".metal":         FileTypeText,
 	".gml":           FileTypeText,
 	".md":            FileTypeText,
+	".markdown":      FileTypeText,
 	".ni":            FileTypeText,
 	".lgt":           FileTypeText,
 	".mo":            FileTypeText,
@@ -511,3 +533,38 @@ var fileTypesByExtension = map[string]FileType{
 	".nlogo":         FileTypeText,
 	".litcoffee":     FileTypeText,
 }
+
+// languagesByExtension contains languages of the most popular text files. All extensions
+// must be present in [fileTypesByExtension].
+var languagesByExtension = map[string]string{
+	".go":         "go",
+	".py":         "python",
+	".js":         "javascript",
+	".jsx":        "javascript",
+	".ts":         "typescript",
+	".rs":         "rust",
+	".c":          "c",
+	".cpp":        "cpp",
+	".java":       "java",
+	".kt":         "kotlin",
+	".scala":      "scala",
+	".swift":      "swift",
+	".cs":         "csharp",
+	".dart":       "dart",
+	".rb":         "ruby",
+	".php":        "php",
+	".lua":        "lua",
+	".sh":         "shell",
+	".dockerfile": "dockerfile",
+	".sql":        "sql",
+	".proto":      "protobuf",
+	".json":       "json",
+	".yml":        "yaml",
+	".toml":       "toml",
+	".ini":        "ini",
+	".html":       "html",
+	".vue":        "html",
+	".xml":        "xml",
+	".css":        "css",
+	".scss":       "css",
+}
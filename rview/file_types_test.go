@@ -0,0 +1,19 @@
+package rview
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLanguage(t *testing.T) {
+	r := require.New(t)
+
+	for ext := range languagesByExtension {
+		r.Equal(FileTypeText, GetFileType(ext), ext)
+	}
+
+	r.Equal("go", GetLanguage(".go"))
+	r.Empty(GetLanguage(".txt"))
+	r.Empty(GetLanguage(".jpg"))
+}
@@ -0,0 +1,432 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ShoshinNikita/rview/pkg/highlight"
+	"github.com/ShoshinNikita/rview/pkg/metrics"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// ErrContentSearchDisabled is returned when the search request contains "content:",
+// but the content index is disabled.
+var ErrContentSearchDisabled = errors.New("content search is disabled")
+
+// contentIndex contains content of text files for full-text search. The whole content is
+// kept in memory, so only files below the size limit are indexed.
+type contentIndex struct {
+	// Files contains indexed files by path.
+	Files map[string]*contentFile `json:"files"`
+}
+
+type contentFile struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+	Content string `json:"content"`
+
+	lowerCasedContent string
+}
+
+// Snippet is a part of a file content around the first match.
+type Snippet struct {
+	Text string
+	// Matches contains byte offsets of matches in Text: [start, end). Matches are sorted
+	// and don't overlap.
+	Matches [][2]int
+}
+
+const (
+	snippetBefore = 60
+	snippetAfter  = 140
+
+	// contentSaveInterval is the number of indexed files after which the content index
+	// is saved on disk, so the progress is not lost on restart.
+	contentSaveInterval = 200
+)
+
+func newContentIndex() *contentIndex {
+	return &contentIndex{
+		Files: make(map[string]*contentFile),
+	}
+}
+
+func newContentFile(size, modTime int64, content string) *contentFile {
+	file := &contentFile{
+		Size:    size,
+		ModTime: modTime,
+		Content: content,
+	}
+	file.prepare()
+	return file
+}
+
+func (index *contentIndex) prepare() {
+	if index.Files == nil {
+		index.Files = make(map[string]*contentFile)
+	}
+	for _, file := range index.Files {
+		file.prepare()
+	}
+}
+
+func (file *contentFile) prepare() {
+	file.lowerCasedContent = lowerCaseKeepOffsets(file.Content)
+}
+
+func (s *Service) loadOrPrepareContentIndex(remote string) {
+	var index *contentIndex
+	err := s.loadFromCache(getContentIndexFilename(remote), &index)
+	if err == nil && index != nil {
+		rlog.Infof("search content index %q has been loaded from the file", remote)
+		index.prepare()
+	} else {
+		// The index will be filled in the background.
+		rlog.Infof("prepare new content index %q: couldn't load index from the file: %v", remote, err)
+		index = newContentIndex()
+	}
+
+	s.mu.Lock()
+	s.contentIndexes[remote] = index
+	s.mu.Unlock()
+}
+
+// requestContentIndexing requests an update of content indexes. It doesn't block.
+func (s *Service) requestContentIndexing() {
+	if !s.opts.ContentIndex {
+		return
+	}
+	select {
+	case s.contentRefreshCh <- struct{}{}:
+	default:
+		// Update has already been requested.
+	}
+}
+
+func (s *Service) startContentIndexing() {
+	defer close(s.contentStoppedCh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case <-s.contentRefreshCh:
+			for _, remote := range s.rclone.GetRemotes() {
+				err := s.updateContentIndex(ctx, remote)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					rlog.Errorf("couldn't update search content index %q: %s", remote, err)
+				}
+			}
+		}
+	}
+}
+
+// updateContentIndex downloads new and modified files and removes deleted files from the
+// content index. The index is saved periodically, so the progress is not lost.
+func (s *Service) updateContentIndex(ctx context.Context, remote string) error {
+	now := time.Now()
+
+	var (
+		toIndex  []dirEntry
+		toDelete []string
+	)
+	s.mu.RLock()
+	pathIndex, index := s.indexes[remote], s.contentIndexes[remote]
+	if pathIndex != nil && index != nil {
+		paths := make(map[string]bool)
+		for entry := range pathIndex.Index.entries() {
+			if !canIndexContent(entry, s.opts.ContentMaxFileSize) {
+				continue
+			}
+			paths[entry.Path] = true
+
+			file, ok := index.Files[entry.Path]
+			if ok && file.Size == entry.Size && file.ModTime == entry.ModTime {
+				continue
+			}
+			toIndex = append(toIndex, entry)
+		}
+		for path := range index.Files {
+			if !paths[path] {
+				toDelete = append(toDelete, path)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(toIndex) == 0 && len(toDelete) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	for _, path := range toDelete {
+		delete(index.Files, path)
+	}
+	s.mu.Unlock()
+
+	// Index files in a stable order.
+	slices.SortFunc(toIndex, func(a, b dirEntry) int {
+		return cmp.Compare(a.Path, b.Path)
+	})
+
+	var indexed, failed int
+	for i, entry := range toIndex {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		content, err := s.readContent(ctx, entry)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Try again on the next update.
+			metrics.SearchContentIndexErrors.Inc()
+			failed++
+			rlog.Debugf("couldn't index content of %q: %s", entry.Path, err)
+			continue
+		}
+
+		file := newContentFile(entry.Size, entry.ModTime, content)
+		s.mu.Lock()
+		index.Files[entry.Path] = file
+		s.mu.Unlock()
+		indexed++
+
+		if (i+1)%contentSaveInterval == 0 {
+			if err := s.saveContentIndex(remote, index); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.saveContentIndex(remote, index); err != nil {
+		return err
+	}
+
+	rlog.Infof(
+		"search content index %q has been updated in %s, indexed: %d, failed: %d, deleted: %d",
+		remote, time.Since(now), indexed, failed, len(toDelete),
+	)
+	return nil
+}
+
+func (s *Service) readContent(ctx context.Context, entry dirEntry) (string, error) {
+	rc, err := s.rclone.OpenFile(ctx, rview.NewFileID(entry.Path, entry.ModTime, entry.Size))
+	if err != nil {
+		return "", fmt.Errorf("couldn't open file: %w", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, s.opts.ContentMaxFileSize))
+	if err != nil {
+		return "", fmt.Errorf("couldn't read file: %w", err)
+	}
+
+	encoding, bomSize := highlight.DetectEncoding(data)
+	text, _ := highlight.Decode(data[bomSize:], encoding)
+	return text, nil
+}
+
+func (s *Service) saveContentIndex(remote string, index *contentIndex) error {
+	// Files are never modified, so a shallow copy is enough to save the index without
+	// blocking search requests.
+	s.mu.RLock()
+	snapshot := &contentIndex{
+		Files: maps.Clone(index.Files),
+	}
+	s.mu.RUnlock()
+
+	err := s.saveToCache(getContentIndexFilename(remote), snapshot)
+	if err != nil {
+		return fmt.Errorf("couldn't save content index: %w", err)
+	}
+	return nil
+}
+
+// searchContent returns files which contents match the request. It must be called
+// with the read lock held.
+func (s *Service) searchContent(req searchRequest, limit int, filter func(path string, isDir bool) bool) ([]Hit, int) {
+	words := make([]string, 0, len(req.contentMatches))
+	for _, word := range req.contentMatches {
+		words = append(words, lowerCaseKeepOffsets(word))
+	}
+
+	type contentHit struct {
+		Hit
+		file *contentFile
+	}
+	var hits []contentHit
+	for remote, index := range s.contentIndexes {
+		pathIndex := s.indexes[remote]
+		if pathIndex == nil {
+			continue
+		}
+
+		candidates := func(yield func(Hit) bool) {
+			for path, file := range index.Files {
+				if filter != nil && !filter(path, false) {
+					continue
+				}
+				if !yield(Hit{Path: path, Size: file.Size, ModTime: file.ModTime}) {
+					return
+				}
+			}
+		}
+		if req.hasPathTerms() {
+			// Check only files which paths match the request.
+			pathHits, _ := pathIndex.Index.search(req, math.MaxInt, func(path string, isDir bool) bool {
+				return !isDir && index.Files[path] != nil && (filter == nil || filter(path, isDir))
+			})
+			candidates = slices.Values(pathHits)
+		}
+
+		for hit := range candidates {
+			file := index.Files[hit.Path]
+			count := file.countMatches(words)
+			if count == 0 {
+				continue
+			}
+			hit.Score = float32(count)
+			hits = append(hits, contentHit{Hit: hit, file: file})
+		}
+	}
+
+	total := len(hits)
+	slices.SortFunc(hits, func(a, b contentHit) int {
+		return compareHits(a.Hit, b.Hit)
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	res := make([]Hit, 0, len(hits))
+	for _, hit := range hits {
+		hit.Snippet = hit.file.getSnippet(words)
+		res = append(res, hit.Hit)
+	}
+	return res, total
+}
+
+// canIndexContent reports whether the content of the file can be indexed.
+func canIndexContent(entry dirEntry, maxFileSize int64) bool {
+	return !entry.IsDir && entry.Size <= maxFileSize && rview.GetFileType(rview.GetFileExt(entry.Path)) == rview.FileTypeText
+}
+
+// countMatches returns the total number of matches of all words. It returns 0 if any of
+// the words is not found. Words must be lower-cased.
+func (file *contentFile) countMatches(words []string) (count int) {
+	for _, word := range words {
+		n := strings.Count(file.lowerCasedContent, word)
+		if n == 0 {
+			return 0
+		}
+		count += n
+	}
+	return count
+}
+
+// getSnippet returns a part of the content around the first match of the first word.
+// Words must be lower-cased.
+func (file *contentFile) getSnippet(words []string) *Snippet {
+	start := strings.Index(file.lowerCasedContent, words[0])
+	if start == -1 {
+		return nil
+	}
+
+	from := max(0, start-snippetBefore)
+	for from > 0 && !utf8.RuneStart(file.Content[from]) {
+		from++
+	}
+	to := min(len(file.Content), start+len(words[0])+snippetAfter)
+	for to < len(file.Content) && !utf8.RuneStart(file.Content[to]) {
+		to--
+	}
+
+	// Show the snippet in a single line. Offsets are not changed.
+	text := strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, file.Content[from:to])
+	lowerCasedText := file.lowerCasedContent[from:to]
+
+	var matches [][2]int
+	for _, word := range words {
+		for i := 0; ; {
+			j := strings.Index(lowerCasedText[i:], word)
+			if j == -1 {
+				break
+			}
+			matches = append(matches, [2]int{i + j, i + j + len(word)})
+			i += j + len(word)
+		}
+	}
+	slices.SortFunc(matches, func(a, b [2]int) int {
+		return cmp.Compare(a[0], b[0])
+	})
+	// Merge overlapping matches.
+	merged := matches[:0]
+	for _, m := range matches {
+		if last := len(merged) - 1; last >= 0 && m[0] <= merged[last][1] {
+			merged[last][1] = max(merged[last][1], m[1])
+			continue
+		}
+		merged = append(merged, m)
+	}
+
+	const ellipsis = "…"
+	if from > 0 {
+		text = ellipsis + text
+		for i := range merged {
+			merged[i][0] += len(ellipsis)
+			merged[i][1] += len(ellipsis)
+		}
+	}
+	if to < len(file.Content) {
+		text += ellipsis
+	}
+
+	return &Snippet{
+		Text:    text,
+		Matches: merged,
+	}
+}
+
+// lowerCaseKeepOffsets lower-cases the text. Characters which lower-cased forms have a different
+// size are not changed: byte offsets in the original and the resulting strings must match.
+func lowerCaseKeepOffsets(s string) string {
+	return strings.Map(func(r rune) rune {
+		lower := unicode.ToLower(r)
+		if utf8.RuneLen(lower) != utf8.RuneLen(r) {
+			return r
+		}
+		return lower
+	}, s)
+}
@@ -0,0 +1,67 @@
+package search
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentFile_getSnippet(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name    string
+		content string
+		words   []string
+		want    *Snippet
+	}{
+		{
+			name:    "short",
+			content: "Hello,\tWorld!\nhello again",
+			words:   []string{"hello", "world"},
+			want: &Snippet{
+				Text:    "Hello, World! hello again",
+				Matches: [][2]int{{0, 5}, {7, 12}, {14, 19}},
+			},
+		},
+		{
+			name:    "overlapping matches",
+			content: "abcdef",
+			words:   []string{"bcd", "cde"},
+			want: &Snippet{
+				Text:    "abcdef",
+				Matches: [][2]int{{1, 5}},
+			},
+		},
+		{
+			name:    "long",
+			content: strings.Repeat("я", 100) + "Match" + strings.Repeat("b", 200),
+			words:   []string{"match"},
+			want: &Snippet{
+				Text:    "…" + strings.Repeat("я", 30) + "Match" + strings.Repeat("b", 140) + "…",
+				Matches: [][2]int{{63, 68}},
+			},
+		},
+		{
+			name:    "unicode",
+			content: "Привет, МИР",
+			words:   []string{"мир"},
+			want: &Snippet{
+				Text:    "Привет, МИР",
+				Matches: [][2]int{{14, 20}},
+			},
+		},
+		{
+			name:    "no matches",
+			content: "abc",
+			words:   []string{"xyz"},
+			want:    nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			file := newContentFile(int64(len(tt.content)), 0, tt.content)
+			require.Equal(t, tt.want, file.getSnippet(tt.words))
+		})
+	}
+}
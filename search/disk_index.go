@@ -0,0 +1,496 @@
+package search
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"iter"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// diskIndex is a read-only index stored in a memory-mapped file. It is searched without
+// decoding the whole file: only posting lists of the requested prefixes and the required
+// entries are decoded.
+//
+// The file has the following layout (all integers are little-endian):
+//
+//	header         | see [diskIndexHeader]
+//	entry blocks   | entries sorted by path, grouped by [entriesPerBlock]
+//	block offsets  | uint64 offset of every entry block
+//	prefix records | prefixes sorted in byte order with their posting lists
+//	prefix offsets | uint64 offset of every prefix record
+//
+// Paths in a block are front-coded: every entry contains the length of the prefix shared with
+// the previous path (uvarint), the length of the remaining suffix (uvarint), the suffix, flags
+// (1 byte), size (uvarint) and mod time (varint). The first entry of a block is stored in full.
+//
+// A prefix record contains the length of the prefix (uvarint), the prefix, the number of ids
+// (uvarint) and delta-encoded sorted ids (uvarint). Ids are positions of entries in the sorted
+// list of entries.
+type diskIndex struct {
+	header diskIndexHeader
+	data   []byte
+	unmap  func() error
+}
+
+const (
+	diskIndexMagic   = "RVIEWIDX"
+	diskIndexVersion = 1
+
+	diskIndexHeaderSize = 64
+	entriesPerBlock     = 16
+
+	entryFlagIsDir = 1 << 0
+)
+
+type diskIndexHeader struct {
+	Version      uint32
+	MinPrefixLen uint16
+	MaxPrefixLen uint16
+	CreatedAt    int64 // unix nano
+	EntryCount   uint32
+	PrefixCount  uint32
+	// BlockOffsets and PrefixOffsets are offsets of the corresponding tables.
+	BlockOffsets  uint64
+	PrefixOffsets uint64
+	// Checksum is CRC-32 of everything after the header.
+	Checksum uint32
+}
+
+func (h diskIndexHeader) encode() []byte {
+	buf := make([]byte, diskIndexHeaderSize)
+	copy(buf, diskIndexMagic)
+	binary.LittleEndian.PutUint32(buf[8:], h.Version)
+	binary.LittleEndian.PutUint16(buf[12:], h.MinPrefixLen)
+	binary.LittleEndian.PutUint16(buf[14:], h.MaxPrefixLen)
+	binary.LittleEndian.PutUint64(buf[16:], uint64(h.CreatedAt)) //nolint:gosec
+	binary.LittleEndian.PutUint32(buf[24:], h.EntryCount)
+	binary.LittleEndian.PutUint32(buf[28:], h.PrefixCount)
+	binary.LittleEndian.PutUint64(buf[32:], h.BlockOffsets)
+	binary.LittleEndian.PutUint64(buf[40:], h.PrefixOffsets)
+	binary.LittleEndian.PutUint32(buf[48:], h.Checksum)
+	return buf
+}
+
+func decodeDiskIndexHeader(data []byte) (h diskIndexHeader, err error) {
+	if len(data) < diskIndexHeaderSize || string(data[:len(diskIndexMagic)]) != diskIndexMagic {
+		return h, errors.New("invalid file format")
+	}
+	h.Version = binary.LittleEndian.Uint32(data[8:])
+	if h.Version != diskIndexVersion {
+		return h, fmt.Errorf("unsupported version %d, expected %d", h.Version, diskIndexVersion)
+	}
+	h.MinPrefixLen = binary.LittleEndian.Uint16(data[12:])
+	h.MaxPrefixLen = binary.LittleEndian.Uint16(data[14:])
+	h.CreatedAt = int64(binary.LittleEndian.Uint64(data[16:])) //nolint:gosec
+	h.EntryCount = binary.LittleEndian.Uint32(data[24:])
+	h.PrefixCount = binary.LittleEndian.Uint32(data[28:])
+	h.BlockOffsets = binary.LittleEndian.Uint64(data[32:])
+	h.PrefixOffsets = binary.LittleEndian.Uint64(data[40:])
+	h.Checksum = binary.LittleEndian.Uint32(data[48:])
+	return h, nil
+}
+
+// newEmptyDiskIndex returns an index without entries. It is used when there is no file yet.
+func newEmptyDiskIndex(minPrefixLen, maxPrefixLen int) *diskIndex {
+	return &diskIndex{
+		header: diskIndexHeader{
+			Version:      diskIndexVersion,
+			MinPrefixLen: uint16(minPrefixLen), //nolint:gosec
+			MaxPrefixLen: uint16(maxPrefixLen), //nolint:gosec
+		},
+		unmap: func() error { return nil },
+	}
+}
+
+// openDiskIndex maps the file into memory and checks its integrity. The file can be closed
+// after the call. [diskIndex.Close] must be called when the index is no longer used.
+func openDiskIndex(f *os.File) (_ *diskIndex, err error) {
+	data, unmap, err := mmapFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't map file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = unmap()
+		}
+	}()
+
+	header, err := decodeDiskIndexHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data[diskIndexHeaderSize:]) != header.Checksum {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	size := uint64(len(data))
+	blockCount := (uint64(header.EntryCount) + entriesPerBlock - 1) / entriesPerBlock
+	if header.BlockOffsets > size || blockCount*8 > size-header.BlockOffsets ||
+		header.PrefixOffsets > size || uint64(header.PrefixCount)*8 != size-header.PrefixOffsets {
+		return nil, errors.New("invalid section offsets")
+	}
+
+	return &diskIndex{
+		header: header,
+		data:   data,
+		unmap:  unmap,
+	}, nil
+}
+
+func (index *diskIndex) Close() error {
+	return index.unmap()
+}
+
+func (index *diskIndex) Check(wantMin, wantMax int) error {
+	minLen, maxLen := int(index.header.MinPrefixLen), int(index.header.MaxPrefixLen)
+	if minLen != wantMin || maxLen != wantMax {
+		return fmt.Errorf(
+			"prefix sizes are different: [%d; %d] (index) != [%d; %d] (expected)",
+			minLen, maxLen, wantMin, wantMax,
+		)
+	}
+	return nil
+}
+
+func (index *diskIndex) count() uint32 {
+	return index.header.EntryCount
+}
+
+func (index *diskIndex) blockCount() int {
+	return int((index.header.EntryCount + entriesPerBlock - 1) / entriesPerBlock)
+}
+
+// readBlock calls fn for every entry of the block until it returns false.
+func (index *diskIndex) readBlock(block int, fn func(id uint32, entry dirEntry) bool) {
+	offset := binary.LittleEndian.Uint64(index.data[index.header.BlockOffsets+uint64(block)*8:])
+	r := byteReader{data: index.data, offset: offset}
+
+	var path []byte
+	id := uint32(block) * entriesPerBlock //nolint:gosec
+	for i := 0; i < entriesPerBlock && id < index.header.EntryCount; i++ {
+		shared := r.uvarint()
+		suffix := r.bytes(r.uvarint())
+		path = append(path[:shared], suffix...)
+
+		flags := r.byte()
+		entry := dirEntry{
+			Path:    string(path),
+			IsDir:   flags&entryFlagIsDir != 0,
+			Size:    int64(r.uvarint()), //nolint:gosec
+			ModTime: r.varint(),
+		}
+		if !fn(id, entry) {
+			return
+		}
+		id++
+	}
+}
+
+// firstPath returns the path of the first entry of the block.
+func (index *diskIndex) firstPath(block int) (path string) {
+	index.readBlock(block, func(_ uint32, entry dirEntry) bool {
+		path = entry.Path
+		return false
+	})
+	return path
+}
+
+func (index *diskIndex) getEntry(id uint32) (res dirEntry, ok bool) {
+	if id >= index.header.EntryCount {
+		return dirEntry{}, false
+	}
+	index.readBlock(int(id/entriesPerBlock), func(entryID uint32, entry dirEntry) bool {
+		if entryID == id {
+			res, ok = entry, true
+			return false
+		}
+		return true
+	})
+	return res, ok
+}
+
+// entriesFrom returns entries in path order, starting from the first entry which path is
+// greater than or equal to the passed one.
+func (index *diskIndex) entriesFrom(path string) iter.Seq2[uint32, dirEntry] {
+	return func(yield func(uint32, dirEntry) bool) {
+		// The last block which first path is less than or equal to the passed one.
+		block := sort.Search(index.blockCount(), func(i int) bool {
+			return index.firstPath(i) > path
+		}) - 1
+		block = max(block, 0)
+
+		for ; block < index.blockCount(); block++ {
+			stop := false
+			index.readBlock(block, func(id uint32, entry dirEntry) bool {
+				if entry.Path < path {
+					return true
+				}
+				stop = !yield(id, entry)
+				return !stop
+			})
+			if stop {
+				return
+			}
+		}
+	}
+}
+
+func (index *diskIndex) entries() iter.Seq2[uint32, dirEntry] {
+	return index.entriesFrom("")
+}
+
+func (index *diskIndex) findPath(path string) (uint32, bool) {
+	for id, entry := range index.entriesFrom(path) {
+		return id, entry.Path == path
+	}
+	return 0, false
+}
+
+// readPrefix returns the prefix and the offset of its posting list.
+func (index *diskIndex) readPrefix(i int) (string, byteReader) {
+	offset := binary.LittleEndian.Uint64(index.data[index.header.PrefixOffsets+uint64(i)*8:])
+	r := byteReader{data: index.data, offset: offset}
+	prefix := r.bytes(r.uvarint())
+	return string(prefix), r
+}
+
+func (index *diskIndex) getIDs(prefix string) []uint32 {
+	count := int(index.header.PrefixCount)
+	i := sort.Search(count, func(i int) bool {
+		p, _ := index.readPrefix(i)
+		return p >= prefix
+	})
+	if i == count {
+		return nil
+	}
+	p, r := index.readPrefix(i)
+	if p != prefix {
+		return nil
+	}
+	return r.postings()
+}
+
+func (index *diskIndex) fuzzyPrefixes(query []rune, maxDist int) iter.Seq2[string, int] {
+	getPrefix := func(i int) string {
+		prefix, _ := index.readPrefix(i)
+		return prefix
+	}
+	return fuzzyMatchSorted(int(index.header.PrefixCount), getPrefix, query, maxDist)
+}
+
+// prefixes returns all prefixes in byte order with their posting lists.
+func (index *diskIndex) prefixes() iter.Seq2[string, []uint32] {
+	return func(yield func(string, []uint32) bool) {
+		for i := range int(index.header.PrefixCount) {
+			prefix, r := index.readPrefix(i)
+			if !yield(prefix, r.postings()) {
+				return
+			}
+		}
+	}
+}
+
+// byteReader decodes values of the memory-mapped file. The integrity of the file is checked
+// in [openDiskIndex], so errors are not expected.
+type byteReader struct {
+	data   []byte
+	offset uint64
+}
+
+func (r *byteReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.offset:])
+	r.offset += uint64(n) //nolint:gosec
+	return v
+}
+
+func (r *byteReader) varint() int64 {
+	v, n := binary.Varint(r.data[r.offset:])
+	r.offset += uint64(n) //nolint:gosec
+	return v
+}
+
+func (r *byteReader) byte() byte {
+	v := r.data[r.offset]
+	r.offset++
+	return v
+}
+
+func (r *byteReader) bytes(n uint64) []byte {
+	v := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return v
+}
+
+func (r *byteReader) postings() []uint32 {
+	ids := make([]uint32, r.uvarint())
+	var prev uint32
+	for i := range ids {
+		prev += uint32(r.uvarint()) //nolint:gosec
+		ids[i] = prev
+	}
+	return ids
+}
+
+// writeDiskIndex writes all entries of the layered index to the file in the format
+// of [diskIndex]. Entries and prefixes of both layers are merged on the fly, so only
+// id mappings and offset tables are kept in memory.
+func writeDiskIndex(f *os.File, index *layeredIndex, createdAt time.Time) error {
+	w := &diskIndexWriter{
+		w:   bufio.NewWriterSize(f, 1<<20),
+		crc: crc32.NewIEEE(),
+	}
+
+	// Reserve space for the header, it is written at the end.
+	_, err := w.w.Write(make([]byte, diskIndexHeaderSize))
+	if err != nil {
+		return err
+	}
+	w.offset = diskIndexHeaderSize
+
+	// Entries
+
+	var (
+		baseIDs      = make([]uint32, index.base.count()) // old id -> new id
+		overlayIDs   = make(map[uint32]uint32, len(index.overlay.Entries))
+		blockOffsets []uint64
+		newID        uint32
+		prevPath     string
+	)
+	for entry := range index.sortedEntries(baseIDs, overlayIDs) {
+		if newID%entriesPerBlock == 0 {
+			blockOffsets = append(blockOffsets, w.offset)
+			prevPath = ""
+		}
+
+		shared := commonPrefixLen(prevPath, entry.Path)
+		w.uvarint(uint64(shared))
+		w.uvarint(uint64(len(entry.Path) - shared))
+		w.string(entry.Path[shared:])
+		var flags byte
+		if entry.IsDir {
+			flags |= entryFlagIsDir
+		}
+		w.byte(flags)
+		w.uvarint(uint64(entry.Size)) //nolint:gosec
+		w.varint(entry.ModTime)
+
+		prevPath = entry.Path
+		newID++
+	}
+
+	blockOffsetsOffset := w.offset
+	for _, offset := range blockOffsets {
+		w.uint64(offset)
+	}
+
+	// Prefixes
+
+	var (
+		prefixOffsets []uint64
+		ids           []uint32
+	)
+	for prefix, prefixIDs := range index.sortedPrefixes() {
+		ids = ids[:0]
+		for _, id := range prefixIDs.base {
+			if newID := baseIDs[id]; newID != deletedEntryID {
+				ids = append(ids, newID)
+			}
+		}
+		for _, id := range prefixIDs.overlay {
+			ids = append(ids, overlayIDs[id])
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		slices.Sort(ids)
+
+		prefixOffsets = append(prefixOffsets, w.offset)
+		w.uvarint(uint64(len(prefix)))
+		w.string(prefix)
+		w.uvarint(uint64(len(ids)))
+		var prev uint32
+		for _, id := range ids {
+			w.uvarint(uint64(id - prev))
+			prev = id
+		}
+	}
+
+	prefixOffsetsOffset := w.offset
+	for _, offset := range prefixOffsets {
+		w.uint64(offset)
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+
+	minLen, maxLen := index.getPrefixLens()
+	header := diskIndexHeader{
+		Version:       diskIndexVersion,
+		MinPrefixLen:  uint16(minLen), //nolint:gosec
+		MaxPrefixLen:  uint16(maxLen), //nolint:gosec
+		CreatedAt:     createdAt.UnixNano(),
+		EntryCount:    newID,
+		PrefixCount:   uint32(len(prefixOffsets)), //nolint:gosec
+		BlockOffsets:  blockOffsetsOffset,
+		PrefixOffsets: prefixOffsetsOffset,
+		Checksum:      w.crc.Sum32(),
+	}
+	_, err = f.WriteAt(header.encode(), 0)
+	return err
+}
+
+type diskIndexWriter struct {
+	w      *bufio.Writer
+	crc    hash.Hash32
+	offset uint64
+	err    error
+	buf    [binary.MaxVarintLen64]byte
+}
+
+func (w *diskIndexWriter) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(data)
+	_, _ = w.crc.Write(data)
+	w.offset += uint64(len(data))
+}
+
+func (w *diskIndexWriter) string(v string) { w.write([]byte(v)) }
+func (w *diskIndexWriter) byte(v byte)     { w.write([]byte{v}) }
+
+func (w *diskIndexWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(w.buf[:], v)
+	w.write(w.buf[:n])
+}
+
+func (w *diskIndexWriter) varint(v int64) {
+	n := binary.PutVarint(w.buf[:], v)
+	w.write(w.buf[:n])
+}
+
+func (w *diskIndexWriter) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[:], v)
+	w.write(w.buf[:8])
+}
+
+func commonPrefixLen(a, b string) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
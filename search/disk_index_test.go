@@ -0,0 +1,277 @@
+package search
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskIndex(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	var entries []rclone.DirEntry
+	for i := range 10 {
+		dir := fmt.Sprintf("/games/game %d/", i)
+		entries = append(entries, newDirEntry(dir))
+		for j := range 7 {
+			entries = append(entries, newDirEntryWithMetadata(fmt.Sprintf("%ssave %d.sav", dir, j), int64(i*j), -int64(j)))
+		}
+	}
+	entries = append(entries,
+		newDirEntry("/games/"),
+		newDirEntry("/изображения/лето 2022/"),
+		newDirEntryWithMetadata("/изображения/лето 2022/море.jpg", 1<<30, 1234567890),
+	)
+
+	wantIndex := newPrefixIndex(slices.Values(entries), 3, 7)
+	index := newTestDiskIndex(t, entries, 3, 7)
+
+	r.NoError(index.Check(3, 7))
+	r.Error(index.Check(3, 8))
+	r.EqualValues(len(entries), index.count())
+
+	// Entries are sorted by path.
+	var paths []string
+	for id, entry := range index.entries() {
+		paths = append(paths, entry.Path)
+
+		gotID, ok := index.findPath(entry.Path)
+		r.True(ok)
+		r.Equal(id, gotID)
+
+		gotEntry, ok := index.getEntry(id)
+		r.True(ok)
+		r.Equal(entry, gotEntry)
+	}
+	r.True(slices.IsSorted(paths))
+	r.Len(paths, len(entries))
+
+	_, ok := index.findPath("/games/game 1")
+	r.False(ok)
+	_, ok = index.getEntry(index.count())
+	r.False(ok)
+
+	var prefixCount int
+	for prefix, ids := range index.prefixes() {
+		prefixCount++
+
+		var gotPaths, wantPaths []string
+		for _, id := range ids {
+			entry, _ := index.getEntry(id)
+			gotPaths = append(gotPaths, entry.Path)
+		}
+		for _, id := range wantIndex.Prefixes[prefix] {
+			wantPaths = append(wantPaths, wantIndex.Entries[id].Path)
+		}
+		r.ElementsMatch(wantPaths, gotPaths, prefix)
+		r.Equal(ids, index.getIDs(prefix))
+	}
+	r.Len(wantIndex.Prefixes, prefixCount)
+	r.Empty(index.getIDs("unknown"))
+
+	layered := newLayeredIndex(index)
+	for _, search := range []string{"game", "save 1", "sav -game", `"game 3"`, "море", "лет 2022", "unknown", "~gmae", "~sabe 1", "~мор"} {
+		req := newSearchRequest(search, 3)
+
+		wantHits, wantTotal := wantIndex.search(req, 1000, nil)
+		hits, total := layered.search(req, 1000, nil)
+		r.Equal(wantTotal, total, search)
+		r.Equal(wantHits, hits, search)
+	}
+}
+
+func TestDiskIndex_Empty(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	index := newTestDiskIndex(t, nil, 3, 7)
+	r.Zero(index.count())
+	_, ok := index.findPath("/")
+	r.False(ok)
+	r.Empty(index.getIDs("abc"))
+
+	hits, total := newLayeredIndex(index).search(newSearchRequest("abc", 3), 10, nil)
+	r.Empty(hits)
+	r.Zero(total)
+}
+
+func TestDiskIndex_InvalidFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "index.bin")
+	writeTestDiskIndex(t, path, []rclone.DirEntry{newDirEntry("/a/"), newDirEntry("/a/b.txt")}, 3, 7)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name    string
+		modify  func(data []byte) []byte
+		wantErr string
+	}{
+		{
+			name:    "invalid magic",
+			modify:  func(data []byte) []byte { data[0] = 'X'; return data },
+			wantErr: "invalid file format",
+		},
+		{
+			name:    "unsupported version",
+			modify:  func(data []byte) []byte { data[8] = 100; return data },
+			wantErr: "unsupported version 100",
+		},
+		{
+			name:    "corrupted data",
+			modify:  func(data []byte) []byte { data[diskIndexHeaderSize+1]++; return data },
+			wantErr: "checksum mismatch",
+		},
+		{
+			name:    "truncated",
+			modify:  func(data []byte) []byte { return data[:len(data)-1] },
+			wantErr: "checksum mismatch",
+		},
+		{
+			name:    "empty",
+			modify:  func(data []byte) []byte { return nil },
+			wantErr: "invalid file format",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := require.New(t)
+
+			path := filepath.Join(t.TempDir(), "index.bin")
+			err := os.WriteFile(path, tt.modify(slices.Clone(data)), 0o600)
+			r.NoError(err)
+
+			f, err := os.Open(path)
+			r.NoError(err)
+			defer f.Close()
+
+			_, err = openDiskIndex(f)
+			r.ErrorContains(err, tt.wantErr)
+		})
+	}
+}
+
+func TestLayeredIndex(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	base := newTestDiskIndex(t, []rclone.DirEntry{
+		newDirEntry("/docs/"),
+		newDirEntryWithMetadata("/docs/report.pdf", 10, 1),
+		newDirEntryWithMetadata("/docs/notes.txt", 20, 2),
+		newDirEntry("/photos/"),
+		newDirEntryWithMetadata("/photos/cat.jpg", 30, 3),
+	}, 3, 7)
+	index := newLayeredIndex(base)
+
+	// Update metadata of a base entry.
+	id, changed := index.upsert(newDirEntryWithMetadata("/docs/notes.txt", 25, 5))
+	r.True(changed)
+	r.True(index.isBaseID(id))
+	_, changed = index.upsert(newDirEntryWithMetadata("/docs/notes.txt", 25, 5))
+	r.False(changed)
+
+	// Add new entries.
+	id, changed = index.upsert(newDirEntryWithMetadata("/photos/dog.jpg", 40, 4))
+	r.True(changed)
+	r.False(index.isBaseID(id))
+	r.Equal(id+1, index.nextID())
+	_, changed = index.upsert(newDirEntryWithMetadata("/music/song.mp3", 50, 6))
+	r.True(changed)
+
+	// Remove base and overlay entries.
+	for _, path := range []string{"/docs/report.pdf", "/music/song.mp3"} {
+		id, ok := index.findPath(path)
+		r.True(ok)
+		index.remove(id)
+		_, ok = index.findPath(path)
+		r.False(ok)
+	}
+	r.EqualValues(5, index.changes)
+
+	want := []rclone.DirEntry{
+		newDirEntry("/docs/"),
+		newDirEntryWithMetadata("/docs/notes.txt", 25, 5),
+		newDirEntry("/photos/"),
+		newDirEntryWithMetadata("/photos/cat.jpg", 30, 3),
+		newDirEntryWithMetadata("/photos/dog.jpg", 40, 4),
+	}
+	wantIndex := newPrefixIndex(slices.Values(want), 3, 7)
+
+	var descendants []string
+	for _, path := range index.descendants("/photos/") {
+		descendants = append(descendants, path)
+	}
+	r.ElementsMatch([]string{"/photos/", "/photos/cat.jpg", "/photos/dog.jpg"}, descendants)
+
+	checkIndex := func(index *layeredIndex) {
+		r.Equal(len(want), index.len())
+		r.ElementsMatch(slices.Collect(maps.Values(wantIndex.Entries)), slices.Collect(index.entries()))
+
+		for _, search := range []string{"docs", "jpg", "report", "song", "note -photo", `"cat"`} {
+			req := newSearchRequest(search, 3)
+
+			wantHits, wantTotal := wantIndex.search(req, 100, nil)
+			hits, total := index.search(req, 100, nil)
+			r.Equal(wantTotal, total, search)
+			r.Equal(wantHits, hits, search)
+		}
+	}
+	checkIndex(index)
+
+	// Merge all changes into a new file.
+	f, err := os.Create(filepath.Join(t.TempDir(), "index.bin"))
+	r.NoError(err)
+	defer f.Close()
+
+	createdAt := time.Unix(123, 456)
+	r.NoError(writeDiskIndex(f, index, createdAt))
+
+	newBase, err := openDiskIndex(f)
+	r.NoError(err)
+	t.Cleanup(func() { newBase.Close() })
+
+	r.Equal(createdAt.UnixNano(), newBase.header.CreatedAt)
+	r.EqualValues(len(want), newBase.count())
+	checkIndex(newLayeredIndex(newBase))
+}
+
+func newTestDiskIndex(t *testing.T, entries []rclone.DirEntry, minPrefixLen, maxPrefixLen int) *diskIndex {
+	path := filepath.Join(t.TempDir(), "index.bin")
+	writeTestDiskIndex(t, path, entries, minPrefixLen, maxPrefixLen)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	index, err := openDiskIndex(f)
+	require.NoError(t, err)
+	t.Cleanup(func() { index.Close() })
+
+	return index
+}
+
+func writeTestDiskIndex(t *testing.T, path string, entries []rclone.DirEntry, minPrefixLen, maxPrefixLen int) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	index := newLayeredIndexWithOverlay(
+		newEmptyDiskIndex(minPrefixLen, maxPrefixLen),
+		newPrefixIndex(slices.Values(entries), minPrefixLen, maxPrefixLen),
+	)
+	err = writeDiskIndex(f, index, time.Now())
+	require.NoError(t, err)
+}
@@ -0,0 +1,176 @@
+package search
+
+import (
+	"iter"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// fuzzyOperator is a prefix of words that can be matched with typos.
+const fuzzyOperator = '~'
+
+// maxEditDistance returns the number of typos allowed in a word of the passed length.
+// Short words are matched only with a single typo to avoid too many false matches.
+func maxEditDistance(wordLen int) int {
+	switch {
+	case wordLen < 3:
+		return 0
+	case wordLen < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// levenshteinRows computes the edit distance between the query and the indexed prefixes row
+// by row: a row for a string is computed from the row of the string without its last character.
+// It allows to reuse rows for prefixes with the same beginning.
+//
+// Insertions, deletions, substitutions and transpositions of adjacent characters are counted
+// as single edits (optimal string alignment distance).
+type levenshteinRows struct {
+	query   []rune
+	maxDist int
+	rows    [][]int
+	chars   []rune
+}
+
+func newLevenshteinRows(query []rune, maxDist int) *levenshteinRows {
+	firstRow := make([]int, len(query)+1)
+	for i := range firstRow {
+		firstRow[i] = i
+	}
+	return &levenshteinRows{
+		query:   query,
+		maxDist: maxDist,
+		rows:    [][]int{firstRow},
+	}
+}
+
+// len returns the number of characters of the current string.
+func (l *levenshteinRows) len() int {
+	return len(l.rows) - 1
+}
+
+// truncate removes rows of the last characters, so the current string contains only n characters.
+func (l *levenshteinRows) truncate(n int) {
+	l.rows = l.rows[:n+1]
+	l.chars = l.chars[:n]
+}
+
+// push adds the character to the current string. It returns false if no string with
+// the current beginning can match the query.
+func (l *levenshteinRows) push(r rune) bool {
+	prev := l.rows[len(l.rows)-1]
+
+	row := make([]int, len(prev))
+	row[0] = prev[0] + 1
+	rowMin := row[0]
+	for i := 1; i < len(row); i++ {
+		cost := 1
+		if l.query[i-1] == r {
+			cost = 0
+		}
+		row[i] = min(row[i-1]+1, prev[i]+1, prev[i-1]+cost)
+
+		if n := len(l.chars); i > 1 && n > 0 && l.query[i-1] == l.chars[n-1] && l.query[i-2] == r {
+			row[i] = min(row[i], l.rows[n-1][i-2]+1)
+		}
+		rowMin = min(rowMin, row[i])
+	}
+	l.rows = append(l.rows, row)
+	l.chars = append(l.chars, r)
+
+	return rowMin <= l.maxDist
+}
+
+// distance returns the distance between the query and the current string.
+func (l *levenshteinRows) distance() int {
+	row := l.rows[len(l.rows)-1]
+	return row[len(row)-1]
+}
+
+// fuzzyMatchSorted returns prefixes that differ from the query by at most maxDist edits.
+// Prefixes must be sorted, so the rows of shared beginnings can be reused, and prefixes
+// that start with a non-matching beginning can be skipped with binary search.
+func fuzzyMatchSorted(count int, getPrefix func(i int) string, query []rune, maxDist int) iter.Seq2[string, int] {
+	return func(yield func(string, int) bool) {
+		var (
+			rows = newLevenshteinRows(query, maxDist)
+			prev []rune
+		)
+		for i := 0; i < count; {
+			prefix := getPrefix(i)
+			runes := []rune(prefix)
+
+			rows.truncate(commonRunePrefixLen(prev, runes))
+
+			matches := true
+			for _, r := range runes[rows.len():] {
+				if !rows.push(r) {
+					matches = false
+					break
+				}
+			}
+			prev = runes[:rows.len()]
+
+			if !matches {
+				// Skip all prefixes with the same non-matching beginning.
+				beginning := string(prev)
+				i += sort.Search(count-i, func(j int) bool {
+					p := getPrefix(i + j)
+					return p > beginning && !strings.HasPrefix(p, beginning)
+				})
+				continue
+			}
+
+			if dist := rows.distance(); dist <= maxDist {
+				if !yield(prefix, dist) {
+					return
+				}
+			}
+			i++
+		}
+	}
+}
+
+// fuzzyMatch is like [fuzzyMatchSorted], but it checks all prefixes one by one.
+func fuzzyMatch(prefixes iter.Seq[string], query []rune, maxDist int) iter.Seq2[string, int] {
+	return func(yield func(string, int) bool) {
+		rows := newLevenshteinRows(query, maxDist)
+		for prefix := range prefixes {
+			// The distance can't be less than the difference of lengths.
+			if n := utf8.RuneCountInString(prefix); n < len(query)-maxDist || n > len(query)+maxDist {
+				continue
+			}
+
+			rows.truncate(0)
+			matches := true
+			for _, r := range prefix {
+				if !rows.push(r) {
+					matches = false
+					break
+				}
+			}
+			if !matches {
+				continue
+			}
+			if dist := rows.distance(); dist <= maxDist {
+				if !yield(prefix, dist) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func commonRunePrefixLen(a, b []rune) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
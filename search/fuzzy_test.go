@@ -0,0 +1,64 @@
+package search
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	t.Parallel()
+
+	prefixes := []string{
+		"cat", "cate", "cater", "caterp", "caterpi", "caterpil", "caterpill",
+		"car", "cars", "cut", "dog", "dogs", "vac", "vaca", "vacat", "vacati", "vacatio", "vacation",
+		"лет", "лето", "ле", "zzz",
+	}
+	slices.Sort(prefixes)
+
+	// levenshtein is a naive implementation of the optimal string alignment distance
+	// to check the results.
+	var levenshtein func(a, b []rune) int
+	levenshtein = func(a, b []rune) int {
+		if len(a) == 0 {
+			return len(b)
+		}
+		if len(b) == 0 {
+			return len(a)
+		}
+		cost := 1
+		if a[0] == b[0] {
+			cost = 0
+		}
+		res := min(
+			levenshtein(a[1:], b)+1,
+			levenshtein(a, b[1:])+1,
+			levenshtein(a[1:], b[1:])+cost,
+		)
+		if len(a) > 1 && len(b) > 1 && a[0] == b[1] && a[1] == b[0] {
+			res = min(res, levenshtein(a[2:], b[2:])+1)
+		}
+		return res
+	}
+
+	for _, query := range []string{"cat", "catterpil", "vacaton", "dgo", "лтео", "xyz", "caterpill", "acr", "vaactoin"} {
+		for maxDist := range 3 {
+			want := make(map[string]int)
+			for _, prefix := range prefixes {
+				if dist := levenshtein([]rune(query), []rune(prefix)); dist <= maxDist {
+					want[prefix] = dist
+				}
+			}
+
+			sorted := maps.Collect(fuzzyMatchSorted(
+				len(prefixes), func(i int) string { return prefixes[i] }, []rune(query), maxDist,
+			))
+			require.Equal(t, want, sorted, "%s, %d", query, maxDist)
+
+			all := maps.Collect(fuzzyMatch(slices.Values(prefixes), []rune(query), maxDist))
+			require.Equal(t, want, all, "%s, %d", query, maxDist)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ShoshinNikita/rview/metadata"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// MetadataService is used to extract GPS coordinates of images for the geo index.
+type MetadataService interface {
+	CanExtractMetadata(id rview.FileID) bool
+	GetMetadata(ctx context.Context, id rview.FileID) (metadata.Metadata, error)
+}
+
+// geoIndex contains GPS coordinates of images. Just like the content index, it is updated
+// in the background after every change of the search index: only new and modified files
+// are processed.
+type geoIndex struct {
+	// Files contains processed files by path, including files without GPS coordinates.
+	Files map[string]*geoFile `json:"files"`
+}
+
+type geoFile struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mod_time"`
+	// Location is nil if the file doesn't have GPS coordinates.
+	Location *metadata.GPS `json:"location,omitempty"`
+}
+
+const (
+	// geoWorkersCount is the number of files processed concurrently.
+	geoWorkersCount = 4
+
+	// geoSaveInterval is the number of processed files after which the geo index is saved
+	// on disk, so the progress is not lost on restart.
+	geoSaveInterval = 500
+)
+
+func newGeoIndex() *geoIndex {
+	return &geoIndex{
+		Files: make(map[string]*geoFile),
+	}
+}
+
+func getGeoIndexFilename(remote string) string {
+	if remote == "" {
+		return "search_geo_index.json.gz"
+	}
+	return "search_geo_index." + remote + ".json.gz"
+}
+
+func (s *Service) loadOrPrepareGeoIndex(remote string) {
+	var index *geoIndex
+	err := s.loadFromCache(getGeoIndexFilename(remote), &index)
+	if err == nil && index != nil && index.Files != nil {
+		rlog.Infof("search geo index %q has been loaded from the file", remote)
+	} else {
+		// The index will be filled in the background.
+		rlog.Infof("prepare new geo index %q: couldn't load index from the file: %v", remote, err)
+		index = newGeoIndex()
+	}
+
+	s.mu.Lock()
+	s.geoIndexes[remote] = index
+	s.mu.Unlock()
+}
+
+// requestGeoIndexing requests an update of geo indexes. It doesn't block.
+func (s *Service) requestGeoIndexing() {
+	if s.opts.Metadata == nil {
+		return
+	}
+	select {
+	case s.geoRefreshCh <- struct{}{}:
+	default:
+		// Update has already been requested.
+	}
+}
+
+func (s *Service) startGeoIndexing() {
+	defer close(s.geoStoppedCh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case <-s.geoRefreshCh:
+			for _, remote := range s.rclone.GetRemotes() {
+				err := s.updateGeoIndex(ctx, remote)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					rlog.Errorf("couldn't update search geo index %q: %s", remote, err)
+				}
+			}
+		}
+	}
+}
+
+// updateGeoIndex extracts GPS coordinates of new and modified images and removes deleted files
+// from the geo index. Files which metadata can't be extracted are not processed again until
+// they are modified. The index is saved periodically, so the progress is not lost.
+func (s *Service) updateGeoIndex(ctx context.Context, remote string) error {
+	now := time.Now()
+
+	var (
+		toIndex  []dirEntry
+		toDelete []string
+	)
+	s.mu.RLock()
+	pathIndex, index := s.indexes[remote], s.geoIndexes[remote]
+	if pathIndex != nil && index != nil {
+		paths := make(map[string]bool)
+		for entry := range pathIndex.Index.entries() {
+			if entry.IsDir || entry.Size <= 0 || !s.opts.Metadata.CanExtractMetadata(newFileID(entry)) {
+				continue
+			}
+			paths[entry.Path] = true
+
+			file, ok := index.Files[entry.Path]
+			if ok && file.Size == entry.Size && file.ModTime == entry.ModTime {
+				continue
+			}
+			toIndex = append(toIndex, entry)
+		}
+		for path := range index.Files {
+			if !paths[path] {
+				toDelete = append(toDelete, path)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(toIndex) == 0 && len(toDelete) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	for _, path := range toDelete {
+		delete(index.Files, path)
+	}
+	s.mu.Unlock()
+
+	// Process files in a stable order.
+	slices.SortFunc(toIndex, func(a, b dirEntry) int {
+		return cmp.Compare(a.Path, b.Path)
+	})
+
+	var (
+		entriesCh = make(chan dirEntry)
+		wg        sync.WaitGroup
+
+		statsMu           sync.Mutex
+		geotagged, failed int
+	)
+	for range geoWorkersCount {
+		wg.Go(func() {
+			for entry := range entriesCh {
+				file := &geoFile{
+					Size:    entry.Size,
+					ModTime: entry.ModTime,
+				}
+				m, err := s.opts.Metadata.GetMetadata(ctx, newFileID(entry))
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					rlog.Debugf("couldn't extract GPS coordinates of %q: %s", entry.Path, err)
+				}
+				file.Location = m.GPS
+
+				statsMu.Lock()
+				if err != nil {
+					failed++
+				}
+				if file.Location != nil {
+					geotagged++
+				}
+				statsMu.Unlock()
+
+				s.mu.Lock()
+				index.Files[entry.Path] = file
+				s.mu.Unlock()
+			}
+		})
+	}
+
+	var saveErr error
+	for i, entry := range toIndex {
+		select {
+		case entriesCh <- entry:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		if (i+1)%geoSaveInterval == 0 {
+			if saveErr = s.saveGeoIndex(remote, index); saveErr != nil {
+				break
+			}
+		}
+	}
+	close(entriesCh)
+	wg.Wait()
+
+	if err := cmp.Or(saveErr, ctx.Err()); err != nil {
+		return err
+	}
+	if err := s.saveGeoIndex(remote, index); err != nil {
+		return err
+	}
+
+	rlog.Infof(
+		"search geo index %q has been updated in %s, processed: %d, geotagged: %d, failed: %d, deleted: %d",
+		remote, time.Since(now), len(toIndex), geotagged, failed, len(toDelete),
+	)
+	return nil
+}
+
+func (s *Service) saveGeoIndex(remote string, index *geoIndex) error {
+	// Files are never modified, so a shallow copy is enough to save the index without
+	// blocking search requests.
+	s.mu.RLock()
+	snapshot := &geoIndex{
+		Files: maps.Clone(index.Files),
+	}
+	s.mu.RUnlock()
+
+	err := s.saveToCache(getGeoIndexFilename(remote), snapshot)
+	if err != nil {
+		return fmt.Errorf("couldn't save geo index: %w", err)
+	}
+	return nil
+}
+
+// GetLocations returns GPS coordinates of all geotagged images from the geo index. The index is
+// updated in the background, so new files can be missing, and modified files can have outdated
+// ids. It returns nil if the geo index is disabled.
+func (s *Service) GetLocations() map[rview.FileID]metadata.GPS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.geoIndexes == nil {
+		return nil
+	}
+
+	res := make(map[rview.FileID]metadata.GPS)
+	for _, index := range s.geoIndexes {
+		for path, file := range index.Files {
+			if file.Location != nil {
+				res[rview.NewFileID(path, file.ModTime, file.Size)] = *file.Location
+			}
+		}
+	}
+	return res
+}
+
+func newFileID(entry dirEntry) rview.FileID {
+	return rview.NewFileID(entry.Path, entry.ModTime, entry.Size)
+}
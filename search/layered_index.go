@@ -0,0 +1,318 @@
+package search
+
+import (
+	"cmp"
+	"iter"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/ShoshinNikita/rview/rclone"
+)
+
+// layeredIndex combines the read-only index loaded from disk with in-memory changes. Changes
+// are merged into a new file when the index is saved, see [writeDiskIndex].
+type layeredIndex struct {
+	base *diskIndex
+	// deleted contains ids of removed base entries.
+	deleted map[uint32]bool
+	// updated contains base entries with new size or mod time.
+	updated map[uint32]dirEntry
+	// overlay contains new entries. Their ids start from the number of base entries.
+	overlay *prefixIndex
+
+	// changes is incremented on every change. It is used to check whether the index
+	// has been changed while it was being saved.
+	changes uint64
+}
+
+// deletedEntryID is used instead of new ids of deleted entries, see [writeDiskIndex].
+const deletedEntryID = math.MaxUint32
+
+func newLayeredIndex(base *diskIndex) *layeredIndex {
+	minLen, maxLen := int(base.header.MinPrefixLen), int(base.header.MaxPrefixLen)
+
+	overlay := newPrefixIndex(slices.Values([]rclone.DirEntry(nil)), minLen, maxLen)
+	overlay.nextID = base.count()
+
+	return newLayeredIndexWithOverlay(base, overlay)
+}
+
+func newLayeredIndexWithOverlay(base *diskIndex, overlay *prefixIndex) *layeredIndex {
+	return &layeredIndex{
+		base:    base,
+		deleted: make(map[uint32]bool),
+		updated: make(map[uint32]dirEntry),
+		overlay: overlay,
+	}
+}
+
+func (index *layeredIndex) Close() error {
+	return index.base.Close()
+}
+
+func (index *layeredIndex) isBaseID(id uint32) bool {
+	return id < index.base.count()
+}
+
+func (index *layeredIndex) search(req searchRequest, limit int, filter func(path string, isDir bool) bool) ([]Hit, int) {
+	return searchView(index, req, limit, filter)
+}
+
+func (index *layeredIndex) getPrefixLens() (minLen, maxLen int) {
+	return index.overlay.getPrefixLens()
+}
+
+func (index *layeredIndex) getIDs(prefix string) []uint32 {
+	ids := index.base.getIDs(prefix)
+	if len(index.deleted) > 0 {
+		ids = slices.DeleteFunc(ids, func(id uint32) bool { return index.deleted[id] })
+	}
+	// Overlay ids are greater than base ids, so ids remain sorted.
+	return append(ids, index.overlay.getIDs(prefix)...)
+}
+
+func (index *layeredIndex) allIDs() iter.Seq[uint32] {
+	return func(yield func(uint32) bool) {
+		for id := range index.base.count() {
+			if !index.deleted[id] && !yield(id) {
+				return
+			}
+		}
+		for id := range index.overlay.allIDs() {
+			if !yield(id) {
+				return
+			}
+		}
+	}
+}
+
+func (index *layeredIndex) fuzzyPrefixes(query []rune, maxDist int) iter.Seq2[string, int] {
+	return func(yield func(string, int) bool) {
+		// Ids of both layers are returned by [layeredIndex.getIDs], so skip duplicate prefixes.
+		seen := make(map[string]bool)
+		for prefix, dist := range index.base.fuzzyPrefixes(query, maxDist) {
+			seen[prefix] = true
+			if !yield(prefix, dist) {
+				return
+			}
+		}
+		for prefix, dist := range index.overlay.fuzzyPrefixes(query, maxDist) {
+			if !seen[prefix] && !yield(prefix, dist) {
+				return
+			}
+		}
+	}
+}
+
+func (index *layeredIndex) getEntry(id uint32) (dirEntry, bool) {
+	if !index.isBaseID(id) {
+		return index.overlay.getEntry(id)
+	}
+	if index.deleted[id] {
+		return dirEntry{}, false
+	}
+	if entry, ok := index.updated[id]; ok {
+		return entry, true
+	}
+	return index.base.getEntry(id)
+}
+
+func (index *layeredIndex) getLowerCasedPath(id uint32) string {
+	if !index.isBaseID(id) {
+		return index.overlay.getLowerCasedPath(id)
+	}
+	entry, _ := index.getEntry(id)
+	return strings.ToLower(entry.Path)
+}
+
+// entries returns all entries in undefined order.
+func (index *layeredIndex) entries() iter.Seq[dirEntry] {
+	return func(yield func(dirEntry) bool) {
+		for id, entry := range index.base.entries() {
+			if index.deleted[id] {
+				continue
+			}
+			if updated, ok := index.updated[id]; ok {
+				entry = updated
+			}
+			if !yield(entry) {
+				return
+			}
+		}
+		for _, entry := range index.overlay.Entries {
+			if !yield(entry) {
+				return
+			}
+		}
+	}
+}
+
+func (index *layeredIndex) len() int {
+	return int(index.base.count()) - len(index.deleted) + len(index.overlay.Entries)
+}
+
+// nextID returns an id for the next new entry. Ids of existing entries are less than it.
+func (index *layeredIndex) nextID() uint32 {
+	return index.overlay.nextID
+}
+
+func (index *layeredIndex) findPath(path string) (uint32, bool) {
+	if id, ok := index.overlay.pathIDs[path]; ok {
+		return id, true
+	}
+	if id, ok := index.base.findPath(path); ok && !index.deleted[id] {
+		return id, true
+	}
+	return 0, false
+}
+
+func (index *layeredIndex) descendants(dir string) iter.Seq2[uint32, string] {
+	return func(yield func(uint32, string) bool) {
+		// Base entries are sorted by path, so all descendants are next to each other.
+		for id, entry := range index.base.entriesFrom(dir) {
+			if !strings.HasPrefix(entry.Path, dir) {
+				break
+			}
+			if !index.deleted[id] && !yield(id, entry.Path) {
+				return
+			}
+		}
+		for id, path := range index.overlay.descendants(dir) {
+			if !yield(id, path) {
+				return
+			}
+		}
+	}
+}
+
+func (index *layeredIndex) upsert(entry rclone.DirEntry) (id uint32, changed bool) {
+	defer func() {
+		if changed {
+			index.changes++
+		}
+	}()
+
+	id, ok := index.findPath(entry.URL)
+	if !ok || !index.isBaseID(id) {
+		return index.overlay.upsert(entry)
+	}
+
+	existing, _ := index.getEntry(id)
+	if existing.Size == entry.Size && existing.ModTime == entry.ModTime {
+		return id, false
+	}
+	existing.Size = entry.Size
+	existing.ModTime = entry.ModTime
+	index.updated[id] = existing
+	return id, true
+}
+
+func (index *layeredIndex) remove(id uint32) {
+	if !index.isBaseID(id) {
+		if _, ok := index.overlay.Entries[id]; ok {
+			index.overlay.remove(id)
+			index.changes++
+		}
+		return
+	}
+	if !index.deleted[id] {
+		index.deleted[id] = true
+		delete(index.updated, id)
+		index.changes++
+	}
+}
+
+// sortedEntries returns entries of both layers sorted by path. It fills the passed
+// maps with new ids of entries: positions in the returned sequence.
+func (index *layeredIndex) sortedEntries(baseIDs []uint32, overlayIDs map[uint32]uint32) iter.Seq[dirEntry] {
+	return func(yield func(dirEntry) bool) {
+		overlayEntries := slices.SortedFunc(maps.Keys(index.overlay.Entries), func(a, b uint32) int {
+			return cmp.Compare(index.overlay.Entries[a].Path, index.overlay.Entries[b].Path)
+		})
+
+		var newID uint32
+		yieldOverlay := func(maxPath string, all bool) bool {
+			for len(overlayEntries) > 0 {
+				id := overlayEntries[0]
+				entry := index.overlay.Entries[id]
+				if !all && entry.Path >= maxPath {
+					break
+				}
+				overlayEntries = overlayEntries[1:]
+
+				overlayIDs[id] = newID
+				newID++
+				if !yield(entry) {
+					return false
+				}
+			}
+			return true
+		}
+
+		for id, entry := range index.base.entries() {
+			if index.deleted[id] {
+				baseIDs[id] = deletedEntryID
+				continue
+			}
+			if !yieldOverlay(entry.Path, false) {
+				return
+			}
+			if updated, ok := index.updated[id]; ok {
+				entry = updated
+			}
+
+			baseIDs[id] = newID
+			newID++
+			if !yield(entry) {
+				return
+			}
+		}
+		yieldOverlay("", true)
+	}
+}
+
+type layeredPrefixIDs struct {
+	base    []uint32
+	overlay []uint32
+}
+
+// sortedPrefixes returns prefixes of both layers in byte order. Ids of base entries can
+// include deleted entries.
+func (index *layeredIndex) sortedPrefixes() iter.Seq2[string, layeredPrefixIDs] {
+	return func(yield func(string, layeredPrefixIDs) bool) {
+		overlayPrefixes := slices.Sorted(maps.Keys(index.overlay.Prefixes))
+
+		yieldOverlay := func(maxPrefix string, all bool) bool {
+			for len(overlayPrefixes) > 0 {
+				prefix := overlayPrefixes[0]
+				if !all && prefix >= maxPrefix {
+					break
+				}
+				overlayPrefixes = overlayPrefixes[1:]
+
+				if !yield(prefix, layeredPrefixIDs{overlay: index.overlay.Prefixes[prefix]}) {
+					return false
+				}
+			}
+			return true
+		}
+
+		for prefix, ids := range index.base.prefixes() {
+			if !yieldOverlay(prefix, false) {
+				return
+			}
+
+			res := layeredPrefixIDs{base: ids}
+			if len(overlayPrefixes) > 0 && overlayPrefixes[0] == prefix {
+				res.overlay = index.overlay.Prefixes[prefix]
+				overlayPrefixes = overlayPrefixes[1:]
+			}
+			if !yield(prefix, res) {
+				return
+			}
+		}
+		yieldOverlay("", true)
+	}
+}
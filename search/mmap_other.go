@@ -0,0 +1,17 @@
+//go:build !unix
+
+package search
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile reads the whole file into memory: memory mapping is supported only on unix systems.
+func mmapFile(f *os.File) ([]byte, func() error, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -23,6 +23,8 @@ type Hit struct {
 	ModTime int64
 
 	Score float32
+	// Snippet is set only for content search, see [Service.Search].
+	Snippet *Snippet
 }
 
 func (h Hit) GetPath() string { return h.Path }
@@ -35,6 +37,9 @@ type prefixIndex struct {
 	Prefixes     map[string][]uint32 `json:"prefixes"`
 
 	lowerCasedPaths map[uint32]string
+	pathIDs         map[string]uint32
+	// nextID is an id for a new entry, see [prefixIndex.upsert].
+	nextID uint32
 }
 
 type dirEntry struct {
@@ -94,12 +99,140 @@ func (index *prefixIndex) UnmarshalJSON(data []byte) error {
 }
 
 func (index *prefixIndex) prepare() {
-	index.lowerCasedPaths = make(map[uint32]string)
+	index.lowerCasedPaths = make(map[uint32]string, len(index.Entries))
+	index.pathIDs = make(map[string]uint32, len(index.Entries))
+	index.nextID = 0
 	for id, path := range index.Entries {
 		index.lowerCasedPaths[id] = strings.ToLower(path.Path)
+		index.pathIDs[path.Path] = id
+		index.nextID = max(index.nextID, id+1)
 	}
 }
 
+// mutableIndex is an index that can be updated, see [updateDir].
+type mutableIndex interface {
+	// descendants returns ids and paths of the directory and all entries inside it.
+	descendants(dir string) iter.Seq2[uint32, string]
+	upsert(entry rclone.DirEntry) (id uint32, changed bool)
+	remove(id uint32)
+}
+
+// updateDir replaces the indexed content of the directory with the passed entries. Entries of
+// removed subdirectories are removed as well. If isDeleted is true, the directory itself is
+// removed. It reports whether the index has been changed.
+func updateDir(index mutableIndex, dir string, entries []rclone.DirEntry, isDeleted bool) (changed bool) {
+	newEntries := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		newEntries[entry.URL] = true
+	}
+
+	var toRemove []uint32
+	for id, path := range index.descendants(dir) {
+		if path == dir {
+			if isDeleted {
+				toRemove = append(toRemove, id)
+			}
+			continue
+		}
+
+		// Path of a direct child of the directory.
+		childPath := path
+		if i := strings.Index(path[len(dir):], "/"); i != -1 {
+			childPath = path[:len(dir)+i+1]
+		}
+		if isDeleted || !newEntries[childPath] {
+			toRemove = append(toRemove, id)
+		}
+	}
+
+	for _, id := range toRemove {
+		index.remove(id)
+		changed = true
+	}
+	if !isDeleted {
+		for _, entry := range entries {
+			if _, entryChanged := index.upsert(entry); entryChanged {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func (index *prefixIndex) descendants(dir string) iter.Seq2[uint32, string] {
+	return func(yield func(uint32, string) bool) {
+		// We don't have a tree, so we have to check all paths.
+		for id, entry := range index.Entries {
+			if strings.HasPrefix(entry.Path, dir) {
+				if !yield(id, entry.Path) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// upsert adds the entry to the index or updates size and mod time of the existing one.
+// It returns id of the entry and reports whether the index has been changed.
+func (index *prefixIndex) upsert(entry rclone.DirEntry) (id uint32, changed bool) {
+	if id, ok := index.pathIDs[entry.URL]; ok {
+		existing := index.Entries[id]
+		if existing.Size == entry.Size && existing.ModTime == entry.ModTime {
+			return id, false
+		}
+		existing.Size = entry.Size
+		existing.ModTime = entry.ModTime
+		index.Entries[id] = existing
+		return id, true
+	}
+
+	id = index.nextID
+	index.nextID++
+
+	index.Entries[id] = dirEntry{
+		Path:    entry.URL,
+		IsDir:   entry.IsDir,
+		Size:    entry.Size,
+		ModTime: entry.ModTime,
+	}
+	index.lowerCasedPaths[id] = strings.ToLower(entry.URL)
+	index.pathIDs[entry.URL] = id
+
+	for prefix := range generatePrefixes(entry.URL, index.MinPrefixLen, index.MaxPrefixLen) {
+		ids := index.Prefixes[prefix]
+		// The new id is the largest one, so ids remain sorted.
+		if len(ids) == 0 || ids[len(ids)-1] != id {
+			index.Prefixes[prefix] = append(ids, id)
+		}
+	}
+	return id, true
+}
+
+func (index *prefixIndex) remove(id uint32) {
+	entry, ok := index.Entries[id]
+	if !ok {
+		return
+	}
+
+	for prefix := range generatePrefixes(entry.Path, index.MinPrefixLen, index.MaxPrefixLen) {
+		ids := index.Prefixes[prefix]
+		i, ok := slices.BinarySearch(ids, id)
+		if !ok {
+			continue
+		}
+		ids = slices.Delete(ids, i, i+1)
+		if len(ids) == 0 {
+			delete(index.Prefixes, prefix)
+		} else {
+			index.Prefixes[prefix] = ids
+		}
+	}
+
+	delete(index.Entries, id)
+	delete(index.lowerCasedPaths, id)
+	delete(index.pathIDs, entry.Path)
+}
+
 func (index *prefixIndex) Check(wantMin, wantMax int) error {
 	if index.MinPrefixLen != wantMin || index.MaxPrefixLen != wantMax {
 		return fmt.Errorf(
@@ -117,21 +250,69 @@ type searchHit struct {
 	lowerCasedPath string
 }
 
-func (index *prefixIndex) Search(search string, limit int) ([]Hit, int) {
-	req := newSearchRequest(search, index.MinPrefixLen)
-	if len(req.words) == 0 && len(req.exactMatches) == 0 && len(req.toExclude) == 0 {
+// Search returns hits sorted by score and the total number of hits. If filter is not nil,
+// only paths that pass it are returned.
+func (index *prefixIndex) Search(search string, limit int, filter func(path string, isDir bool) bool) ([]Hit, int) {
+	return index.search(newSearchRequest(search, index.MinPrefixLen), limit, filter)
+}
+
+func (index *prefixIndex) search(req searchRequest, limit int, filter func(path string, isDir bool) bool) ([]Hit, int) {
+	return searchView(index, req, limit, filter)
+}
+
+// indexView is a read-only view of an index used for search. It is implemented by
+// [prefixIndex] and [layeredIndex].
+type indexView interface {
+	getPrefixLens() (minLen, maxLen int)
+	// getIDs returns sorted ids of entries with the prefix.
+	getIDs(prefix string) []uint32
+	allIDs() iter.Seq[uint32]
+	getEntry(id uint32) (dirEntry, bool)
+	getLowerCasedPath(id uint32) string
+	// fuzzyPrefixes returns prefixes that differ from the query by at most maxDist edits
+	// with their distances.
+	fuzzyPrefixes(query []rune, maxDist int) iter.Seq2[string, int]
+}
+
+func (index *prefixIndex) getPrefixLens() (minLen, maxLen int) {
+	return index.MinPrefixLen, index.MaxPrefixLen
+}
+
+func (index *prefixIndex) getIDs(prefix string) []uint32 {
+	return index.Prefixes[prefix]
+}
+
+func (index *prefixIndex) allIDs() iter.Seq[uint32] {
+	return maps.Keys(index.Entries)
+}
+
+func (index *prefixIndex) fuzzyPrefixes(query []rune, maxDist int) iter.Seq2[string, int] {
+	return fuzzyMatch(maps.Keys(index.Prefixes), query, maxDist)
+}
+
+func (index *prefixIndex) getEntry(id uint32) (dirEntry, bool) {
+	entry, ok := index.Entries[id]
+	return entry, ok
+}
+
+func (index *prefixIndex) getLowerCasedPath(id uint32) string {
+	return index.lowerCasedPaths[id]
+}
+
+func searchView(index indexView, req searchRequest, limit int, filter func(path string, isDir bool) bool) ([]Hit, int) {
+	if !req.hasPathTerms() {
 		return nil, 0
 	}
 
 	var hitsIter iter.Seq[searchHit]
-	if len(req.words) > 0 {
-		hitsIter = index.searchByPrefixes(req.words)
+	if len(req.words) > 0 || len(req.fuzzyWords) > 0 {
+		hitsIter = searchByPrefixes(index, req.words, req.fuzzyWords)
 
 	} else {
 		// Only exact matches, only excludes, or both - have to check all paths.
 		hitsIter = func(yield func(searchHit) bool) {
-			for id := range index.Entries {
-				if !yield(index.newSearchHit(id, float32(math.Inf(1)))) {
+			for id := range index.allIDs() {
+				if !yield(newSearchHit(index, id, float32(math.Inf(1)))) {
 					return
 				}
 			}
@@ -150,6 +331,14 @@ func (index *prefixIndex) Search(search string, limit int) ([]Hit, int) {
 		})
 	}
 
+	// Filter by the passed filter. Do it before compaction to not hide visible paths.
+	if filter != nil {
+		hitsIter = deleteIter(hitsIter, func(h searchHit) bool {
+			entry, _ := index.getEntry(h.id)
+			return !filter(entry.Path, entry.IsDir)
+		})
+	}
+
 	// Filter by excludes.
 	if len(req.toExclude) > 0 {
 		hitsIter = deleteIter(hitsIter, func(h searchHit) bool {
@@ -164,7 +353,7 @@ func (index *prefixIndex) Search(search string, limit int) ([]Hit, int) {
 
 	var res []Hit
 	for h := range hitsIter {
-		entry := index.Entries[h.id]
+		entry, _ := index.getEntry(h.id)
 		res = append(res, Hit{
 			Path:    entry.Path,
 			IsDir:   entry.IsDir,
@@ -180,12 +369,7 @@ func (index *prefixIndex) Search(search string, limit int) ([]Hit, int) {
 	res = compactSearchHits(res)
 	total := len(res)
 
-	slices.SortFunc(res, func(a, b Hit) int {
-		if v := cmp.Compare(a.Score, b.Score); v != 0 {
-			return -1 * v
-		}
-		return rclone.CompareDirEntryByName(a, b)
-	})
+	slices.SortFunc(res, compareHits)
 
 	if len(res) > limit {
 		res = res[:limit]
@@ -194,30 +378,65 @@ func (index *prefixIndex) Search(search string, limit int) ([]Hit, int) {
 	return res, total
 }
 
-// searchByPrefixes checks every word for prefix matches.
-func (index *prefixIndex) searchByPrefixes(words [][]rune) iter.Seq[searchHit] {
+// searchByPrefixes checks every word for prefix matches. Fuzzy words can also match prefixes
+// with typos.
+//
+// The score of a hit is the number of matched prefixes. If there are fuzzy words, 1 / (2 + sum of
+// edit distances) is added to the score. It is less than 1, so hits with more matched prefixes are
+// still ranked first, and hits matched only with typos are ranked last.
+func searchByPrefixes(index indexView, words, fuzzyWords [][]rune) iter.Seq[searchHit] {
 	noopIter := func(yield func(searchHit) bool) {}
 
+	minPrefixLen, maxPrefixLen := index.getPrefixLens()
+
 	var (
 		matchCounts        = make(map[uint32]int)
+		editDistances      = make(map[uint32]int)
 		matchesForAllWords = make(map[uint32]bool)
 	)
-	for _, word := range words {
+	for i, word := range slices.Concat(words, fuzzyWords) {
 		// If a word length is less than MinPrefixLen, no prefixes will be generated, and
 		// no hits will be returned. So, ignore such words.
-		if len(word) < index.MinPrefixLen {
+		if len(word) < minPrefixLen {
 			continue
 		}
 
 		matches := make(map[uint32]bool)
-		for prefix := range generatePrefixes(string(word), index.MinPrefixLen, index.MaxPrefixLen) {
-			for _, id := range index.Prefixes[prefix] {
+		for prefix := range generatePrefixes(string(word), minPrefixLen, maxPrefixLen) {
+			for _, id := range index.getIDs(prefix) {
 				matchCounts[id]++
 
 				matches[id] = true
 			}
 		}
 
+		if isFuzzy := i >= len(words); isFuzzy {
+			// Longer prefixes are not indexed, so use only the beginning of the word.
+			query := word[:min(len(word), maxPrefixLen)]
+
+			maxDist := maxEditDistance(len(word))
+
+			distances := make(map[uint32]int)
+			for prefix, dist := range index.fuzzyPrefixes(query, maxDist) {
+				for _, id := range index.getIDs(prefix) {
+					if d, ok := distances[id]; !ok || dist < d {
+						distances[id] = dist
+					}
+				}
+			}
+			for id := range matches {
+				if _, ok := distances[id]; !ok {
+					// Matched only by shorter prefixes.
+					distances[id] = maxDist + 1
+				}
+			}
+			for id, dist := range distances {
+				editDistances[id] += dist
+
+				matches[id] = true
+			}
+		}
+
 		// No reason to continue search - all words have to match.
 		if len(matches) == 0 {
 			return noopIter
@@ -241,21 +460,33 @@ func (index *prefixIndex) searchByPrefixes(words [][]rune) iter.Seq[searchHit] {
 
 	return func(yield func(searchHit) bool) {
 		for id := range matchesForAllWords {
-			if !yield(index.newSearchHit(id, float32(matchCounts[id]))) {
+			score := float32(matchCounts[id])
+			if len(fuzzyWords) > 0 {
+				score += 1 / float32(2+editDistances[id])
+			}
+			if !yield(newSearchHit(index, id, score)) {
 				return
 			}
 		}
 	}
 }
 
-func (index *prefixIndex) newSearchHit(id uint32, score float32) searchHit {
+func newSearchHit(index indexView, id uint32, score float32) searchHit {
 	return searchHit{
 		id:             id,
-		lowerCasedPath: index.lowerCasedPaths[id],
+		lowerCasedPath: index.getLowerCasedPath(id),
 		score:          score,
 	}
 }
 
+// compareHits sorts hits by score in descending order, and then by name.
+func compareHits(a, b Hit) int {
+	if v := cmp.Compare(a.Score, b.Score); v != 0 {
+		return -1 * v
+	}
+	return rclone.CompareDirEntryByName(a, b)
+}
+
 // compactSearchHits merges paths like '/a/b/', '/a/b/c', '/a/b/d/e/' and etc. to just '/a/b/'
 // if their scores are equal. Passed hits must be sorted.
 func compactSearchHits(hits []Hit) []Hit {
@@ -305,10 +536,17 @@ func generatePrefixes(path string, minLen, maxLen int) iter.Seq[string] {
 	}
 }
 
+// contentOperator is a prefix of words and phrases that must be searched in file contents.
+const contentOperator = "content:"
+
 type searchRequest struct {
-	words        [][]rune
+	words [][]rune
+	// fuzzyWords can be matched with typos, see [searchByPrefixes].
+	fuzzyWords   [][]rune
 	exactMatches []string
 	toExclude    []string
+	// contentMatches contains words and phrases that must be present in file contents.
+	contentMatches []string
 
 	extractedWords []string // only for testing
 }
@@ -364,15 +602,26 @@ func newSearchRequest(search string, minWordLen int) (req searchRequest) {
 		var (
 			exclude bool
 			exact   bool
+			fuzzy   bool
+			content bool
 			until   byte = ' '
 		)
-		switch r {
-		case '"':
+		if strings.HasPrefix(search[idx:], contentOperator) {
+			content = true
+			idx += len(contentOperator)
+			r, _ = get()
+		}
+		switch {
+		case r == '"':
 			until = '"'
-			exact = true
+			exact = !content
+			move()
+
+		case r == fuzzyOperator && !content:
+			fuzzy = true
 			move()
 
-		case '-':
+		case r == '-' && !content:
 			exclude = true
 			move()
 			if r, _ := get(); r == '"' {
@@ -388,10 +637,14 @@ func newSearchRequest(search string, minWordLen int) (req searchRequest) {
 		}
 
 		switch {
+		case content:
+			req.contentMatches = append(req.contentMatches, word)
 		case exact:
 			req.exactMatches = append(req.exactMatches, word)
 		case exclude:
 			req.toExclude = append(req.toExclude, word)
+		case fuzzy:
+			req.fuzzyWords = append(req.fuzzyWords, splitToNormalizedWords(word, minWordLen)...)
 		default:
 			req.words = append(req.words, splitToNormalizedWords(word, minWordLen)...)
 
@@ -403,6 +656,11 @@ func newSearchRequest(search string, minWordLen int) (req searchRequest) {
 	return req
 }
 
+// hasPathTerms reports whether the request contains any words that must be searched in paths.
+func (req searchRequest) hasPathTerms() bool {
+	return len(req.words) > 0 || len(req.fuzzyWords) > 0 || len(req.exactMatches) > 0 || len(req.toExclude) > 0
+}
+
 func splitToNormalizedWords(v string, minLen int) (res [][]rune) {
 	var (
 		word       []rune
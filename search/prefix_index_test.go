@@ -3,6 +3,8 @@ package search
 import (
 	"encoding/json"
 	"io/fs"
+	"iter"
+	"maps"
 	"math"
 	"path"
 	"path/filepath"
@@ -92,7 +94,7 @@ func TestPrefixIndex(t *testing.T) {
 	t.Run("basic search", func(t *testing.T) {
 		r := require.New(t)
 
-		hits, _ := index.Search(`games`, 5)
+		hits, _ := index.Search(`games`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/starfield/", Score: 3, IsDir: true},
@@ -107,7 +109,7 @@ func TestPrefixIndex(t *testing.T) {
 	t.Run("limit", func(t *testing.T) {
 		r := require.New(t)
 
-		hits, total := index.Search(`games`, 2)
+		hits, total := index.Search(`games`, 2, nil)
 		r.Equal(4, total)
 		r.Equal(
 			[]Hit{
@@ -122,7 +124,7 @@ func TestPrefixIndex(t *testing.T) {
 		r := require.New(t)
 
 		// Short words must be ignored
-		hits, _ := index.Search(`games ru`, 5)
+		hits, _ := index.Search(`games ru`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/starfield/", Score: 3, IsDir: true},
@@ -133,7 +135,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search(`games rush`, 5)
+		hits, _ = index.Search(`games rush`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/hi-fi rush/1.jpg", Score: 5},
@@ -146,10 +148,10 @@ func TestPrefixIndex(t *testing.T) {
 	t.Run("exact match", func(t *testing.T) {
 		r := require.New(t)
 
-		hits, _ := index.Search(`"games/hifi RUSH"`, 5)
+		hits, _ := index.Search(`"games/hifi RUSH"`, 5, nil)
 		r.Empty(hits)
 
-		hits, _ = index.Search(`"games/hi-fi RUSH"`, 5)
+		hits, _ = index.Search(`"games/hi-fi RUSH"`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/hi-fi rush/1.jpg", Score: float32(math.Inf(1))},
@@ -158,7 +160,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search(`"games"`, 5)
+		hits, _ = index.Search(`"games"`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/starfield/", Score: float32(math.Inf(1)), IsDir: true},
@@ -168,7 +170,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search(`"games" "jpg"`, 5)
+		hits, _ = index.Search(`"games" "jpg"`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/hi-fi rush/1.jpg", Score: float32(math.Inf(1))},
@@ -177,7 +179,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search(`"games" "jpg" "1"`, 5)
+		hits, _ = index.Search(`"games" "jpg" "1"`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/hi-fi rush/1.jpg", Score: float32(math.Inf(1))},
@@ -185,14 +187,14 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search(`"games" "jpg" "1" "2"`, 5)
+		hits, _ = index.Search(`"games" "jpg" "1" "2"`, 5, nil)
 		r.Empty(hits)
 	})
 
 	t.Run("exclude", func(t *testing.T) {
 		r := require.New(t)
 
-		hits, _ := index.Search(`games -"hi-fi"`, 5)
+		hits, _ := index.Search(`games -"hi-fi"`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/starfield/", Score: 3, IsDir: true},
@@ -201,7 +203,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search(`games -"hi-fi" -"gaming"`, 5)
+		hits, _ = index.Search(`games -"hi-fi" -"gaming"`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/starfield/", Score: 3, IsDir: true},
@@ -209,7 +211,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search(`"games" -"starfield"`, 5)
+		hits, _ = index.Search(`"games" -"starfield"`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/games/hi-fi rush/1.jpg", Score: float32(math.Inf(1))},
@@ -218,7 +220,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search(`-"games" -"gaming" -"лето"`, 5)
+		hits, _ = index.Search(`-"games" -"gaming" -"лето"`, 5, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/hello !&a! world.go", Score: float32(math.Inf(1))},
@@ -227,6 +229,86 @@ func TestPrefixIndex(t *testing.T) {
 		)
 	})
 
+	t.Run("fuzzy", func(t *testing.T) {
+		r := require.New(t)
+
+		hits, _ := index.Search(`rash`, 5, nil)
+		r.Empty(hits)
+
+		// Hits matched only with typos.
+		hits, _ = index.Search(`~rash`, 5, nil)
+		r.Equal(
+			[]Hit{
+				{Path: "/games/hi-fi rush/1.jpg", Score: 1.0 / 3},
+				{Path: "/games/hi-fi rush/2.jpg", Score: 1.0 / 3},
+			},
+			hits,
+		)
+
+		hits, _ = index.Search(`~rash games`, 5, nil)
+		r.Equal(
+			[]Hit{
+				{Path: "/games/hi-fi rush/1.jpg", Score: 3 + 1.0/3},
+				{Path: "/games/hi-fi rush/2.jpg", Score: 3 + 1.0/3},
+			},
+			hits,
+		)
+
+		// Hits with fewer typos are ranked first.
+		hits, _ = index.Search(`~gamng`, 5, nil)
+		r.Equal(
+			[]Hit{
+				{Path: "/gaming/", Score: 1 + 1.0/3, IsDir: true},
+				{Path: "/games/starfield/", Score: 1 + 1.0/4, IsDir: true},
+				{Path: "/games/hi-fi rush/1.jpg", Score: 1 + 1.0/4},
+				{Path: "/games/hi-fi rush/2.jpg", Score: 1 + 1.0/4},
+			},
+			hits,
+		)
+
+		// Prefix matches are ranked above matches with typos.
+		hits, _ = index.Search(`~starfeild`, 5, nil)
+		r.Equal(
+			[]Hit{
+				{Path: "/games/starfield/", Score: 3 + 1.0/3, IsDir: true},
+			},
+			hits,
+		)
+		hits, _ = index.Search(`~gam`, 5, nil)
+		r.Equal(
+			[]Hit{
+				{Path: "/games/starfield/", Score: 1 + 1.0/2, IsDir: true},
+				{Path: "/gaming/", Score: 1 + 1.0/2, IsDir: true},
+				{Path: "/games/hi-fi rush/1.jpg", Score: 1 + 1.0/2},
+				{Path: "/games/hi-fi rush/2.jpg", Score: 1 + 1.0/2},
+			},
+			hits,
+		)
+	})
+
+	t.Run("search with filter", func(t *testing.T) {
+		r := require.New(t)
+
+		filter := func(path string, _ bool) bool {
+			return !strings.HasPrefix(path, "/games/hi-fi rush/")
+		}
+
+		// "/games/hi-fi rush/" is not in the index, so hits must not be compacted into it.
+		hits, total := index.Search("games", 5, filter)
+		r.Equal(2, total)
+		r.Equal(
+			[]Hit{
+				{Path: "/games/starfield/", Score: 3, IsDir: true},
+				{Path: "/gaming/", Score: 1, IsDir: true},
+			},
+			hits,
+		)
+
+		hits, total = index.Search("rush", 5, filter)
+		r.Equal(0, total)
+		r.Empty(hits)
+	})
+
 	t.Run("search with a one-letter word", func(t *testing.T) {
 		r := require.New(t)
 
@@ -234,21 +316,21 @@ func TestPrefixIndex(t *testing.T) {
 			{URL: "a beautiful picture"},
 		}
 		index := newPrefixIndex(slices.Values(entries), 3, 7)
-		hits, _ := index.Search("a beautiful", 10)
+		hits, _ := index.Search("a beautiful", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "a beautiful picture", Score: 5},
 			},
 			hits,
 		)
-		hits, _ = index.Search("a b beautiful", 10)
+		hits, _ = index.Search("a b beautiful", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "a beautiful picture", Score: 5},
 			},
 			hits,
 		)
-		hits, _ = index.Search(`a "beautiful"`, 10)
+		hits, _ = index.Search(`a "beautiful"`, 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "a beautiful picture", Score: float32(math.Inf(1))},
@@ -269,25 +351,25 @@ func TestPrefixIndex(t *testing.T) {
 		index := newPrefixIndex(slices.Values(entries), 3, 7)
 
 		// Both searches, with and without accented characters, succeed.
-		hits, _ := index.Search("schuchternes", 10)
+		hits, _ := index.Search("schuchternes", 10, nil)
 		r.Equal(
 			[]Hit{{Path: "schüchternes Lächeln", Score: 5}},
 			hits,
 		)
-		hits, _ = index.Search("schüchternes", 10)
+		hits, _ = index.Search("schüchternes", 10, nil)
 		r.Equal(
 			[]Hit{{Path: "schüchternes Lächeln", Score: 5}},
 			hits,
 		)
 
 		// But exact search succeeds only for input with accented characters.
-		hits, _ = index.Search(`"schuchternes"`, 10)
+		hits, _ = index.Search(`"schuchternes"`, 10, nil)
 		r.Empty(hits)
-		hits, _ = index.Search(`"schüchternes"`, 10)
+		hits, _ = index.Search(`"schüchternes"`, 10, nil)
 		r.NotEmpty(hits)
 
 		// Other cases.
-		hits, _ = index.Search("hello", 10)
+		hits, _ = index.Search("hello", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "hello world", Score: 3},
@@ -295,7 +377,7 @@ func TestPrefixIndex(t *testing.T) {
 			},
 			hits,
 		)
-		hits, _ = index.Search("ĥ̷̩e̴͕̯̺͛l̸̨̹͍̈́̍͛", 10)
+		hits, _ = index.Search("ĥ̷̩e̴͕̯̺͛l̸̨̹͍̈́̍͛", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "hello world", Score: 1},
@@ -303,12 +385,12 @@ func TestPrefixIndex(t *testing.T) {
 			},
 			hits,
 		)
-		hits, _ = index.Search("белыи", 10)
+		hits, _ = index.Search("белыи", 10, nil)
 		r.Equal(
 			[]Hit{{Path: "белый", Score: 3}},
 			hits,
 		)
-		hits, _ = index.Search("бёлый", 10)
+		hits, _ = index.Search("бёлый", 10, nil)
 		r.Equal(
 			[]Hit{{Path: "белый", Score: 3}},
 			hits,
@@ -329,7 +411,7 @@ func TestPrefixIndex(t *testing.T) {
 		}
 		index := newPrefixIndex(slices.Values(entries), 3, 7)
 
-		hits, _ := index.Search("anim", 10)
+		hits, _ := index.Search("anim", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/animals/", Score: 2, IsDir: true},
@@ -338,7 +420,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search("anim dogs", 10)
+		hits, _ = index.Search("anim dogs", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/animals/dogs/", Score: 4, IsDir: true},
@@ -346,7 +428,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search("anim cats", 10)
+		hits, _ = index.Search("anim cats", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/animals/cats/", Score: 4, IsDir: true},
@@ -355,7 +437,7 @@ func TestPrefixIndex(t *testing.T) {
 			hits,
 		)
 
-		hits, _ = index.Search("anime jpeg", 10)
+		hits, _ = index.Search("anime jpeg", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/anime/art.jpeg", Score: 5},
@@ -374,7 +456,7 @@ func TestPrefixIndex(t *testing.T) {
 			newDirEntry("/game/gamesaves/3.txt"),
 		}
 		index = newPrefixIndex(slices.Values(entries), 3, 7)
-		hits, _ = index.Search("games", 10)
+		hits, _ = index.Search("games", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/game/gamesaves/", Score: 3, IsDir: true},
@@ -390,7 +472,7 @@ func TestPrefixIndex(t *testing.T) {
 			newDirEntry("/test.Dockerfile.dockerignore"),
 		}
 		index = newPrefixIndex(slices.Values(entries), 3, 7)
-		hits, _ = index.Search(`"dockerfile"`, 10)
+		hits, _ = index.Search(`"dockerfile"`, 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/Dockerfile", Score: float32(math.Inf(1))},
@@ -409,7 +491,7 @@ func TestPrefixIndex(t *testing.T) {
 		}
 		index := newPrefixIndex(slices.Values(entries), 3, 7)
 
-		hits, _ := index.Search("cat", 10)
+		hits, _ := index.Search("cat", 10, nil)
 		r.Equal(
 			[]Hit{
 				{Path: "/cats/", IsDir: true, Size: 0, ModTime: 123, Score: 1},
@@ -421,6 +503,137 @@ func TestPrefixIndex(t *testing.T) {
 	})
 }
 
+func TestPrefixIndex_updateDir(t *testing.T) {
+	t.Parallel()
+
+	initialEntries := []rclone.DirEntry{
+		newDirEntry("/games/"),
+		newDirEntry("/games/saves/"),
+		newDirEntry("/games/saves/1.sav"),
+		newDirEntry("/games/config.txt"),
+		newDirEntry("/games/notes.txt"),
+		newDirEntry("/gamesaves/"),
+		newDirEntry("/gamesaves/2.sav"),
+	}
+
+	// getPrefixes returns prefixes with paths instead of ids to compare indexes.
+	getPrefixes := func(r *require.Assertions, index indexView, prefixes iter.Seq[string]) map[string][]string {
+		res := make(map[string][]string)
+		for prefix := range prefixes {
+			ids := index.getIDs(prefix)
+			r.True(slices.IsSorted(ids))
+
+			for _, id := range ids {
+				entry, ok := index.getEntry(id)
+				r.True(ok)
+				res[prefix] = append(res[prefix], entry.Path)
+			}
+			slices.Sort(res[prefix])
+		}
+		return res
+	}
+	getEntries := func(index indexView) (res []dirEntry) {
+		for id := range index.allIDs() {
+			entry, _ := index.getEntry(id)
+			res = append(res, entry)
+		}
+		return res
+	}
+
+	for _, tt := range []struct {
+		name        string
+		dir         string
+		entries     []rclone.DirEntry
+		isDeleted   bool
+		wantChanged bool
+		want        []rclone.DirEntry
+	}{
+		{
+			name: "no changes",
+			dir:  "/games/",
+			entries: []rclone.DirEntry{
+				newDirEntry("/games/saves/"),
+				newDirEntry("/games/config.txt"),
+				newDirEntry("/games/notes.txt"),
+			},
+			wantChanged: false,
+			want:        initialEntries,
+		},
+		{
+			name: "add, remove and modify",
+			dir:  "/games/",
+			entries: []rclone.DirEntry{
+				newDirEntryWithMetadata("/games/config.txt", 15, 100),
+				newDirEntry("/games/gameplay.mp4"),
+			},
+			wantChanged: true,
+			want: []rclone.DirEntry{
+				newDirEntry("/games/"),
+				newDirEntryWithMetadata("/games/config.txt", 15, 100),
+				newDirEntry("/games/gameplay.mp4"),
+				newDirEntry("/gamesaves/"),
+				newDirEntry("/gamesaves/2.sav"),
+			},
+		},
+		{
+			name:        "deleted dir",
+			dir:         "/games/",
+			isDeleted:   true,
+			wantChanged: true,
+			want: []rclone.DirEntry{
+				newDirEntry("/gamesaves/"),
+				newDirEntry("/gamesaves/2.sav"),
+			},
+		},
+		{
+			name: "file replaced with dir",
+			dir:  "/games/",
+			entries: []rclone.DirEntry{
+				newDirEntry("/games/saves/"),
+				newDirEntry("/games/config.txt"),
+				newDirEntry("/games/notes.txt/"),
+			},
+			wantChanged: true,
+			want: []rclone.DirEntry{
+				newDirEntry("/games/"),
+				newDirEntry("/games/saves/"),
+				newDirEntry("/games/saves/1.sav"),
+				newDirEntry("/games/config.txt"),
+				newDirEntry("/games/notes.txt/"),
+				newDirEntry("/gamesaves/"),
+				newDirEntry("/gamesaves/2.sav"),
+			},
+		},
+	} {
+		wantIndex := newPrefixIndex(slices.Values(tt.want), 3, 7)
+		// Check removed prefixes too.
+		allPrefixes := slices.Concat(
+			slices.Collect(maps.Keys(newPrefixIndex(slices.Values(initialEntries), 3, 7).Prefixes)),
+			slices.Collect(maps.Keys(wantIndex.Prefixes)),
+		)
+
+		t.Run(tt.name, func(t *testing.T) {
+			r := require.New(t)
+
+			index := newPrefixIndex(slices.Values(initialEntries), 3, 7)
+			r.Equal(tt.wantChanged, updateDir(index, tt.dir, tt.entries, tt.isDeleted))
+
+			r.ElementsMatch(slices.Collect(maps.Values(wantIndex.Entries)), slices.Collect(maps.Values(index.Entries)))
+			r.Equal(getPrefixes(r, wantIndex, slices.Values(allPrefixes)), getPrefixes(r, index, slices.Values(allPrefixes)))
+			r.ElementsMatch(slices.Collect(maps.Values(wantIndex.lowerCasedPaths)), slices.Collect(maps.Values(index.lowerCasedPaths)))
+		})
+		t.Run(tt.name+" (layered)", func(t *testing.T) {
+			r := require.New(t)
+
+			index := newLayeredIndex(newTestDiskIndex(t, initialEntries, 3, 7))
+			r.Equal(tt.wantChanged, updateDir(index, tt.dir, tt.entries, tt.isDeleted))
+
+			r.ElementsMatch(getEntries(wantIndex), getEntries(index))
+			r.Equal(getPrefixes(r, wantIndex, slices.Values(allPrefixes)), getPrefixes(r, index, slices.Values(allPrefixes)))
+		})
+	}
+}
+
 func TestNewSearchRequest(t *testing.T) {
 	for _, tt := range []struct {
 		search     string
@@ -481,6 +694,14 @@ func TestNewSearchRequest(t *testing.T) {
 				toExclude: []string{"hello world"},
 			},
 		},
+		{
+			search: `Content:Hello content:"Beautiful World" world -content: content:`,
+			want: searchRequest{
+				contentMatches: []string{"hello", "beautiful world"},
+				toExclude:      []string{"content:"},
+				extractedWords: []string{"world"},
+			},
+		},
 		{
 			search: `a/aa/aaa/aaaa`,
 			want: searchRequest{
@@ -492,6 +713,21 @@ func TestNewSearchRequest(t *testing.T) {
 			},
 			checkWords: true,
 		},
+		{
+			search: `~Vacaton cat content:~word ~ ~ab -~xyz`,
+			want: searchRequest{
+				words: [][]rune{
+					[]rune("cat"),
+				},
+				fuzzyWords: [][]rune{
+					[]rune("vacaton"),
+				},
+				contentMatches: []string{"~word"},
+				toExclude:      []string{"~xyz"},
+				extractedWords: []string{"cat"},
+			},
+			checkWords: true,
+		},
 		{
 			search: `a b c dd`,
 			want: searchRequest{
@@ -504,6 +740,7 @@ func TestNewSearchRequest(t *testing.T) {
 			got := newSearchRequest(tt.search, 3)
 			if !tt.checkWords {
 				got.words = nil // too tiresome to test
+				got.fuzzyWords = nil
 			}
 
 			assert.Equal(t, tt.want, got)
@@ -559,7 +796,7 @@ func BenchmarkPrefixIndex_Search(b *testing.B) {
 	run := func(s string) {
 		b.Run(s, func(b *testing.B) {
 			for b.Loop() {
-				index.Search(s, 10)
+				index.Search(s, 10, nil)
 			}
 		})
 	}
@@ -7,43 +7,94 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"iter"
 	"math"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ShoshinNikita/rview/pkg/metrics"
 	"github.com/ShoshinNikita/rview/pkg/rlog"
 	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
 )
 
 type Service struct {
 	rclone Rclone
 	dir    *os.Root
-
-	stopCh    chan struct{}
-	stoppedCh chan struct{}
-
-	mu    sync.RWMutex
-	index *searchIndex
+	opts   Options
+
+	stopCh           chan struct{}
+	stoppedCh        chan struct{}
+	contentStoppedCh chan struct{}
+	// contentRefreshCh is used to request an update of content indexes.
+	contentRefreshCh chan struct{}
+	geoStoppedCh     chan struct{}
+	// geoRefreshCh is used to request an update of geo indexes.
+	geoRefreshCh chan struct{}
+
+	mu sync.RWMutex
+	// indexes contains a separate index for every remote, see [Rclone.GetRemotes].
+	indexes map[string]*searchIndex
+	// contentIndexes contains a separate content index for every remote. It is nil
+	// if the content search is disabled.
+	contentIndexes map[string]*contentIndex
+	// geoIndexes contains a separate geo index for every remote. It is nil if
+	// the geo index is disabled.
+	geoIndexes map[string]*geoIndex
 
 	minPrefixLen int
 	maxPrefixLen int
-	filename     string
+}
+
+type Options struct {
+	// ContentIndex enables the full-text search over contents of text files.
+	ContentIndex bool
+	// ContentMaxFileSize is the max size of a file which content can be indexed.
+	ContentMaxFileSize int64
+	// Fuzzy allows all words to match with typos, not only the ones with "~".
+	Fuzzy bool
+	// Metadata is used to build geo indexes with GPS coordinates of images, optional.
+	// If it is nil, the geo index is disabled.
+	Metadata MetadataService
 }
 
 type Rclone interface {
-	GetAllFiles(ctx context.Context) (iter.Seq[rclone.DirEntry], error)
+	// GetRemotes returns names of all remotes. In single-remote mode, it returns a single empty name.
+	GetRemotes() []string
+	GetAllFiles(ctx context.Context, remote string) iter.Seq2[rclone.DirEntry, error]
+	// GetDirEntries returns direct children of the directory. It returns an error
+	// for which [rclone.IsNotFoundError] is true if the directory doesn't exist.
+	GetDirEntries(ctx context.Context, dir string) iter.Seq2[rclone.DirEntry, error]
+	OpenFile(ctx context.Context, id rview.FileID) (io.ReadCloser, error)
 }
 
 type searchIndex struct {
-	Index *prefixIndex `json:"index"`
+	Index *layeredIndex
+	// CreatedAt is the time of the last refresh, see [Service.refreshIndex].
+	CreatedAt time.Time
+
+	// modified is true if the index has been changed after it was saved, see [Service.ApplyDirChange].
+	modified bool
+	// refreshing is true while the index is being refreshed. The index can't be saved during
+	// the refresh because ids of entries change on save.
+	refreshing bool
+	// lastFullRefresh is the start time of the last refresh that listed all directories. It is
+	// not saved, so the first refresh after a restart is always full.
+	lastFullRefresh time.Time
+}
 
-	CreatedAt time.Time `json:"created_at"`
+// legacySearchIndex is the format of indexes saved as gzipped JSON. Such indexes are
+// converted to [diskIndex] on load.
+type legacySearchIndex struct {
+	Index     *prefixIndex `json:"index"`
+	CreatedAt time.Time    `json:"created_at"`
 }
 
-func NewService(rclone Rclone, dirRoot *os.Root) (*Service, error) {
+func NewService(rclone Rclone, dirRoot *os.Root, opts Options) (*Service, error) {
 	const (
 		minPrefixLen = 3
 		maxPrefixLen = 10
@@ -58,41 +109,112 @@ func NewService(rclone Rclone, dirRoot *os.Root) (*Service, error) {
 		return nil, fmt.Errorf("couldn't open root: %w", err)
 	}
 
-	return &Service{
+	s := &Service{
 		rclone: rclone,
 		dir:    searchDirRoot,
+		opts:   opts,
 		//
-		stopCh:    make(chan struct{}),
-		stoppedCh: make(chan struct{}),
+		stopCh:           make(chan struct{}),
+		stoppedCh:        make(chan struct{}),
+		contentStoppedCh: make(chan struct{}),
+		contentRefreshCh: make(chan struct{}, 1),
+		geoStoppedCh:     make(chan struct{}),
+		geoRefreshCh:     make(chan struct{}, 1),
+		//
+		indexes: make(map[string]*searchIndex),
 		//
 		minPrefixLen: minPrefixLen,
 		maxPrefixLen: maxPrefixLen,
-		filename:     "search_index.json.gz",
-	}, nil
+	}
+	if opts.ContentIndex {
+		s.contentIndexes = make(map[string]*contentIndex)
+	}
+	if opts.Metadata != nil {
+		s.geoIndexes = make(map[string]*geoIndex)
+	}
+	return s, nil
+}
+
+func getIndexFilename(remote string) string {
+	if remote == "" {
+		return "search_index.bin"
+	}
+	return "search_index." + remote + ".bin"
+}
+
+func getLegacyIndexFilename(remote string) string {
+	if remote == "" {
+		return "search_index.json.gz"
+	}
+	return "search_index." + remote + ".json.gz"
+}
+
+func getContentIndexFilename(remote string) string {
+	if remote == "" {
+		return "search_content_index.json.gz"
+	}
+	return "search_content_index." + remote + ".json.gz"
 }
 
 func (s *Service) Start() (err error) {
 	defer func() {
 		if err != nil {
 			close(s.stoppedCh)
+			close(s.contentStoppedCh)
+			close(s.geoStoppedCh)
 			return
 		}
 
 		go s.startBackgroundRefresh()
+
+		if s.opts.ContentIndex {
+			s.requestContentIndexing()
+			go s.startContentIndexing()
+		} else {
+			close(s.contentStoppedCh)
+		}
+
+		if s.opts.Metadata != nil {
+			s.requestGeoIndexing()
+			go s.startGeoIndexing()
+		} else {
+			close(s.geoStoppedCh)
+		}
 	}()
 
-	s.index, err = s.loadIndexFromCache()
+	for _, remote := range s.rclone.GetRemotes() {
+		err = s.loadOrPrepareIndex(remote)
+		if err != nil {
+			return err
+		}
+		if s.opts.ContentIndex {
+			s.loadOrPrepareContentIndex(remote)
+		}
+		if s.opts.Metadata != nil {
+			s.loadOrPrepareGeoIndex(remote)
+		}
+	}
+	return nil
+}
+
+func (s *Service) loadOrPrepareIndex(remote string) (err error) {
+	index, err := s.loadIndexFromCache(remote)
 	if err == nil {
-		rlog.Info("search index has been loaded from the file")
+		rlog.Infof("search index %q has been loaded from the file", remote)
+
+		s.mu.Lock()
+		s.indexes[remote] = index
+		s.mu.Unlock()
+
 		return nil
 	}
 
-	rlog.Infof("prepare new index: couldn't load index from the file: %s", err)
+	rlog.Infof("prepare new index %q: couldn't load index from the file: %s", remote, err)
 
 	// The first few requests can fail with error "connection refused" because
 	// rclone is still starting.
 	for i := 1; true; i++ {
-		err = s.RefreshIndex(context.Background())
+		err = s.refreshIndex(context.Background(), remote, true)
 		if err == nil {
 			return nil
 		}
@@ -110,34 +232,55 @@ func (s *Service) Start() (err error) {
 	panic("unreachable")
 }
 
-func (s *Service) loadIndexFromCache() (res *searchIndex, err error) {
-	rc, err := s.dir.Open(s.filename)
-	if err != nil {
-		return nil, fmt.Errorf("couldn't open file: %w", err)
+func (s *Service) loadIndexFromCache(remote string) (*searchIndex, error) {
+	_, err := s.dir.Stat(getIndexFilename(remote))
+	if errors.Is(err, fs.ErrNotExist) {
+		err = s.migrateLegacyIndex(remote)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't migrate legacy index: %w", err)
+		}
 	}
-	defer rc.Close()
 
-	gzipReader, err := gzip.NewReader(rc)
+	base, err := s.openIndexFile(remote)
 	if err != nil {
 		return nil, err
 	}
-	defer gzipReader.Close()
+	if err := base.Check(s.minPrefixLen, s.maxPrefixLen); err != nil {
+		base.Close()
+		return nil, err
+	}
+	return &searchIndex{
+		Index:     newLayeredIndex(base),
+		CreatedAt: time.Unix(0, base.header.CreatedAt),
+	}, nil
+}
 
-	err = json.NewDecoder(gzipReader).Decode(&res)
+// migrateLegacyIndex converts the index saved as gzipped JSON to [diskIndex].
+func (s *Service) migrateLegacyIndex(remote string) error {
+	var legacyIndex *legacySearchIndex
+	err := s.loadFromCache(getLegacyIndexFilename(remote), &legacyIndex)
 	if err != nil {
-		return nil, fmt.Errorf("decode error: %w", err)
+		return err
 	}
-	if err := gzipReader.Close(); err != nil {
-		return nil, fmt.Errorf("couldn't close gzip reader: %w", err)
+	if legacyIndex == nil || legacyIndex.Index == nil {
+		return errors.New("index is not ready")
+	}
+	if err := legacyIndex.Index.Check(s.minPrefixLen, s.maxPrefixLen); err != nil {
+		return err
 	}
 
-	if res == nil || res.Index == nil {
-		return nil, errors.New("index is not ready")
+	index := newLayeredIndexWithOverlay(newEmptyDiskIndex(s.minPrefixLen, s.maxPrefixLen), legacyIndex.Index)
+	err = s.saveIndexFile(remote, index, legacyIndex.CreatedAt)
+	if err != nil {
+		return err
 	}
-	if err := res.Index.Check(s.minPrefixLen, s.maxPrefixLen); err != nil {
-		return nil, err
+
+	rlog.Infof("search index %q has been converted to the binary format", remote)
+
+	if err := s.dir.Remove(getLegacyIndexFilename(remote)); err != nil {
+		rlog.Warnf("couldn't remove legacy search index %q: %s", remote, err)
 	}
-	return res, nil
+	return nil
 }
 
 func (s *Service) startBackgroundRefresh() {
@@ -148,6 +291,9 @@ func (s *Service) startBackgroundRefresh() {
 
 	defer close(s.stoppedCh)
 
+	// Don't lose changes on shutdown.
+	defer s.saveModifiedIndexes()
+
 	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 	for {
@@ -156,17 +302,21 @@ func (s *Service) startBackgroundRefresh() {
 			return
 
 		case <-ticker.C:
-			s.mu.RLock()
-			createdAt := s.index.CreatedAt
-			s.mu.RUnlock()
+			s.saveModifiedIndexes()
 
-			if time.Since(createdAt) < refreshInterval {
-				continue
-			}
+			for _, remote := range s.rclone.GetRemotes() {
+				s.mu.RLock()
+				createdAt := s.indexes[remote].CreatedAt
+				s.mu.RUnlock()
 
-			err := s.RefreshIndex(context.Background())
-			if err != nil {
-				rlog.Errorf("couldn't refresh search index: %s", err)
+				if time.Since(createdAt) < refreshInterval {
+					continue
+				}
+
+				err := s.refreshIndex(context.Background(), remote, false)
+				if err != nil {
+					rlog.Errorf("couldn't refresh search index %q: %s", remote, err)
+				}
 			}
 		}
 	}
@@ -175,19 +325,37 @@ func (s *Service) startBackgroundRefresh() {
 func (s *Service) Shutdown(ctx context.Context) error {
 	close(s.stopCh)
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-s.stoppedCh:
-		return nil
+	for _, ch := range []chan struct{}{s.stoppedCh, s.contentStoppedCh, s.geoStoppedCh} {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for remote, index := range s.indexes {
+		if err := index.Index.Close(); err != nil {
+			rlog.Errorf("couldn't close search index %q: %s", remote, err)
+		}
 	}
+	clear(s.indexes)
+
+	return nil
 }
 
 func (s *Service) GetMinSearchLength() int {
 	return s.minPrefixLen
 }
 
-func (s *Service) Search(_ context.Context, search string, limit int) (hits []Hit, total int, _ error) {
+// Search returns hits sorted by score and the total number of hits. If filter is not nil,
+// only paths that pass it are returned. If the search contains "content:" operators, only
+// files with matching contents are returned, and hits have snippets.
+func (s *Service) Search(
+	_ context.Context, search string, limit int, filter func(path string, isDir bool) bool,
+) (hits []Hit, total int, _ error) {
 	now := time.Now()
 	defer func() {
 		metrics.SearchDuration.Observe(time.Since(now).Seconds())
@@ -197,20 +365,109 @@ func (s *Service) Search(_ context.Context, search string, limit int) (hits []Hi
 	defer s.mu.RUnlock()
 
 	// Usually happens in integration tests.
-	if s.index == nil || s.index.Index == nil {
+	if len(s.indexes) == 0 {
 		return nil, 0, errors.New("index is not ready")
 	}
 
-	hits, total = s.index.Index.Search(search, limit)
+	req := newSearchRequest(search, s.minPrefixLen)
+	if s.opts.Fuzzy {
+		req.fuzzyWords = append(req.fuzzyWords, req.words...)
+		req.words = nil
+	}
+	if len(req.contentMatches) > 0 {
+		if s.contentIndexes == nil {
+			return nil, 0, ErrContentSearchDisabled
+		}
+		hits, total = s.searchContent(req, limit, filter)
+		return hits, total, nil
+	}
+
+	for _, index := range s.indexes {
+		remoteHits, remoteTotal := index.Index.search(req, limit, filter)
+		hits = append(hits, remoteHits...)
+		total += remoteTotal
+	}
+	if len(s.indexes) > 1 {
+		// Hits of every index are already sorted, but we have to sort them again after merging.
+		slices.SortFunc(hits, compareHits)
+		if len(hits) > limit {
+			hits = hits[:limit]
+		}
+	}
 	return hits, total, nil
 }
 
-// RefreshIndex requests all files from rclone and creates a new index.
-func (s *Service) RefreshIndex(ctx context.Context) (finalErr error) {
+// GetFiles returns all files (not directories) from the index that match the filter.
+// The order of files is not defined.
+func (s *Service) GetFiles(_ context.Context, filter func(path string) bool) ([]Hit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.indexes) == 0 {
+		return nil, errors.New("index is not ready")
+	}
+
+	var res []Hit
+	for _, index := range s.indexes {
+		for entry := range index.Index.entries() {
+			if entry.IsDir || !filter(entry.Path) {
+				continue
+			}
+			res = append(res, Hit{
+				Path:    entry.Path,
+				Size:    entry.Size,
+				ModTime: entry.ModTime,
+			})
+		}
+	}
+	return res, nil
+}
+
+// RefreshIndex requests all files from rclone and updates indexes of all remotes. Unlike
+// background refreshes, it never skips unchanged directories.
+func (s *Service) RefreshIndex(ctx context.Context) error {
+	for _, remote := range s.rclone.GetRemotes() {
+		err := s.refreshIndex(ctx, remote, true)
+		if err != nil {
+			return fmt.Errorf("couldn't refresh index %q: %w", remote, err)
+		}
+	}
+	return nil
+}
+
+const (
+	// refreshWorkersCount is the number of directories listed concurrently during a refresh.
+	refreshWorkersCount = 8
+
+	// fullRefreshInterval is the max interval between refreshes that list all directories,
+	// see [Service.updateIndex].
+	fullRefreshInterval = 7 * 24 * time.Hour
+)
+
+type refreshStats struct {
+	full                    bool
+	dirs, files             int
+	added, updated, removed int
+	// skipped is the number of unchanged directories which subtrees were not listed.
+	skipped int
+}
+
+func (stats *refreshStats) count(entry rclone.DirEntry) {
+	if entry.IsDir {
+		stats.dirs++
+	} else {
+		stats.files++
+	}
+}
+
+// refreshIndex requests files from rclone. A new index is created only if there is no index
+// yet. Otherwise, the existing index is updated in place: only changed entries are touched, and
+// searches are not blocked for the whole refresh. If full is false, unchanged directories
+// can be skipped, see [Service.updateIndex].
+func (s *Service) refreshIndex(ctx context.Context, remote string, full bool) (finalErr error) {
 	var (
-		now       = time.Now()
-		dirCount  int
-		fileCount int
+		now   = time.Now()
+		stats refreshStats
 	)
 	defer func() {
 		// Monitor duration even for errors.
@@ -221,40 +478,399 @@ func (s *Service) RefreshIndex(ctx context.Context) (finalErr error) {
 			metrics.SearchRefreshIndexesErrors.Inc()
 			return
 		}
-		rlog.Infof("search index has been successfully refreshed in %s, dirs: %d, files: %d", dur, dirCount, fileCount)
+		rlog.Infof(
+			"search index %q has been successfully refreshed in %s, full: %t, dirs: %d, files: %d, "+
+				"added: %d, updated: %d, removed: %d, skipped dirs: %d",
+			remote, dur, stats.full, stats.dirs, stats.files, stats.added, stats.updated, stats.removed, stats.skipped,
+		)
 	}()
 
-	dirEntries, err := s.rclone.GetAllFiles(ctx)
-	if err != nil {
-		return fmt.Errorf("couldn't get all files from rclone: %w", err)
+	s.mu.RLock()
+	index := s.indexes[remote]
+	s.mu.RUnlock()
+
+	if index == nil {
+		stats.full = true
+		return s.prepareIndex(ctx, remote, &stats)
+	}
+	return s.updateIndex(ctx, remote, index, full, &stats)
+}
+
+func (s *Service) prepareIndex(ctx context.Context, remote string, stats *refreshStats) error {
+	var iterErr error
+	dirEntries := func(yield func(rclone.DirEntry) bool) {
+		for entry, err := range s.rclone.GetAllFiles(ctx, remote) {
+			if err != nil {
+				iterErr = err
+				return
+			}
+			stats.count(entry)
+			if !yield(entry) {
+				return
+			}
+		}
 	}
 
-	index := &searchIndex{
-		Index:     newPrefixIndex(dirEntries, s.minPrefixLen, s.maxPrefixLen),
-		CreatedAt: time.Now(),
+	newIndex := newPrefixIndex(dirEntries, s.minPrefixLen, s.maxPrefixLen)
+	if iterErr != nil {
+		return fmt.Errorf("couldn't get all files from rclone: %w", iterErr)
 	}
+	stats.added = len(newIndex.Entries)
 
 	// Save the index on disk before updating in-memory state to avoid
 	// any inconsistency.
-	err = s.saveIndexToCache(index)
+	createdAt := time.Now()
+	err := s.saveIndexFile(
+		remote, newLayeredIndexWithOverlay(newEmptyDiskIndex(s.minPrefixLen, s.maxPrefixLen), newIndex), createdAt,
+	)
 	if err != nil {
 		return fmt.Errorf("couldn't save new index: %w", err)
 	}
+	base, err := s.openIndexFile(remote)
+	if err != nil {
+		return fmt.Errorf("couldn't open new index: %w", err)
+	}
+
+	s.mu.Lock()
+	s.indexes[remote] = &searchIndex{
+		Index:           newLayeredIndex(base),
+		CreatedAt:       createdAt,
+		lastFullRefresh: createdAt,
+	}
+	s.mu.Unlock()
+
+	// New files have to be indexed.
+	s.requestIndexing()
+
+	return nil
+}
+
+// updateIndex walks directories of the remote and applies their content to the existing index.
+// Directories are listed one by one, so memory usage depends on the size of the largest directory,
+// not on the number of files.
+//
+// If full is false, subdirectories with the same mod time as in the index are skipped along with
+// their subtrees. Backends without mod times of directories (for example, object storages) return
+// the current time, so their directories are never skipped. Other backends update mod times only
+// when direct children change, so changes deep in skipped subtrees are missed until the next full
+// refresh or a dir change notification (see [Service.ApplyDirChange]). That is why a full refresh
+// is done at least every [fullRefreshInterval] and after every restart.
+func (s *Service) updateIndex(ctx context.Context, remote string, index *searchIndex, full bool, stats *refreshStats) error {
+	s.mu.Lock()
+	if index.refreshing {
+		s.mu.Unlock()
+		return errors.New("index is already being refreshed")
+	}
+	index.refreshing = true
+	full = full || time.Since(index.lastFullRefresh) >= fullRefreshInterval
+	stats.full = full
+	s.mu.Unlock()
+
+	startedAt := time.Now()
+	err := s.walkDirs(ctx, remote, index, full, stats)
+
+	s.mu.Lock()
+	index.refreshing = false
+	switch {
+	case err != nil:
+		// Mod times of subdirectories could have been updated before their content, so
+		// they can't be trusted until the next full refresh.
+		index.lastFullRefresh = time.Time{}
+	default:
+		index.CreatedAt = time.Now()
+		index.modified = true
+		if full {
+			index.lastFullRefresh = startedAt
+		}
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	err = s.saveModifiedIndex(remote)
+	if err != nil {
+		return fmt.Errorf("couldn't save updated index: %w", err)
+	}
+
+	if stats.added > 0 || stats.updated > 0 || stats.removed > 0 {
+		// New and modified files have to be indexed.
+		s.requestIndexing()
+	}
+	return nil
+}
+
+// walkDirs refreshes directories level by level starting from the root directory of the remote.
+// Directories of the same level are listed concurrently. The walk stops after the first error,
+// applied changes are kept because they are correct.
+func (s *Service) walkDirs(ctx context.Context, remote string, index *searchIndex, full bool, stats *refreshStats) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	rootDir := "/"
+	if remote != "" {
+		rootDir = "/" + remote + "/"
+	}
+
+	for dirs := []string{rootDir}; len(dirs) > 0; {
+		var (
+			wg      sync.WaitGroup
+			sem     = make(chan struct{}, refreshWorkersCount)
+			mu      sync.Mutex
+			subdirs []string
+		)
+		for _, dir := range dirs {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+
+			wg.Go(func() {
+				defer func() { <-sem }()
+
+				res, err := s.refreshDir(ctx, index, dir, dir == rootDir, full, stats)
+				if err != nil {
+					cancel(fmt.Errorf("couldn't refresh dir %q: %w", dir, err))
+					return
+				}
+
+				mu.Lock()
+				subdirs = append(subdirs, res...)
+				mu.Unlock()
+			})
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		dirs = subdirs
+	}
+	return nil
+}
+
+// refreshDir applies the content of the directory to the index and returns subdirectories
+// that have to be refreshed next. Subdirectories are compared with the index before it is
+// updated, see [Service.updateIndex].
+func (s *Service) refreshDir(
+	ctx context.Context, index *searchIndex, dir string, isRoot, full bool, stats *refreshStats,
+) ([]string, error) {
+
+	var (
+		entries   []rclone.DirEntry
+		isDeleted bool
+	)
+	for entry, err := range s.rclone.GetDirEntries(ctx, dir) {
+		if err != nil {
+			if isRoot || !rclone.IsNotFoundError(err) {
+				return nil, err
+			}
+			// The directory has been removed after its parent was listed.
+			entries, isDeleted = nil, true
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subdirs []string
+	for _, entry := range entries {
+		stats.count(entry)
+		if !entry.IsDir {
+			continue
+		}
+		if !full {
+			id, ok := index.Index.findPath(entry.URL)
+			if ok {
+				if existing, ok := index.Index.getEntry(id); ok && existing.ModTime == entry.ModTime {
+					stats.skipped++
+					continue
+				}
+			}
+		}
+		subdirs = append(subdirs, entry.URL)
+	}
+
+	var (
+		prevLen     = index.Index.len()
+		prevNextID  = index.Index.nextID()
+		prevChanges = index.Index.changes
+	)
+	if updateDir(index.Index, dir, entries, isDeleted) {
+		index.modified = true
+	}
+	added := int(index.Index.nextID() - prevNextID)
+	removed := prevLen - index.Index.len() + added
+
+	stats.added += added
+	stats.removed += removed
+	stats.updated += int(index.Index.changes-prevChanges) - added - removed
+
+	return subdirs, nil
+}
+
+// ApplyDirChange updates indexes with the new content of the directory. It is much cheaper
+// than [Service.RefreshIndex]. Updated indexes are saved on disk periodically.
+func (s *Service) ApplyDirChange(change rclone.DirChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changed bool
+	for remote, index := range s.indexes {
+		if remote != "" && !strings.HasPrefix(change.Dir, "/"+remote+"/") {
+			continue
+		}
+		entries := keepDirModTimes(index.Index, change.Entries)
+		if updateDir(index.Index, change.Dir, entries, change.IsDeleted) {
+			index.modified = true
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	rlog.Debugf("search index has been updated with changes of dir %q", change.Dir)
+
+	// New and modified files have to be indexed.
+	s.requestIndexing()
+}
+
+// keepDirModTimes returns entries with mod times of subdirectories replaced with the indexed ones.
+// Mod times of directories are used to skip unchanged subtrees during refreshes, so they must be
+// updated only with the content of directories, see [Service.refreshDir]. New subdirectories get
+// zero mod time, so they are listed by the next refresh.
+func keepDirModTimes(index *layeredIndex, entries []rclone.DirEntry) []rclone.DirEntry {
+	res := slices.Clone(entries)
+	for i, entry := range res {
+		if !entry.IsDir {
+			continue
+		}
+		res[i].ModTime = 0
+		if id, ok := index.findPath(entry.URL); ok {
+			if existing, ok := index.getEntry(id); ok {
+				res[i].ModTime = existing.ModTime
+			}
+		}
+	}
+	return res
+}
+
+// requestIndexing requests updates of content and geo indexes. It doesn't block.
+func (s *Service) requestIndexing() {
+	s.requestContentIndexing()
+	s.requestGeoIndexing()
+}
+
+func (s *Service) saveModifiedIndexes() {
+	for _, remote := range s.rclone.GetRemotes() {
+		err := s.saveModifiedIndex(remote)
+		if err != nil {
+			rlog.Errorf("couldn't save modified search index %q: %s", remote, err)
+		}
+	}
+}
 
+// saveModifiedIndex saves the index if it has been modified after the last save. The saved
+// file is loaded instead of the in-memory changes.
+func (s *Service) saveModifiedIndex(remote string) error {
 	s.mu.Lock()
-	s.index = index
+	index := s.indexes[remote]
+	if index == nil || !index.modified || index.refreshing {
+		s.mu.Unlock()
+		return nil
+	}
+	index.modified = false
+	changes := index.Index.changes
+	createdAt := index.CreatedAt
 	s.mu.Unlock()
 
+	s.mu.RLock()
+	err := s.saveIndexFile(remote, index.Index, createdAt)
+	s.mu.RUnlock()
+
+	var base *diskIndex
+	if err == nil {
+		base, err = s.openIndexFile(remote)
+	}
+	if err != nil {
+		s.mu.Lock()
+		index.modified = true
+		s.mu.Unlock()
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index.Index.changes != changes {
+		// The index has been changed during the save, keep in-memory changes. The old file
+		// remains mapped even after it was replaced.
+		index.modified = true
+		base.Close()
+		return nil
+	}
+
+	if err := index.Index.Close(); err != nil {
+		rlog.Errorf("couldn't close search index %q: %s", remote, err)
+	}
+	index.Index = newLayeredIndex(base)
 	return nil
 }
 
-func (s *Service) saveIndexToCache(index *searchIndex) error {
+// saveIndexFile writes the index to a temporary file and then replaces the index file with it,
+// so the index file is never corrupted.
+func (s *Service) saveIndexFile(remote string, index *layeredIndex, createdAt time.Time) error {
+	filename := getIndexFilename(remote)
+	tmpFilename := filename + ".tmp"
+
+	f, err := s.dir.Create(tmpFilename)
+	if err != nil {
+		return fmt.Errorf("couldn't create file: %w", err)
+	}
+	defer f.Close()
+
+	err = writeDiskIndex(f, index, createdAt)
+	if err != nil {
+		return fmt.Errorf("couldn't write index to file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("couldn't close file: %w", err)
+	}
+	if err := s.dir.Rename(tmpFilename, filename); err != nil {
+		return fmt.Errorf("couldn't rename file: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) openIndexFile(remote string) (*diskIndex, error) {
+	f, err := s.dir.Open(getIndexFilename(remote))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open file: %w", err)
+	}
+	defer f.Close()
+
+	index, err := openDiskIndex(f)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open index: %w", err)
+	}
+	return index, nil
+}
+
+// saveToCache encodes the value as gzipped JSON and writes it to the file.
+func (s *Service) saveToCache(filename string, v any) error {
 	// Don't store encoded index in memory because it can be very large.
 	r, w := io.Pipe()
 	go func() {
 		gzipWriter := gzip.NewWriter(w)
 
-		err := json.NewEncoder(gzipWriter).Encode(index)
+		err := json.NewEncoder(gzipWriter).Encode(v)
 		if err != nil {
 			w.CloseWithError(fmt.Errorf("couldn't encode index: %w", err))
 			return
@@ -269,7 +885,7 @@ func (s *Service) saveIndexToCache(index *searchIndex) error {
 	// TODO: write index to tmp file and then rename it (requires go1.25):
 	// https://github.com/golang/go/issues/73041
 
-	f, err := s.dir.Create(s.filename)
+	f, err := s.dir.Create(filename)
 	if err != nil {
 		return fmt.Errorf("couldn't create file: %w", err)
 	}
@@ -283,3 +899,27 @@ func (s *Service) saveIndexToCache(index *searchIndex) error {
 	}
 	return nil
 }
+
+// loadFromCache decodes the value from the file written by [Service.saveToCache].
+func (s *Service) loadFromCache(filename string, v any) error {
+	rc, err := s.dir.Open(filename)
+	if err != nil {
+		return fmt.Errorf("couldn't open file: %w", err)
+	}
+	defer rc.Close()
+
+	gzipReader, err := gzip.NewReader(rc)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	err = json.NewDecoder(gzipReader).Decode(v)
+	if err != nil {
+		return fmt.Errorf("decode error: %w", err)
+	}
+	if err := gzipReader.Close(); err != nil {
+		return fmt.Errorf("couldn't close gzip reader: %w", err)
+	}
+	return nil
+}
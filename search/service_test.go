@@ -3,13 +3,20 @@ package search
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"iter"
 	"os"
 	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ShoshinNikita/rview/metadata"
 	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
 	"github.com/stretchr/testify/require"
 )
 
@@ -21,7 +28,7 @@ func TestService_RefreshIndex(t *testing.T) {
 	r.NoError(err)
 
 	rcloneStub := &rcloneStub{
-		GetAllFilesFn: func(context.Context) (iter.Seq[rclone.DirEntry], error) {
+		GetAllFilesFn: func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
 			return slices.Values([]rclone.DirEntry{
 				newDirEntry("/hello world.go"),
 				newDirEntry("/gaming.txt"),
@@ -31,7 +38,7 @@ func TestService_RefreshIndex(t *testing.T) {
 			}), nil
 		},
 	}
-	s, err := NewService(rcloneStub, root)
+	s, err := NewService(rcloneStub, root, Options{})
 	r.NoError(err)
 	err = s.Start()
 	r.NoError(err)
@@ -40,7 +47,7 @@ func TestService_RefreshIndex(t *testing.T) {
 		r.NoError(err)
 	}()
 
-	hits, _, err := s.Search(ctx, "games", 5)
+	hits, _, err := s.Search(ctx, "games", 5, nil)
 	r.NoError(err)
 	r.Equal(
 		[]Hit{
@@ -50,7 +57,17 @@ func TestService_RefreshIndex(t *testing.T) {
 		hits,
 	)
 
-	rcloneStub.GetAllFilesFn = func(context.Context) (iter.Seq[rclone.DirEntry], error) {
+	files, err := s.GetFiles(ctx, func(path string) bool { return !strings.HasSuffix(path, ".txt") })
+	r.NoError(err)
+	r.ElementsMatch(
+		[]Hit{
+			{Path: "/hello world.go"},
+			{Path: "/arts/games/1.jpeg"},
+		},
+		files,
+	)
+
+	rcloneStub.GetAllFilesFn = func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
 		return slices.Values([]rclone.DirEntry{
 			newDirEntry("/hello world.go"),
 			newDirEntry("/qwerty.txt"),
@@ -60,17 +77,759 @@ func TestService_RefreshIndex(t *testing.T) {
 	err = s.RefreshIndex(ctx)
 	r.NoError(err)
 
-	hits, _, err = s.Search(ctx, "games", 5)
+	hits, _, err = s.Search(ctx, "games", 5, nil)
 	r.NoError(err)
 	r.Empty(hits)
 }
 
+func TestService_IncrementalRefresh(t *testing.T) {
+	r := require.New(t)
+	ctx := t.Context()
+
+	root, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	rcloneStub := &rcloneStub{
+		GetAllFilesFn: func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
+			return slices.Values([]rclone.DirEntry{
+				newDirEntry("/games/"),
+				newDirEntryWithMetadata("/games/1.sav", 10, 100),
+				newDirEntryWithMetadata("/games/2.sav", 10, 100),
+			}), nil
+		},
+	}
+	newService := func() *Service {
+		s, err := NewService(rcloneStub, root, Options{})
+		r.NoError(err)
+		err = s.Start()
+		r.NoError(err)
+		return s
+	}
+	getFiles := func(s *Service) []Hit {
+		files, err := s.GetFiles(ctx, func(string) bool { return true })
+		r.NoError(err)
+		slices.SortFunc(files, func(a, b Hit) int { return strings.Compare(a.Path, b.Path) })
+		return files
+	}
+	getIndex := func(s *Service) *layeredIndex {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		return s.indexes[""].Index
+	}
+
+	s := newService()
+
+	rcloneStub.GetAllFilesFn = func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
+		return slices.Values([]rclone.DirEntry{
+			newDirEntry("/games/"),
+			newDirEntryWithMetadata("/games/1.sav", 20, 200),
+			newDirEntryWithMetadata("/games/3.sav", 10, 100),
+		}), nil
+	}
+	err = s.RefreshIndex(ctx)
+	r.NoError(err)
+	r.Equal(
+		[]Hit{
+			{Path: "/games/1.sav", Size: 20, ModTime: 200},
+			{Path: "/games/3.sav", Size: 10, ModTime: 100},
+		},
+		getFiles(s),
+	)
+	// The updated index has been saved and loaded from disk.
+	r.EqualValues(3, getIndex(s).base.count())
+	r.Empty(getIndex(s).overlay.Entries)
+
+	hits, _, err := s.Search(ctx, "sav", 10, nil)
+	r.NoError(err)
+	r.Equal(
+		[]Hit{
+			{Path: "/games/1.sav", Size: 20, ModTime: 200, Score: 1},
+			{Path: "/games/3.sav", Size: 10, ModTime: 100, Score: 1},
+		},
+		hits,
+	)
+
+	// Directories listed before an error are applied, the broken one is not changed.
+	rcloneStub.GetAllFilesFn = func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
+		return slices.Values([]rclone.DirEntry{
+			newDirEntry("/games/"),
+			newDirEntryWithMetadata("/games/4.sav", 10, 100),
+			newDirEntry("/saves/"),
+			newDirEntryWithMetadata("/saves/1.sav", 10, 100),
+		}), nil
+	}
+	rcloneStub.DirErrs = map[string]error{"/saves/": errors.New("unexpected EOF")}
+
+	err = s.RefreshIndex(ctx)
+	r.ErrorContains(err, "unexpected EOF")
+	r.Equal(
+		[]Hit{
+			{Path: "/games/4.sav", Size: 10, ModTime: 100},
+		},
+		getFiles(s),
+	)
+
+	// Changes are saved on disk.
+	err = s.Shutdown(ctx)
+	r.NoError(err)
+
+	rcloneStub.DirErrs = nil
+	s = newService()
+	defer func() {
+		err := s.Shutdown(t.Context())
+		r.NoError(err)
+	}()
+	r.Len(getFiles(s), 1)
+}
+
+func TestService_SkipUnchangedDirs(t *testing.T) {
+	r := require.New(t)
+	ctx := t.Context()
+
+	root, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	rcloneStub := &rcloneStub{
+		GetAllFilesFn: func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
+			return slices.Values([]rclone.DirEntry{
+				newDirEntryWithMetadata("/games/", 0, 100),
+				newDirEntryWithMetadata("/games/1.sav", 10, 100),
+				newDirEntryWithMetadata("/photos/", 0, 100),
+				newDirEntryWithMetadata("/photos/2024/", 0, 100),
+				newDirEntryWithMetadata("/photos/2024/1.jpg", 10, 100),
+			}), nil
+		},
+	}
+	s, err := NewService(rcloneStub, root, Options{})
+	r.NoError(err)
+	err = s.Start()
+	r.NoError(err)
+	defer func() {
+		err := s.Shutdown(t.Context())
+		r.NoError(err)
+	}()
+
+	getFiles := func() (res []string) {
+		files, err := s.GetFiles(ctx, func(string) bool { return true })
+		r.NoError(err)
+		for _, file := range files {
+			res = append(res, file.Path)
+		}
+		slices.Sort(res)
+		return res
+	}
+
+	// Mod time of "/games/" is changed, "/photos/2024/" is changed deep in the unchanged subtree.
+	rcloneStub.GetAllFilesFn = func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
+		return slices.Values([]rclone.DirEntry{
+			newDirEntryWithMetadata("/games/", 0, 200),
+			newDirEntryWithMetadata("/games/1.sav", 10, 100),
+			newDirEntryWithMetadata("/games/2.sav", 10, 200),
+			newDirEntryWithMetadata("/photos/", 0, 100),
+			newDirEntryWithMetadata("/photos/2024/", 0, 200),
+			newDirEntryWithMetadata("/photos/2024/1.jpg", 10, 100),
+			newDirEntryWithMetadata("/photos/2024/2.jpg", 10, 200),
+		}), nil
+	}
+	rcloneStub.getListedDirs()
+
+	err = s.refreshIndex(ctx, "", false)
+	r.NoError(err)
+	r.Equal([]string{"/", "/games/"}, rcloneStub.getListedDirs())
+	r.Equal([]string{"/games/1.sav", "/games/2.sav", "/photos/2024/1.jpg"}, getFiles())
+
+	// Mod times of subdirectories are not updated by dir changes: their content is unknown.
+	entries := []rclone.DirEntry{
+		newDirEntryWithMetadata("/games/", 0, 200),
+		newDirEntryWithMetadata("/music/", 0, 300),
+		newDirEntryWithMetadata("/photos/", 0, 300),
+	}
+	s.ApplyDirChange(rclone.DirChange{Dir: "/", Entries: entries})
+
+	rcloneStub.GetAllFilesFn = func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
+		return slices.Values([]rclone.DirEntry{
+			newDirEntryWithMetadata("/games/", 0, 200),
+			newDirEntryWithMetadata("/games/1.sav", 10, 100),
+			newDirEntryWithMetadata("/games/2.sav", 10, 200),
+			newDirEntryWithMetadata("/music/", 0, 300),
+			newDirEntryWithMetadata("/music/1.mp3", 10, 300),
+			newDirEntryWithMetadata("/photos/", 0, 300),
+			newDirEntryWithMetadata("/photos/2024/", 0, 200),
+			newDirEntryWithMetadata("/photos/2024/1.jpg", 10, 100),
+			newDirEntryWithMetadata("/photos/2024/2.jpg", 10, 200),
+		}), nil
+	}
+	err = s.refreshIndex(ctx, "", false)
+	r.NoError(err)
+	r.Equal([]string{"/", "/music/", "/photos/", "/photos/2024/"}, rcloneStub.getListedDirs())
+	r.Equal(
+		[]string{"/games/1.sav", "/games/2.sav", "/music/1.mp3", "/photos/2024/1.jpg", "/photos/2024/2.jpg"},
+		getFiles(),
+	)
+
+	// Full refreshes don't skip directories.
+	err = s.RefreshIndex(ctx)
+	r.NoError(err)
+	r.Equal([]string{"/", "/games/", "/music/", "/photos/", "/photos/2024/"}, rcloneStub.getListedDirs())
+
+	// Refreshes become full after an error.
+	rcloneStub.DirErrs = map[string]error{"/": errors.New("unexpected EOF")}
+	err = s.refreshIndex(ctx, "", false)
+	r.ErrorContains(err, "unexpected EOF")
+	rcloneStub.DirErrs = nil
+	rcloneStub.getListedDirs()
+
+	err = s.refreshIndex(ctx, "", false)
+	r.NoError(err)
+	r.Equal([]string{"/", "/games/", "/music/", "/photos/", "/photos/2024/"}, rcloneStub.getListedDirs())
+}
+
+func TestService_MigrateLegacyIndex(t *testing.T) {
+	r := require.New(t)
+	ctx := t.Context()
+
+	root, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	rcloneStub := &rcloneStub{
+		GetAllFilesFn: func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
+			return nil, errors.New("index must be loaded from disk")
+		},
+	}
+	s, err := NewService(rcloneStub, root, Options{})
+	r.NoError(err)
+
+	createdAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = s.saveToCache(getLegacyIndexFilename(""), legacySearchIndex{
+		Index: newPrefixIndex(slices.Values([]rclone.DirEntry{
+			newDirEntry("/games/"),
+			newDirEntryWithMetadata("/games/1.sav", 10, 100),
+		}), s.minPrefixLen, s.maxPrefixLen),
+		CreatedAt: createdAt,
+	})
+	r.NoError(err)
+
+	err = s.Start()
+	r.NoError(err)
+	defer func() {
+		err := s.Shutdown(t.Context())
+		r.NoError(err)
+	}()
+
+	hits, _, err := s.Search(ctx, "sav", 10, nil)
+	r.NoError(err)
+	r.Equal([]Hit{{Path: "/games/1.sav", Size: 10, ModTime: 100, Score: 1}}, hits)
+
+	s.mu.RLock()
+	r.True(createdAt.Equal(s.indexes[""].CreatedAt))
+	s.mu.RUnlock()
+
+	_, err = root.Stat("search/search_index.bin")
+	r.NoError(err)
+	_, err = root.Stat("search/search_index.json.gz")
+	r.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestService_MultipleRemotes(t *testing.T) {
+	r := require.New(t)
+	ctx := t.Context()
+
+	root, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	remoteFiles := map[string][]rclone.DirEntry{
+		"photos": {
+			newDirEntry("/photos/"),
+			newDirEntry("/photos/games/"),
+			newDirEntry("/photos/games/1.jpeg"),
+		},
+		"docs": {
+			newDirEntry("/docs/"),
+			newDirEntry("/docs/gaming.txt"),
+		},
+	}
+	rcloneStub := &rcloneStub{
+		Remotes: []string{"photos", "docs"},
+		GetAllFilesFn: func(_ context.Context, remote string) (iter.Seq[rclone.DirEntry], error) {
+			return slices.Values(remoteFiles[remote]), nil
+		},
+	}
+	s, err := NewService(rcloneStub, root, Options{})
+	r.NoError(err)
+	err = s.Start()
+	r.NoError(err)
+	defer func() {
+		err := s.Shutdown(t.Context())
+		r.NoError(err)
+	}()
+
+	for _, filename := range []string{"search_index.photos.bin", "search_index.docs.bin"} {
+		_, err := root.Stat("search/" + filename)
+		r.NoError(err)
+	}
+
+	hits, total, err := s.Search(ctx, "games", 5, nil)
+	r.NoError(err)
+	r.Equal(2, total)
+	r.Equal(
+		[]Hit{
+			{Path: "/photos/games/", IsDir: true, Score: 3},
+			{Path: "/docs/gaming.txt", IsDir: false, Score: 1},
+		},
+		hits,
+	)
+
+	hits, _, err = s.Search(ctx, "games", 1, nil)
+	r.NoError(err)
+	r.Equal([]Hit{{Path: "/photos/games/", IsDir: true, Score: 3}}, hits)
+
+	files, err := s.GetFiles(ctx, func(string) bool { return true })
+	r.NoError(err)
+	r.ElementsMatch(
+		[]Hit{
+			{Path: "/photos/games/1.jpeg"},
+			{Path: "/docs/gaming.txt"},
+		},
+		files,
+	)
+}
+
+func TestService_ApplyDirChange(t *testing.T) {
+	r := require.New(t)
+	ctx := t.Context()
+
+	root, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	remoteFiles := map[string][]rclone.DirEntry{
+		"photos": {
+			newDirEntry("/photos/"),
+			newDirEntry("/photos/games/"),
+			newDirEntry("/photos/games/1.jpeg"),
+		},
+		"docs": {
+			newDirEntry("/docs/"),
+			newDirEntry("/docs/gaming.txt"),
+		},
+	}
+	rcloneStub := &rcloneStub{
+		Remotes: []string{"photos", "docs"},
+		GetAllFilesFn: func(_ context.Context, remote string) (iter.Seq[rclone.DirEntry], error) {
+			return slices.Values(remoteFiles[remote]), nil
+		},
+	}
+	newService := func() *Service {
+		s, err := NewService(rcloneStub, root, Options{})
+		r.NoError(err)
+		err = s.Start()
+		r.NoError(err)
+		return s
+	}
+	search := func(s *Service, search string) (res []string) {
+		hits, _, err := s.Search(ctx, search, 10, nil)
+		r.NoError(err)
+		for _, hit := range hits {
+			res = append(res, hit.Path)
+		}
+		return res
+	}
+
+	s := newService()
+	r.Equal([]string{"/photos/games/", "/docs/gaming.txt"}, search(s, "games"))
+
+	s.ApplyDirChange(rclone.DirChange{
+		Dir: "/docs/",
+		Entries: []rclone.DirEntry{
+			newDirEntry("/docs/games.txt"),
+		},
+	})
+	s.ApplyDirChange(rclone.DirChange{
+		Dir:       "/photos/games/",
+		IsDeleted: true,
+	})
+	r.Equal([]string{"/docs/games.txt"}, search(s, "games"))
+
+	// Changes are saved on shutdown.
+	err = s.Shutdown(ctx)
+	r.NoError(err)
+
+	s = newService()
+	defer func() {
+		err := s.Shutdown(t.Context())
+		r.NoError(err)
+	}()
+	r.Equal([]string{"/docs/games.txt"}, search(s, "games"))
+}
+
+func TestService_ContentSearch(t *testing.T) {
+	r := require.New(t)
+	ctx := t.Context()
+
+	root, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	contents := map[string]string{
+		"/notes/todo.txt":  "Buy milk.\nCall Bob about the Beautiful World project",
+		"/notes/ideas.md":  "A beautiful garden, a beautiful house",
+		"/notes/large.txt": "beautiful",
+		"/photos/1.jpeg":   "beautiful",
+	}
+	newEntries := func() []rclone.DirEntry {
+		return []rclone.DirEntry{
+			newDirEntry("/notes/"),
+			newDirEntryWithMetadata("/notes/todo.txt", int64(len(contents["/notes/todo.txt"])), 1),
+			newDirEntryWithMetadata("/notes/ideas.md", int64(len(contents["/notes/ideas.md"])), 1),
+			newDirEntryWithMetadata("/notes/large.txt", 1<<20, 1),
+			newDirEntry("/photos/"),
+			newDirEntryWithMetadata("/photos/1.jpeg", 9, 1),
+		}
+	}
+	rcloneStub := &rcloneStub{
+		GetAllFilesFn: func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
+			return slices.Values(newEntries()), nil
+		},
+		OpenFileFn: func(_ context.Context, id rview.FileID) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(contents[id.GetPath()])), nil
+		},
+	}
+	opts := Options{
+		ContentIndex:       true,
+		ContentMaxFileSize: 1 << 10,
+	}
+
+	startService := func() *Service {
+		s, err := NewService(rcloneStub, root, opts)
+		r.NoError(err)
+		r.NoError(s.Start())
+		return s
+	}
+	waitForFiles := func(s *Service, count int) {
+		r.Eventually(func() bool {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return len(s.contentIndexes[""].Files) == count
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	s := startService()
+	waitForFiles(s, 2)
+
+	hits, total, err := s.Search(ctx, "content:beautiful", 10, nil)
+	r.NoError(err)
+	r.Equal(2, total)
+	r.Equal(
+		[]Hit{
+			{
+				Path: "/notes/ideas.md", Size: 37, ModTime: 1, Score: 2,
+				Snippet: &Snippet{Text: "A beautiful garden, a beautiful house", Matches: [][2]int{{2, 11}, {22, 31}}},
+			},
+			{
+				Path: "/notes/todo.txt", Size: 52, ModTime: 1, Score: 1,
+				Snippet: &Snippet{Text: "Buy milk. Call Bob about the Beautiful World project", Matches: [][2]int{{29, 38}}},
+			},
+		},
+		hits,
+	)
+
+	// Content and path operators can be combined.
+	hits, total, err = s.Search(ctx, `content:"beautiful world" notes`, 10, nil)
+	r.NoError(err)
+	r.Equal(1, total)
+	r.Equal("/notes/todo.txt", hits[0].Path)
+
+	hits, _, err = s.Search(ctx, `content:beautiful -todo`, 10, nil)
+	r.NoError(err)
+	r.Len(hits, 1)
+	r.Equal("/notes/ideas.md", hits[0].Path)
+
+	hits, _, err = s.Search(ctx, `content:beautiful`, 10, func(path string, _ bool) bool { return path != "/notes/ideas.md" })
+	r.NoError(err)
+	r.Len(hits, 1)
+	r.Equal("/notes/todo.txt", hits[0].Path)
+
+	// Modified and deleted files.
+	contents["/notes/todo.txt"] = "Nothing to do"
+	newEntries = func() []rclone.DirEntry {
+		return []rclone.DirEntry{
+			newDirEntry("/notes/"),
+			newDirEntryWithMetadata("/notes/todo.txt", int64(len(contents["/notes/todo.txt"])), 2),
+		}
+	}
+	r.NoError(s.RefreshIndex(ctx))
+	r.Eventually(func() bool {
+		hits, _, err := s.Search(ctx, "content:nothing", 10, nil)
+		return err == nil && len(hits) == 1
+	}, time.Second, 10*time.Millisecond)
+	waitForFiles(s, 1)
+
+	// The index is loaded from the file.
+	r.NoError(s.Shutdown(ctx))
+	delete(contents, "/notes/todo.txt")
+
+	s = startService()
+	defer func() {
+		err := s.Shutdown(t.Context())
+		r.NoError(err)
+	}()
+	waitForFiles(s, 1)
+	hits, _, err = s.Search(ctx, "content:nothing", 10, nil)
+	r.NoError(err)
+	r.Equal([]Hit{
+		{
+			Path: "/notes/todo.txt", Size: 13, ModTime: 2, Score: 1,
+			Snippet: &Snippet{Text: "Nothing to do", Matches: [][2]int{{0, 7}}},
+		},
+	}, hits)
+
+	t.Run("disabled", func(t *testing.T) {
+		r := require.New(t)
+
+		root, err := os.OpenRoot(t.TempDir())
+		r.NoError(err)
+
+		s, err := NewService(rcloneStub, root, Options{})
+		r.NoError(err)
+		r.NoError(s.Start())
+		defer func() {
+			r.NoError(s.Shutdown(t.Context()))
+		}()
+
+		_, _, err = s.Search(t.Context(), "content:nothing", 10, nil)
+		r.ErrorIs(err, ErrContentSearchDisabled)
+	})
+}
+
+func TestService_GeoIndex(t *testing.T) {
+	r := require.New(t)
+	ctx := t.Context()
+
+	root, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+
+	eiffelTower := metadata.GPS{Latitude: 48.8584, Longitude: 2.2945}
+	bigBen := metadata.GPS{Latitude: 51.5007, Longitude: -0.1246}
+
+	newEntries := func() []rclone.DirEntry {
+		return []rclone.DirEntry{
+			newDirEntry("/photos/"),
+			newDirEntryWithMetadata("/photos/paris.jpg", 100, 1),
+			newDirEntryWithMetadata("/photos/no-gps.jpg", 100, 1),
+			newDirEntryWithMetadata("/photos/broken.jpg", 100, 1),
+			newDirEntryWithMetadata("/photos/notes.txt", 100, 1),
+		}
+	}
+	rcloneStub := &rcloneStub{
+		GetAllFilesFn: func(context.Context, string) (iter.Seq[rclone.DirEntry], error) {
+			return slices.Values(newEntries()), nil
+		},
+	}
+	metadataStub := &metadataServiceStub{
+		locations: map[string]metadata.GPS{
+			"/photos/paris.jpg": eiffelTower,
+		},
+	}
+
+	startService := func() *Service {
+		s, err := NewService(rcloneStub, root, Options{Metadata: metadataStub})
+		r.NoError(err)
+		r.NoError(s.Start())
+		return s
+	}
+	waitForFiles := func(s *Service, count int) {
+		r.Eventually(func() bool {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return len(s.geoIndexes[""].Files) == count
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	s := startService()
+	waitForFiles(s, 3)
+	r.Equal(
+		map[rview.FileID]metadata.GPS{
+			rview.NewFileID("/photos/paris.jpg", 1, 100): eiffelTower,
+		},
+		s.GetLocations(),
+	)
+	r.ElementsMatch([]string{"/photos/paris.jpg", "/photos/no-gps.jpg", "/photos/broken.jpg"}, metadataStub.getCalls())
+
+	// Only new and modified files are processed.
+	metadataStub.resetCalls()
+	metadataStub.setLocation("/photos/london.jpg", bigBen)
+	newEntries = func() []rclone.DirEntry {
+		return []rclone.DirEntry{
+			newDirEntry("/photos/"),
+			newDirEntryWithMetadata("/photos/paris.jpg", 200, 2),
+			newDirEntryWithMetadata("/photos/no-gps.jpg", 100, 1),
+			newDirEntryWithMetadata("/photos/london.jpg", 100, 1),
+		}
+	}
+	r.NoError(s.RefreshIndex(ctx))
+	r.Eventually(func() bool {
+		return len(s.GetLocations()) == 2 && len(metadataStub.getCalls()) == 2
+	}, time.Second, 10*time.Millisecond)
+	waitForFiles(s, 3)
+
+	wantLocations := map[rview.FileID]metadata.GPS{
+		rview.NewFileID("/photos/paris.jpg", 2, 200):  eiffelTower,
+		rview.NewFileID("/photos/london.jpg", 1, 100): bigBen,
+	}
+	r.Equal(wantLocations, s.GetLocations())
+	r.ElementsMatch([]string{"/photos/paris.jpg", "/photos/london.jpg"}, metadataStub.getCalls())
+
+	// The index is loaded from the file.
+	r.NoError(s.Shutdown(ctx))
+	metadataStub.resetCalls()
+
+	s = startService()
+	defer func() {
+		r.NoError(s.Shutdown(t.Context()))
+	}()
+	r.Equal(wantLocations, s.GetLocations())
+	r.Never(func() bool {
+		return len(metadataStub.getCalls()) > 0
+	}, 100*time.Millisecond, 10*time.Millisecond)
+
+	t.Run("disabled", func(t *testing.T) {
+		r := require.New(t)
+
+		root, err := os.OpenRoot(t.TempDir())
+		r.NoError(err)
+
+		s, err := NewService(rcloneStub, root, Options{})
+		r.NoError(err)
+		r.NoError(s.Start())
+		defer func() {
+			r.NoError(s.Shutdown(t.Context()))
+		}()
+
+		r.Nil(s.GetLocations())
+	})
+}
+
+type metadataServiceStub struct {
+	mu        sync.Mutex
+	locations map[string]metadata.GPS
+	calls     []string
+}
+
+func (*metadataServiceStub) CanExtractMetadata(id rview.FileID) bool {
+	return rview.GetFileType(id.GetExt()) == rview.FileTypeImage
+}
+
+func (s *metadataServiceStub) GetMetadata(_ context.Context, id rview.FileID) (metadata.Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, id.GetPath())
+	if id.GetPath() == "/photos/broken.jpg" {
+		return metadata.Metadata{}, errors.New("broken file")
+	}
+	if gps, ok := s.locations[id.GetPath()]; ok {
+		return metadata.Metadata{GPS: &gps}, nil
+	}
+	return metadata.Metadata{}, nil
+}
+
+func (s *metadataServiceStub) setLocation(path string, gps metadata.GPS) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locations[path] = gps
+}
+
+func (s *metadataServiceStub) getCalls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.calls)
+}
+
+func (s *metadataServiceStub) resetCalls() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = nil
+}
+
 type rcloneStub struct {
-	GetAllFilesFn func(context.Context) (iter.Seq[rclone.DirEntry], error)
+	Remotes []string
+	// GetAllFilesFn returns all files of the remote. Entries of directories are taken from it too.
+	GetAllFilesFn func(context.Context, string) (iter.Seq[rclone.DirEntry], error)
+	// DirErrs are returned after entries of directories, like after a broken response.
+	DirErrs    map[string]error
+	OpenFileFn func(context.Context, rview.FileID) (io.ReadCloser, error)
+
+	mu         sync.Mutex
+	listedDirs []string
 }
 
-func (s rcloneStub) GetAllFiles(ctx context.Context) (iter.Seq[rclone.DirEntry], error) {
-	return s.GetAllFilesFn(ctx)
+func (s *rcloneStub) GetRemotes() []string {
+	if len(s.Remotes) == 0 {
+		return []string{""}
+	}
+	return s.Remotes
+}
+
+func (s *rcloneStub) GetAllFiles(ctx context.Context, remote string) iter.Seq2[rclone.DirEntry, error] {
+	return func(yield func(rclone.DirEntry, error) bool) {
+		entries, err := s.GetAllFilesFn(ctx, remote)
+		if err != nil {
+			yield(rclone.DirEntry{}, err)
+			return
+		}
+		for entry := range entries {
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (s *rcloneStub) GetDirEntries(ctx context.Context, dir string) iter.Seq2[rclone.DirEntry, error] {
+	return func(yield func(rclone.DirEntry, error) bool) {
+		s.mu.Lock()
+		s.listedDirs = append(s.listedDirs, dir)
+		s.mu.Unlock()
+
+		var remote string
+		if len(s.Remotes) > 0 {
+			remote, _, _ = strings.Cut(strings.TrimPrefix(dir, "/"), "/")
+		}
+		entries, err := s.GetAllFilesFn(ctx, remote)
+		if err != nil {
+			yield(rclone.DirEntry{}, err)
+			return
+		}
+		for entry := range entries {
+			name, ok := strings.CutPrefix(entry.URL, dir)
+			if !ok || name == "" || strings.Contains(strings.TrimSuffix(name, "/"), "/") {
+				continue
+			}
+			if !yield(entry, nil) {
+				return
+			}
+		}
+		if err := s.DirErrs[dir]; err != nil {
+			yield(rclone.DirEntry{}, err)
+		}
+	}
+}
+
+// getListedDirs returns directories listed since the previous call.
+func (s *rcloneStub) getListedDirs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.listedDirs
+	s.listedDirs = nil
+	slices.Sort(res)
+	return res
+}
+
+func (s *rcloneStub) OpenFile(ctx context.Context, id rview.FileID) (io.ReadCloser, error) {
+	return s.OpenFileFn(ctx, id)
 }
 
 // TestService_GenerateDocs generates an output in Markdown format that is used in documentation for search.
@@ -95,6 +854,10 @@ func TestGenerateDocs(t *testing.T) {
 			search: `caterpillar`,
 			desc:   "search for filepaths that have the same prefixes as `caterpillar` (`cat`, `cate`, `cater`, ...)",
 		},
+		{
+			search: `~dgo`,
+			desc:   "search for filepaths that have the same prefixes as `dgo` or prefixes with typos, like `dog`",
+		},
 		{
 			search: `"caterpillar"`,
 			desc:   "search for filepaths that have exactly `caterpillar`",
@@ -122,9 +885,9 @@ func TestGenerateDocs(t *testing.T) {
 	}
 
 	rclone := &rcloneStub{
-		GetAllFilesFn: func(ctx context.Context) (iter.Seq[rclone.DirEntry], error) { return slices.Values(entries), nil },
+		GetAllFilesFn: func(context.Context, string) (iter.Seq[rclone.DirEntry], error) { return slices.Values(entries), nil },
 	}
-	s, err := NewService(rclone, root)
+	s, err := NewService(rclone, root, Options{})
 	r.NoError(err)
 	err = s.Start()
 	r.NoError(err)
@@ -142,7 +905,7 @@ func TestGenerateDocs(t *testing.T) {
 
 	fmt.Fprint(buf, "\n**Search Requests:**\n\n")
 	for _, tt := range tests {
-		hits, _, err := s.Search(t.Context(), tt.search, 10)
+		hits, _, err := s.Search(t.Context(), tt.search, 10, nil)
 		r.NoError(err)
 
 		fmt.Fprintf(buf, "- `%s` - %s. Results:\n", tt.search, tt.desc)
@@ -0,0 +1,125 @@
+package share
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxUnlockAttempts is the max number of password attempts per link and per client
+	// within unlockAttemptsWindow.
+	maxUnlockAttempts    = 10
+	unlockAttemptsWindow = time.Minute
+
+	// After a few consecutive failures, every failed attempt blocks the link and the client
+	// for a delay that doubles with every failure. The first failures are not delayed to not
+	// annoy users who mistype the password.
+	allowedUnlockFailures = 3
+	minUnlockFailureDelay = time.Second
+	maxUnlockFailureDelay = time.Minute
+)
+
+// unlockLimiter limits password attempts. Passwords are checked with a slow hash function,
+// so the limits protect both against password guessing and against CPU exhaustion.
+type unlockLimiter struct {
+	mu          sync.Mutex
+	attempts    map[string]*unlockAttempts
+	lastCleanup time.Time
+}
+
+type unlockAttempts struct {
+	windowStart  time.Time
+	count        int
+	failures     int
+	blockedUntil time.Time
+}
+
+func newUnlockLimiter() *unlockLimiter {
+	return &unlockLimiter{
+		attempts: make(map[string]*unlockAttempts),
+	}
+}
+
+// Allow reports whether an attempt can be made for all keys. The attempt is counted
+// only if it is allowed.
+func (l *unlockLimiter) Allow(now time.Time, keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cleanupLocked(now)
+
+	for _, key := range keys {
+		a := l.attempts[key]
+		if a == nil {
+			continue
+		}
+		if now.Before(a.blockedUntil) {
+			return false
+		}
+		if now.Sub(a.windowStart) < unlockAttemptsWindow && a.count >= maxUnlockAttempts {
+			return false
+		}
+	}
+
+	for _, key := range keys {
+		a := l.attempts[key]
+		if a == nil {
+			a = &unlockAttempts{}
+			l.attempts[key] = a
+		}
+		if now.Sub(a.windowStart) >= unlockAttemptsWindow {
+			a.windowStart = now
+			a.count = 0
+		}
+		a.count++
+	}
+	return true
+}
+
+// Fail blocks the keys after a failed attempt.
+func (l *unlockLimiter) Fail(now time.Time, keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		a := l.attempts[key]
+		if a == nil {
+			continue
+		}
+		a.failures++
+		if n := a.failures - allowedUnlockFailures; n > 0 {
+			delay := min(minUnlockFailureDelay<<min(n-1, 6), maxUnlockFailureDelay)
+			a.blockedUntil = now.Add(delay)
+		}
+	}
+}
+
+// Reset resets consecutive failures of the keys after a successful attempt.
+func (l *unlockLimiter) Reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if a := l.attempts[key]; a != nil {
+			a.failures = 0
+			a.blockedUntil = time.Time{}
+		}
+	}
+}
+
+// cleanupLocked removes stale entries, so the map doesn't grow with the number of clients.
+func (l *unlockLimiter) cleanupLocked(now time.Time) {
+	if now.Sub(l.lastCleanup) < unlockAttemptsWindow {
+		return
+	}
+	l.lastCleanup = now
+
+	for key, a := range l.attempts {
+		// Keep consecutive failures for a while after the last block has expired.
+		stale := now.Sub(a.windowStart) >= unlockAttemptsWindow &&
+			now.After(a.blockedUntil.Add(maxUnlockFailureDelay))
+		if stale {
+			delete(l.attempts, key)
+		}
+	}
+}
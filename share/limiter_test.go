@@ -0,0 +1,72 @@
+package share
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnlockLimiter(t *testing.T) {
+	t.Run("failures", func(t *testing.T) {
+		r := require.New(t)
+
+		l := newUnlockLimiter()
+		now := time.Now()
+
+		// The first failures are not delayed.
+		for range allowedUnlockFailures {
+			r.True(l.Allow(now, "link:a", "ip:1"))
+			l.Fail(now, "link:a", "ip:1")
+		}
+		r.True(l.Allow(now, "link:a", "ip:1"))
+		l.Fail(now, "link:a", "ip:1")
+
+		// Both the link and the client are blocked.
+		r.False(l.Allow(now, "link:a", "ip:2"))
+		r.False(l.Allow(now, "link:b", "ip:1"))
+		r.True(l.Allow(now, "link:b", "ip:2"))
+
+		now = now.Add(minUnlockFailureDelay)
+		r.True(l.Allow(now, "link:a", "ip:1"))
+		l.Fail(now, "link:a", "ip:1")
+
+		// The delay doubles.
+		r.False(l.Allow(now.Add(minUnlockFailureDelay), "link:a", "ip:1"))
+		now = now.Add(2 * minUnlockFailureDelay)
+		r.True(l.Allow(now, "link:a", "ip:1"))
+
+		// A successful attempt resets failures.
+		l.Reset("link:a", "ip:1")
+		l.Fail(now, "link:a", "ip:1")
+		r.True(l.Allow(now, "link:a", "ip:1"))
+	})
+
+	t.Run("attempts", func(t *testing.T) {
+		r := require.New(t)
+
+		l := newUnlockLimiter()
+		now := time.Now()
+
+		for i := range maxUnlockAttempts {
+			r.True(l.Allow(now, "link:a", "ip:"+string(rune('a'+i))))
+		}
+		r.False(l.Allow(now, "link:a", "ip:z"))
+
+		now = now.Add(unlockAttemptsWindow)
+		r.True(l.Allow(now, "link:a", "ip:z"))
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		r := require.New(t)
+
+		l := newUnlockLimiter()
+		now := time.Now()
+
+		r.True(l.Allow(now, "link:a", "ip:1"))
+		r.Len(l.attempts, 2)
+
+		l.Allow(now.Add(unlockAttemptsWindow + maxUnlockFailureDelay + time.Second))
+		r.Empty(l.attempts)
+	})
+}
@@ -0,0 +1,41 @@
+package share
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNoopShareService = errors.New("noop share service")
+
+// NoopService is used when share links are disabled.
+type NoopService struct{}
+
+func NewNoopService() *NoopService {
+	return &NoopService{}
+}
+
+func (NoopService) CreateLink(CreateOptions) (string, Link, error) {
+	return "", Link{}, ErrNoopShareService
+}
+
+func (NoopService) GetLink(string) (Link, error) {
+	return Link{}, ErrNoopShareService
+}
+
+func (NoopService) Unlock(Link, string, string) (string, error) {
+	return "", ErrNoopShareService
+}
+
+func (NoopService) IsUnlocked(Link, string) bool {
+	return false
+}
+
+func (NoopService) AddDownload(Link) {}
+
+func (NoopService) GetLinks(string) []LinkInfo {
+	return nil
+}
+
+func (NoopService) Shutdown(context.Context) error {
+	return nil
+}
@@ -0,0 +1,374 @@
+package share
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	pkgPath "path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ShoshinNikita/rview/auth"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/pkg/signed"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+var (
+	ErrInvalidLink     = errors.New("invalid share link")
+	ErrLinkExpired     = errors.New("share link is expired")
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrInvalidOptions  = errors.New("invalid options")
+	ErrTooManyAttempts = errors.New("too many attempts")
+)
+
+const (
+	// MaxTTL is the maximum lifetime of a link.
+	MaxTTL = 365 * 24 * time.Hour
+
+	// downloadsSaveDelay is the delay before download counters are saved on disk.
+	downloadsSaveDelay = 10 * time.Second
+)
+
+// Link is a public link to a file or a directory.
+type Link struct {
+	ID string
+	// Path is the path of a shared file or directory. Paths of directories end with '/'.
+	Path string
+	// ModTime and Size are set only for files: links to files become invalid after
+	// the files are modified.
+	ModTime int64
+	Size    int64
+
+	ExpiresAt   time.Time
+	HasPassword bool
+	// CreatedBy is the user who created the link. Links give access only to files available
+	// to their creators.
+	CreatedBy string
+}
+
+func (l Link) IsDir() bool {
+	return strings.HasSuffix(l.Path, "/")
+}
+
+// FileID returns the id of a shared file. It must be called only for links to files.
+func (l Link) FileID() rview.FileID {
+	return rview.NewFileID(l.Path, l.ModTime, l.Size)
+}
+
+// Contains reports whether the path is the shared file or belongs to the shared directory.
+func (l Link) Contains(path string) bool {
+	if !l.IsDir() {
+		return path == l.Path
+	}
+	return strings.HasPrefix(path, l.Path) || path+"/" == l.Path
+}
+
+// LinkInfo contains info about a link that is not a part of the token.
+type LinkInfo struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Downloads int       `json:"downloads"`
+	// PasswordHash is set only for links protected with a password, see [auth.HashPassword].
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+type CreateOptions struct {
+	// Path is the path of a file or a directory. Paths of directories must end with '/'.
+	Path    string
+	ModTime int64
+	Size    int64
+	TTL     time.Duration
+	// Password is optional.
+	Password  string
+	CreatedBy string
+}
+
+// Service creates and checks public share links. Links are tokens signed with a secret key,
+// so they survive restarts and don't require storage. However, password hashes and download
+// counters are stored in a small file, links protected with a password don't work without it.
+type Service struct {
+	dir    *os.Root
+	signer *signed.Signer
+
+	mu      sync.Mutex
+	links   map[string]LinkInfo
+	limiter *unlockLimiter
+
+	// saveTimer is set when download counters have to be saved, see [Service.AddDownload].
+	saveTimer *time.Timer
+}
+
+// linkData is the payload of a token.
+type linkData struct {
+	ID          string `json:"id"`
+	Path        string `json:"path"`
+	ModTime     int64  `json:"mod_time,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	ExpiresAt   int64  `json:"expires_at"`
+	HasPassword bool   `json:"has_password,omitempty"`
+	CreatedBy   string `json:"created_by,omitempty"`
+}
+
+const linksFilename = "links.json"
+
+func NewService(dirRoot *os.Root) (*Service, error) {
+	err := dirRoot.Mkdir("share", 0700)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("couldn't create 'share' subdirectory: %w", err)
+	}
+	shareDirRoot, err := dirRoot.OpenRoot("share")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open root: %w", err)
+	}
+
+	secret, err := signed.LoadOrCreateSecret(shareDirRoot, "secret.key")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't prepare secret key: %w", err)
+	}
+
+	links := make(map[string]LinkInfo)
+	data, err := shareDirRoot.ReadFile(linksFilename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// There are no links yet.
+	case err != nil:
+		return nil, fmt.Errorf("couldn't read links: %w", err)
+	default:
+		err = json.Unmarshal(data, &links)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't unmarshal links from %q: %w", linksFilename, err)
+		}
+	}
+
+	return &Service{
+		dir:     shareDirRoot,
+		signer:  signed.NewSigner(secret),
+		limiter: newUnlockLimiter(),
+		links:   links,
+	}, nil
+}
+
+// CreateLink creates a new link. The returned token should be used in urls.
+func (s *Service) CreateLink(opts CreateOptions) (token string, _ Link, _ error) {
+	// Paths must be clean because they are used as prefixes.
+	if cleanPath := pkgPath.Clean(opts.Path); opts.Path == "/" || !strings.HasPrefix(opts.Path, "/") ||
+		(cleanPath != opts.Path && cleanPath+"/" != opts.Path) {
+
+		return "", Link{}, fmt.Errorf("%w: invalid path %q", ErrInvalidOptions, opts.Path)
+	}
+	if opts.TTL <= 0 || opts.TTL > MaxTTL {
+		return "", Link{}, fmt.Errorf("%w: ttl must be in range (0, %s]", ErrInvalidOptions, MaxTTL)
+	}
+
+	now := time.Now()
+	data := linkData{
+		ID:          rand.Text(),
+		Path:        opts.Path,
+		ExpiresAt:   now.Add(opts.TTL).Unix(),
+		HasPassword: opts.Password != "",
+		CreatedBy:   opts.CreatedBy,
+	}
+	if !strings.HasSuffix(opts.Path, "/") {
+		data.ModTime = opts.ModTime
+		data.Size = opts.Size
+	}
+
+	info := LinkInfo{
+		ID:        data.ID,
+		Path:      data.Path,
+		CreatedBy: opts.CreatedBy,
+		CreatedAt: now.UTC(),
+		ExpiresAt: time.Unix(data.ExpiresAt, 0).UTC(),
+	}
+	if opts.Password != "" {
+		var err error
+		info.PasswordHash, err = auth.HashPassword(opts.Password)
+		if err != nil {
+			return "", Link{}, fmt.Errorf("couldn't hash password: %w", err)
+		}
+	}
+
+	token, err := s.signer.EncodeToken("link", data)
+	if err != nil {
+		return "", Link{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.links[info.ID] = info
+	err = s.saveLinks()
+	if err != nil {
+		delete(s.links, info.ID)
+		return "", Link{}, err
+	}
+
+	return token, newLink(data), nil
+}
+
+// GetLink checks the token and returns the link.
+func (s *Service) GetLink(token string) (Link, error) {
+	var data linkData
+	err := s.signer.DecodeToken("link", token, &data)
+	if err != nil {
+		return Link{}, ErrInvalidLink
+	}
+	link := newLink(data)
+	if time.Now().After(link.ExpiresAt) {
+		return Link{}, ErrLinkExpired
+	}
+	return link, nil
+}
+
+func newLink(data linkData) Link {
+	return Link{
+		ID:          data.ID,
+		Path:        data.Path,
+		ModTime:     data.ModTime,
+		Size:        data.Size,
+		ExpiresAt:   time.Unix(data.ExpiresAt, 0),
+		HasPassword: data.HasPassword,
+		CreatedBy:   data.CreatedBy,
+	}
+}
+
+// Unlock checks the password of the link and returns a token that should be passed
+// to [Service.IsUnlocked]. Attempts are limited per link and per client, see [unlockLimiter].
+func (s *Service) Unlock(link Link, password, clientIP string) (unlockToken string, _ error) {
+	s.mu.Lock()
+	info, ok := s.links[link.ID]
+	s.mu.Unlock()
+
+	if !ok || info.PasswordHash == "" {
+		return "", ErrInvalidLink
+	}
+
+	now := time.Now()
+	keys := []string{"link:" + link.ID}
+	if clientIP != "" {
+		keys = append(keys, "ip:"+clientIP)
+	}
+	if !s.limiter.Allow(now, keys...) {
+		return "", ErrTooManyAttempts
+	}
+	if !auth.CheckPassword(info.PasswordHash, password) {
+		s.limiter.Fail(now, keys...)
+		return "", ErrInvalidPassword
+	}
+	s.limiter.Reset(keys...)
+
+	return s.signer.Sign("unlock", link.ID), nil
+}
+
+// IsUnlocked reports whether the link doesn't require a password or the token returned
+// by [Service.Unlock] is valid.
+func (s *Service) IsUnlocked(link Link, unlockToken string) bool {
+	if !link.HasPassword {
+		return true
+	}
+	return s.signer.Verify("unlock", link.ID, unlockToken)
+}
+
+// AddDownload increments the download counter of the link. Counters are saved on disk
+// with a delay, so popular links don't cause a write on every download.
+func (s *Service) AddDownload(link Link) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.links[link.ID]
+	if !ok {
+		// The file with links was removed, the link still works.
+		return
+	}
+	info.Downloads++
+	s.links[link.ID] = info
+
+	if s.saveTimer == nil {
+		s.saveTimer = time.AfterFunc(downloadsSaveDelay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if s.saveTimer == nil {
+				// Links have been saved by Shutdown.
+				return
+			}
+			s.saveTimer = nil
+
+			if err := s.saveLinks(); err != nil {
+				rlog.Errorf("couldn't save download counters of share links: %s", err)
+			}
+		})
+	}
+}
+
+// Shutdown saves download counters that haven't been saved yet.
+func (s *Service) Shutdown(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saveTimer == nil {
+		return nil
+	}
+	s.saveTimer.Stop()
+	s.saveTimer = nil
+
+	return s.saveLinks()
+}
+
+// GetLinks returns not expired links created by the user, the newest first.
+func (s *Service) GetLinks(username string) []LinkInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var res []LinkInfo
+	for _, info := range s.links {
+		if info.CreatedBy == username && now.Before(info.ExpiresAt) {
+			info.PasswordHash = ""
+			res = append(res, info)
+		}
+	}
+	slices.SortFunc(res, func(a, b LinkInfo) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return res
+}
+
+// saveLinks writes links to the file. Expired links are removed. It must be called
+// with locked mutex.
+func (s *Service) saveLinks() error {
+	now := time.Now()
+	for id, info := range s.links {
+		if now.After(info.ExpiresAt) {
+			delete(s.links, id)
+		}
+	}
+
+	data, err := json.Marshal(s.links)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal links: %w", err)
+	}
+
+	// Write to a temporary file first to not corrupt links on failure.
+	const tmpFilename = linksFilename + ".tmp"
+	err = s.dir.WriteFile(tmpFilename, data, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't write links: %w", err)
+	}
+	err = s.dir.Rename(tmpFilename, linksFilename)
+	if err != nil {
+		return fmt.Errorf("couldn't rename file with links: %w", err)
+	}
+	return nil
+}
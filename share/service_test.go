@@ -0,0 +1,179 @@
+package share
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T, dir string) *Service {
+	dirRoot, err := os.OpenRoot(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = dirRoot.Close() })
+
+	s, err := NewService(dirRoot)
+	require.NoError(t, err)
+	return s
+}
+
+func TestService(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestService(t, dir)
+
+	t.Run("file", func(t *testing.T) {
+		r := require.New(t)
+
+		token, link, err := s.CreateLink(CreateOptions{
+			Path: "/photos/a.jpg", ModTime: 1700000000, Size: 123, TTL: time.Hour, CreatedBy: "alice",
+		})
+		r.NoError(err)
+		r.False(link.IsDir())
+		r.False(link.HasPassword)
+		r.Equal("alice", link.CreatedBy)
+		r.Equal("/photos/a.jpg", link.FileID().GetPath())
+		r.Equal(int64(123), link.FileID().GetSize())
+		r.True(link.Contains("/photos/a.jpg"))
+		r.False(link.Contains("/photos/b.jpg"))
+
+		gotLink, err := s.GetLink(token)
+		r.NoError(err)
+		r.Equal(link, gotLink)
+		r.True(s.IsUnlocked(gotLink, ""))
+
+		_, err = s.GetLink(token + "x")
+		r.ErrorIs(err, ErrInvalidLink)
+		_, err = s.GetLink("x" + token)
+		r.ErrorIs(err, ErrInvalidLink)
+	})
+
+	t.Run("dir", func(t *testing.T) {
+		r := require.New(t)
+
+		token, link, err := s.CreateLink(CreateOptions{
+			Path: "/photos/2024/", ModTime: 1700000000, Size: 123, TTL: time.Hour,
+		})
+		r.NoError(err)
+		r.True(link.IsDir())
+		// Mod time and size are ignored for directories.
+		r.Zero(link.ModTime)
+		r.Zero(link.Size)
+
+		for path, want := range map[string]bool{
+			"/photos/2024":          true,
+			"/photos/2024/":         true,
+			"/photos/2024/a.jpg":    true,
+			"/photos/2024/dir/":     true,
+			"/photos/2024-02/a.jpg": false,
+			"/photos/":              false,
+		} {
+			r.Equal(want, link.Contains(path), path)
+		}
+
+		gotLink, err := s.GetLink(token)
+		r.NoError(err)
+		r.Equal(link, gotLink)
+	})
+
+	t.Run("expired link", func(t *testing.T) {
+		r := require.New(t)
+
+		token, err := s.signer.EncodeToken("link", linkData{
+			ID: "id", Path: "/photos/", ExpiresAt: time.Now().Add(-time.Second).Unix(),
+		})
+		r.NoError(err)
+
+		_, err = s.GetLink(token)
+		r.ErrorIs(err, ErrLinkExpired)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		r := require.New(t)
+
+		for _, opts := range []CreateOptions{
+			{Path: "/", TTL: time.Hour},
+			{Path: "photos/", TTL: time.Hour},
+			{Path: "/photos/../private/", TTL: time.Hour},
+			{Path: "/photos//a.jpg", TTL: time.Hour},
+			{Path: "/photos/", TTL: 0},
+			{Path: "/photos/", TTL: MaxTTL + time.Hour},
+		} {
+			_, _, err := s.CreateLink(opts)
+			r.ErrorIs(err, ErrInvalidOptions, "%+v", opts)
+		}
+	})
+
+	t.Run("password", func(t *testing.T) {
+		r := require.New(t)
+
+		token, link, err := s.CreateLink(CreateOptions{Path: "/photos/", TTL: time.Hour, Password: "qwerty"})
+		r.NoError(err)
+		r.True(link.HasPassword)
+
+		link, err = s.GetLink(token)
+		r.NoError(err)
+		r.True(link.HasPassword)
+		r.False(s.IsUnlocked(link, ""))
+
+		_, err = s.Unlock(link, "123", "10.0.0.1")
+		r.ErrorIs(err, ErrInvalidPassword)
+
+		unlockToken, err := s.Unlock(link, "qwerty", "10.0.0.1")
+		r.NoError(err)
+		r.True(s.IsUnlocked(link, unlockToken))
+
+		// Tokens can't be used for other links.
+		_, otherLink, err := s.CreateLink(CreateOptions{Path: "/photos/", TTL: time.Hour, Password: "qwerty"})
+		r.NoError(err)
+		r.False(s.IsUnlocked(otherLink, unlockToken))
+
+		// Password guessing is limited.
+		for range allowedUnlockFailures + 1 {
+			_, err = s.Unlock(otherLink, "123", "10.0.0.2")
+			r.ErrorIs(err, ErrInvalidPassword)
+		}
+		_, err = s.Unlock(otherLink, "qwerty", "10.0.0.3")
+		r.ErrorIs(err, ErrTooManyAttempts)
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		r := require.New(t)
+
+		token, link, err := s.CreateLink(CreateOptions{Path: "/docs/", TTL: time.Hour, CreatedBy: "bob"})
+		r.NoError(err)
+		s.AddDownload(link)
+		s.AddDownload(link)
+
+		// Counters are saved with a delay.
+		r.Zero(newTestService(t, dir).GetLinks("bob")[0].Downloads)
+		r.NoError(s.Shutdown(t.Context()))
+
+		// Links and download counters must survive restarts.
+		s := newTestService(t, dir)
+
+		gotLink, err := s.GetLink(token)
+		r.NoError(err)
+		r.Equal(link, gotLink)
+		s.AddDownload(gotLink)
+		r.NoError(s.Shutdown(t.Context()))
+
+		links := s.GetLinks("bob")
+		r.Len(links, 1)
+		r.Equal("/docs/", links[0].Path)
+		r.Equal(3, links[0].Downloads)
+	})
+
+	t.Run("get links", func(t *testing.T) {
+		r := require.New(t)
+
+		links := s.GetLinks("alice")
+		r.Len(links, 1)
+		r.Equal("/photos/a.jpg", links[0].Path)
+
+		for _, link := range s.GetLinks("") {
+			// Password hashes must not be returned.
+			r.Empty(link.PasswordHash)
+		}
+	})
+}
@@ -0,0 +1,286 @@
+// A minimal slippy map: it renders raster tiles in Web Mercator projection and markers.
+// The map can be moved by dragging and zoomed with the mouse wheel, double click or buttons.
+//
+// Usage:
+//
+//	const map = createMap(container, { tileURL: "https://tile.openstreetmap.org/{z}/{x}/{y}.png" });
+//	map.onMoveEnd(() => { map.setMarkers(...) });
+//	map.fitBounds([west, south, east, north]);
+const createMap = (() => {
+	const tileSize = 256;
+	const minZoom = 1;
+	const maxZoom = 19;
+	const maxLatitude = 85.0511287798;
+
+	const worldSize = (zoom) => tileSize * Math.pow(2, zoom);
+
+	// project converts coordinates into world pixels.
+	const project = (lat, lon, zoom) => {
+		lat = Math.max(Math.min(lat, maxLatitude), -maxLatitude);
+
+		const size = worldSize(zoom);
+		const sin = Math.sin(lat * Math.PI / 180);
+		return {
+			x: (lon + 180) / 360 * size,
+			y: (0.5 - Math.log((1 + sin) / (1 - sin)) / (4 * Math.PI)) * size,
+		};
+	};
+
+	// unproject converts world pixels into coordinates.
+	const unproject = (x, y, zoom) => {
+		const size = worldSize(zoom);
+		const n = Math.PI * (1 - 2 * y / size);
+		return {
+			lat: Math.atan(Math.sinh(n)) * 180 / Math.PI,
+			lon: x / size * 360 - 180,
+		};
+	};
+
+	return (container, opts) => {
+		container.classList.add("map");
+
+		const tilesLayer = document.createElement("div");
+		tilesLayer.classList.add("map-tiles");
+		const markersLayer = document.createElement("div");
+		markersLayer.classList.add("map-markers");
+		container.append(tilesLayer, markersLayer);
+
+		// tile key -> img
+		let tiles = new Map();
+		// [{ lat, lon, elem }]
+		let markers = [];
+		let moveEndCallbacks = [];
+		let moveEndTimeoutID = null;
+
+		// Center in world pixels for the current zoom.
+		let zoom = minZoom;
+		let center = { x: worldSize(zoom) / 2, y: worldSize(zoom) / 2 };
+
+		const getTopLeft = () => {
+			return {
+				x: center.x - container.clientWidth / 2,
+				y: center.y - container.clientHeight / 2,
+			};
+		};
+
+		const render = () => {
+			const topLeft = getTopLeft();
+			const tilesCount = Math.pow(2, zoom);
+
+			const newTiles = new Map();
+			const minX = Math.floor(topLeft.x / tileSize);
+			const maxX = Math.floor((topLeft.x + container.clientWidth) / tileSize);
+			const minY = Math.max(Math.floor(topLeft.y / tileSize), 0);
+			const maxY = Math.min(Math.floor((topLeft.y + container.clientHeight) / tileSize), tilesCount - 1);
+			for (let x = minX; x <= maxX; x++) {
+				for (let y = minY; y <= maxY; y++) {
+					const key = `${zoom}/${x}/${y}`;
+
+					let img = tiles.get(key);
+					if (!img) {
+						// Wrap tiles horizontally.
+						const wrappedX = ((x % tilesCount) + tilesCount) % tilesCount;
+
+						img = document.createElement("img");
+						img.classList.add("map-tile");
+						img.draggable = false;
+						img.alt = "";
+						img.src = opts.tileURL.
+							replace("{z}", zoom).
+							replace("{x}", wrappedX).
+							replace("{y}", y);
+						tilesLayer.appendChild(img);
+					}
+					img.style.transform = `translate(${x * tileSize - topLeft.x}px, ${y * tileSize - topLeft.y}px)`;
+					newTiles.set(key, img);
+				}
+			}
+			for (const [key, img] of tiles) {
+				if (!newTiles.has(key)) {
+					img.remove();
+				}
+			}
+			tiles = newTiles;
+
+			for (const marker of markers) {
+				const p = project(marker.lat, marker.lon, zoom);
+				marker.elem.style.transform = `translate(${p.x - topLeft.x}px, ${p.y - topLeft.y}px)`;
+			}
+		};
+
+		const fireMoveEnd = () => {
+			window.clearTimeout(moveEndTimeoutID);
+			moveEndTimeoutID = window.setTimeout(() => {
+				moveEndCallbacks.forEach(fn => fn());
+			}, 200);
+		};
+
+		const clampCenter = () => {
+			const size = worldSize(zoom);
+			center.y = Math.max(Math.min(center.y, size), 0);
+			// Keep the center within the world horizontally.
+			center.x = ((center.x % size) + size) % size;
+		};
+
+		// setZoom changes the zoom level keeping the point under (offsetX, offsetY) in place.
+		const setZoom = (newZoom, offsetX = container.clientWidth / 2, offsetY = container.clientHeight / 2) => {
+			newZoom = Math.max(Math.min(newZoom, maxZoom), minZoom);
+			if (newZoom === zoom) {
+				return;
+			}
+
+			const topLeft = getTopLeft();
+			const scale = Math.pow(2, newZoom - zoom);
+			const point = { x: topLeft.x + offsetX, y: topLeft.y + offsetY };
+
+			center = {
+				x: point.x * scale - offsetX + container.clientWidth / 2,
+				y: point.y * scale - offsetY + container.clientHeight / 2,
+			};
+			zoom = newZoom;
+
+			clampCenter();
+			render();
+			fireMoveEnd();
+		};
+
+		// Dragging
+		let dragPosition = null;
+		container.addEventListener("pointerdown", ev => {
+			if (ev.target.closest(".map-marker, .map-controls")) {
+				return;
+			}
+			dragPosition = { x: ev.clientX, y: ev.clientY };
+			container.setPointerCapture(ev.pointerId);
+			container.classList.add("dragging");
+		});
+		container.addEventListener("pointermove", ev => {
+			if (!dragPosition) {
+				return;
+			}
+			center.x -= ev.clientX - dragPosition.x;
+			center.y -= ev.clientY - dragPosition.y;
+			dragPosition = { x: ev.clientX, y: ev.clientY };
+
+			clampCenter();
+			render();
+		});
+		const stopDragging = () => {
+			if (!dragPosition) {
+				return;
+			}
+			dragPosition = null;
+			container.classList.remove("dragging");
+			fireMoveEnd();
+		};
+		container.addEventListener("pointerup", stopDragging);
+		container.addEventListener("pointercancel", stopDragging);
+
+		// Zooming
+		container.addEventListener("wheel", ev => {
+			ev.preventDefault();
+
+			const box = container.getBoundingClientRect();
+			setZoom(zoom + (ev.deltaY < 0 ? 1 : -1), ev.clientX - box.left, ev.clientY - box.top);
+		}, { passive: false });
+		container.addEventListener("dblclick", ev => {
+			if (ev.target.closest(".map-marker, .map-controls")) {
+				return;
+			}
+			const box = container.getBoundingClientRect();
+			setZoom(zoom + 1, ev.clientX - box.left, ev.clientY - box.top);
+		});
+
+		const controls = document.createElement("div");
+		controls.classList.add("map-controls");
+		for (const [text, title, delta] of [["+", "Zoom In", 1], ["−", "Zoom Out", -1]]) {
+			const button = document.createElement("button");
+			button.textContent = text;
+			button.title = title;
+			button.addEventListener("click", () => setZoom(zoom + delta));
+			controls.appendChild(button);
+		}
+		container.appendChild(controls);
+
+		if (opts.attribution) {
+			const attribution = document.createElement("div");
+			attribution.classList.add("map-attribution");
+			attribution.innerHTML = opts.attribution;
+			container.appendChild(attribution);
+		}
+
+		window.addEventListener("resize", () => {
+			render();
+			fireMoveEnd();
+		});
+
+		return {
+			getZoom: () => zoom,
+
+			// getBounds returns the visible area: [west, south, east, north].
+			getBounds: () => {
+				const topLeft = getTopLeft();
+				const nw = unproject(topLeft.x, Math.max(topLeft.y, 0), zoom);
+				const se = unproject(
+					topLeft.x + container.clientWidth,
+					Math.min(topLeft.y + container.clientHeight, worldSize(zoom)),
+					zoom,
+				);
+
+				let west = nw.lon;
+				let east = se.lon;
+				if (east - west >= 360) {
+					west = -180;
+					east = 180;
+				} else {
+					// Normalize longitudes. If the visible area crosses the antimeridian,
+					// west is greater than east.
+					const width = east - west;
+					west -= 360 * Math.floor((west + 180) / 360);
+					east = west + width;
+					if (east > 180) {
+						east -= 360;
+					}
+				}
+				return [west, se.lat, east, nw.lat];
+			},
+
+			// fitBounds shows the area with the max possible zoom level: [west, south, east, north].
+			fitBounds: ([west, south, east, north], padding = 40) => {
+				let newZoom = maxZoom;
+				for (; newZoom > minZoom; newZoom--) {
+					const nw = project(north, west, newZoom);
+					const se = project(south, east, newZoom);
+					if (se.x - nw.x <= container.clientWidth - 2 * padding && se.y - nw.y <= container.clientHeight - 2 * padding) {
+						break;
+					}
+				}
+				zoom = Math.min(newZoom, opts.maxFitZoom || maxZoom);
+
+				const nw = project(north, west, zoom);
+				const se = project(south, east, zoom);
+				center = { x: (nw.x + se.x) / 2, y: (nw.y + se.y) / 2 };
+
+				clampCenter();
+				render();
+				fireMoveEnd();
+			},
+
+			// setMarkers replaces all markers: [{ lat, lon, elem }].
+			setMarkers: (newMarkers) => {
+				markers.forEach(m => m.elem.remove());
+
+				markers = newMarkers;
+				markers.forEach(m => {
+					m.elem.classList.add("map-marker");
+					markersLayer.appendChild(m.elem);
+				});
+				render();
+			},
+
+			onMoveEnd: (fn) => {
+				moveEndCallbacks.push(fn);
+			},
+		};
+	};
+})();
@@ -0,0 +1,89 @@
+// Storyboards are images with evenly spaced video frames. Hovering over an element with
+// "data-storyboard-url" shows the frame that corresponds to the cursor position. The element
+// must contain a ".storyboard" child that is used to display frames.
+//
+// Clicking on such an element dispatches "storyboard-click" event with the frame time.
+(() => {
+	// url -> Promise with index or null
+	const indexes = new Map();
+
+	const loadIndex = (url) => {
+		if (!indexes.has(url)) {
+			const promise = fetch(url + "&format=json").
+				then(resp => {
+					if (!resp.ok) {
+						throw new Error(`unexpected status code ${resp.status}`);
+					}
+					return resp.json();
+				}).
+				catch(err => {
+					console.warn(`couldn't load storyboard index: ${err}`);
+					return null;
+				});
+
+			indexes.set(url, promise);
+		}
+		return indexes.get(url);
+	};
+
+	// getFrame returns the frame that corresponds to the cursor position.
+	const getFrame = (elem, index, clientX) => {
+		const box = elem.getBoundingClientRect();
+		const pos = Math.min(Math.max((clientX - box.left) / box.width, 0), 1);
+		const i = Math.min(Math.floor(pos * index.frames.length), index.frames.length - 1);
+		return [index.frames[i], pos];
+	};
+
+	const percent = (n, total) => total > 1 ? (n / (total - 1)) * 100 : 0;
+
+	let activeElem = null;
+
+	const deactivate = () => {
+		activeElem?.classList.remove("storyboard-active");
+		activeElem = null;
+	};
+
+	document.addEventListener("mousemove", async (ev) => {
+		const elem = ev.target.closest("[data-storyboard-url]");
+		if (elem !== activeElem) {
+			deactivate();
+		}
+		if (!elem) {
+			return;
+		}
+		activeElem = elem;
+
+		const index = await loadIndex(elem.dataset.storyboardUrl);
+		if (!index || !index.frames?.length || activeElem !== elem) {
+			return;
+		}
+
+		const [frame, pos] = getFrame(elem, index, ev.clientX);
+		const storyboard = elem.querySelector(".storyboard");
+
+		storyboard.style.backgroundImage = `url("${elem.dataset.storyboardUrl}")`;
+		storyboard.style.backgroundSize = `${index.columns * 100}% ${index.rows * 100}%`;
+		storyboard.style.backgroundPosition = [
+			percent(frame.x / index.tile_width, index.columns) + "%",
+			percent(frame.y / index.tile_height, index.rows) + "%",
+		].join(" ");
+		elem.style.setProperty("--storyboard-position", pos);
+		elem.classList.add("storyboard-active");
+	});
+
+	document.addEventListener("mouseleave", deactivate);
+
+	document.addEventListener("click", async (ev) => {
+		const elem = ev.target.closest("[data-storyboard-url]");
+		if (!elem) {
+			return;
+		}
+		const index = await loadIndex(elem.dataset.storyboardUrl);
+		if (!index || !index.frames?.length) {
+			return;
+		}
+
+		const [frame] = getFrame(elem, index, ev.clientX);
+		elem.dispatchEvent(new CustomEvent("storyboard-click", { detail: { time: frame.time } }));
+	});
+})();
@@ -48,8 +48,8 @@ func startTestRview() {
 			Dir:        tempDir,
 			//
 			Rclone: rview.RcloneConfig{
-				Target: "./testdata",
-				Port:   mustGetFreePort(),
+				Targets: rview.RcloneTargets{{Target: "./testdata"}},
+				Port:    mustGetFreePort(),
 			},
 			//
 			ImagePreviewMode:       rview.ImagePreviewModeThumbnails,
@@ -201,6 +201,7 @@ func TestAPI_GetDirInfo(t *testing.T) {
 						FileType:             rview.FileTypeText,
 						CanPreview:           true,
 						OriginalFileURL:      "/api/file/Lorem%20ipsum.txt?mod_time=1677510000&size=943",
+						TextPreviewURL:       "/api/text-preview/Lorem%20ipsum.txt?mod_time=1677510000&size=943",
 						IconName:             "document",
 					},
 					{
@@ -212,6 +213,7 @@ func TestAPI_GetDirInfo(t *testing.T) {
 						FileType:             rview.FileTypeText,
 						CanPreview:           true,
 						OriginalFileURL:      "/api/file/main.go?mod_time=1649355835&size=73",
+						TextPreviewURL:       "/api/text-preview/main.go?mod_time=1649355835&size=73",
 						IconName:             "document",
 					},
 					{
@@ -255,6 +257,7 @@ func TestAPI_GetDirInfo(t *testing.T) {
 						FileType:             rview.FileTypeText,
 						CanPreview:           true,
 						OriginalFileURL:      "/api/file/Other/a%20&%20b/x/x%20&%20y.txt?mod_time=1686009613&size=4",
+						TextPreviewURL:       "/api/text-preview/Other/a%20&%20b/x/x%20&%20y.txt?mod_time=1686009613&size=4",
 						IconName:             "document",
 					},
 				},
@@ -286,6 +289,7 @@ func TestAPI_GetDirInfo(t *testing.T) {
 						FileType:             rview.FileTypeText,
 						CanPreview:           true,
 						OriginalFileURL:      "/api/file/Other/spe%27sial%20%21%20cha%3Cracters/x/y/100%25.txt?mod_time=1759891740&size=0",
+						TextPreviewURL:       "/api/text-preview/Other/spe%27sial%20%21%20cha%3Cracters/x/y/100%25.txt?mod_time=1759891740&size=0",
 						IconName:             "document",
 					},
 					{
@@ -297,6 +301,7 @@ func TestAPI_GetDirInfo(t *testing.T) {
 						FileType:             rview.FileTypeText,
 						CanPreview:           true,
 						OriginalFileURL:      "/api/file/Other/spe%27sial%20%21%20cha%3Cracters/x/y/a%20+%20b.txt?mod_time=1662637022&size=0",
+						TextPreviewURL:       "/api/text-preview/Other/spe%27sial%20%21%20cha%3Cracters/x/y/a%20+%20b.txt?mod_time=1662637022&size=0",
 						IconName:             "document",
 					},
 					{
@@ -308,6 +313,7 @@ func TestAPI_GetDirInfo(t *testing.T) {
 						FileType:             rview.FileTypeText,
 						CanPreview:           true,
 						OriginalFileURL:      "/api/file/Other/spe%27sial%20%21%20cha%3Cracters/x/y/f%3Eile.txt?mod_time=1662637022&size=0",
+						TextPreviewURL:       "/api/text-preview/Other/spe%27sial%20%21%20cha%3Cracters/x/y/f%3Eile.txt?mod_time=1662637022&size=0",
 						IconName:             "document",
 					},
 				},
@@ -0,0 +1,541 @@
+package thumbnails
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	pkgPath "path"
+	"slices"
+	"strings"
+
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/pkg/ranged"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// dirCoverFilenames are the names of images that are used as covers for all audio files in
+// the same directory. The order defines the priority.
+var dirCoverFilenames = []string{
+	"cover.jpg", "cover.jpeg", "cover.png",
+	"folder.jpg", "folder.jpeg", "folder.png",
+}
+
+// openAudioPreview returns an image that represents an audio file. It tries the following sources:
+//
+//  1. Embedded cover art: ID3v2 APIC, FLAC PICTURE or Ogg METADATA_BLOCK_PICTURE. Only the tags
+//     are downloaded.
+//  2. A cover image in the same directory, see [dirCoverFilenames].
+//  3. A waveform rendered by ffmpeg. It requires downloading the entire file.
+func (s *ThumbnailService) openAudioPreview(ctx context.Context, id rview.FileID) (io.ReadCloser, error) {
+	cover, err := s.extractEmbeddedCover(ctx, id)
+	if err == nil {
+		return io.NopCloser(bytes.NewReader(cover)), nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	rlog.Debugf("couldn't extract embedded cover from %q: %s", id.GetPath(), err)
+
+	rc, err := s.openDirCover(ctx, id)
+	if err == nil {
+		return rc, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	rlog.Debugf("couldn't find cover in the directory of %q: %s", id.GetPath(), err)
+
+	waveform, err := s.renderWaveform(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(waveform)), nil
+}
+
+func (s *ThumbnailService) extractEmbeddedCover(ctx context.Context, id rview.FileID) ([]byte, error) {
+	reader := ranged.NewReader(ctx, id, s.rclone.RequestFileRange)
+	defer reader.Close()
+
+	return extractEmbeddedCover(io.NewSectionReader(reader, 0, reader.Size()))
+}
+
+func (s *ThumbnailService) openDirCover(ctx context.Context, id rview.FileID) (io.ReadCloser, error) {
+	dir := pkgPath.Dir(id.GetPath())
+	info, err := s.rclone.GetDirInfo(ctx, misc.EnsureSuffix(dir, "/"), "", "")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get dir info: %w", err)
+	}
+
+	bestIndex := -1
+	var coverID rview.FileID
+	for _, entry := range info.Entries {
+		if entry.IsDir || entry.Size > maxEmbeddedImageSize {
+			continue
+		}
+		i := slices.Index(dirCoverFilenames, strings.ToLower(entry.Leaf))
+		if i == -1 || (bestIndex != -1 && bestIndex < i) {
+			continue
+		}
+		bestIndex = i
+		coverID = rview.NewFileID(pkgPath.Join(dir, entry.Leaf), entry.ModTime, entry.Size)
+	}
+	if bestIndex == -1 {
+		return nil, errors.New("no cover image")
+	}
+	return s.rclone.OpenFile(ctx, coverID)
+}
+
+// renderWaveform renders the waveform of the first audio stream with ffmpeg.
+func (s *ThumbnailService) renderWaveform(ctx context.Context, id rview.FileID) ([]byte, error) {
+	server, err := ranged.NewServer(ctx, id, s.rclone.RequestFileRange)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start server for ffmpeg: %w", err)
+	}
+	defer server.Close()
+
+	waveform, err := runFFmpegCmd(
+		ctx, "ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", server.URL(),
+		"-filter_complex", "[0:a:0]aformat=channel_layouts=mono,showwavespic=s=1024x512:colors=0x7aa2f7",
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-c:v", "mjpeg",
+		"-q:v", "2",
+		"pipe:1",
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(waveform) == 0 {
+		return nil, errors.New("ffmpeg returned no waveform")
+	}
+	return waveform, nil
+}
+
+// extractEmbeddedCover detects the container by its magic bytes and returns an embedded cover.
+func extractEmbeddedCover(r *io.SectionReader) ([]byte, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, fmt.Errorf("couldn't read magic bytes: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("ID3")):
+		return extractID3Cover(r)
+	case bytes.Equal(magic, []byte("fLaC")):
+		return extractFlacCover(r)
+	case bytes.Equal(magic, []byte("OggS")):
+		return extractOggCover(r)
+	default:
+		return nil, errors.New("unsupported audio container")
+	}
+}
+
+// frontCoverPictureType is the "Cover (front)" picture type. ID3v2 and FLAC use the same
+// picture types. If there is no front cover, the first picture is used.
+const frontCoverPictureType = 3
+
+// extractID3Cover extracts the APIC (PIC for ID3v2.2) frame.
+//
+// See https://id3.org/id3v2.3.0 and https://id3.org/id3v2.4.0-structure.
+func extractID3Cover(r *io.SectionReader) ([]byte, error) {
+	header := make([]byte, 10)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("couldn't read ID3 header: %w", err)
+	}
+	version := header[3]
+	flags := header[5]
+	size := decodeSynchsafeInt(header[6:10])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported ID3 version: 2.%d", version)
+	}
+	if size > maxEmbeddedImageSize+1<<20 {
+		return nil, fmt.Errorf("ID3 tag is too large: %d", size)
+	}
+
+	tag := make([]byte, size)
+	if _, err := r.ReadAt(tag, 10); err != nil {
+		return nil, fmt.Errorf("couldn't read ID3 tag: %w", err)
+	}
+
+	const (
+		unsynchronisationFlag = 0x80
+		extendedHeaderFlag    = 0x40
+	)
+	if version < 4 && flags&unsynchronisationFlag != 0 {
+		tag = removeUnsynchronisation(tag)
+	}
+	if version > 2 && flags&extendedHeaderFlag != 0 {
+		if len(tag) < 4 {
+			return nil, errors.New("invalid ID3 extended header")
+		}
+		// ID3v2.3 doesn't include the size field into the size of the extended header.
+		extSize := int(binary.BigEndian.Uint32(tag[:4])) + 4
+		if version == 4 {
+			extSize = decodeSynchsafeInt(tag[:4])
+		}
+		if extSize > len(tag) {
+			return nil, errors.New("invalid ID3 extended header size")
+		}
+		tag = tag[extSize:]
+	}
+
+	frameHeaderSize := 10
+	if version == 2 {
+		frameHeaderSize = 6
+	}
+
+	var cover []byte
+	for len(tag) >= frameHeaderSize && tag[0] != 0 {
+		var (
+			frameID   string
+			frameSize int
+			skipFrame bool
+		)
+		switch version {
+		case 2:
+			frameID = string(tag[:3])
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			frameID = string(tag[:4])
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+			// Skip compressed and encrypted frames.
+			skipFrame = tag[9]&0xc0 != 0
+		case 4:
+			frameID = string(tag[:4])
+			frameSize = decodeSynchsafeInt(tag[4:8])
+			// Skip compressed, encrypted frames and frames with data length indicator.
+			skipFrame = tag[9]&0x0d != 0
+		}
+		if frameSize < 0 || frameHeaderSize+frameSize > len(tag) {
+			return nil, fmt.Errorf("invalid size of ID3 frame %q", frameID)
+		}
+
+		data := tag[frameHeaderSize : frameHeaderSize+frameSize]
+		if version == 4 && tag[9]&0x02 != 0 {
+			data = removeUnsynchronisation(data)
+		}
+		tag = tag[frameHeaderSize+frameSize:]
+
+		if skipFrame || (frameID != "APIC" && frameID != "PIC") {
+			continue
+		}
+		pictureType, picture, err := parseID3Picture(data, version)
+		if err != nil {
+			return nil, err
+		}
+		if pictureType == frontCoverPictureType {
+			return picture, nil
+		}
+		if cover == nil {
+			cover = picture
+		}
+	}
+	if cover == nil {
+		return nil, errors.New("no picture in ID3 tag")
+	}
+	return cover, nil
+}
+
+func parseID3Picture(data []byte, version byte) (pictureType byte, picture []byte, err error) {
+	errInvalidFrame := errors.New("invalid ID3 picture frame")
+
+	if len(data) < 1 {
+		return 0, nil, errInvalidFrame
+	}
+	encoding := data[0]
+	data = data[1:]
+
+	// Skip image format: 3 chars for ID3v2.2, null-terminated MIME type for others.
+	if version == 2 {
+		if len(data) < 3 {
+			return 0, nil, errInvalidFrame
+		}
+		data = data[3:]
+	} else {
+		i := bytes.IndexByte(data, 0)
+		if i == -1 {
+			return 0, nil, errInvalidFrame
+		}
+		data = data[i+1:]
+	}
+
+	if len(data) < 1 {
+		return 0, nil, errInvalidFrame
+	}
+	pictureType = data[0]
+	data = data[1:]
+
+	// Skip description. UTF-16 strings are terminated with 2 null bytes.
+	switch encoding {
+	case 1, 2:
+		i := 0
+		for ; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				break
+			}
+		}
+		if i+1 >= len(data) {
+			return 0, nil, errInvalidFrame
+		}
+		data = data[i+2:]
+	default:
+		i := bytes.IndexByte(data, 0)
+		if i == -1 {
+			return 0, nil, errInvalidFrame
+		}
+		data = data[i+1:]
+	}
+	if len(data) == 0 {
+		return 0, nil, errInvalidFrame
+	}
+	return pictureType, data, nil
+}
+
+func decodeSynchsafeInt(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// removeUnsynchronisation replaces all "0xFF 0x00" sequences with "0xFF".
+func removeUnsynchronisation(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// extractFlacCover extracts the PICTURE metadata block. Other blocks are skipped.
+//
+// See https://www.rfc-editor.org/rfc/rfc9639.html#name-metadata-blocks.
+func extractFlacCover(r *io.SectionReader) ([]byte, error) {
+	const pictureBlockType = 6
+
+	var (
+		offset int64 = 4 // skip "fLaC"
+		header       = make([]byte, 4)
+		cover  []byte
+	)
+	for {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, fmt.Errorf("couldn't read FLAC metadata block header: %w", err)
+		}
+		offset += int64(len(header))
+
+		isLast := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		blockSize := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+
+		if blockType == pictureBlockType {
+			if blockSize > maxEmbeddedImageSize+1<<10 {
+				return nil, fmt.Errorf("FLAC picture is too large: %d", blockSize)
+			}
+			block := make([]byte, blockSize)
+			if _, err := r.ReadAt(block, offset); err != nil {
+				return nil, fmt.Errorf("couldn't read FLAC picture: %w", err)
+			}
+			pictureType, picture, err := parseFlacPicture(block)
+			if err != nil {
+				return nil, err
+			}
+			if pictureType == frontCoverPictureType {
+				return picture, nil
+			}
+			if cover == nil {
+				cover = picture
+			}
+		}
+		offset += int64(blockSize)
+
+		if isLast {
+			break
+		}
+	}
+	if cover == nil {
+		return nil, errors.New("no picture in FLAC metadata")
+	}
+	return cover, nil
+}
+
+// parseFlacPicture parses the FLAC picture block. Ogg files contain the same
+// block in the METADATA_BLOCK_PICTURE comment.
+func parseFlacPicture(block []byte) (pictureType uint32, picture []byte, err error) {
+	errInvalidBlock := errors.New("invalid FLAC picture block")
+
+	readUint32 := func() (uint32, bool) {
+		if len(block) < 4 {
+			return 0, false
+		}
+		v := binary.BigEndian.Uint32(block)
+		block = block[4:]
+		return v, true
+	}
+	skip := func(n uint32) bool {
+		if uint64(len(block)) < uint64(n) {
+			return false
+		}
+		block = block[n:]
+		return true
+	}
+
+	pictureType, ok := readUint32()
+	if !ok {
+		return 0, nil, errInvalidBlock
+	}
+	// MIME type and description.
+	for range 2 {
+		length, ok := readUint32()
+		if !ok || !skip(length) {
+			return 0, nil, errInvalidBlock
+		}
+	}
+	// Width, height, color depth and number of colors.
+	if !skip(16) {
+		return 0, nil, errInvalidBlock
+	}
+	length, ok := readUint32()
+	if !ok || uint64(len(block)) < uint64(length) || length == 0 {
+		return 0, nil, errInvalidBlock
+	}
+	return pictureType, block[:length], nil
+}
+
+// extractOggCover extracts the METADATA_BLOCK_PICTURE field from the comment header
+// of Vorbis and Opus streams. The comment header is the second packet of the first
+// logical stream.
+//
+// See https://www.rfc-editor.org/rfc/rfc3533.html#section-6 and
+// https://wiki.xiph.org/VorbisComment#METADATA_BLOCK_PICTURE.
+func extractOggCover(r *io.SectionReader) ([]byte, error) {
+	const maxPacketSize = maxEmbeddedImageSize * 4 / 3 // base64
+
+	var (
+		offset     int64
+		serial     []byte
+		packet     []byte
+		packetsNum int
+		header     = make([]byte, 27)
+	)
+	for packetsNum < 2 {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, fmt.Errorf("couldn't read Ogg page header: %w", err)
+		}
+		if !bytes.Equal(header[:4], []byte("OggS")) {
+			return nil, errors.New("invalid Ogg page")
+		}
+		segmentTable := make([]byte, header[26])
+		if _, err := r.ReadAt(segmentTable, offset+27); err != nil {
+			return nil, fmt.Errorf("couldn't read Ogg segment table: %w", err)
+		}
+		offset += 27 + int64(len(segmentTable))
+
+		pageSize := 0
+		for _, v := range segmentTable {
+			pageSize += int(v)
+		}
+		if serial == nil {
+			serial = bytes.Clone(header[14:18])
+		}
+		if !bytes.Equal(serial, header[14:18]) {
+			// Skip pages of other logical streams.
+			offset += int64(pageSize)
+			continue
+		}
+
+		page := make([]byte, pageSize)
+		if _, err := r.ReadAt(page, offset); err != nil {
+			return nil, fmt.Errorf("couldn't read Ogg page: %w", err)
+		}
+		offset += int64(pageSize)
+
+		// A packet ends with a segment shorter than 255 bytes.
+		for _, v := range segmentTable {
+			packet = append(packet, page[:v]...)
+			page = page[v:]
+
+			if len(packet) > maxPacketSize {
+				return nil, errors.New("comment header of Ogg stream is too large")
+			}
+			if v < 255 {
+				packetsNum++
+				if packetsNum == 2 {
+					break
+				}
+				packet = packet[:0]
+			}
+		}
+	}
+	return parseOggComments(packet)
+}
+
+func parseOggComments(packet []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x03vorbis")):
+		packet = packet[7:]
+	case bytes.HasPrefix(packet, []byte("OpusTags")):
+		packet = packet[8:]
+	default:
+		return nil, errors.New("unsupported Ogg comment header")
+	}
+
+	errInvalidComments := errors.New("invalid Ogg comment header")
+
+	readString := func() ([]byte, bool) {
+		if len(packet) < 4 {
+			return nil, false
+		}
+		length := binary.LittleEndian.Uint32(packet)
+		packet = packet[4:]
+		if uint64(len(packet)) < uint64(length) {
+			return nil, false
+		}
+		v := packet[:length]
+		packet = packet[length:]
+		return v, true
+	}
+
+	// Vendor string.
+	if _, ok := readString(); !ok {
+		return nil, errInvalidComments
+	}
+	if len(packet) < 4 {
+		return nil, errInvalidComments
+	}
+	count := binary.LittleEndian.Uint32(packet)
+	packet = packet[4:]
+
+	const pictureField = "METADATA_BLOCK_PICTURE="
+
+	var cover []byte
+	for range count {
+		comment, ok := readString()
+		if !ok {
+			return nil, errInvalidComments
+		}
+		if len(comment) < len(pictureField) || !strings.EqualFold(string(comment[:len(pictureField)]), pictureField) {
+			continue
+		}
+
+		block, err := base64.StdEncoding.DecodeString(string(comment[len(pictureField):]))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode picture: %w", err)
+		}
+		pictureType, picture, err := parseFlacPicture(block)
+		if err != nil {
+			return nil, err
+		}
+		if pictureType == frontCoverPictureType {
+			return picture, nil
+		}
+		if cover == nil {
+			cover = picture
+		}
+	}
+	if cover == nil {
+		return nil, errors.New("no picture in Ogg comments")
+	}
+	return cover, nil
+}
@@ -0,0 +1,175 @@
+package thumbnails
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	pkgPath "path"
+	"strings"
+
+	"github.com/ShoshinNikita/rview/pkg/ranged"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// maxEmbeddedImageSize is the max size of a cover or a thumbnail embedded into a document.
+const maxEmbeddedImageSize = 20 << 20 // 20 MiB
+
+// openDocumentPreview returns an image that represents the first page of a document. PDFs are
+// downloaded entirely because vips needs the whole file to render a page. Other documents are
+// zip archives with embedded covers or thumbnails. So, we read only the necessary parts of them.
+func (s *ThumbnailService) openDocumentPreview(ctx context.Context, id rview.FileID) (io.ReadCloser, error) {
+	ext := id.GetExt()
+	if ext == ".pdf" {
+		return s.rclone.OpenFile(ctx, id)
+	}
+
+	reader := ranged.NewReader(ctx, id, s.rclone.RequestFileRange)
+	defer reader.Close()
+
+	zipReader, err := zip.NewReader(reader, reader.Size())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open document as zip archive: %w", err)
+	}
+
+	var file *zip.File
+	switch ext {
+	case ".epub":
+		file, err = findEpubCover(zipReader)
+	case ".odt", ".ods", ".odp":
+		// OpenDocument files always have a thumbnail of the first page.
+		file, err = findZipFile(zipReader, "Thumbnails/thumbnail.png")
+	case ".docx", ".xlsx", ".pptx":
+		// Office Open XML files have a thumbnail only if it was enabled on save.
+		file, err = findOfficeThumbnail(zipReader)
+	default:
+		return nil, fmt.Errorf("unsupported document format: %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file.UncompressedSize64 > maxEmbeddedImageSize {
+		return nil, fmt.Errorf("embedded image is too large: %d", file.UncompressedSize64)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %q: %w", file.Name, err)
+	}
+	defer rc.Close()
+
+	// Read the image now because the reader is closed on return.
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %q: %w", file.Name, err)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func findZipFile(r *zip.Reader, name string) (*zip.File, error) {
+	for _, f := range r.File {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("no %q in archive", name)
+}
+
+// findOfficeThumbnail looks for "docProps/thumbnail.*". Only formats supported by vips are allowed:
+// for example, Word on Windows can save thumbnails in .wmf.
+func findOfficeThumbnail(r *zip.Reader) (*zip.File, error) {
+	for _, f := range r.File {
+		dir, name := pkgPath.Split(f.Name)
+		if dir != "docProps/" || !strings.HasPrefix(name, "thumbnail.") {
+			continue
+		}
+		switch strings.ToLower(pkgPath.Ext(name)) {
+		case ".jpeg", ".jpg", ".png":
+			return f, nil
+		}
+	}
+	return nil, errors.New("no thumbnail in document")
+}
+
+// findEpubCover finds a cover image with the help of the package document (.opf). EPUB 3 marks
+// the cover with the "cover-image" property, EPUB 2 uses <meta name="cover">.
+//
+// See https://www.w3.org/TR/epub-33/#sec-cover-image.
+func findEpubCover(r *zip.Reader) (*zip.File, error) {
+	decodeXML := func(name string, v any) error {
+		f, err := findZipFile(r, name)
+		if err != nil {
+			return err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("couldn't open %q: %w", name, err)
+		}
+		defer rc.Close()
+
+		if err := xml.NewDecoder(rc).Decode(v); err != nil {
+			return fmt.Errorf("couldn't decode %q: %w", name, err)
+		}
+		return nil
+	}
+
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := decodeXML("META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, errors.New("no rootfile in container.xml")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg struct {
+		Metas []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"metadata>meta"`
+		Items []struct {
+			ID         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"manifest>item"`
+	}
+	if err := decodeXML(opfPath, &pkg); err != nil {
+		return nil, err
+	}
+
+	var coverID string
+	for _, meta := range pkg.Metas {
+		if meta.Name == "cover" {
+			coverID = meta.Content
+		}
+	}
+
+	var href string
+	for _, item := range pkg.Items {
+		if !strings.HasPrefix(item.MediaType, "image/") {
+			continue
+		}
+		if (coverID != "" && item.ID == coverID) || strings.Contains(" "+item.Properties+" ", " cover-image ") {
+			href = item.Href
+			break
+		}
+	}
+	if href == "" {
+		return nil, errors.New("no cover in epub")
+	}
+
+	// Paths in the package document are relative to it and can be escaped.
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return findZipFile(r, pkgPath.Join(pkgPath.Dir(opfPath), href))
+}
@@ -24,6 +24,18 @@ func (NoopThumbnailService) OpenThumbnail(context.Context, rview.FileID, Thumbna
 	return nil, "", ErrNoopThumbnailService
 }
 
+func (NoopThumbnailService) CanGenerateStoryboard(rview.FileID) bool {
+	return false
+}
+
+func (NoopThumbnailService) OpenStoryboard(context.Context, rview.FileID) (io.ReadCloser, error) {
+	return nil, ErrNoopThumbnailService
+}
+
+func (NoopThumbnailService) GetStoryboardIndex(context.Context, rview.FileID) (StoryboardIndex, error) {
+	return StoryboardIndex{}, ErrNoopThumbnailService
+}
+
 func (NoopThumbnailService) Shutdown(context.Context) error {
 	return nil
 }
@@ -20,6 +20,7 @@ import (
 
 	"github.com/ShoshinNikita/rview/pkg/metrics"
 	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rclone"
 	"github.com/ShoshinNikita/rview/rview"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -53,6 +54,10 @@ type ThumbnailService struct {
 	useOriginalImageThresholdSize int64
 	thumbnailsFormat              rview.ThumbnailsFormat
 	processRawImages              bool
+	processVideos                 bool
+	processDocuments              bool
+	maxDocumentSize               int64
+	processAudio                  bool
 
 	workersCount int
 
@@ -74,6 +79,7 @@ type Cache interface {
 type Rclone interface {
 	OpenFile(context.Context, rview.FileID) (io.ReadCloser, error)
 	RequestFileRange(ctx context.Context, id rview.FileID, rangeStart, rangeEnd int) (io.ReadCloser, error)
+	GetDirInfo(ctx context.Context, path string, sort, order string) (*rclone.DirInfo, error)
 }
 
 type ThumbnailID struct {
@@ -85,9 +91,14 @@ type generateThumbnailTask struct {
 	thumbnailID ThumbnailID
 	useOriginal bool
 	size        ThumbnailSize
+	// storyboard indicates that the task should generate a storyboard instead of a thumbnail.
+	// See [ThumbnailService.processStoryboardTask].
+	storyboard bool
 }
 
-func CheckDeps() error {
+// CheckDeps checks that all external tools are installed. ffmpeg is required
+// only for video and audio files.
+func CheckDeps(checkFFmpeg bool) error {
 	ctx := context.Background()
 
 	if err := exec.CommandContext(ctx, "vips", "--version").Run(); err != nil {
@@ -96,19 +107,39 @@ func CheckDeps() error {
 	if err := exec.CommandContext(ctx, "exiftool", "-ver").Run(); err != nil {
 		return fmt.Errorf("exiftool is not installed: %w", err)
 	}
+	if checkFFmpeg {
+		for _, name := range []string{"ffmpeg", "ffprobe"} {
+			if err := exec.CommandContext(ctx, name, "-version").Run(); err != nil {
+				return fmt.Errorf("%s is not installed: %w", name, err)
+			}
+		}
+	}
 	return nil
 }
 
+type Options struct {
+	WorkersCount     int
+	ThumbnailsFormat rview.ThumbnailsFormat
+	// ProcessRawImages enables thumbnail generation for RAW images.
+	ProcessRawImages bool
+	// ProcessVideos enables thumbnail generation for videos. It requires ffmpeg.
+	ProcessVideos bool
+	// ProcessAudio enables thumbnail generation for audio files: embedded covers,
+	// cover images in the same directory or waveforms. It requires ffmpeg.
+	ProcessAudio bool
+	// ProcessDocuments enables thumbnail generation for PDFs, EPUBs and office documents.
+	ProcessDocuments bool
+	// MaxDocumentSize is the max size of a document to generate a thumbnail for.
+	// 0 means no limit.
+	MaxDocumentSize int64
+}
+
 // NewThumbnailService prepares a new service for thumbnail generation.
 // It generates thumbnails only for large files and uses the small ones as-is.
 //
 // For some images we can generate thumbnails of different formats. For example,
 // for .heic images we generate .jpeg thumbnails.
-func NewThumbnailService(
-	rclone Rclone, cache, originalImageCache Cache, workersCount int, thumbnailsFormat rview.ThumbnailsFormat,
-	processRawImages bool,
-) *ThumbnailService {
-
+func NewThumbnailService(rclone Rclone, cache, originalImageCache Cache, opts Options) *ThumbnailService {
 	r := &ThumbnailService{
 		cache:              cache,
 		originalImageCache: originalImageCache,
@@ -117,10 +148,14 @@ func NewThumbnailService(
 		rclone:                        rclone,
 		resizeFn:                      resizeWithVips,
 		useOriginalImageThresholdSize: 200 << 10, // 200 KiB
-		thumbnailsFormat:              thumbnailsFormat,
-		processRawImages:              processRawImages,
+		thumbnailsFormat:              opts.ThumbnailsFormat,
+		processRawImages:              opts.ProcessRawImages,
+		processVideos:                 opts.ProcessVideos,
+		processDocuments:              opts.ProcessDocuments,
+		maxDocumentSize:               opts.MaxDocumentSize,
+		processAudio:                  opts.ProcessAudio,
 		//
-		workersCount: workersCount,
+		workersCount: opts.WorkersCount,
 		//
 		tasksCh:         make(chan generateThumbnailTask, 10_000),
 		inProgressTasks: make(map[ThumbnailID]struct{}),
@@ -162,6 +197,9 @@ func (s *ThumbnailService) startWorkers() {
 					metrics.ThumbnailsErrors.Inc()
 					rlog.Errorf("couldn't process task for %q: %s", task.fileID.GetPath(), err)
 
+				case task.storyboard:
+					rlog.Debugf("storyboard for %q was generated in %s", task.fileID.GetPath(), dur)
+
 				case stats.originalImageUsed:
 					metrics.ThumbnailsOriginalImageUsed.Inc()
 					rlog.Debugf("use original image for %q, size: %s", task.fileID.GetPath(), toMiB(stats.originalSize))
@@ -206,6 +244,10 @@ type stats struct {
 }
 
 func (s *ThumbnailService) processTask(ctx context.Context, task generateThumbnailTask) (finalStats stats, err error) {
+	if task.storyboard {
+		return stats{}, s.processStoryboardTask(ctx, task)
+	}
+
 	cacheFilepath, err := s.cache.GetFilepath(task.thumbnailID.FileID)
 	if err != nil {
 		return stats{}, fmt.Errorf("couldn't get path for a thumbnail file: %w", err)
@@ -249,7 +291,7 @@ func (s *ThumbnailService) processTask(ctx context.Context, task generateThumbna
 	if err != nil {
 		return stats{}, fmt.Errorf("couldn't load image: %w", err)
 	}
-	if getImageType(task.fileID) != rawImageType && originalSize != task.fileID.GetSize() {
+	if t := getImageType(task.fileID); !isExtractedImageType(t) && originalSize != task.fileID.GetSize() {
 		return stats{}, fmt.Errorf("temp file has wrong size, expected: %d, got: %d", task.fileID.GetSize(), originalSize)
 	}
 	if err := tempFile.Close(); err != nil {
@@ -291,9 +333,16 @@ func (s *ThumbnailService) openImage(ctx context.Context, id rview.FileID) (rc i
 		return rc, nil
 	}
 
-	if getImageType(id) == rawImageType {
+	switch getImageType(id) {
+	case rawImageType:
 		rc, err = s.extractPreviewFromRawImage(ctx, id)
-	} else {
+	case videoImageType:
+		rc, err = s.extractFrameFromVideo(ctx, id)
+	case documentImageType:
+		rc, err = s.openDocumentPreview(ctx, id)
+	case audioImageType:
+		rc, err = s.openAudioPreview(ctx, id)
+	default:
 		rc, err = s.rclone.OpenFile(ctx, id)
 	}
 	if err != nil {
@@ -544,6 +593,13 @@ func (s *ThumbnailService) CanGenerateThumbnail(id rview.FileID) bool {
 		return false
 	case rawImageType:
 		return s.processRawImages
+	case videoImageType:
+		return s.processVideos
+	case audioImageType:
+		return s.processAudio
+	case documentImageType:
+		// PDFs are downloaded entirely, so skip large documents.
+		return s.processDocuments && (s.maxDocumentSize == 0 || id.GetSize() <= s.maxDocumentSize)
 	default:
 		return true
 	}
@@ -560,8 +616,22 @@ const (
 	heicImageType
 	avifImageType
 	rawImageType
+	videoImageType    // a frame extracted from a video
+	documentImageType // the first page or a cover of a document
+	audioImageType    // a cover or a waveform of an audio file
 )
 
+// isExtractedImageType reports whether an image is extracted from a file instead of
+// being the file itself.
+func isExtractedImageType(t imageType) bool {
+	switch t {
+	case rawImageType, videoImageType, documentImageType, audioImageType:
+		return true
+	default:
+		return false
+	}
+}
+
 func getImageType(id rview.FileID) imageType {
 	switch id.GetExt() {
 	case ".jpg", ".jpeg":
@@ -578,6 +648,12 @@ func getImageType(id rview.FileID) imageType {
 		return avifImageType
 	case ".arw", ".rw2", ".nef", ".cr3":
 		return rawImageType
+	case ".mp4", ".mkv", ".mov", ".webm", ".avi", ".mpg", ".mpeg":
+		return videoImageType
+	case ".pdf", ".epub", ".odt", ".ods", ".odp", ".docx", ".xlsx", ".pptx":
+		return documentImageType
+	case ".mp3", ".mpa", ".flac", ".ogg", ".opus", ".wav", ".aif", ".wma":
+		return audioImageType
 	default:
 		return unsupportedImageType
 	}
@@ -621,25 +697,37 @@ func (s *ThumbnailService) OpenThumbnail(
 		return rc, contentType, nil
 	}
 
+	err = s.runTask(ctx, generateThumbnailTask{
+		fileID:      id,
+		thumbnailID: thumbnailID,
+		useOriginal: useOriginal,
+		size:        size,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	rc, err = s.cache.Open(thumbnailID.FileID)
+	return rc, contentType, err
+}
+
+// runTask sends the task to the workers if the same task is not already in progress,
+// and waits for its completion. The task result should be checked separately.
+func (s *ThumbnailService) runTask(ctx context.Context, task generateThumbnailTask) error {
 	isInProgress := func(addTask bool) bool {
 		s.inProgressTasksMu.Lock()
 		defer s.inProgressTasksMu.Unlock()
 
-		if _, ok := s.inProgressTasks[thumbnailID]; ok {
+		if _, ok := s.inProgressTasks[task.thumbnailID]; ok {
 			return true
 		}
 		if addTask {
-			s.inProgressTasks[thumbnailID] = struct{}{}
+			s.inProgressTasks[task.thumbnailID] = struct{}{}
 		}
 		return false
 	}
 	if !isInProgress(true) {
-		s.tasksCh <- generateThumbnailTask{
-			fileID:      id,
-			thumbnailID: thumbnailID,
-			useOriginal: useOriginal,
-			size:        size,
-		}
+		s.tasksCh <- task
 	}
 
 	ticker := time.NewTicker(50 * time.Millisecond)
@@ -654,12 +742,10 @@ func (s *ThumbnailService) OpenThumbnail(
 		case <-ticker.C:
 			continue
 		case <-ctx.Done():
-			return nil, "", ctx.Err()
+			return ctx.Err()
 		}
 	}
-
-	rc, err = s.cache.Open(thumbnailID.FileID)
-	return rc, contentType, err
+	return nil
 }
 
 func (s *ThumbnailService) shouldUseOriginalImage(id rview.FileID) bool {
@@ -675,6 +761,18 @@ func (s *ThumbnailService) shouldUseOriginalImage(id rview.FileID) bool {
 	case rawImageType:
 		// Always extract jpeg preview from RAW files.
 		return false
+
+	case videoImageType:
+		// Always extract a frame from videos.
+		return false
+
+	case documentImageType:
+		// Always render the first page of documents.
+		return false
+
+	case audioImageType:
+		// Always extract a cover or render a waveform.
+		return false
 	}
 
 	return id.GetSize() < s.useOriginalImageThresholdSize
@@ -723,7 +821,7 @@ func (s *ThumbnailService) getThumbnailExt(id rview.FileID) (string, error) {
 			newExt = ".jpeg"
 		case avifImageType: // already efficient enough and supported by modern browsers
 			newExt = ""
-		case rawImageType:
+		case rawImageType, videoImageType, documentImageType, audioImageType:
 			newExt = ".jpeg"
 		default:
 			return "", fmt.Errorf("%w: %q", ErrUnsupportedImageFormat, id.GetExt())
@@ -743,7 +841,7 @@ func (s *ThumbnailService) getThumbnailExt(id rview.FileID) (string, error) {
 			newExt = ".avif"
 		case avifImageType: // already .avif
 			newExt = ""
-		case rawImageType:
+		case rawImageType, videoImageType, documentImageType, audioImageType:
 			newExt = ".avif"
 		default:
 			return "", fmt.Errorf("%w: %q", ErrUnsupportedImageFormat, id.GetExt())
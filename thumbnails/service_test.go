@@ -1,8 +1,11 @@
 package thumbnails
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"image"
 	"image/jpeg"
@@ -17,6 +20,7 @@ import (
 	"time"
 
 	"github.com/ShoshinNikita/rview/pkg/cache"
+	"github.com/ShoshinNikita/rview/rclone"
 	"github.com/ShoshinNikita/rview/rview"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -38,7 +42,9 @@ func TestThumbnailService(t *testing.T) {
 		resizeFn func(originalFile, cacheFile string, id ThumbnailID, size ThumbnailSize) error,
 	) *ThumbnailService {
 
-		service := NewThumbnailService(nil, diskCache, cache.NewInMemoryCache(), 2, rview.JpegThumbnails, true)
+		service := NewThumbnailService(nil, diskCache, cache.NewInMemoryCache(), Options{
+			WorkersCount: 2, ThumbnailsFormat: rview.JpegThumbnails, ProcessRawImages: true,
+		})
 		service.useOriginalImageThresholdSize = useOriginalImageThresholdSize
 		service.rclone = rcloneMock{openFileFn: openFileFn}
 		service.resizeFn = func(_ context.Context, originalFile, cacheFile string, id ThumbnailID, size ThumbnailSize) error {
@@ -215,20 +221,100 @@ func TestThumbnailService_CanGenerateThumbnail(t *testing.T) {
 
 	now := time.Now().Unix()
 
-	canGenerate := NewThumbnailService(nil, nil, nil, 0, rview.JpegThumbnails, true).CanGenerateThumbnail
+	canGenerate := NewThumbnailService(nil, nil, nil, Options{
+		ThumbnailsFormat: rview.JpegThumbnails, ProcessRawImages: true, ProcessVideos: true, ProcessAudio: true,
+		ProcessDocuments: true, MaxDocumentSize: 10 << 20,
+	}).CanGenerateThumbnail
 
 	r.True(canGenerate(rview.NewFileID("/home/users/test.png", now, 0)))
 	r.True(canGenerate(rview.NewFileID("/home/users/test.pNg", now, 0)))
 	r.True(canGenerate(rview.NewFileID("/home/users/test.JPG", now, 0)))
 	r.True(canGenerate(rview.NewFileID("/home/users/test with space.jpeg", now, 0)))
 	r.True(canGenerate(rview.NewFileID("/test.gif", now, 0)))
+	r.True(canGenerate(rview.NewFileID("/videos/test.MKV", now, 0)))
+	r.True(canGenerate(rview.NewFileID("/docs/invoice.PDF", now, 0)))
+	r.True(canGenerate(rview.NewFileID("/docs/book.epub", now, 0)))
+	r.True(canGenerate(rview.NewFileID("/docs/book.pdf", now, 10<<20)))
+	r.False(canGenerate(rview.NewFileID("/docs/large book.pdf", now, 10<<20+1)))
+	r.False(canGenerate(rview.NewFileID("/docs/old.doc", now, 0)))
+	r.True(canGenerate(rview.NewFileID("/music/song.mp3", now, 0)))
+	r.True(canGenerate(rview.NewFileID("/music/song.FLAC", now, 0)))
+	r.False(canGenerate(rview.NewFileID("/music/playlist.wpl", now, 0)))
 	r.False(canGenerate(rview.NewFileID("/home/users/x.txt", now, 0)))
+
+	// Videos and audio files are disabled.
+	canGenerate = NewThumbnailService(nil, nil, nil, Options{ThumbnailsFormat: rview.JpegThumbnails}).CanGenerateThumbnail
+	r.True(canGenerate(rview.NewFileID("/test.gif", now, 0)))
+	r.False(canGenerate(rview.NewFileID("/videos/test.mp4", now, 0)))
+	r.False(canGenerate(rview.NewFileID("/music/song.mp3", now, 0)))
+	r.False(canGenerate(rview.NewFileID("/docs/invoice.pdf", now, 0)))
+}
+
+func TestThumbnailService_Storyboard(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	now := time.Now().Unix()
+
+	service := NewThumbnailService(nil, cache.NewInMemoryCache(), nil, Options{
+		WorkersCount: 1, ThumbnailsFormat: rview.JpegThumbnails, ProcessVideos: true,
+	})
+	t.Cleanup(func() {
+		r.NoError(service.Shutdown(context.Background()))
+	})
+
+	r.True(service.CanGenerateStoryboard(rview.NewFileID("/videos/test.MKV", now, 0)))
+	r.False(service.CanGenerateStoryboard(rview.NewFileID("/test.jpg", now, 0)))
+	r.False(
+		NewThumbnailService(nil, nil, nil, Options{ThumbnailsFormat: rview.JpegThumbnails}).
+			CanGenerateStoryboard(rview.NewFileID("/videos/test.mp4", now, 0)),
+	)
+
+	// Storyboard ids depend on mod time and size.
+	id := rview.NewFileID("/videos/boat.mp4", now, 100)
+	imageID, indexID := newStoryboardIDs(id)
+	r.Equal(rview.NewFileID("/videos/boat.mp4.storyboard.jpeg", now, 100), imageID.FileID)
+	r.Equal(rview.NewFileID("/videos/boat.mp4.storyboard.json", now, 100), indexID.FileID)
+
+	newImageID, _ := newStoryboardIDs(rview.NewFileID("/videos/boat.mp4", now+1, 100))
+	r.NotEqual(imageID, newImageID)
+
+	// Use the cached storyboard.
+	wantIndex := StoryboardIndex{
+		TileWidth: 256, TileHeight: 144, Columns: 4, Rows: 1,
+		Frames: []StoryboardFrame{
+			{Time: 1.5, X: 0, Y: 0},
+			{Time: 4.5, X: 256, Y: 0},
+		},
+	}
+	r.NoError(service.cache.Write(imageID.FileID, strings.NewReader("image")))
+	r.NoError(service.cache.Write(indexID.FileID, strings.NewReader(
+		`{"tile_width":256,"tile_height":144,"columns":4,"rows":1,"frames":[{"time":1.5,"x":0,"y":0},{"time":4.5,"x":256,"y":0}]}`,
+	)))
+
+	index, err := service.GetStoryboardIndex(t.Context(), id)
+	r.NoError(err)
+	r.Equal(wantIndex, index)
+
+	rc, err := service.OpenStoryboard(t.Context(), id)
+	r.NoError(err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	r.NoError(err)
+	r.Equal("image", string(data))
+
+	// Invalid files.
+	_, err = service.OpenStoryboard(t.Context(), rview.NewFileID("/test.jpg", now, 100))
+	r.Error(err)
+	_, err = service.OpenStoryboard(t.Context(), rview.NewFileID("/videos/boat.mp4", now, 0))
+	r.Error(err)
 }
 
 func TestThumbnailService_NewThumbnailID(t *testing.T) {
 	t.Parallel()
 
-	service := NewThumbnailService(nil, nil, nil, 0, rview.JpegThumbnails, true)
+	service := NewThumbnailService(nil, nil, nil, Options{ThumbnailsFormat: rview.JpegThumbnails, ProcessRawImages: true})
 
 	for _, tt := range []struct {
 		path          string
@@ -251,6 +337,14 @@ func TestThumbnailService_NewThumbnailID(t *testing.T) {
 			path: "/x/y/z/screenshot.PNG", size: ThumbnailLarge,
 			wantThumbnail: "/x/y/z/screenshot.PNG.thumbnail-large.jpeg",
 		},
+		{
+			path: "/videos/boat.mp4", size: ThumbnailSmall,
+			wantThumbnail: "/videos/boat.mp4.thumbnail-small.jpeg",
+		},
+		{
+			path: "/docs/manual.pdf", size: ThumbnailMedium,
+			wantThumbnail: "/docs/manual.pdf.thumbnail-medium.jpeg",
+		},
 	} {
 		id := rview.NewFileID(tt.path, 33, 15)
 		thumbnail, err := service.newThumbnailID(id, tt.size)
@@ -346,7 +440,9 @@ func TestThumbnailService_ImageType(t *testing.T) {
 							return io.NopCloser(bytes.NewReader(img.rawImage)), nil
 						},
 					}
-					service := NewThumbnailService(rclone, diskCache, cache.NewInMemoryCache(), 1, thumbnailsFormat, true)
+					service := NewThumbnailService(rclone, diskCache, cache.NewInMemoryCache(), Options{
+						WorkersCount: 1, ThumbnailsFormat: thumbnailsFormat, ProcessRawImages: true,
+					})
 
 					fileID := rview.NewFileID(tt.file, 0, img.size)
 					rc, contentType, err := service.OpenThumbnail(t.Context(), fileID, "")
@@ -387,7 +483,9 @@ func TestThumbnailService_AllImageTypes(t *testing.T) {
 						return io.NopCloser(bytes.NewReader(originalImage)), nil
 					},
 				}
-				thumbnailService := NewThumbnailService(mock, diskCache, cache.NewInMemoryCache(), 1, format, true)
+				thumbnailService := NewThumbnailService(mock, diskCache, cache.NewInMemoryCache(), Options{
+					WorkersCount: 1, ThumbnailsFormat: format, ProcessRawImages: true,
+				})
 				thumbnailService.GenerateThumbnailsForSmallFiles()
 
 				ctx, cancel := context.WithTimeout(t.Context(), time.Second)
@@ -444,7 +542,9 @@ func TestThumbnailService_openImage(t *testing.T) {
 			return io.NopCloser(bytes.NewReader([]byte("hello world"))), nil
 		},
 	}
-	service := NewThumbnailService(rclone, nil, cache.NewInMemoryCache(), 1, rview.JpegThumbnails, true)
+	service := NewThumbnailService(rclone, nil, cache.NewInMemoryCache(), Options{
+		WorkersCount: 1, ThumbnailsFormat: rview.JpegThumbnails, ProcessRawImages: true,
+	})
 
 	getFile := func() (string, error) {
 		rc, err := service.openImage(t.Context(), rview.NewFileID("1.txt", 0, 0))
@@ -491,6 +591,7 @@ func TestThumbnailService_openImage(t *testing.T) {
 type rcloneMock struct {
 	openFileFn         func(context.Context, rview.FileID) (io.ReadCloser, error)
 	requestFileRangeFn func(ctx context.Context, id rview.FileID, start, end int) (io.ReadCloser, error)
+	getDirInfoFn       func(ctx context.Context, path string) (*rclone.DirInfo, error)
 }
 
 func (m rcloneMock) OpenFile(ctx context.Context, id rview.FileID) (io.ReadCloser, error) {
@@ -501,6 +602,309 @@ func (m rcloneMock) RequestFileRange(ctx context.Context, id rview.FileID, start
 	return m.requestFileRangeFn(ctx, id, start, end)
 }
 
+func (m rcloneMock) GetDirInfo(ctx context.Context, path string, _, _ string) (*rclone.DirInfo, error) {
+	return m.getDirInfoFn(ctx, path)
+}
+
+func TestThumbnailService_openDocumentPreview(t *testing.T) {
+	t.Parallel()
+
+	newZip := func(t *testing.T, files map[string]string) []byte {
+		t.Helper()
+
+		buf := bytes.NewBuffer(nil)
+		w := zip.NewWriter(buf)
+		for name, content := range files {
+			f, err := w.Create(name)
+			require.NoError(t, err)
+			_, err = f.Write([]byte(content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	const epubContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles>
+		<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+	</rootfiles>
+</container>`
+
+	for _, tt := range []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "book.epub",
+			files: map[string]string{
+				"META-INF/container.xml": epubContainer,
+				"OEBPS/content.opf": `<package version="2.0">
+					<metadata><meta name="cover" content="cover-id"/></metadata>
+					<manifest>
+						<item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+						<item id="other" href="images/other.jpg" media-type="image/jpeg"/>
+						<item id="cover-id" href="images/cover%20image.jpg" media-type="image/jpeg"/>
+					</manifest>
+				</package>`,
+				"OEBPS/images/other.jpg":       "other",
+				"OEBPS/images/cover image.jpg": "epub2 cover",
+			},
+			want: "epub2 cover",
+		},
+		{
+			name: "book3.epub",
+			files: map[string]string{
+				"META-INF/container.xml": epubContainer,
+				"OEBPS/content.opf": `<package version="3.0">
+					<manifest>
+						<item id="img" href="img.png" media-type="image/png"/>
+						<item id="c" href="cover.png" media-type="image/png" properties="cover-image"/>
+					</manifest>
+				</package>`,
+				"OEBPS/img.png":   "img",
+				"OEBPS/cover.png": "epub3 cover",
+			},
+			want: "epub3 cover",
+		},
+		{
+			name: "no-cover.epub",
+			files: map[string]string{
+				"META-INF/container.xml": epubContainer,
+				"OEBPS/content.opf":      `<package><manifest><item id="img" href="img.png" media-type="image/png"/></manifest></package>`,
+				"OEBPS/img.png":          "img",
+			},
+			wantErr: true,
+		},
+		{
+			name: "text.odt",
+			files: map[string]string{
+				"content.xml":              "",
+				"Thumbnails/thumbnail.png": "odt thumbnail",
+			},
+			want: "odt thumbnail",
+		},
+		{
+			name: "table.xlsx",
+			files: map[string]string{
+				"xl/workbook.xml":         "",
+				"docProps/thumbnail.jpeg": "xlsx thumbnail",
+			},
+			want: "xlsx thumbnail",
+		},
+		{
+			name: "text.docx",
+			files: map[string]string{
+				"word/document.xml":      "",
+				"docProps/thumbnail.wmf": "wmf thumbnail",
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := require.New(t)
+
+			data := newZip(t, tt.files)
+			mock := rcloneMock{
+				requestFileRangeFn: func(_ context.Context, _ rview.FileID, start, end int) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(data[start : end+1])), nil
+				},
+			}
+			service := NewThumbnailService(mock, nil, nil, Options{ThumbnailsFormat: rview.JpegThumbnails})
+
+			rc, err := service.openDocumentPreview(t.Context(), rview.NewFileID("/"+tt.name, 0, int64(len(data))))
+			if tt.wantErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+			defer rc.Close()
+
+			got, err := io.ReadAll(rc)
+			r.NoError(err)
+			r.Equal(tt.want, string(got))
+		})
+	}
+}
+
+func TestThumbnailService_openAudioPreview(t *testing.T) {
+	t.Parallel()
+
+	flacPicture := func(pictureType uint32, data string) []byte {
+		var b []byte
+		b = binary.BigEndian.AppendUint32(b, pictureType)
+		b = binary.BigEndian.AppendUint32(b, uint32(len("image/jpeg")))
+		b = append(b, "image/jpeg"...)
+		b = binary.BigEndian.AppendUint32(b, 0) // description
+		b = append(b, make([]byte, 16)...)      // width, height, depth, colors
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+		return append(b, data...)
+	}
+
+	id3v23 := func(frames ...[]byte) []byte {
+		body := bytes.Join(frames, nil)
+		body = append(body, make([]byte, 32)...) // padding
+		size := len(body)
+		b := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+		b = append(b, body...)
+		return append(b, "mp3 frames"...)
+	}
+	id3v23Frame := func(id string, data []byte) []byte {
+		b := []byte(id)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+		b = append(b, 0, 0)
+		return append(b, data...)
+	}
+	apic := func(pictureType byte, data string) []byte {
+		// UTF-16 description "ab" with BOM.
+		b := []byte{1}
+		b = append(b, "image/jpeg\x00"...)
+		b = append(b, pictureType)
+		b = append(b, 0xff, 0xfe, 'a', 0, 'b', 0, 0, 0)
+		return append(b, data...)
+	}
+
+	flac := func(blocks ...[]byte) []byte {
+		b := []byte("fLaC")
+		for i, block := range blocks {
+			blockType := block[0]
+			if i == len(blocks)-1 {
+				blockType |= 0x80
+			}
+			size := len(block) - 1
+			b = append(b, blockType, byte(size>>16), byte(size>>8), byte(size))
+			b = append(b, block[1:]...)
+		}
+		return append(b, "flac frames"...)
+	}
+
+	ogg := func(packets ...[]byte) []byte {
+		var segments, body []byte
+		for _, p := range packets {
+			n := len(p)
+			for ; n >= 255; n -= 255 {
+				segments = append(segments, 255)
+			}
+			segments = append(segments, byte(n))
+			body = append(body, p...)
+		}
+		// Split segments into pages to check packets that span several pages.
+		var b []byte
+		for len(segments) > 0 {
+			n := min(len(segments), 3)
+			pageSize := 0
+			for _, v := range segments[:n] {
+				pageSize += int(v)
+			}
+			b = append(b, "OggS"...)
+			b = append(b, make([]byte, 22)...) // version, type, granule, serial, sequence, checksum
+			b = append(b, byte(n))
+			b = append(b, segments[:n]...)
+			b = append(b, body[:pageSize]...)
+			segments, body = segments[n:], body[pageSize:]
+		}
+		return b
+	}
+	vorbisComments := func(comments ...string) []byte {
+		b := []byte("\x03vorbis")
+		b = binary.LittleEndian.AppendUint32(b, 6)
+		b = append(b, "vendor"...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+		for _, c := range comments {
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+			b = append(b, c...)
+		}
+		return b
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			name: "front-cover.mp3",
+			data: id3v23(
+				id3v23Frame("TIT2", []byte("\x00title")),
+				id3v23Frame("APIC", apic(0, "other picture")),
+				id3v23Frame("APIC", apic(3, "front cover")),
+			),
+			want: "front cover",
+		},
+		{
+			name: "other-picture.mp3",
+			data: id3v23(id3v23Frame("APIC", apic(0, "other picture"))),
+			want: "other picture",
+		},
+		{
+			name: "song.flac",
+			data: flac(
+				append([]byte{0}, make([]byte, 34)...), // STREAMINFO
+				append([]byte{6}, flacPicture(3, "flac cover")...),
+			),
+			want: "flac cover",
+		},
+		{
+			name: "song.ogg",
+			data: ogg(
+				[]byte("\x01vorbis id header"),
+				vorbisComments(
+					"TITLE=song",
+					"metadata_block_picture="+base64.StdEncoding.EncodeToString(flacPicture(3, strings.Repeat("ogg cover", 100))),
+				),
+				[]byte("\x05vorbis setup header"),
+			),
+			want: strings.Repeat("ogg cover", 100),
+		},
+		{
+			name: "no-cover.wav",
+			data: []byte("RIFF....WAVEfmt "),
+			want: "dir cover",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := require.New(t)
+
+			mock := rcloneMock{
+				requestFileRangeFn: func(_ context.Context, _ rview.FileID, start, end int) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(tt.data[start : end+1])), nil
+				},
+				getDirInfoFn: func(_ context.Context, path string) (*rclone.DirInfo, error) {
+					r.Equal("/music/", path)
+
+					return &rclone.DirInfo{
+						Entries: []rclone.DirEntry{
+							{Leaf: "cover.png", Size: 100},
+							{Leaf: "Folder.jpg", Size: 100},
+							{Leaf: "Cover.JPG", Size: 100},
+						},
+					}, nil
+				},
+				openFileFn: func(_ context.Context, id rview.FileID) (io.ReadCloser, error) {
+					r.Equal("/music/Cover.JPG", id.GetPath())
+
+					return io.NopCloser(strings.NewReader("dir cover")), nil
+				},
+			}
+			service := NewThumbnailService(mock, nil, nil, Options{ThumbnailsFormat: rview.JpegThumbnails})
+
+			rc, err := service.openAudioPreview(t.Context(), rview.NewFileID("/music/"+tt.name, 0, int64(len(tt.data))))
+			r.NoError(err)
+			defer rc.Close()
+
+			got, err := io.ReadAll(rc)
+			r.NoError(err)
+			r.Equal(tt.want, string(got))
+		})
+	}
+}
+
 func TestThumbnailService_extractPreviewFromRawImage(t *testing.T) {
 	var jpgFromRaw []byte
 	{
@@ -529,7 +933,9 @@ func TestThumbnailService_extractPreviewFromRawImage(t *testing.T) {
 			return io.NopCloser(bytes.NewReader(jpgFromRaw)), nil
 		},
 	}
-	service := NewThumbnailService(mock, cache.NewInMemoryCache(), cache.NewInMemoryCache(), 1, rview.AvifThumbnails, true)
+	service := NewThumbnailService(mock, cache.NewInMemoryCache(), cache.NewInMemoryCache(), Options{
+		WorkersCount: 1, ThumbnailsFormat: rview.AvifThumbnails, ProcessRawImages: true,
+	})
 
 	extract := func(t *testing.T, name string) ([]byte, []Call) {
 		t.Helper()
@@ -0,0 +1,238 @@
+package thumbnails
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/ranged"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+const (
+	storyboardFrames     = 12
+	storyboardColumns    = 4
+	storyboardTileWidth  = 256
+	storyboardTileHeight = 144
+)
+
+// StoryboardIndex describes the location of frames in the storyboard image.
+type StoryboardIndex struct {
+	TileWidth  int               `json:"tile_width"`
+	TileHeight int               `json:"tile_height"`
+	Columns    int               `json:"columns"`
+	Rows       int               `json:"rows"`
+	Frames     []StoryboardFrame `json:"frames"`
+}
+
+type StoryboardFrame struct {
+	// Time is the frame position in seconds.
+	Time float64 `json:"time"`
+	// X and Y are the coordinates of the top left corner of the frame.
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// CanGenerateStoryboard detects if we can generate a storyboard for a file based on its filename.
+func (s *ThumbnailService) CanGenerateStoryboard(id rview.FileID) bool {
+	return s.processVideos && getImageType(id) == videoImageType
+}
+
+// OpenStoryboard returns [io.ReadCloser] for the jpeg image with evenly spaced video frames.
+// Use [ThumbnailService.GetStoryboardIndex] to get positions of the frames. It generates
+// a new storyboard if needed.
+func (s *ThumbnailService) OpenStoryboard(ctx context.Context, id rview.FileID) (io.ReadCloser, error) {
+	imageID, _, err := s.prepareStoryboard(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.cache.Open(imageID.FileID)
+}
+
+// GetStoryboardIndex returns the index of the storyboard image. It generates a new storyboard if needed.
+func (s *ThumbnailService) GetStoryboardIndex(ctx context.Context, id rview.FileID) (StoryboardIndex, error) {
+	_, indexID, err := s.prepareStoryboard(ctx, id)
+	if err != nil {
+		return StoryboardIndex{}, err
+	}
+
+	rc, err := s.cache.Open(indexID.FileID)
+	if err != nil {
+		return StoryboardIndex{}, err
+	}
+	defer rc.Close()
+
+	var index StoryboardIndex
+	if err := json.NewDecoder(rc).Decode(&index); err != nil {
+		return StoryboardIndex{}, fmt.Errorf("couldn't decode storyboard index: %w", err)
+	}
+	return index, nil
+}
+
+// prepareStoryboard generates a storyboard if it doesn't exist yet. The index is written
+// after the image, so its presence means that the storyboard is ready.
+func (s *ThumbnailService) prepareStoryboard(ctx context.Context, id rview.FileID) (imageID, indexID ThumbnailID, err error) {
+	if s.stopped.Load() {
+		return ThumbnailID{}, ThumbnailID{}, errors.New("service was stopped")
+	}
+
+	if !s.CanGenerateStoryboard(id) {
+		return ThumbnailID{}, ThumbnailID{}, fmt.Errorf("can't generate storyboard for %q", id.GetExt())
+	}
+	if fileSize := id.GetSize(); fileSize <= 0 {
+		return ThumbnailID{}, ThumbnailID{}, fmt.Errorf("file id has invalid size: %d", fileSize)
+	}
+
+	imageID, indexID = newStoryboardIDs(id)
+
+	if rc, err := s.cache.Open(indexID.FileID); err == nil {
+		rc.Close()
+		return imageID, indexID, nil
+	}
+
+	err = s.runTask(ctx, generateThumbnailTask{
+		fileID:      id,
+		thumbnailID: imageID,
+		storyboard:  true,
+	})
+	if err != nil {
+		return ThumbnailID{}, ThumbnailID{}, err
+	}
+	return imageID, indexID, nil
+}
+
+// newStoryboardIDs returns ids of the storyboard image and its index. The ids keep mod time
+// and size of the original file, so the storyboard is regenerated after the file change.
+func newStoryboardIDs(id rview.FileID) (imageID, indexID ThumbnailID) {
+	newID := func(suffix string) ThumbnailID {
+		return ThumbnailID{
+			FileID: rview.NewFileID(id.GetPath()+suffix, id.GetModTime(), id.GetSize()),
+		}
+	}
+	return newID(".storyboard.jpeg"), newID(".storyboard.json")
+}
+
+// processStoryboardTask extracts evenly spaced frames from a video and joins them into a single
+// image. Like with thumbnails, ffmpeg reads the video via [ranged.Server]. So, only the parts
+// around the frames are downloaded.
+func (s *ThumbnailService) processStoryboardTask(ctx context.Context, task generateThumbnailTask) error {
+	imageID, indexID := newStoryboardIDs(task.fileID)
+
+	server, err := ranged.NewServer(ctx, task.fileID, s.rclone.RequestFileRange)
+	if err != nil {
+		return fmt.Errorf("couldn't start server for ffmpeg: %w", err)
+	}
+	defer server.Close()
+
+	duration, err := probeVideoDuration(ctx, server.URL())
+	if err != nil {
+		return err
+	}
+	if duration <= 0 {
+		return errors.New("video has unknown duration")
+	}
+
+	tempDir, err := os.MkdirTemp("", "rview-storyboard-*")
+	if err != nil {
+		return fmt.Errorf("couldn't create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	index := StoryboardIndex{
+		TileWidth:  storyboardTileWidth,
+		TileHeight: storyboardTileHeight,
+		Columns:    storyboardColumns,
+	}
+	var framePaths []string
+	for i := range storyboardFrames {
+		// Take frames from the middle of the intervals to skip the very first and last frames.
+		pos := duration * time.Duration(2*i+1) / (2 * storyboardFrames)
+
+		frame, err := extractFrameWithFFmpeg(ctx, server.URL(), pos, storyboardTileFilters())
+		if err != nil {
+			return fmt.Errorf("couldn't extract frame at %s: %w", pos, err)
+		}
+		if len(frame) == 0 {
+			// The duration can be inaccurate, so there can be no frames at the end of a video.
+			continue
+		}
+
+		path := filepath.Join(tempDir, "frame-"+strconv.Itoa(i)+".jpeg")
+		if err := os.WriteFile(path, frame, 0600); err != nil {
+			return fmt.Errorf("couldn't save frame: %w", err)
+		}
+
+		n := len(framePaths)
+		framePaths = append(framePaths, path)
+		index.Frames = append(index.Frames, StoryboardFrame{
+			Time: pos.Seconds(),
+			X:    (n % storyboardColumns) * storyboardTileWidth,
+			Y:    (n / storyboardColumns) * storyboardTileHeight,
+		})
+	}
+	if len(framePaths) == 0 {
+		return errors.New("ffmpeg returned no frames")
+	}
+	index.Rows = (len(framePaths) + storyboardColumns - 1) / storyboardColumns
+
+	imagePath, err := s.cache.GetFilepath(imageID.FileID)
+	if err != nil {
+		return fmt.Errorf("couldn't get path for a storyboard file: %w", err)
+	}
+	if err := joinImagesWithVips(ctx, framePaths, imagePath, storyboardColumns); err != nil {
+		if err := s.cache.Remove(imageID.FileID); err != nil {
+			rlog.Warnf("couldn't remove storyboard for %s after join error: %s", task.fileID, err)
+		}
+		return err
+	}
+
+	rawIndex, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal storyboard index: %w", err)
+	}
+	if err := s.cache.Write(indexID.FileID, bytes.NewReader(rawIndex)); err != nil {
+		return fmt.Errorf("couldn't write storyboard index: %w", err)
+	}
+	return nil
+}
+
+// storyboardTileFilters returns ffmpeg filters that fit a frame into a storyboard tile. Frames
+// with a different aspect ratio are padded with black.
+func storyboardTileFilters() []string {
+	w := strconv.Itoa(storyboardTileWidth)
+	h := strconv.Itoa(storyboardTileHeight)
+	return []string{
+		"scale=" + w + ":" + h + ":force_original_aspect_ratio=decrease",
+		"pad=" + w + ":" + h + ":(ow-iw)/2:(oh-ih)/2",
+		"setsar=1",
+	}
+}
+
+// joinImagesWithVips joins images of the same size into a grid.
+func joinImagesWithVips(ctx context.Context, images []string, output string, columns int) error {
+	//nolint:gosec
+	cmd := exec.CommandContext(
+		ctx,
+		"vips", "arrayjoin",
+		strings.Join(images, " "),
+		output+"[Q=75,optimize_coding]",
+		"--across", strconv.Itoa(min(columns, len(images))),
+	)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("couldn't join images: %w, stderr: %q", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package thumbnails
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/ranged"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// extractFrameFromVideo extracts a representative frame from a video. ffmpeg reads the video via
+// [ranged.Server], so only the necessary parts of the file are downloaded: usually the headers, the
+// index and a few seconds of the video around the seek position.
+func (s *ThumbnailService) extractFrameFromVideo(ctx context.Context, id rview.FileID) (io.ReadCloser, error) {
+	server, err := ranged.NewServer(ctx, id, s.rclone.RequestFileRange)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start server for ffmpeg: %w", err)
+	}
+	defer server.Close()
+
+	duration, err := probeVideoDuration(ctx, server.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip intros and fade-ins: they rarely represent the video.
+	frame, err := extractFrameWithFFmpeg(ctx, server.URL(), duration/10, representativeFrameFilters())
+	if err == nil && len(frame) == 0 {
+		// The video is too short, or all frames after the seek position are too dark.
+		rlog.Debugf("no suitable frames for %q, use the first one", id.GetPath())
+
+		frame, err = extractFrameWithFFmpeg(ctx, server.URL(), 0, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) == 0 {
+		return nil, errors.New("ffmpeg returned no frames")
+	}
+	return io.NopCloser(bytes.NewReader(frame)), nil
+}
+
+// probeVideoDuration returns the video duration. It returns 0 if the duration is unknown.
+func probeVideoDuration(ctx context.Context, url string) (time.Duration, error) {
+	output, err := runFFmpegCmd(
+		ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		url,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	rawDuration := strings.TrimSpace(string(output))
+	if rawDuration == "" || rawDuration == "N/A" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(rawDuration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't parse video duration %q: %w", rawDuration, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// representativeFrameFilters returns ffmpeg filters that choose the most representative frame
+// among non-dark frames.
+func representativeFrameFilters() []string {
+	const (
+		// minAverageLuma is the minimal average luma of a frame. Black has luma 16 in the limited range.
+		minAverageLuma = "24"
+		// framesToAnalyze is the number of frames to choose a representative one from.
+		framesToAnalyze = "30"
+	)
+	return []string{
+		"signalstats",
+		"metadata=mode=select:key=lavfi.signalstats.YAVG:function=greater:value=" + minAverageLuma,
+		"thumbnail=" + framesToAnalyze,
+	}
+}
+
+// extractFrameWithFFmpeg returns the first jpeg frame after the seek position that passes through
+// the filters. Only 30 seconds after the seek position are analyzed. Empty output means that there
+// are no suitable frames.
+func extractFrameWithFFmpeg(ctx context.Context, url string, seek time.Duration, filters []string) ([]byte, error) {
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-ss", strconv.FormatFloat(seek.Seconds(), 'f', 3, 64),
+		"-t", "30", // limit the amount of data to download
+		"-i", url,
+		"-an", "-sn", "-dn", // ignore all streams except video
+	}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	args = append(args,
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-c:v", "mjpeg",
+		"-q:v", "2", // best quality, the frame will be resized anyway
+		"pipe:1",
+	)
+	return runFFmpegCmd(ctx, "ffmpeg", args...)
+}
+
+func runFFmpegCmd(ctx context.Context, name string, args ...string) ([]byte, error) {
+	stderr := bytes.NewBuffer(nil)
+
+	cmd := exec.CommandContext(ctx, name, args...) //nolint:gosec
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w, stderr: %q", name, err, stderr.String())
+	}
+	return output, nil
+}
@@ -0,0 +1,138 @@
+package transcoding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/ShoshinNikita/rview/pkg/ranged"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// transcodeWithFFmpeg transcodes a video into fragmented MP4. Unlike regular MP4, fragmented
+// MP4 can be played while it is being written. Streams that are already supported by browsers
+// are copied without re-encoding.
+//
+// After transcoding the video is remuxed into regular MP4 with the index at the beginning,
+// so browsers can seek without downloading the entire file.
+func (s *Service) transcodeWithFFmpeg(ctx context.Context, id rview.FileID, output string) error {
+	server, err := ranged.NewServer(ctx, id, s.rclone.RequestFileRange)
+	if err != nil {
+		return fmt.Errorf("couldn't start server for ffmpeg: %w", err)
+	}
+	defer server.Close()
+
+	videoCodec, audioCodec, err := probeCodecs(ctx, server.URL())
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", server.URL(),
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-sn", "-dn",
+	}
+	if videoCodec == "h264" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args,
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-crf", "23",
+			"-pix_fmt", "yuv420p",
+			"-vf", "scale='min(1920,iw)':-2", // don't upscale, keep the aspect ratio
+		)
+	}
+	switch audioCodec {
+	case "aac", "mp3":
+		args = append(args, "-c:a", "copy")
+	default:
+		args = append(args, "-c:a", "aac", "-b:a", "160k", "-ac", "2")
+	}
+	args = append(args,
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4",
+		"-y", output,
+	)
+	if _, err := runFFmpegCmd(ctx, "ffmpeg", args...); err != nil {
+		return err
+	}
+
+	// Replace the file instead of overwriting it: clients that are still reading
+	// the fragmented MP4 must not be affected.
+	tempOutput := output + ".faststart"
+	_, err = runFFmpegCmd(
+		ctx, "ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", output,
+		"-map", "0",
+		"-c", "copy",
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		"-y", tempOutput,
+	)
+	if err != nil {
+		_ = os.Remove(tempOutput)
+		return fmt.Errorf("couldn't remux video: %w", err)
+	}
+	if err := os.Rename(tempOutput, output); err != nil {
+		return fmt.Errorf("couldn't replace fragmented video: %w", err)
+	}
+	return nil
+}
+
+// probeCodecs returns codecs of the first video and audio streams. audioCodec is empty
+// if there are no audio streams.
+func probeCodecs(ctx context.Context, url string) (videoCodec, audioCodec string, err error) {
+	output, err := runFFmpegCmd(
+		ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name",
+		"-of", "json",
+		url,
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	var resp struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return "", "", fmt.Errorf("couldn't decode ffprobe output: %w", err)
+	}
+	for _, stream := range resp.Streams {
+		switch {
+		case stream.CodecType == "video" && videoCodec == "":
+			videoCodec = stream.CodecName
+		case stream.CodecType == "audio" && audioCodec == "":
+			audioCodec = stream.CodecName
+		}
+	}
+	if videoCodec == "" {
+		return "", "", fmt.Errorf("no video streams")
+	}
+	return videoCodec, audioCodec, nil
+}
+
+func runFFmpegCmd(ctx context.Context, name string, args ...string) ([]byte, error) {
+	stderr := bytes.NewBuffer(nil)
+
+	cmd := exec.CommandContext(ctx, name, args...) //nolint:gosec
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w, stderr: %q", name, err, stderr.String())
+	}
+	return output, nil
+}
@@ -0,0 +1,29 @@
+package transcoding
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+var ErrNoopTranscodingService = errors.New("noop transcoding service")
+
+type NoopService struct{}
+
+func NewNoopService() *NoopService {
+	return &NoopService{}
+}
+
+func (NoopService) CanTranscode(rview.FileID) bool {
+	return false
+}
+
+func (NoopService) Open(context.Context, rview.FileID) (io.ReadCloser, bool, error) {
+	return nil, false, ErrNoopTranscodingService
+}
+
+func (NoopService) Shutdown(context.Context) error {
+	return nil
+}
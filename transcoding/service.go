@@ -0,0 +1,258 @@
+package transcoding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// Service transcodes videos that can't be played by browsers (.mkv, .avi and etc.) into MP4.
+// Transcoded videos are saved to the cache. While transcoding is in progress, clients receive
+// the output of ffmpeg as soon as it is written.
+type Service struct {
+	rclone Rclone
+	cache  Cache
+
+	transcodeFn func(ctx context.Context, id rview.FileID, output string) error
+
+	// workersSem limits the number of parallel transcoding jobs.
+	workersSem chan struct{}
+	jobs       map[rview.FileID]*job
+	jobsMu     sync.Mutex
+	jobsWg     sync.WaitGroup
+
+	stopped *atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+type Rclone interface {
+	RequestFileRange(ctx context.Context, id rview.FileID, rangeStart, rangeEnd int) (io.ReadCloser, error)
+}
+
+type Cache interface {
+	Open(id rview.FileID) (io.ReadCloser, error)
+	GetFilepath(id rview.FileID) (path string, err error)
+}
+
+type job struct {
+	// partPath is the path of the file ffmpeg writes to. It is renamed to the cache
+	// file after successful transcoding.
+	partPath string
+	doneCh   chan struct{}
+	err      error
+}
+
+// CheckDeps checks that all external tools are installed.
+func CheckDeps() error {
+	ctx := context.Background()
+
+	for _, name := range []string{"ffmpeg", "ffprobe"} {
+		if err := exec.CommandContext(ctx, name, "-version").Run(); err != nil {
+			return fmt.Errorf("%s is not installed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// NewService prepares a new service for video transcoding. workersCount defines the max number
+// of videos that can be transcoded at the same time.
+func NewService(rclone Rclone, cache Cache, workersCount int) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Service{
+		rclone: rclone,
+		cache:  cache,
+		//
+		workersSem: make(chan struct{}, max(workersCount, 1)),
+		jobs:       make(map[rview.FileID]*job),
+		//
+		stopped: new(atomic.Bool),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	s.transcodeFn = s.transcodeWithFFmpeg
+
+	return s
+}
+
+// CanTranscode detects if a video should be transcoded based on its filename. Browsers can
+// play .mp4 and .webm files, so they are never transcoded.
+func (*Service) CanTranscode(id rview.FileID) bool {
+	switch id.GetExt() {
+	case ".avi", ".mkv", ".mov", ".mpg", ".mpeg":
+		return true
+	default:
+		return false
+	}
+}
+
+// Open returns the transcoded video. It starts transcoding if needed. If transcoding is in
+// progress, isComplete is false and the returned reader follows the ffmpeg output until
+// the end of transcoding. Otherwise, the reader also implements [io.Seeker].
+func (s *Service) Open(ctx context.Context, id rview.FileID) (rc io.ReadCloser, isComplete bool, err error) {
+	if s.stopped.Load() {
+		return nil, false, errors.New("service was stopped")
+	}
+	if !s.CanTranscode(id) {
+		return nil, false, fmt.Errorf("can't transcode %q", id.GetExt())
+	}
+	if fileSize := id.GetSize(); fileSize <= 0 {
+		return nil, false, fmt.Errorf("file id has invalid size: %d", fileSize)
+	}
+
+	cacheID := newCacheID(id)
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	j, ok := s.jobs[cacheID]
+	if !ok {
+		if rc, err := s.cache.Open(cacheID); err == nil {
+			return rc, true, nil
+		}
+
+		j, err = s.startJob(id, cacheID)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	// It is safe to open the file because it is renamed only under the lock.
+	f, err := os.Open(j.partPath)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't open transcoded file: %w", err)
+	}
+	return &followReader{ctx: ctx, job: j, f: f}, false, nil
+}
+
+// newCacheID returns the id of the transcoded video. It keeps mod time and size of the
+// original file, so the video is transcoded again after the file change.
+func newCacheID(id rview.FileID) rview.FileID {
+	return rview.NewFileID(id.GetPath()+".transcoded.mp4", id.GetModTime(), id.GetSize())
+}
+
+// startJob creates an empty output file and starts transcoding in the background.
+// It must be called under the jobs lock.
+func (s *Service) startJob(id, cacheID rview.FileID) (*job, error) {
+	cachePath, err := s.cache.GetFilepath(cacheID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get path for a transcoded file: %w", err)
+	}
+
+	j := &job{
+		partPath: cachePath + ".part",
+		doneCh:   make(chan struct{}),
+	}
+	f, err := os.Create(j.partPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create file for transcoding: %w", err)
+	}
+	_ = f.Close()
+
+	s.jobs[cacheID] = j
+	s.jobsWg.Go(func() {
+		now := time.Now()
+		err := s.runJob(id, j)
+		if err != nil {
+			err = fmt.Errorf("couldn't transcode %q: %w", id.GetPath(), err)
+			rlog.Error(err)
+		} else {
+			rlog.Debugf("%q was transcoded in %s", id.GetPath(), time.Since(now))
+		}
+
+		s.jobsMu.Lock()
+		defer s.jobsMu.Unlock()
+
+		if err == nil {
+			err = os.Rename(j.partPath, cachePath)
+		}
+		if err != nil {
+			if err := os.Remove(j.partPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				rlog.Warnf("couldn't remove transcoded file after error: %s", err)
+			}
+		}
+
+		j.err = err
+		delete(s.jobs, cacheID)
+		close(j.doneCh)
+	})
+	return j, nil
+}
+
+func (s *Service) runJob(id rview.FileID, j *job) error {
+	select {
+	case s.workersSem <- struct{}{}:
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+	defer func() { <-s.workersSem }()
+
+	return s.transcodeFn(s.ctx, id, j.partPath)
+}
+
+// Shutdown stops all transcoding jobs and waits for them to finish.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.stopped.Store(true)
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.jobsWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// followReader reads a file that is being written by ffmpeg. It returns [io.EOF] only
+// after the end of transcoding.
+type followReader struct {
+	ctx context.Context
+	job *job
+	f   *os.File
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.f.Read(p)
+		if n > 0 || (err != nil && !errors.Is(err, io.EOF)) {
+			return n, err
+		}
+
+		select {
+		case <-r.job.doneCh:
+			if r.job.err != nil {
+				return 0, r.job.err
+			}
+			// Read the rest of the file.
+			return r.f.Read(p)
+
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *followReader) Close() error {
+	return r.f.Close()
+}
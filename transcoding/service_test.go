@@ -0,0 +1,164 @@
+package transcoding
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/pkg/cache"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService(t *testing.T) {
+	t.Parallel()
+
+	newService := func(t *testing.T, workersCount int, transcodeFn func(ctx context.Context, output string) error) *Service {
+		diskCache, err := cache.NewDiskCache("", t.TempDir(), cache.Options{DisableCleaner: true})
+		require.NoError(t, err)
+
+		s := NewService(nil, diskCache, workersCount)
+		s.transcodeFn = func(ctx context.Context, _ rview.FileID, output string) error {
+			return transcodeFn(ctx, output)
+		}
+		t.Cleanup(func() {
+			require.NoError(t, s.Shutdown(context.Background()))
+		})
+		return s
+	}
+
+	// writeChunks appends chunks to the output file with a delay to simulate ffmpeg.
+	writeChunks := func(output string, chunks ...string) error {
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		for _, chunk := range chunks {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := f.WriteString(chunk); err != nil {
+				return err
+			}
+		}
+		return f.Close()
+	}
+
+	t.Run("follow output", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var calls atomic.Int32
+		s := newService(t, 1, func(_ context.Context, output string) error {
+			calls.Add(1)
+			return writeChunks(output, "hello", " ", "world")
+		})
+
+		id := rview.NewFileID("/video.mkv", time.Now().Unix(), 100)
+
+		// Clients that open the video during transcoding receive the entire output.
+		var readers []io.ReadCloser
+		for range 2 {
+			rc, isComplete, err := s.Open(t.Context(), id)
+			r.NoError(err)
+			r.False(isComplete)
+			readers = append(readers, rc)
+		}
+		for _, rc := range readers {
+			data, err := io.ReadAll(rc)
+			r.NoError(err)
+			r.Equal("hello world", string(data))
+			rc.Close()
+		}
+
+		// Transcoded video is used after completion.
+		rc, isComplete, err := s.Open(t.Context(), id)
+		r.NoError(err)
+		r.True(isComplete)
+		defer rc.Close()
+
+		r.Implements((*io.Seeker)(nil), rc)
+		data, err := io.ReadAll(rc)
+		r.NoError(err)
+		r.Equal("hello world", string(data))
+
+		r.Equal(int32(1), calls.Load())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		s := newService(t, 1, func(_ context.Context, output string) error {
+			if err := writeChunks(output, "hello"); err != nil {
+				return err
+			}
+			return errors.New("ffmpeg failed")
+		})
+
+		id := rview.NewFileID("/video.avi", time.Now().Unix(), 100)
+
+		rc, _, err := s.Open(t.Context(), id)
+		r.NoError(err)
+		defer rc.Close()
+
+		_, err = io.ReadAll(rc)
+		r.ErrorContains(err, "ffmpeg failed")
+
+		// The video must not be cached.
+		_, err = s.cache.Open(newCacheID(id))
+		r.ErrorIs(err, cache.ErrCacheMiss)
+	})
+
+	t.Run("bounded workers", func(t *testing.T) {
+		t.Parallel()
+
+		r := require.New(t)
+
+		var inProgress, maxInProgress atomic.Int32
+		s := newService(t, 2, func(_ context.Context, output string) error {
+			n := inProgress.Add(1)
+			defer inProgress.Add(-1)
+
+			for {
+				v := maxInProgress.Load()
+				if n <= v || maxInProgress.CompareAndSwap(v, n) {
+					break
+				}
+			}
+			return writeChunks(output, "x", "x")
+		})
+
+		var readers []io.ReadCloser
+		for i := range 5 {
+			id := rview.NewFileID("/video-"+strings.Repeat("x", i)+".mov", time.Now().Unix(), 100)
+
+			rc, _, err := s.Open(t.Context(), id)
+			r.NoError(err)
+			readers = append(readers, rc)
+		}
+		for _, rc := range readers {
+			data, err := io.ReadAll(rc)
+			r.NoError(err)
+			r.Equal("xx", string(data))
+			rc.Close()
+		}
+		r.Equal(int32(2), maxInProgress.Load())
+	})
+
+	t.Run("unsupported file", func(t *testing.T) {
+		t.Parallel()
+
+		s := newService(t, 1, nil)
+
+		_, _, err := s.Open(t.Context(), rview.NewFileID("/video.mp4", 0, 100))
+		require.Error(t, err)
+	})
+}
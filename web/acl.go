@@ -0,0 +1,85 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/ShoshinNikita/rview/acl"
+	"github.com/ShoshinNikita/rview/auth"
+	"github.com/ShoshinNikita/rview/duplicates"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// getUsername returns the name of the current user. The username is taken from the session
+// (see [authMiddleware]) or from Basic Auth credentials. Passwords are not checked, so Basic Auth
+// is trusted only for requests made by trusted reverse proxies. It returns an empty string for
+// anonymous users.
+func (s *Server) getUsername(r *http.Request) string {
+	if username := getSession(r.Context()).Username; username != "" {
+		return username
+	}
+	if username, _, ok := r.BasicAuth(); ok && auth.IsTrustedProxy(r.RemoteAddr, s.cfg.Auth.TrustedProxies) {
+		return username
+	}
+	return ""
+}
+
+// getAccess returns access of the current user.
+func (s *Server) getAccess(r *http.Request) acl.Access {
+	return s.aclService.GetAccess(s.getUsername(r))
+}
+
+// checkFileAccess checks whether the current user has access to the file. If not, it writes
+// 404 Not Found to not reveal the existence of the file.
+func (s *Server) checkFileAccess(w http.ResponseWriter, r *http.Request, id rview.FileID) bool {
+	if s.getAccess(r).IsAllowed(id.GetPath()) {
+		return true
+	}
+	writeError(w, http.StatusNotFound, "file %q not found", id.GetPath())
+	return false
+}
+
+// filterDirEntries returns entries visible to the user. It always returns a new slice because
+// the passed entries can be cached.
+func filterDirEntries(access acl.Access, entries []rclone.DirEntry) []rclone.DirEntry {
+	res := make([]rclone.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if access.IsVisible(entry.URL, entry.IsDir) {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+// getSearchFilter returns a filter for search hits. It returns nil if all paths are available.
+func getSearchFilter(access acl.Access) func(path string, isDir bool) bool {
+	if access.IsFull() {
+		return nil
+	}
+	return access.IsVisible
+}
+
+// filterDuplicates returns groups with files available to the user. Groups with a single
+// available file are skipped.
+func filterDuplicates(res duplicates.Result, access acl.Access) duplicates.Result {
+	if access.IsFull() {
+		return res
+	}
+
+	groups := make([]duplicates.Group, 0, len(res.Groups))
+	for _, group := range res.Groups {
+		var files []duplicates.File
+		for _, f := range group.Files {
+			if access.IsAllowed(f.Path) {
+				files = append(files, f)
+			}
+		}
+		if len(files) < 2 {
+			continue
+		}
+		group.Files = files
+		groups = append(groups, group)
+	}
+	res.Groups = groups
+	return res
+}
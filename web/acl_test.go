@@ -0,0 +1,159 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/acl"
+	"github.com/ShoshinNikita/rview/auth"
+	"github.com/ShoshinNikita/rview/duplicates"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/ShoshinNikita/rview/search"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestACLService(t *testing.T) *acl.Service {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	err := os.WriteFile(path, []byte(`{
+		"rules": [
+			{"users": ["*"], "allow": ["/public/"]},
+			{"users": ["bob"], "allow": ["/photos/2024/"], "deny": ["/photos/2024/private/"]}
+		]
+	}`), 0600)
+	require.NoError(t, err)
+
+	s, err := acl.NewService(path)
+	require.NoError(t, err)
+	return s
+}
+
+func TestServer_getUsername(t *testing.T) {
+	r := require.New(t)
+
+	var cfg rview.Config
+	cfg.Auth.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	s := &Server{cfg: cfg}
+
+	req := httptest.NewRequest("GET", "/ui/", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	r.Empty(s.getUsername(req))
+
+	req.SetBasicAuth("carol", "qwerty")
+	r.Equal("carol", s.getUsername(req))
+
+	// Basic Auth credentials are not trusted for requests from other addresses.
+	req.RemoteAddr = "192.168.1.1:5000"
+	r.Empty(s.getUsername(req))
+
+	// Or if there are no trusted proxies.
+	req.RemoteAddr = "10.1.2.3:5000"
+	r.Empty((&Server{}).getUsername(req))
+
+	req = req.WithContext(withSession(req.Context(), auth.Session{Username: "alice"}))
+	r.Equal("alice", s.getUsername(req))
+}
+
+func TestACLFilters(t *testing.T) {
+	aclService := newTestACLService(t)
+	access := aclService.GetAccess("bob")
+
+	t.Run("dir entries", func(t *testing.T) {
+		r := require.New(t)
+
+		entries := []rclone.DirEntry{
+			{URL: "/photos/", IsDir: true},
+			{URL: "/photos/2024/", IsDir: true},
+			{URL: "/photos/2024/a.jpg"},
+			{URL: "/photos/2024/private/", IsDir: true},
+			{URL: "/photos/2023/", IsDir: true},
+			{URL: "/photos/b.jpg"},
+			{URL: "/public/", IsDir: true},
+		}
+		var got []string
+		for _, e := range filterDirEntries(access, entries) {
+			got = append(got, e.URL)
+		}
+		r.Equal([]string{"/photos/", "/photos/2024/", "/photos/2024/a.jpg", "/public/"}, got)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		r := require.New(t)
+
+		r.Nil(getSearchFilter(acl.FullAccess()))
+
+		filter := getSearchFilter(access)
+		r.True(filter("/photos/", true))
+		r.True(filter("/photos/2024/a.jpg", false))
+		r.False(filter("/photos/2024/private/a.jpg", false))
+		r.False(filter("/photos/b.jpg", false))
+	})
+
+	t.Run("timeline", func(t *testing.T) {
+		r := require.New(t)
+
+		tl := newTimeline([]timelineEntry{
+			{Hit: search.Hit{Path: "/photos/2024/a.jpg"}},
+			{Hit: search.Hit{Path: "/photos/2024/private/b.jpg"}},
+			{Hit: search.Hit{Path: "/public/c.jpg"}},
+		}, time.Time{})
+
+		r.Same(tl, tl.filterByAccess(acl.FullAccess()))
+
+		var got []string
+		for _, e := range tl.filterByAccess(access).entries {
+			got = append(got, e.Path)
+		}
+		r.Equal([]string{"/photos/2024/a.jpg", "/public/c.jpg"}, got)
+
+		r.Len(tl.filterByAccess(aclService.GetAccess("")).entries, 1)
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		r := require.New(t)
+
+		res := filterDuplicates(duplicates.Result{
+			Groups: []duplicates.Group{
+				{Files: []duplicates.File{{Path: "/photos/2024/a.jpg"}, {Path: "/public/a.jpg"}}},
+				{Files: []duplicates.File{{Path: "/photos/2024/b.jpg"}, {Path: "/photos/2024/private/b.jpg"}}},
+				{
+					Files: []duplicates.File{
+						{Path: "/photos/2024/c.jpg"}, {Path: "/photos/2024/private/c.jpg"}, {Path: "/public/c.jpg"},
+					},
+				},
+			},
+		}, access)
+		r.Equal(
+			[]duplicates.Group{
+				{Files: []duplicates.File{{Path: "/photos/2024/a.jpg"}, {Path: "/public/a.jpg"}}},
+				{Files: []duplicates.File{{Path: "/photos/2024/c.jpg"}, {Path: "/public/c.jpg"}}},
+			},
+			res.Groups,
+		)
+	})
+}
+
+func TestServer_checkFileAccess(t *testing.T) {
+	r := require.New(t)
+
+	s := &Server{aclService: newTestACLService(t)}
+
+	for path, wantOK := range map[string]bool{
+		"/public/a.jpg":  true,
+		"/photos/a.jpg":  false,
+		"/private/a.jpg": false,
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/file/", nil)
+		ok := s.checkFileAccess(w, req, rview.NewFileID(path, 0, 0))
+		r.Equal(wantOK, ok, path)
+		if !wantOK {
+			r.Equal(http.StatusNotFound, w.Code)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package web
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	pkgPath "path"
+	"strconv"
+	"strings"
+
+	"github.com/ShoshinNikita/rview/archive"
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// handleArchive streams the directory as a ZIP or TAR archive ("format" query parameter).
+// Only the selected entries are archived if the "name" query parameter is passed (it can be
+// passed multiple times).
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	dir := pkgPath.Clean("/" + strings.TrimPrefix(r.URL.Path, "/api/archive"))
+	dir = misc.EnsureSuffix(dir, "/")
+
+	format := archive.Format(cmp.Or(r.FormValue("format"), string(archive.FormatZip)))
+	contentType, ok := map[archive.Format]string{
+		archive.FormatZip: "application/zip",
+		archive.FormatTar: "application/x-tar",
+	}[format]
+	if !ok {
+		writeBadRequestError(w, "unsupported format %q", format)
+		return
+	}
+
+	access := s.getAccess(r)
+	if !access.IsVisible(dir, true) {
+		writeError(w, http.StatusNotFound, "dir %q not found", dir)
+		return
+	}
+	var filter func(path string, isDir bool) bool
+	if !access.IsFull() {
+		filter = access.IsVisible
+	}
+
+	filename := "rview." + string(format)
+	if dir != "/" {
+		filename = pkgPath.Base(dir) + "." + string(format)
+	}
+	rw := &archiveResponseWriter{
+		ResponseWriter: w,
+		contentType:    contentType,
+		disposition:    mime.FormatMediaType("attachment", map[string]string{"filename": filename}),
+	}
+
+	err := s.archiveService.Write(r.Context(), rw, archive.Options{
+		Format: format,
+		Dir:    dir,
+		Names:  r.URL.Query()["name"],
+		Filter: filter,
+	})
+	switch {
+	case err == nil:
+		return
+
+	case rw.started:
+		if r.Context().Err() != nil {
+			// The request was canceled by the user.
+			return
+		}
+		// Abort the response, otherwise the client would get a truncated archive
+		// without any indication of the error.
+		rlog.Errorf("couldn't write archive of %q: %s", dir, err)
+		panic(http.ErrAbortHandler)
+
+	case rclone.IsNotFoundError(err), errors.Is(err, archive.ErrEntryNotFound):
+		writeError(w, http.StatusNotFound, "%s", err)
+	default:
+		writeInternalServerError(w, "couldn't write archive: %s", err)
+	}
+}
+
+// archiveResponseWriter sets the headers of an archive on the first write. It allows
+// to respond with an error if the archive can't be prepared.
+type archiveResponseWriter struct {
+	http.ResponseWriter
+
+	contentType string
+	disposition string
+	started     bool
+}
+
+func (w *archiveResponseWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.started = true
+
+		w.Header().Set("Content-Type", w.contentType)
+		w.Header().Set("Content-Disposition", w.disposition)
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// readDir returns the content of the directory. The directory can be inside an archive,
+// in this case the path of the archive is returned as well.
+func (s *Server) readDir(ctx context.Context, dir string, sort, order string) (_ *rclone.DirInfo, archivePath string, _ error) {
+	archiveID, memberPath, ok, err := s.archiveService.SplitPath(ctx, dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't check path: %w", err)
+	}
+	if !ok {
+		info, err := s.rclone.GetDirInfo(ctx, dir, sort, order)
+		return info, "", err
+	}
+
+	info, err := s.archiveService.GetDirInfo(ctx, archiveID, memberPath, sort, order)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't read archive: %w", err)
+	}
+	return info, archiveID.GetPath(), nil
+}
+
+// serveArchiveMember writes the file from the archive. Range requests are supported only
+// for uncompressed files.
+func (s *Server) serveArchiveMember(w http.ResponseWriter, r *http.Request, fileID, archiveID rview.FileID, memberPath string) {
+	file, member, err := s.archiveService.OpenMember(r.Context(), archiveID, memberPath)
+	if err != nil {
+		if errors.Is(err, archive.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "%s", err)
+			return
+		}
+		writeInternalServerError(w, "couldn't open file in archive: %s", err)
+		return
+	}
+	defer file.Close()
+
+	if member.Size != fileID.GetSize() || member.ModTime.Unix() != fileID.GetModTime() {
+		writeError(w, http.StatusNotFound, "file %q was changed", fileID.GetPath())
+		return
+	}
+
+	if rs, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, fileID.GetName(), member.ModTime, rs)
+		return
+	}
+
+	contentType := cmp.Or(mime.TypeByExtension(fileID.GetExt()), "application/octet-stream")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(member.Size, 10))
+	w.Header().Set("Last-Modified", member.ModTime.UTC().Format(http.TimeFormat))
+	io.Copy(w, file)
+}
@@ -0,0 +1,209 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/archive"
+	"github.com/ShoshinNikita/rview/auth"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/stretchr/testify/require"
+)
+
+type testArchiveService struct {
+	err error
+	// writeBeforeErr makes the service write some data before returning the error.
+	writeBeforeErr bool
+
+	gotOpts archive.Options
+
+	// archiveID is the only archive that can be browsed.
+	archiveID rview.FileID
+	// members contains content of archive members by path.
+	members map[string]string
+}
+
+func (s *testArchiveService) Write(_ context.Context, w io.Writer, opts archive.Options) error {
+	s.gotOpts = opts
+	if s.err == nil || s.writeBeforeErr {
+		w.Write([]byte("archive"))
+	}
+	return s.err
+}
+
+func (s *testArchiveService) SplitPath(_ context.Context, path string) (rview.FileID, string, bool, error) {
+	memberPath, ok := strings.CutPrefix(path, s.archiveID.GetPath()+"/")
+	if !ok || s.archiveID == (rview.FileID{}) {
+		return rview.FileID{}, "", false, nil
+	}
+	return s.archiveID, memberPath, true, nil
+}
+
+func (s *testArchiveService) GetDirInfo(_ context.Context, _ rview.FileID, dir, sort, order string) (*rclone.DirInfo, error) {
+	info := &rclone.DirInfo{
+		Dir: s.archiveID.GetPath() + "/" + dir,
+	}
+	seen := make(map[string]bool)
+	for path, content := range s.members {
+		path, ok := strings.CutPrefix(path, dir)
+		if !ok {
+			continue
+		}
+		name, _, isDir := strings.Cut(path, "/")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		entry := rclone.DirEntry{
+			URL:     info.Dir + name,
+			Leaf:    name,
+			IsDir:   isDir,
+			ModTime: 1700000000,
+		}
+		if !isDir {
+			entry.Size = int64(len(content))
+		}
+		info.Entries = append(info.Entries, entry)
+	}
+	if len(info.Entries) == 0 {
+		return nil, archive.ErrNotFound
+	}
+	rclone.SortDirInfo(info, sort, order)
+	return info, nil
+}
+
+func (s *testArchiveService) OpenMember(_ context.Context, _ rview.FileID, path string) (io.ReadCloser, archive.Member, error) {
+	content, ok := s.members[path]
+	if !ok {
+		return nil, archive.Member{}, archive.ErrNotFound
+	}
+	member := archive.Member{
+		Path:    path,
+		Size:    int64(len(content)),
+		ModTime: time.Unix(1700000000, 0),
+	}
+	if strings.HasSuffix(path, ".txt") {
+		// Compressed files can't be seeked.
+		return io.NopCloser(strings.NewReader(content)), member, nil
+	}
+	return readSeekCloser{strings.NewReader(content)}, member, nil
+}
+
+type readSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekCloser) Close() error { return nil }
+
+func TestServer_handleArchive(t *testing.T) {
+	r := require.New(t)
+
+	archiveService := &testArchiveService{}
+	s := &Server{
+		aclService:     newTestACLService(t),
+		archiveService: archiveService,
+	}
+
+	getArchive := func(url, username string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req = req.WithContext(withSession(req.Context(), auth.Session{Username: username}))
+		w := httptest.NewRecorder()
+		s.handleArchive(w, req)
+		return w
+	}
+
+	w := getArchive("/api/archive/photos/2024/?name=a.jpg&name=dir", "bob")
+	r.Equal(http.StatusOK, w.Code)
+	r.Equal("archive", w.Body.String())
+	r.Equal("application/zip", w.Header().Get("Content-Type"))
+	r.Equal(`attachment; filename=2024.zip`, w.Header().Get("Content-Disposition"))
+	r.Equal("/photos/2024/", archiveService.gotOpts.Dir)
+	r.Equal([]string{"a.jpg", "dir"}, archiveService.gotOpts.Names)
+	r.Equal(archive.FormatZip, archiveService.gotOpts.Format)
+	// Files hidden by ACL must be filtered out.
+	r.NotNil(archiveService.gotOpts.Filter)
+	r.False(archiveService.gotOpts.Filter("/photos/2024/private/a.jpg", false))
+
+	w = getArchive("/api/archive/photos/2024/../2024?format=tar", "bob")
+	r.Equal(http.StatusOK, w.Code)
+	r.Equal("application/x-tar", w.Header().Get("Content-Type"))
+	r.Equal("/photos/2024/", archiveService.gotOpts.Dir)
+
+	w = getArchive("/api/archive/photos/2024/?format=rar", "bob")
+	r.Equal(http.StatusBadRequest, w.Code)
+
+	w = getArchive("/api/archive/photos/2024/", "alice")
+	r.Equal(http.StatusNotFound, w.Code)
+
+	// Errors before the first write are returned to users.
+	archiveService.err = archive.ErrEntryNotFound
+	w = getArchive("/api/archive/photos/2024/", "bob")
+	r.Equal(http.StatusNotFound, w.Code)
+	r.Empty(w.Header().Get("Content-Disposition"))
+
+	archiveService.err = errors.New("some error")
+	w = getArchive("/api/archive/photos/2024/", "bob")
+	r.Equal(http.StatusInternalServerError, w.Code)
+
+	// Responses must be aborted on errors after the first write.
+	archiveService.writeBeforeErr = true
+	r.PanicsWithValue(http.ErrAbortHandler, func() {
+		getArchive("/api/archive/photos/2024/", "bob")
+	})
+}
+
+func TestServer_serveArchiveMember(t *testing.T) {
+	r := require.New(t)
+
+	s := &Server{
+		aclService: newTestACLService(t),
+		archiveService: &testArchiveService{
+			archiveID: rview.NewFileID("/public/a.zip", 1700000000, 100),
+			members: map[string]string{
+				"docs/a.txt": "hello world",
+				"img.jpg":    "jpg",
+			},
+		},
+	}
+
+	getFile := func(path string, size int, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fileIDToURL("/api/file", rview.NewFileID(path, 1700000000, int64(size))), nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		s.handleFile(w, req)
+		return w
+	}
+
+	w := getFile("/public/a.zip/docs/a.txt", 11, "")
+	r.Equal(http.StatusOK, w.Code)
+	r.Equal("hello world", w.Body.String())
+	r.Equal("text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	r.Equal("11", w.Header().Get("Content-Length"))
+
+	// Range requests are supported for uncompressed files.
+	w = getFile("/public/a.zip/img.jpg", 3, "bytes=1-")
+	r.Equal(http.StatusPartialContent, w.Code)
+	r.Equal("pg", w.Body.String())
+	r.Equal("image/jpeg", w.Header().Get("Content-Type"))
+
+	// Outdated ids.
+	w = getFile("/public/a.zip/img.jpg", 4, "")
+	r.Equal(http.StatusNotFound, w.Code)
+
+	w = getFile("/public/a.zip/docs/b.txt", 1, "")
+	r.Equal(http.StatusNotFound, w.Code)
+
+	// ACL is checked for paths inside archives as well.
+	w = getFile("/photos/a.zip/img.jpg", 3, "")
+	r.Equal(http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,256 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ShoshinNikita/rview/auth"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+)
+
+const (
+	sessionCookieName = "rview_session"
+	// oidcStateCookieName is used to store the state of the OpenID Connect authorization flow.
+	oidcStateCookieName = "rview_oidc_state"
+
+	// CSRF token can be passed either in the header (for fetch requests) or in the form field.
+	csrfTokenHeader    = "X-CSRF-Token"
+	csrfTokenFormField = "csrf_token"
+)
+
+type sessionContextKey struct{}
+
+func withSession(ctx context.Context, session auth.Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, session)
+}
+
+// getSession returns the session of the current user. Username is empty if authentication is disabled.
+func getSession(ctx context.Context) auth.Session {
+	session, _ := ctx.Value(sessionContextKey{}).(auth.Session)
+	return session
+}
+
+type sessionRefContextKey struct{}
+
+// withSessionRef is used to pass the session from [authMiddleware] to outer middlewares.
+func withSessionRef(ctx context.Context, session *auth.Session) context.Context {
+	return context.WithValue(ctx, sessionRefContextKey{}, session)
+}
+
+func getSessionRef(ctx context.Context) *auth.Session {
+	session, _ := ctx.Value(sessionRefContextKey{}).(*auth.Session)
+	return session
+}
+
+func (s *Server) handlePageWithLogin(w http.ResponseWriter, r *http.Request) {
+	next := getNextURL(r.URL.Query().Get("next"))
+
+	page := s.newLoginPage(next)
+	if !page.PasswordEnabled && !page.OIDCEnabled {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if _, err := s.authService.GetSession(cookie.Value); err == nil {
+			// Already logged in.
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+	}
+	if !page.PasswordEnabled {
+		// There is nothing to show on the login page.
+		http.Redirect(w, r, "/auth/oidc/login?next="+url.QueryEscape(next), http.StatusSeeOther)
+		return
+	}
+
+	s.executeTemplate(w, r, "login.html", page)
+}
+
+func (s *Server) newLoginPage(next string) LoginPage {
+	return LoginPage{
+		BuildInfo:       s.cfg.BuildInfo,
+		PasswordEnabled: s.cfg.Auth.UsersFile != "",
+		OIDCEnabled:     s.cfg.Auth.OIDC.IssuerURL != "",
+		Next:            next,
+	}
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var (
+		username = r.PostFormValue("username")
+		password = r.PostFormValue("password")
+		next     = getNextURL(r.PostFormValue("next"))
+	)
+
+	token, session, err := s.authService.Login(username, password)
+	switch {
+	case errors.Is(err, auth.ErrNoopAuthService), errors.Is(err, auth.ErrMethodDisabled):
+		writeBadRequestError(w, "password authentication is disabled")
+		return
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		page := s.newLoginPage(next)
+		page.Username = username
+		page.Error = "Invalid username or password"
+
+		w.WriteHeader(http.StatusUnauthorized)
+		s.executeTemplate(w, r, "login.html", page)
+		return
+	case err != nil:
+		writeInternalServerError(w, "couldn't log in: %s", err)
+		return
+	}
+
+	setSessionCookie(w, r, token, session)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// handleOIDCLogin redirects the user to the OpenID Connect provider.
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	next := getNextURL(r.URL.Query().Get("next"))
+
+	authURL, stateToken, err := s.authService.StartOIDCLogin(r.Context(), next)
+	switch {
+	case errors.Is(err, auth.ErrNoopAuthService), errors.Is(err, auth.ErrMethodDisabled):
+		writeBadRequestError(w, "OpenID Connect authentication is disabled")
+		return
+	case err != nil:
+		writeInternalServerError(w, "couldn't start OpenID Connect login: %s", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    stateToken,
+		Path:     "/auth/oidc/",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		// Lax mode is required because the callback is requested after redirect from the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// handleOIDCCallback handles the redirect from the OpenID Connect provider.
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	showError := func(msg string) {
+		page := s.newLoginPage("/")
+		page.Error = msg
+
+		w.WriteHeader(http.StatusUnauthorized)
+		s.executeTemplate(w, r, "login.html", page)
+	}
+
+	if errCode := query.Get("error"); errCode != "" {
+		rlog.Warnf("OpenID Connect provider returned error %q: %s", errCode, query.Get("error_description"))
+		showError("Couldn't log in with SSO: " + errCode)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil {
+		showError("Couldn't log in with SSO: login session is expired, try again")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    "",
+		Path:     "/auth/oidc/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	token, session, next, err := s.authService.FinishOIDCLogin(r.Context(), cookie.Value, query.Get("state"), query.Get("code"))
+	switch {
+	case errors.Is(err, auth.ErrNoopAuthService), errors.Is(err, auth.ErrMethodDisabled):
+		writeBadRequestError(w, "OpenID Connect authentication is disabled")
+		return
+	case err != nil:
+		rlog.Warnf("couldn't finish OpenID Connect login: %s", err)
+		showError("Couldn't log in with SSO")
+		return
+	}
+
+	setSessionCookie(w, r, token, session)
+	http.Redirect(w, r, getNextURL(next), http.StatusSeeOther)
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, session auth.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		err := s.authService.Logout(cookie.Value)
+		if err != nil {
+			writeInternalServerError(w, "couldn't log out: %s", err)
+			return
+		}
+	}
+
+	deleteSessionCookie(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func deleteSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// getNextURL returns the url to redirect to after login. Only local urls are allowed
+// to prevent open redirects.
+func getNextURL(next string) string {
+	// Urls like "//example.com" and "/\example.com" are treated by browsers as absolute.
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// isSecureRequest reports whether the request was made over HTTPS, directly or through a proxy.
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// getClientIP returns the address of the client. Header "X-Forwarded-For" is used only for
+// requests made by trusted proxies: the last address is the one added by the proxy.
+func (s *Server) getClientIP(r *http.Request) string {
+	if auth.IsTrustedProxy(r.RemoteAddr, s.cfg.Auth.TrustedProxies) {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			addrs := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,353 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/auth"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/ShoshinNikita/rview/static"
+	"github.com/stretchr/testify/require"
+)
+
+type testAuthService struct{}
+
+func (testAuthService) Login(username, password string) (string, auth.Session, error) {
+	if username != "alice" || password != "qwerty" {
+		return "", auth.Session{}, auth.ErrInvalidCredentials
+	}
+	return "alice-token", auth.Session{Username: "alice", ExpiresAt: time.Now().Add(time.Hour), CSRFToken: "csrf"}, nil
+}
+
+func (testAuthService) GetSession(token string) (auth.Session, error) {
+	if token != "alice-token" {
+		return auth.Session{}, auth.ErrInvalidSession
+	}
+	return auth.Session{Username: "alice", CSRFToken: "csrf"}, nil
+}
+
+func (testAuthService) Logout(string) error {
+	return nil
+}
+
+func (testAuthService) StartOIDCLogin(_ context.Context, next string) (string, string, error) {
+	return "https://idp.example.com/authorize?state=state", "state-token:" + next, nil
+}
+
+func (testAuthService) FinishOIDCLogin(_ context.Context, stateToken, state, code string) (string, auth.Session, string, error) {
+	next, ok := strings.CutPrefix(stateToken, "state-token:")
+	if !ok || state != "state" || code != "code" {
+		return "", auth.Session{}, "", errors.New("invalid state or code")
+	}
+	return "bob-token", auth.Session{Username: "bob", Method: auth.MethodOIDC}, next, nil
+}
+
+func (testAuthService) GetProxySession(remoteAddr string, header http.Header) (auth.Session, error) {
+	username := header.Get("Remote-User")
+	if username == "" {
+		return auth.Session{}, auth.ErrInvalidSession
+	}
+	if !strings.HasPrefix(remoteAddr, "10.") {
+		return auth.Session{}, auth.ErrUntrustedProxy
+	}
+	return auth.Session{Username: username, Method: auth.MethodProxy, CSRFToken: "proxy-csrf"}, nil
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	var gotSession auth.Session
+	handler := authMiddleware(testAuthService{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSession = getSession(r.Context())
+	}))
+
+	send := func(method, path string, cookie string, header http.Header) *httptest.ResponseRecorder {
+		gotSession = auth.Session{}
+
+		req := httptest.NewRequest(method, path, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+		}
+		for k, values := range header {
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("public paths", func(t *testing.T) {
+		r := require.New(t)
+
+		for _, path := range []string{"/login", "/static/css/index.css"} {
+			w := send("GET", path, "", nil)
+			r.Equal(http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		r := require.New(t)
+
+		w := send("GET", "/ui/a/b/?sort=size", "", nil)
+		r.Equal(http.StatusSeeOther, w.Code)
+		r.Equal("/login?next="+url.QueryEscape("/ui/a/b/?sort=size"), w.Header().Get("Location"))
+
+		w = send("GET", "/api/dir/", "", nil)
+		r.Equal(http.StatusUnauthorized, w.Code)
+
+		w = send("GET", "/api/dir/", "invalid-token", nil)
+		r.Equal(http.StatusUnauthorized, w.Code)
+		r.Contains(w.Header().Get("Set-Cookie"), sessionCookieName+"=;")
+
+		w = send("POST", "/api/search/refresh-index", "", nil)
+		r.Equal(http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		r := require.New(t)
+
+		w := send("GET", "/ui/", "alice-token", nil)
+		r.Equal(http.StatusOK, w.Code)
+		r.Equal("alice", gotSession.Username)
+
+		w = send("POST", "/api/search/refresh-index", "alice-token", nil)
+		r.Equal(http.StatusForbidden, w.Code)
+
+		w = send("POST", "/api/search/refresh-index", "alice-token", http.Header{csrfTokenHeader: {"qwerty"}})
+		r.Equal(http.StatusForbidden, w.Code)
+
+		w = send("POST", "/api/search/refresh-index", "alice-token", http.Header{csrfTokenHeader: {"csrf"}})
+		r.Equal(http.StatusOK, w.Code)
+		r.Equal("alice", gotSession.Username)
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		r := require.New(t)
+
+		sendFrom := func(remoteAddr, method string, header http.Header) *httptest.ResponseRecorder {
+			gotSession = auth.Session{}
+
+			req := httptest.NewRequest(method, "/api/search/refresh-index", nil)
+			req.RemoteAddr = remoteAddr
+			for k, values := range header {
+				for _, v := range values {
+					req.Header.Add(k, v)
+				}
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w
+		}
+
+		w := sendFrom("10.0.0.1:4000", "GET", http.Header{"Remote-User": {"carol"}})
+		r.Equal(http.StatusOK, w.Code)
+		r.Equal("carol", gotSession.Username)
+		r.Equal(auth.MethodProxy, gotSession.Method)
+
+		w = sendFrom("10.0.0.1:4000", "POST", http.Header{"Remote-User": {"carol"}})
+		r.Equal(http.StatusForbidden, w.Code)
+
+		w = sendFrom("10.0.0.1:4000", "POST", http.Header{"Remote-User": {"carol"}, csrfTokenHeader: {"proxy-csrf"}})
+		r.Equal(http.StatusOK, w.Code)
+
+		// The header must be ignored for untrusted addresses.
+		w = sendFrom("192.168.0.1:4000", "GET", http.Header{"Remote-User": {"carol"}})
+		r.Equal(http.StatusUnauthorized, w.Code)
+		r.Empty(gotSession.Username)
+	})
+
+	t.Run("session is passed to outer middlewares", func(t *testing.T) {
+		r := require.New(t)
+
+		sessionRef := new(auth.Session)
+		req := httptest.NewRequest("GET", "/ui/", nil)
+		req = req.WithContext(withSessionRef(req.Context(), sessionRef))
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "alice-token"})
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		r.Equal("alice", sessionRef.Username)
+	})
+
+	t.Run("invalidate token", func(t *testing.T) {
+		r := require.New(t)
+
+		// Requests with a token are passed to the handler that checks the token.
+		w := send("POST", "/api/invalidate", "", http.Header{"Authorization": {"Bearer secret"}})
+		r.Equal(http.StatusOK, w.Code)
+		r.Empty(gotSession.Username)
+
+		w = send("POST", "/api/invalidate", "", http.Header{"Authorization": {"Basic YWxpY2U6cXdlcnR5"}})
+		r.Equal(http.StatusUnauthorized, w.Code)
+
+		w = send("POST", "/api/search/refresh-index", "", http.Header{"Authorization": {"Bearer secret"}})
+		r.Equal(http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("auth is disabled", func(t *testing.T) {
+		r := require.New(t)
+
+		handler := authMiddleware(auth.NewNoopService(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+		for _, method := range []string{"GET", "POST"} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(method, "/api/search/refresh-index", nil))
+			r.Equal(http.StatusOK, w.Code)
+		}
+	})
+}
+
+func TestServer_getClientIP(t *testing.T) {
+	r := require.New(t)
+
+	var cfg rview.Config
+	cfg.Auth.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	s := &Server{cfg: cfg}
+
+	getClientIP := func(remoteAddr string, forwardedFor ...string) string {
+		req := httptest.NewRequest("POST", "/s/token/unlock", nil)
+		req.RemoteAddr = remoteAddr
+		for _, v := range forwardedFor {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		return s.getClientIP(req)
+	}
+
+	r.Equal("192.168.1.1", getClientIP("192.168.1.1:5000"))
+	r.Equal("192.168.1.1", getClientIP("192.168.1.1:5000", "1.2.3.4"))
+	r.Equal("1.2.3.4", getClientIP("10.0.0.1:5000", "1.2.3.4"))
+	r.Equal("5.6.7.8", getClientIP("10.0.0.1:5000", "1.2.3.4, 5.6.7.8"))
+	r.Equal("5.6.7.8", getClientIP("10.0.0.1:5000", "1.2.3.4", "5.6.7.8"))
+	r.Equal("10.0.0.1", getClientIP("10.0.0.1:5000"))
+}
+
+func TestServer_handleLogin(t *testing.T) {
+	r := require.New(t)
+
+	s := &Server{authService: testAuthService{}}
+
+	login := func(username, password, next string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {username}, "password": {password}, "next": {next}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		s.handleLogin(w, req)
+		return w
+	}
+
+	w := login("alice", "qwerty", "/ui/photos/")
+	r.Equal(http.StatusSeeOther, w.Code)
+	r.Equal("/ui/photos/", w.Header().Get("Location"))
+
+	cookies := w.Result().Cookies()
+	r.Len(cookies, 1)
+	r.Equal(sessionCookieName, cookies[0].Name)
+	r.Equal("alice-token", cookies[0].Value)
+	r.True(cookies[0].HttpOnly)
+
+	// Open redirects are forbidden.
+	for _, next := range []string{"https://example.com", "//example.com", `/\example.com`, ""} {
+		w := login("alice", "qwerty", next)
+		r.Equal(http.StatusSeeOther, w.Code)
+		r.Equal("/", w.Header().Get("Location"))
+	}
+}
+
+type logoutAuthService struct {
+	testAuthService
+
+	revokedTokens []string
+}
+
+func (s *logoutAuthService) Logout(token string) error {
+	s.revokedTokens = append(s.revokedTokens, token)
+	return nil
+}
+
+func TestServer_handleLogout(t *testing.T) {
+	r := require.New(t)
+
+	authService := &logoutAuthService{}
+	s := &Server{authService: authService}
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "alice-token"})
+	w := httptest.NewRecorder()
+	s.handleLogout(w, req)
+
+	r.Equal(http.StatusSeeOther, w.Code)
+	r.Equal("/login", w.Header().Get("Location"))
+	r.Equal([]string{"alice-token"}, authService.revokedTokens)
+
+	cookies := w.Result().Cookies()
+	r.Len(cookies, 1)
+	r.Equal(sessionCookieName, cookies[0].Name)
+	r.Empty(cookies[0].Value)
+	r.Negative(cookies[0].MaxAge)
+}
+
+func TestServer_handleOIDC(t *testing.T) {
+	r := require.New(t)
+
+	s := &Server{
+		cfg:         rview.Config{Auth: rview.AuthConfig{OIDC: rview.OIDCConfig{IssuerURL: "https://idp.example.com"}}},
+		authService: testAuthService{},
+		iconsFS:     static.NewIconsFS(false),
+		templatesFS: static.NewTemplatesFS(false),
+	}
+
+	w := httptest.NewRecorder()
+	s.handleOIDCLogin(w, httptest.NewRequest("GET", "/auth/oidc/login?next=%2Fui%2Fphotos%2F", nil))
+	r.Equal(http.StatusSeeOther, w.Code)
+	r.Equal("https://idp.example.com/authorize?state=state", w.Header().Get("Location"))
+
+	cookies := w.Result().Cookies()
+	r.Len(cookies, 1)
+	stateCookie := cookies[0]
+	r.Equal(oidcStateCookieName, stateCookie.Name)
+	r.Equal("state-token:/ui/photos/", stateCookie.Value)
+	r.Equal("/auth/oidc/", stateCookie.Path)
+	r.True(stateCookie.HttpOnly)
+
+	callback := func(query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/auth/oidc/callback?"+query, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.handleOIDCCallback(w, req)
+		return w
+	}
+
+	w = callback("state=state&code=code", stateCookie)
+	r.Equal(http.StatusSeeOther, w.Code)
+	r.Equal("/ui/photos/", w.Header().Get("Location"))
+
+	var sessionCookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		switch c.Name {
+		case sessionCookieName:
+			sessionCookie = c
+		case oidcStateCookieName:
+			r.Equal(-1, c.MaxAge)
+		}
+	}
+	r.NotNil(sessionCookie)
+	r.Equal("bob-token", sessionCookie.Value)
+
+	for _, query := range []string{"state=other&code=code", "error=access_denied"} {
+		w = callback(query, stateCookie)
+		r.Equal(http.StatusUnauthorized, w.Code, query)
+		r.Contains(w.Body.String(), "Couldn&#39;t log in with SSO", query)
+		r.Contains(w.Body.String(), "Log In with SSO", query)
+	}
+	w = callback("state=state&code=code", nil)
+	r.Equal(http.StatusUnauthorized, w.Code)
+}
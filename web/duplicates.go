@@ -0,0 +1,48 @@
+package web
+
+import (
+	pkgPath "path"
+
+	"github.com/ShoshinNikita/rview/duplicates"
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/rclone"
+)
+
+// convertDuplicates converts groups of duplicates. Files are converted the same way as
+// directory entries, so they have thumbnails.
+func (s *Server) convertDuplicates(res duplicates.Result) DuplicatesResponse {
+	resp := DuplicatesResponse{
+		Groups:    make([]DuplicatesGroup, 0, len(res.Groups)),
+		HashType:  res.HashType,
+		CreatedAt: res.CreatedAt,
+	}
+	for _, group := range res.Groups {
+		rcloneEntries := make([]rclone.DirEntry, 0, len(group.Files))
+		for _, f := range group.Files {
+			rcloneEntries = append(rcloneEntries, rclone.DirEntry{
+				URL:     f.Path,
+				Leaf:    pkgPath.Base(f.Path),
+				Size:    f.Size,
+				ModTime: f.ModTime,
+			})
+		}
+		info := s.convertRcloneInfo(&rclone.DirInfo{Entries: rcloneEntries})
+
+		wastedSize := group.WastedSize()
+		g := DuplicatesGroup{
+			Kind:                    string(group.Kind),
+			WastedSize:              wastedSize,
+			HumanReadableWastedSize: misc.FormatFileSize(wastedSize),
+			Files:                   make([]DuplicateFile, 0, len(group.Files)),
+		}
+		for i, f := range group.Files {
+			g.Files = append(g.Files, DuplicateFile{
+				DirEntry: info.Entries[i],
+				Path:     f.Path,
+				WebURL:   getWebURL(f.Path, false),
+			})
+		}
+		resp.Groups = append(resp.Groups, g)
+	}
+	return resp
+}
@@ -0,0 +1,192 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	pkgPath "path"
+	"strconv"
+	"strings"
+
+	"github.com/ShoshinNikita/rview/metadata"
+	"github.com/ShoshinNikita/rview/rclone"
+)
+
+const (
+	maxMapZoom = 20
+
+	// clustersPerTile defines the number of clusters per tile side: 256px tiles are divided
+	// into cells of 64px.
+	clustersPerTile = 4
+)
+
+// boundingBox is an area on the map. If West is greater than East, the area crosses
+// the antimeridian.
+type boundingBox struct {
+	West, South, East, North float64
+}
+
+// parseBoundingBox parses a bounding box in format "west,south,east,north". It returns nil
+// for an empty value.
+func parseBoundingBox(value string) (*boundingBox, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, errors.New(`bbox must be in format "west,south,east,north"`)
+	}
+	var coords [4]float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid bbox coordinate: %q", p)
+		}
+		coords[i] = v
+	}
+
+	bbox := &boundingBox{West: coords[0], South: coords[1], East: coords[2], North: coords[3]}
+	for _, lon := range []float64{bbox.West, bbox.East} {
+		if lon < -180 || lon > 180 {
+			return nil, errors.New("bbox is invalid: longitude must be in range [-180; 180]")
+		}
+	}
+	for _, lat := range []float64{bbox.South, bbox.North} {
+		if lat < -90 || lat > 90 {
+			return nil, errors.New("bbox is invalid: latitude must be in range [-90; 90]")
+		}
+	}
+	if bbox.South > bbox.North {
+		return nil, errors.New("bbox is invalid: south must not be greater than north")
+	}
+	return bbox, nil
+}
+
+func (b boundingBox) contains(gps metadata.GPS) bool {
+	if gps.Latitude < b.South || b.North < gps.Latitude {
+		return false
+	}
+	if b.West > b.East {
+		// The box crosses the antimeridian: it consists of [West; 180] and [-180; East].
+		return b.West <= gps.Longitude || gps.Longitude <= b.East
+	}
+	return b.West <= gps.Longitude && gps.Longitude <= b.East
+}
+
+func (b *boundingBox) extend(gps metadata.GPS) {
+	b.West = min(b.West, gps.Longitude)
+	b.East = max(b.East, gps.Longitude)
+	b.South = min(b.South, gps.Latitude)
+	b.North = max(b.North, gps.Latitude)
+}
+
+func (b boundingBox) String() string {
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return format(b.West) + "," + format(b.South) + "," + format(b.East) + "," + format(b.North)
+}
+
+// getLocationsBoundingBox returns the bounding box of all geotagged files of the timeline.
+func getLocationsBoundingBox(t *timeline) (boundingBox, bool) {
+	var (
+		res   boundingBox
+		found bool
+	)
+	for _, e := range t.entries {
+		if e.location == nil {
+			continue
+		}
+		if !found {
+			res = boundingBox{West: e.location.Longitude, South: e.location.Latitude, East: e.location.Longitude, North: e.location.Latitude}
+			found = true
+			continue
+		}
+		res.extend(*e.location)
+	}
+	return res, found
+}
+
+// getMapClusters groups geotagged files within the bounding box into clusters using a grid.
+// The size of grid cells depends on the zoom level. The newest file of every cluster is used
+// as its preview.
+func (s *Server) getMapClusters(t *timeline, bbox boundingBox, zoom int) MapResponse {
+	cellSize := 360 / (math.Exp2(float64(zoom)) * clustersPerTile)
+
+	type cluster struct {
+		first     timelineEntry
+		count     int
+		bbox      boundingBox
+		latitude  float64 // sum of latitudes
+		longitude float64 // sum of longitudes
+	}
+	var (
+		clusters       []*cluster
+		clustersByCell = make(map[[2]int]*cluster)
+	)
+	for _, e := range t.entries {
+		if e.location == nil || !bbox.contains(*e.location) {
+			continue
+		}
+		loc := *e.location
+
+		cell := [2]int{
+			int(math.Floor(loc.Longitude / cellSize)),
+			int(math.Floor(loc.Latitude / cellSize)),
+		}
+		c, ok := clustersByCell[cell]
+		if !ok {
+			// Entries are sorted in reverse chronological order, so the first entry is the newest one.
+			c = &cluster{
+				first: e,
+				bbox:  boundingBox{West: loc.Longitude, South: loc.Latitude, East: loc.Longitude, North: loc.Latitude},
+			}
+			clustersByCell[cell] = c
+			clusters = append(clusters, c)
+		}
+		c.count++
+		c.latitude += loc.Latitude
+		c.longitude += loc.Longitude
+		c.bbox.extend(loc)
+	}
+
+	// Use the same logic as for directories to get thumbnail urls.
+	rcloneEntries := make([]rclone.DirEntry, 0, len(clusters))
+	for _, c := range clusters {
+		rcloneEntries = append(rcloneEntries, rclone.DirEntry{
+			URL:     c.first.Path,
+			Leaf:    pkgPath.Base(c.first.Path),
+			Size:    c.first.Size,
+			ModTime: c.first.ModTime,
+		})
+	}
+	info := s.convertRcloneInfo(&rclone.DirInfo{Entries: rcloneEntries})
+
+	resp := MapResponse{
+		Clusters: make([]MapCluster, 0, len(clusters)),
+	}
+	for i, c := range clusters {
+		timelineURL := mustParseURL("/ui-timeline")
+		timelineURL.RawQuery = "bbox=" + c.bbox.String()
+
+		resp.Clusters = append(resp.Clusters, MapCluster{
+			Latitude:     c.latitude / float64(c.count),
+			Longitude:    c.longitude / float64(c.count),
+			Count:        c.count,
+			BBox:         c.bbox.String(),
+			ThumbnailURL: info.Entries[i].ThumbnailURL,
+			TimelineURL:  timelineURL.String(),
+		})
+		resp.Total += c.count
+	}
+	return resp
+}
+
+func parseMapZoom(value string) (int, error) {
+	zoom, err := strconv.Atoi(value)
+	if err != nil || zoom < 0 || zoom > maxMapZoom {
+		return 0, fmt.Errorf("zoom must be in range [0; %d]", maxMapZoom)
+	}
+	return zoom, nil
+}
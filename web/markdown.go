@@ -0,0 +1,235 @@
+package web
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	pkgPath "path"
+	"strings"
+
+	"github.com/ShoshinNikita/rview/acl"
+	"github.com/ShoshinNikita/rview/archive"
+	"github.com/ShoshinNikita/rview/pkg/highlight"
+	"github.com/ShoshinNikita/rview/pkg/markdown"
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/pkg/rlog"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+const (
+	// maxMarkdownSize is the max size of a Markdown file that can be rendered.
+	maxMarkdownSize = 1 << 20
+	// maxMarkdownLinkedDirs is the max number of directories that can be read to resolve
+	// links of a single Markdown file.
+	maxMarkdownLinkedDirs = 10
+
+	// markdownIDPrefix is added to ids of headings to avoid conflicts with ids of the page.
+	markdownIDPrefix = "md-"
+)
+
+// readmeFilenames contains lowercase names of files that are rendered below the directory
+// entries, in order of priority.
+var readmeFilenames = []string{"readme.md", "readme.txt", "index.md"}
+
+func isMarkdownFile(ext string) bool {
+	return ext == ".md" || ext == ".markdown"
+}
+
+// handleMarkdown returns a rendered Markdown file as an HTML fragment.
+func (s *Server) handleMarkdown(w http.ResponseWriter, r *http.Request) {
+	id, err := fileIDFromRequest(r, "/api/markdown")
+	if err != nil {
+		writeBadRequestError(w, "invalid file id: %s", err.Error())
+		return
+	}
+	if !s.checkFileAccess(w, r, id) {
+		return
+	}
+	if !isMarkdownFile(id.GetExt()) {
+		writeBadRequestError(w, "%q is not a Markdown file", id.GetName())
+		return
+	}
+	if id.GetSize() > maxMarkdownSize {
+		writeBadRequestError(w, "file is too large: %d bytes, max %d", id.GetSize(), maxMarkdownSize)
+		return
+	}
+
+	res, err := s.renderMarkdown(r.Context(), s.getAccess(r), id, nil)
+	if err != nil {
+		if rclone.IsNotFoundError(err) || errors.Is(err, archive.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "%s", err)
+			return
+		}
+		writeInternalServerError(w, "couldn't render file: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(res))
+}
+
+// renderReadme renders the README file of the directory, if any. Errors are only logged:
+// the directory must be shown anyway.
+func (s *Server) renderReadme(ctx context.Context, access acl.Access, info DirInfo) template.HTML {
+	var readme *DirEntry
+	for _, name := range readmeFilenames {
+		for i, entry := range info.Entries {
+			if !entry.IsDir && strings.ToLower(entry.Filename) == name {
+				readme = &info.Entries[i]
+				break
+			}
+		}
+		if readme != nil {
+			break
+		}
+	}
+	if readme == nil || readme.Size > maxMarkdownSize {
+		return ""
+	}
+
+	id := rview.NewFileID(pkgPath.Join(info.Dir, readme.Filename), readme.ModTime.Unix(), readme.Size)
+	res, err := s.renderMarkdown(ctx, access, id, info.Entries)
+	if err != nil {
+		rlog.Errorf("couldn't render readme %q: %s", id.GetPath(), err)
+		return ""
+	}
+	// The output of the renderer is sanitized.
+	return template.HTML(res)
+}
+
+// renderMarkdown reads and renders the Markdown file. Plain text files are rendered as is.
+// Relative links are resolved with the directory listings: entries of the file directory
+// can be passed to avoid extra requests.
+func (s *Server) renderMarkdown(ctx context.Context, access acl.Access, id rview.FileID, entries []DirEntry) (string, error) {
+	data, err := s.readTextChunk(ctx, id, 0, maxMarkdownSize)
+	if err != nil {
+		return "", err
+	}
+	encoding, bomSize := highlight.DetectEncoding(data)
+	text, _ := highlight.Decode(data[bomSize:], encoding)
+
+	if !isMarkdownFile(id.GetExt()) {
+		return `<pre class="markdown-plain-text">` + html.EscapeString(text) + "</pre>", nil
+	}
+
+	resolver := &markdownLinkResolver{
+		s:      s,
+		ctx:    ctx,
+		access: access,
+		dir:    pkgPath.Dir(id.GetPath()),
+		dirs:   make(map[string]markdownLinkedDir),
+	}
+	if entries != nil {
+		resolver.dirs[resolver.dir] = markdownLinkedDir{entries: entries}
+	}
+	return markdown.Render(text, markdown.Options{
+		ResolveURL: resolver.resolve,
+		IDPrefix:   markdownIDPrefix,
+	}), nil
+}
+
+// markdownLinkResolver resolves relative links of a Markdown file: links to files and
+// directories are replaced with links to the UI or API. Links to missing or hidden files
+// are removed.
+type markdownLinkResolver struct {
+	s      *Server
+	ctx    context.Context
+	access acl.Access
+	// dir is the directory of the Markdown file.
+	dir string
+
+	dirs map[string]markdownLinkedDir
+}
+
+type markdownLinkedDir struct {
+	entries []DirEntry
+	// isNotFound is set for missing directories and when the limit of read directories is reached.
+	isNotFound bool
+}
+
+func (l *markdownLinkResolver) resolve(dest string, isImage bool) string {
+	rawPath, fragment, _ := strings.Cut(dest, "#")
+	rawPath, _, _ = strings.Cut(rawPath, "?")
+
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		path = rawPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = l.dir + "/" + path
+	}
+	path = pkgPath.Clean(path)
+	if fragment != "" {
+		fragment = "#" + markdownIDPrefix + fragment
+	}
+
+	if path == "/" {
+		if isImage {
+			return ""
+		}
+		return "/ui/" + fragment
+	}
+
+	dir, name := pkgPath.Split(path)
+	linkedDir := l.readDir(pkgPath.Clean(dir))
+	if linkedDir.isNotFound {
+		return ""
+	}
+	idx := -1
+	for i, entry := range linkedDir.entries {
+		if entry.Filename == name {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return ""
+	}
+	entry := linkedDir.entries[idx]
+
+	if !l.access.IsVisible(path, entry.IsDir) {
+		return ""
+	}
+
+	// Use the same URLs as the directory listing.
+	switch {
+	case isImage && (entry.FileType == rview.FileTypeImage || entry.FileType == rview.FileTypeRawImage):
+		return cmp.Or(entry.ThumbnailURL, entry.OriginalFileURL)
+	case isImage:
+		return entry.OriginalFileURL
+	case entry.WebDirURL != "":
+		// Directories and archives.
+		return entry.WebDirURL + fragment
+	case entry.CanPreview:
+		return getWebURL(path, false)
+	default:
+		return entry.OriginalFileURL
+	}
+}
+
+func (l *markdownLinkResolver) readDir(dir string) markdownLinkedDir {
+	if res, ok := l.dirs[dir]; ok {
+		return res
+	}
+	if len(l.dirs) >= maxMarkdownLinkedDirs || !l.access.IsVisible(misc.EnsureSuffix(dir, "/"), true) {
+		return markdownLinkedDir{isNotFound: true}
+	}
+
+	var res markdownLinkedDir
+	rcloneInfo, archivePath, err := l.s.readDir(l.ctx, misc.EnsureSuffix(dir, "/"), "", "")
+	if err != nil {
+		if !rclone.IsNotFoundError(err) && !errors.Is(err, archive.ErrNotFound) {
+			rlog.Errorf("couldn't read dir %q to resolve links: %s", dir, err)
+		}
+		res.isNotFound = true
+	} else {
+		res.entries = l.s.convertDirInfo(rcloneInfo, archivePath != "").Entries
+	}
+	l.dirs[dir] = res
+	return res
+}
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_handleMarkdown(t *testing.T) {
+	r := require.New(t)
+
+	const readme = "# Docs\n\n" +
+		"![logo](img/logo.png) [guide](docs/index.md#usage) [dir](docs/) [missing](none.md) " +
+		"[hidden](../../photos/2024/a.md) <script>alert(1)</script>"
+
+	s := &Server{
+		aclService: newTestACLService(t),
+		archiveService: &testArchiveService{
+			archiveID: rview.NewFileID("/public/a.zip", 1700000000, 100),
+			members: map[string]string{
+				"README.md":     readme,
+				"img/logo.png":  "png",
+				"docs/index.md": "# Guide",
+				"docs/a.txt":    "a",
+			},
+		},
+	}
+
+	getMarkdown := func(path string, size int) (string, int) {
+		url := fileIDToURL("/api/markdown", rview.NewFileID(path, 1700000000, int64(size)))
+		w := httptest.NewRecorder()
+		s.handleMarkdown(w, httptest.NewRequest("GET", url, nil))
+		return w.Body.String(), w.Code
+	}
+
+	res, code := getMarkdown("/public/a.zip/README.md", len(readme))
+	r.Equal(http.StatusOK, code)
+	r.Equal(
+		`<h1 id="md-docs">Docs</h1>`+"\n"+
+			`<p><img src="/api/file/public/a.zip/img/logo.png?mod_time=1700000000&amp;size=3" alt="logo" loading="lazy"> `+
+			`<a href="/ui/public/a.zip/docs/?preview=index.md">guide</a> <a href="/ui/public/a.zip/docs/">dir</a> missing hidden </p>`+"\n",
+		res,
+	)
+
+	_, code = getMarkdown("/public/a.zip/docs/a.txt", 1)
+	r.Equal(http.StatusBadRequest, code)
+	// Outdated ids.
+	_, code = getMarkdown("/public/a.zip/README.md", len(readme)+1)
+	r.Equal(http.StatusNotFound, code)
+
+	t.Run("readme", func(t *testing.T) {
+		r := require.New(t)
+
+		req := httptest.NewRequest("GET", "/ui/public/a.zip/docs/", nil)
+		access := s.getAccess(req)
+
+		info, err := s.getDirInfo(t.Context(), access, "/public/a.zip/docs/", nil)
+		r.NoError(err)
+		r.Equal(`<h1 id="md-guide">Guide</h1>`+"\n", string(s.renderReadme(t.Context(), access, info)))
+
+		info, err = s.getDirInfo(t.Context(), access, "/public/a.zip/img/", nil)
+		r.NoError(err)
+		r.Empty(s.renderReadme(t.Context(), access, info))
+	})
+}
+
+func TestServer_renderReadme_PlainText(t *testing.T) {
+	r := require.New(t)
+
+	s := &Server{
+		aclService: newTestACLService(t),
+		archiveService: &testArchiveService{
+			archiveID: rview.NewFileID("/public/a.zip", 1700000000, 100),
+			members: map[string]string{
+				"ReadMe.txt": "<b>hello</b>",
+				"index.md":   "# Index",
+			},
+		},
+	}
+	req := httptest.NewRequest("GET", "/ui/public/a.zip/", nil)
+	access := s.getAccess(req)
+
+	info, err := s.getDirInfo(t.Context(), access, "/public/a.zip/", nil)
+	r.NoError(err)
+
+	// "README.txt" has priority over "index.md".
+	res := string(s.renderReadme(t.Context(), access, info))
+	r.Equal(`<pre class="markdown-plain-text">&lt;b&gt;hello&lt;/b&gt;</pre>`, res)
+	r.False(strings.Contains(res, "Index"))
+}
@@ -2,12 +2,16 @@ package web
 
 import (
 	"bytes"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ShoshinNikita/rview/auth"
 	"github.com/ShoshinNikita/rview/pkg/metrics"
 	"github.com/ShoshinNikita/rview/pkg/rlog"
 	"github.com/prometheus/client_golang/prometheus"
@@ -26,7 +30,12 @@ func loggingMiddleware(h http.Handler) http.Handler {
 			"/ui/",
 			"/api/dir/",
 			"/api/file/",
+			"/api/archive/",
 			"/api/thumbnail/",
+			"/api/text-preview/",
+			"/api/markdown/",
+			"/s/",
+			"/api/share/",
 		}
 		for _, prefix := range prefixes {
 			if strings.HasPrefix(path, prefix) {
@@ -38,11 +47,23 @@ func loggingMiddleware(h http.Handler) http.Handler {
 		now := time.Now()
 		rw := newResponseWriter(w, r)
 
+		// The session is set by authMiddleware that is called after this middleware.
+		sessionRef := new(auth.Session)
+		r = r.WithContext(withSessionRef(r.Context(), sessionRef))
+
 		h.ServeHTTP(rw, r)
 
+		user := "anonymous"
+		if sessionRef.Username != "" {
+			user = fmt.Sprintf("%q (%s)", sessionRef.Username, sessionRef.Method)
+		}
 		if !rw.requestCanceledByUser && rw.errMsg.Len() > 0 {
-			rlog.Errorf(`request "%s %s" failed with code %d: %s`, r.Method, r.URL.Path, rw.statusCode, rw.errMsg.String())
+			rlog.Errorf(
+				`request "%s %s" by %s failed with code %d: %s`,
+				r.Method, r.URL.Path, user, rw.statusCode, rw.errMsg.String(),
+			)
 		}
+		rlog.Debugf(`request "%s %s" by %s: code %d, %s`, r.Method, r.URL.Path, user, rw.statusCode, time.Since(now))
 
 		statusCode := rw.statusCode
 		if rw.requestCanceledByUser {
@@ -113,6 +134,76 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.headerWritten = true
 }
 
+// authMiddleware checks that requests are made by authenticated users: unauthenticated users
+// are redirected to the login page, API requests fail with 401. Users can be authenticated by
+// a trusted reverse proxy or with a session cookie. CSRF tokens are checked for all requests
+// that can change state. If authentication is disabled, [auth.NoopService] accepts all requests.
+func authMiddleware(authService AuthService, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if path == "/login" || path == "/favicon.ico" ||
+			strings.HasPrefix(path, "/static/") || strings.HasPrefix(path, "/auth/oidc/") ||
+			// Share links are available to anonymous users.
+			strings.HasPrefix(path, "/s/") || strings.HasPrefix(path, "/api/share/") ||
+			// Upload scripts use a token, it is checked by the handler.
+			(path == "/api/invalidate" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := authService.GetProxySession(r.RemoteAddr, r.Header)
+		if errors.Is(err, auth.ErrUntrustedProxy) {
+			rlog.Debugf("proxy header is ignored for request from untrusted address %q", r.RemoteAddr)
+		}
+
+		var cookie *http.Cookie
+		if err != nil {
+			var token string
+			cookie, err = r.Cookie(sessionCookieName)
+			if err == nil {
+				token = cookie.Value
+			}
+			session, err = authService.GetSession(token)
+		}
+		if err != nil {
+			if cookie != nil {
+				deleteSessionCookie(w, r)
+			}
+
+			if r.Method == http.MethodGet && !strings.HasPrefix(path, "/api/") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			} else {
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+			}
+			return
+		}
+
+		isSafeMethod := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		if !isSafeMethod && session.CSRFToken != "" {
+			csrfToken := r.Header.Get(csrfTokenHeader)
+			if csrfToken == "" {
+				csrfToken = r.PostFormValue(csrfTokenFormField)
+			}
+			if subtle.ConstantTimeCompare([]byte(csrfToken), []byte(session.CSRFToken)) != 1 {
+				writeError(w, http.StatusForbidden, "invalid csrf token")
+				return
+			}
+		}
+
+		if sessionRef := getSessionRef(r.Context()); sessionRef != nil {
+			*sessionRef = session
+		}
+		h.ServeHTTP(w, r.WithContext(withSession(r.Context(), session)))
+	})
+}
+
+// getBearerToken returns the token from header "Authorization: Bearer <token>". Such requests
+// are not made by browsers, so they don't need CSRF protection.
+func getBearerToken(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
 // cacheMiddleware sets "Cache-Control" and "Etag" headers.
 func cacheMiddleware(maxAge time.Duration, gitHash string, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
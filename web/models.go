@@ -1,6 +1,7 @@
 package web
 
 import (
+	"html/template"
 	"time"
 
 	"github.com/ShoshinNikita/rview/rview"
@@ -26,8 +27,24 @@ type DirInfo struct {
 	// IsNotFound indicates whether the requested directory wasn't found.
 	IsNotFound bool `json:"is_not_found"`
 
+	// CachedAt is set when rclone is unavailable and the outdated listing is shown.
+	CachedAt time.Time `json:"cached_at,omitzero"`
+	// HumanReadableCacheAge is the age of the outdated listing, see [misc.FormatAge].
+	HumanReadableCacheAge string `json:"-"`
+
+	// ArchivePath is the path of the archive if the directory is inside it.
+	ArchivePath string `json:"archive_path,omitempty"`
+
+	// Readme is the rendered README file of the directory. It is set only for the UI.
+	Readme template.HTML `json:"-"`
+
 	// Search contains a search phrase used for the 'Search Results' page.
 	Search string `json:"search"`
+
+	// Remotes contains names of all remotes in multi-remote mode.
+	Remotes []string `json:"remotes,omitempty"`
+	// Remote is the name of the remote of the current directory in multi-remote mode.
+	Remote string `json:"remote,omitempty"`
 }
 
 type DirBreadcrumb struct {
@@ -54,12 +71,117 @@ type DirEntry struct {
 	WebDirURL string `json:"web_dir_url,omitempty"`
 	// OriginalFileURL is an url that should be used to open an original file.
 	OriginalFileURL string `json:"original_file_url,omitempty"`
-	// ThumbnailURL is an url that should be used to open a thumbnail file (not empty only for images).
+	// ThumbnailURL is an url that should be used to open a thumbnail file (not empty only for images, videos, documents and audio files).
 	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	// StoryboardURL is an url that should be used to open a storyboard image with evenly spaced
+	// frames (not empty only for videos). Add "format=json" to get positions of the frames.
+	StoryboardURL string `json:"storyboard_url,omitempty"`
+	// TranscodedVideoURL is an url that should be used to play a video that is not supported
+	// by browsers (not empty only for videos when transcoding is enabled).
+	TranscodedVideoURL string `json:"transcoded_video_url,omitempty"`
+	// MetadataURL is an url that should be used to get metadata of an image: camera, exposure,
+	// GPS and etc. (not empty only for images when metadata extraction is enabled).
+	MetadataURL string `json:"metadata_url,omitempty"`
+	// TextPreviewURL is an url that should be used to get a highlighted preview of a text file
+	// (not empty only for text files).
+	TextPreviewURL string `json:"text_preview_url,omitempty"`
+	// MarkdownURL is an url that should be used to get a rendered Markdown file (not empty only
+	// for Markdown files).
+	MarkdownURL string `json:"markdown_url,omitempty"`
+	// GroupTitle is a title of a group of files which starts with this entry. It is set
+	// only when files are sorted by capture time.
+	GroupTitle string `json:"group_title,omitempty"`
 	// IconName is an name of an file icon. The icon choice is based on filename and file extension.
 	IconName string `json:"icon_name"`
 }
 
+type TextPreview struct {
+	// HTML contains highlighted lines of a text file. Every line is wrapped in <span class="line">,
+	// its number is stored in "data-line" attribute.
+	HTML     string `json:"html"`
+	Language string `json:"language,omitempty"`
+	Encoding string `json:"encoding"`
+	// NextOffset is an offset of the next part of the file, 0 means that the whole file is loaded.
+	// The next part should be requested with "offset", "line" and "encoding" query parameters.
+	NextOffset int64 `json:"next_offset,omitempty"`
+	NextLine   int   `json:"next_line,omitempty"`
+}
+
+type TimelinePage struct {
+	rview.BuildInfo
+
+	Years  []TimelineYear
+	Total  int
+	Offset int
+	// Filtered indicates whether the timeline contains only files from the selected area of the map.
+	Filtered bool
+}
+
+type TimelineResponse struct {
+	Entries []TimelineEntry `json:"entries"`
+	// Years contains info about all years and months of the timeline and can be used for navigation.
+	Years  []TimelineYear `json:"years"`
+	Offset int            `json:"offset"`
+	// NextOffset is an offset of the next part of the timeline, 0 means that there are no more entries.
+	NextOffset int `json:"next_offset,omitempty"`
+	Total      int `json:"total"`
+}
+
+type TimelineEntry struct {
+	DirEntry
+
+	// Path is the full unescaped path of a file.
+	Path string `json:"path"`
+	// Date is the capture time of a file or its mod time if capture time is unknown.
+	Date time.Time `json:"date"`
+	// WebURL is an url to the preview of a file in its directory.
+	WebURL string `json:"web_url"`
+}
+
+type TimelineYear struct {
+	Year   int             `json:"year"`
+	Offset int             `json:"offset"`
+	Count  int             `json:"count"`
+	Months []TimelineMonth `json:"months"`
+}
+
+type TimelineMonth struct {
+	Month  int    `json:"month"`
+	Title  string `json:"title"`
+	Offset int    `json:"offset"`
+	Count  int    `json:"count"`
+}
+
+type MapPage struct {
+	rview.BuildInfo
+
+	// TileURL is a template of an url for map tiles.
+	TileURL string
+	// BBox is the bounding box of all geotagged files, empty if there are no such files.
+	BBox string
+	// MetadataEnabled indicates whether metadata extraction is enabled. GPS coordinates are
+	// not available without it.
+	MetadataEnabled bool
+}
+
+type MapResponse struct {
+	Clusters []MapCluster `json:"clusters"`
+	Total    int          `json:"total"`
+}
+
+type MapCluster struct {
+	// Latitude and Longitude define the center of a cluster.
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Count     int     `json:"count"`
+	// BBox is the bounding box of all files of a cluster, in format "west,south,east,north".
+	BBox string `json:"bbox"`
+	// ThumbnailURL is an url of a thumbnail of the newest file of a cluster.
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	// TimelineURL is an url to the timeline page with files of a cluster.
+	TimelineURL string `json:"timeline_url"`
+}
+
 type SearchResponse struct {
 	Search string      `json:"search"`
 	Hits   []SearchHit `json:"hits"`
@@ -74,4 +196,81 @@ type SearchHit struct {
 	Score   float32 `json:"score"`
 	WebURL  string  `json:"web_url"`
 	Icon    string  `json:"icon"`
+	// Snippet is a part of the file content with highlighted matches, only for content search.
+	Snippet template.HTML `json:"snippet,omitempty"`
+}
+
+type DuplicatesPage struct {
+	rview.BuildInfo
+	DuplicatesResponse
+
+	// Enabled indicates whether duplicates detection is enabled.
+	Enabled bool
+	// Ready indicates whether duplicates have been detected at least once.
+	Ready bool
+}
+
+type DuplicatesResponse struct {
+	Groups []DuplicatesGroup `json:"groups"`
+	// HashType is the type of content hashes used to detect identical files, for example "md5".
+	// It is empty if the remote doesn't support hashes.
+	HashType  string    `json:"hash_type,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DuplicatesGroup struct {
+	// Kind is either "identical" or "similar".
+	Kind string `json:"kind"`
+	// WastedSize is the total size of all files of a group except the largest one.
+	WastedSize              int64           `json:"wasted_size"`
+	HumanReadableWastedSize string          `json:"human_readable_wasted_size"`
+	Files                   []DuplicateFile `json:"files"`
+}
+
+type DuplicateFile struct {
+	DirEntry
+
+	// Path is the full unescaped path of a file.
+	Path string `json:"path"`
+	// WebURL is an url to the preview of a file in its directory.
+	WebURL string `json:"web_url"`
+}
+
+type LoginPage struct {
+	rview.BuildInfo
+
+	PasswordEnabled bool
+	OIDCEnabled     bool
+
+	// Next is the url to redirect to after successful login.
+	Next     string
+	Username string
+	Error    string
+}
+
+type SharePage struct {
+	rview.BuildInfo
+
+	// URL is the url of the share link.
+	URL string
+	// Name is the name of the shared file or directory.
+	Name      string
+	ExpiresAt time.Time
+
+	// PasswordRequired indicates that the link is protected with a password that wasn't entered yet.
+	PasswordRequired bool
+	Error            string
+
+	// Dir is the path of the current directory relative to the shared one (only for directories).
+	Dir         string
+	Breadcrumbs []DirBreadcrumb
+	Entries     []DirEntry
+	// File is the shared file (only for files).
+	File *DirEntry
+}
+
+type ShareLinkResponse struct {
+	// URL is a relative url of the share link.
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
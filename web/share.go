@@ -0,0 +1,398 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	pkgPath "path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ShoshinNikita/rview/acl"
+	"github.com/ShoshinNikita/rview/pkg/misc"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/ShoshinNikita/rview/share"
+	"github.com/ShoshinNikita/rview/static"
+)
+
+// shareCookiePrefix is a prefix of cookies with tokens of unlocked password-protected links.
+const shareCookiePrefix = "rview_share_"
+
+var errSharedFileChanged = errors.New("shared file was modified or removed")
+
+// handleCreateShareLink creates a new share link for a file or a directory. Users can share
+// only files and directories available to them.
+func (s *Server) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	path := r.PostFormValue("path")
+	ttl, err := time.ParseDuration(r.PostFormValue("ttl"))
+	if err != nil {
+		writeBadRequestError(w, "invalid ttl: %s", err)
+		return
+	}
+
+	var modTime, size int64
+	if !strings.HasSuffix(path, "/") {
+		modTime, err = strconv.ParseInt(r.PostFormValue("mod_time"), 10, 64)
+		if err != nil {
+			writeBadRequestError(w, "invalid mod_time: %s", err)
+			return
+		}
+		size, err = strconv.ParseInt(r.PostFormValue("size"), 10, 64)
+		if err != nil {
+			writeBadRequestError(w, "invalid size: %s", err)
+			return
+		}
+	}
+
+	if !s.getAccess(r).IsAllowed(path) {
+		writeError(w, http.StatusNotFound, "%q not found", path)
+		return
+	}
+
+	token, link, err := s.shareService.CreateLink(share.CreateOptions{
+		Path:      path,
+		ModTime:   modTime,
+		Size:      size,
+		TTL:       ttl,
+		Password:  r.PostFormValue("password"),
+		CreatedBy: s.getUsername(r),
+	})
+	switch {
+	case errors.Is(err, share.ErrNoopShareService):
+		writeBadRequestError(w, "share links are disabled")
+		return
+	case errors.Is(err, share.ErrInvalidOptions):
+		writeBadRequestError(w, "%s", err)
+		return
+	case err != nil:
+		writeInternalServerError(w, "couldn't create share link: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ShareLinkResponse{
+		URL:       getShareURL(token),
+		ExpiresAt: link.ExpiresAt.UTC(),
+	})
+}
+
+// handleGetShareLinks returns links created by the current user.
+func (s *Server) handleGetShareLinks(w http.ResponseWriter, r *http.Request) {
+	links := s.shareService.GetLinks(s.getUsername(r))
+	if links == nil {
+		links = []share.LinkInfo{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// handleSharePage shows a shared file or the content of a shared directory. The page is
+// available to anonymous users.
+func (s *Server) handleSharePage(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	link, ok := s.getShareLink(w, r)
+	if !ok {
+		return
+	}
+
+	page := SharePage{
+		BuildInfo: s.cfg.BuildInfo,
+		URL:       getShareURL(token),
+		Name:      pkgPath.Base(link.Path),
+		ExpiresAt: link.ExpiresAt.UTC(),
+	}
+	if !s.isShareUnlocked(r, link) {
+		page.PasswordRequired = true
+		s.executeTemplate(w, r, "share.html", page)
+		return
+	}
+
+	access := s.getShareAccess(link)
+
+	if !link.IsDir() {
+		if !access.IsAllowed(link.Path) {
+			writeError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		entry, err := s.getSharedFile(r.Context(), link)
+		if err != nil {
+			if errors.Is(err, errSharedFileChanged) {
+				writeError(w, http.StatusNotFound, "%s", err)
+				return
+			}
+			writeInternalServerError(w, "couldn't get shared file: %s", err)
+			return
+		}
+
+		file := s.convertSharedEntry(token, link, entry)
+		page.File = &file
+		s.executeTemplate(w, r, "share.html", page)
+		return
+	}
+
+	dir, ok := getSharedPath(link, misc.EnsureSuffix(r.PathValue("path"), "/"))
+	if !ok || !access.IsVisible(dir, true) {
+		writeError(w, http.StatusNotFound, "dir not found")
+		return
+	}
+	info, err := s.rclone.GetDirInfo(r.Context(), dir, "namedirfirst", "asc")
+	if err != nil {
+		if rclone.IsNotFoundError(err) {
+			writeError(w, http.StatusNotFound, "dir not found")
+			return
+		}
+		writeInternalServerError(w, "couldn't get dir info: %s", err)
+		return
+	}
+
+	page.Dir = strings.TrimPrefix(dir, link.Path)
+	page.Breadcrumbs = getShareBreadcrumbs(token, page.Name, page.Dir)
+	entries := filterDirEntries(access, info.Entries)
+	page.Entries = make([]DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		page.Entries = append(page.Entries, s.convertSharedEntry(token, link, entry))
+	}
+
+	s.executeTemplate(w, r, "share.html", page)
+}
+
+// handleUnlockShare checks the password of a password-protected link.
+func (s *Server) handleUnlockShare(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	link, ok := s.getShareLink(w, r)
+	if !ok {
+		return
+	}
+
+	writePasswordError := func(code int, msg string) {
+		w.WriteHeader(code)
+		s.executeTemplate(w, r, "share.html", SharePage{
+			BuildInfo:        s.cfg.BuildInfo,
+			URL:              getShareURL(token),
+			Name:             pkgPath.Base(link.Path),
+			ExpiresAt:        link.ExpiresAt.UTC(),
+			PasswordRequired: true,
+			Error:            msg,
+		})
+	}
+
+	unlockToken, err := s.shareService.Unlock(link, r.PostFormValue("password"), s.getClientIP(r))
+	switch {
+	case errors.Is(err, share.ErrInvalidPassword):
+		writePasswordError(http.StatusUnauthorized, "Invalid password")
+		return
+	case errors.Is(err, share.ErrTooManyAttempts):
+		writePasswordError(http.StatusTooManyRequests, "Too many attempts, try again later")
+		return
+	case err != nil:
+		writeError(w, http.StatusNotFound, "link not found")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookiePrefix + link.ID,
+		Value:    unlockToken,
+		Path:     "/",
+		Expires:  link.ExpiresAt,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, getShareURL(token), http.StatusSeeOther)
+}
+
+// handleShareFile proxies the request for a shared file to Rclone.
+func (s *Server) handleShareFile(w http.ResponseWriter, r *http.Request) {
+	link, id, ok := s.getSharedFileID(w, r, "file")
+	if !ok {
+		return
+	}
+
+	// Count only the first request, browsers make multiple requests with "Range" header
+	// to play videos.
+	if rangeHeader := r.Header.Get("Range"); rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-") {
+		s.shareService.AddDownload(link)
+	}
+
+	s.rclone.ProxyFileRequest(id, w, r)
+}
+
+// handleShareThumbnail returns the thumbnail of a shared file.
+func (s *Server) handleShareThumbnail(w http.ResponseWriter, r *http.Request) {
+	_, id, ok := s.getSharedFileID(w, r, "thumbnail")
+	if !ok {
+		return
+	}
+
+	s.writeThumbnail(w, r, id)
+}
+
+// getShareLink returns the link by the token from the request path. It writes 404 Not Found
+// for invalid and expired links.
+func (s *Server) getShareLink(w http.ResponseWriter, r *http.Request) (share.Link, bool) {
+	link, err := s.shareService.GetLink(r.PathValue("token"))
+	switch {
+	case errors.Is(err, share.ErrLinkExpired):
+		writeError(w, http.StatusNotFound, "link is expired")
+		return share.Link{}, false
+	case err != nil:
+		writeError(w, http.StatusNotFound, "link not found")
+		return share.Link{}, false
+	}
+	return link, true
+}
+
+// getShareAccess returns access of the creator of the link. It is checked on every request
+// because access rules can change after the link was created.
+func (s *Server) getShareAccess(link share.Link) acl.Access {
+	return s.aclService.GetAccess(link.CreatedBy)
+}
+
+func (s *Server) isShareUnlocked(r *http.Request, link share.Link) bool {
+	var unlockToken string
+	if cookie, err := r.Cookie(shareCookiePrefix + link.ID); err == nil {
+		unlockToken = cookie.Value
+	}
+	return s.shareService.IsUnlocked(link, unlockToken)
+}
+
+// getSharedFileID returns the id of a file requested with "/api/share/<token>/<endpoint>/<path>".
+// For links to directories, the path is relative to the shared directory and the file id must
+// be passed in the query, see [fileIDFromRequest]. For links to files, the path is ignored.
+func (s *Server) getSharedFileID(w http.ResponseWriter, r *http.Request, endpoint string) (share.Link, rview.FileID, bool) {
+	link, ok := s.getShareLink(w, r)
+	if !ok {
+		return share.Link{}, rview.FileID{}, false
+	}
+	if !s.isShareUnlocked(r, link) {
+		writeError(w, http.StatusUnauthorized, "password required")
+		return share.Link{}, rview.FileID{}, false
+	}
+
+	access := s.getShareAccess(link)
+
+	if !link.IsDir() {
+		if !access.IsAllowed(link.Path) {
+			writeError(w, http.StatusNotFound, "file not found")
+			return share.Link{}, rview.FileID{}, false
+		}
+		return link, link.FileID(), true
+	}
+
+	relID, err := fileIDFromRequest(r, "/api/share/"+r.PathValue("token")+"/"+endpoint)
+	if err != nil {
+		writeBadRequestError(w, "invalid file id: %s", err.Error())
+		return share.Link{}, rview.FileID{}, false
+	}
+	path, ok := getSharedPath(link, relID.GetPath())
+	if !ok || strings.HasSuffix(path, "/") || !access.IsAllowed(path) {
+		writeError(w, http.StatusNotFound, "file not found")
+		return share.Link{}, rview.FileID{}, false
+	}
+	return link, rview.NewFileID(path, relID.GetModTime(), relID.GetSize()), true
+}
+
+// getSharedPath returns the full path by the path relative to the shared directory. It reports
+// false if the path is outside the shared directory.
+func getSharedPath(link share.Link, relPath string) (string, bool) {
+	if !link.IsDir() {
+		return link.Path, true
+	}
+
+	path := pkgPath.Join(link.Path, relPath)
+	if relPath == "" || strings.HasSuffix(relPath, "/") {
+		path = misc.EnsureSuffix(path, "/")
+	}
+	return path, link.Contains(path)
+}
+
+// getSharedFile returns the shared file. Links to files become invalid after the files are
+// modified, so it returns [errSharedFileChanged] if the file has another mod time or size.
+func (s *Server) getSharedFile(ctx context.Context, link share.Link) (rclone.DirEntry, error) {
+	dir := misc.EnsureSuffix(pkgPath.Dir(link.Path), "/")
+	info, err := s.rclone.GetDirInfo(ctx, dir, "", "")
+	if err != nil {
+		if rclone.IsNotFoundError(err) {
+			return rclone.DirEntry{}, errSharedFileChanged
+		}
+		return rclone.DirEntry{}, fmt.Errorf("couldn't get dir info: %w", err)
+	}
+	for _, entry := range info.Entries {
+		if entry.URL == link.Path && entry.ModTime == link.ModTime && entry.Size == link.Size {
+			return entry, nil
+		}
+	}
+	return rclone.DirEntry{}, errSharedFileChanged
+}
+
+// convertSharedEntry converts an entry of a shared directory. Urls of the entry point to
+// the public share endpoints.
+func (s *Server) convertSharedEntry(token string, link share.Link, entry rclone.DirEntry) DirEntry {
+	relPath := "/" + pkgPath.Base(entry.URL)
+	if link.IsDir() {
+		relPath = strings.TrimPrefix(entry.URL, strings.TrimSuffix(link.Path, "/"))
+	}
+
+	filename := pkgPath.Clean(entry.Leaf)
+	modTime := time.Unix(entry.ModTime, 0).UTC()
+	res := DirEntry{
+		Filename:             filename,
+		IsDir:                entry.IsDir,
+		Size:                 entry.Size,
+		ModTime:              modTime,
+		HumanReadableModTime: misc.FormatModTime(modTime),
+		IconName:             static.GetFileIcon(filename, entry.IsDir),
+	}
+	if entry.IsDir {
+		res.WebDirURL = mustParseURL("/s/").JoinPath(token, relPath, "/").String()
+		return res
+	}
+
+	id := rview.NewFileID(entry.URL, entry.ModTime, entry.Size)
+	relID := rview.NewFileID(relPath, entry.ModTime, entry.Size)
+	prefix := "/api/share/" + token
+
+	res.HumanReadableSize = misc.FormatFileSize(entry.Size)
+	res.FileType = rview.GetFileType(id.GetExt())
+	res.OriginalFileURL = fileIDToURL(prefix+"/file", relID)
+
+	switch s.cfg.ImagePreviewMode {
+	case rview.ImagePreviewModeOriginal:
+		if res.FileType == rview.FileTypeImage {
+			res.ThumbnailURL = res.OriginalFileURL
+		}
+	case rview.ImagePreviewModeThumbnails:
+		if s.thumbnailService.CanGenerateThumbnail(id) {
+			res.ThumbnailURL = fileIDToURL(prefix+"/thumbnail", relID)
+		}
+	}
+	return res
+}
+
+// getShareBreadcrumbs returns breadcrumbs for a directory relative to the shared one.
+func getShareBreadcrumbs(token, name, dir string) []DirBreadcrumb {
+	res := []DirBreadcrumb{
+		{Link: getShareURL(token), Text: name},
+	}
+	link := mustParseURL("/s/").JoinPath(token)
+	for part := range strings.SplitSeq(strings.Trim(dir, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		link = link.JoinPath(part)
+		res = append(res, DirBreadcrumb{
+			Link: link.String() + "/",
+			Text: part,
+		})
+	}
+	return res
+}
+
+func getShareURL(token string) string {
+	return "/s/" + token + "/"
+}
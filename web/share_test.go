@@ -0,0 +1,288 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShoshinNikita/rview/auth"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/ShoshinNikita/rview/share"
+	"github.com/ShoshinNikita/rview/static"
+	"github.com/ShoshinNikita/rview/thumbnails"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSharedPath(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	dirLink := share.Link{Path: "/photos/2024/"}
+	for relPath, want := range map[string]string{
+		"":                "/photos/2024/",
+		"/":               "/photos/2024/",
+		"/a.jpg":          "/photos/2024/a.jpg",
+		"/dir/":           "/photos/2024/dir/",
+		"dir/b.jpg":       "/photos/2024/dir/b.jpg",
+		"/../2023/a.jpg":  "",
+		"/dir/../../../":  "",
+		"/dir/../c.jpg":   "/photos/2024/c.jpg",
+		"/../2024-02/":    "",
+		"/../2024/d.jpg":  "/photos/2024/d.jpg",
+		"/../../a.jpg":    "",
+		"/./dir/./e.jpg/": "/photos/2024/dir/e.jpg/",
+	} {
+		path, ok := getSharedPath(dirLink, relPath)
+		if want == "" {
+			r.False(ok, relPath)
+			continue
+		}
+		r.True(ok, relPath)
+		r.Equal(want, path, relPath)
+	}
+
+	// The path is ignored for links to files.
+	path, ok := getSharedPath(share.Link{Path: "/photos/a.jpg"}, "/../b.jpg")
+	r.True(ok)
+	r.Equal("/photos/a.jpg", path)
+}
+
+func TestServer_handleShareLinks(t *testing.T) {
+	r := require.New(t)
+
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+	shareService, err := share.NewService(dirRoot)
+	r.NoError(err)
+
+	s := &Server{
+		cfg:          rview.Config{ShareLinks: true},
+		aclService:   newTestACLService(t),
+		shareService: shareService,
+		iconsFS:      static.NewIconsFS(false),
+		templatesFS:  static.NewTemplatesFS(false),
+	}
+
+	createLink := func(username string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/shares", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(withSession(req.Context(), auth.Session{Username: username}))
+
+		w := httptest.NewRecorder()
+		s.handleCreateShareLink(w, req)
+		return w
+	}
+
+	// Users can share only available files.
+	w := createLink("alice", url.Values{"path": {"/photos/2024/"}, "ttl": {"1h"}})
+	r.Equal(http.StatusNotFound, w.Code)
+
+	w = createLink("bob", url.Values{"path": {"/photos/2024/dir/../"}, "ttl": {"1h"}})
+	r.Equal(http.StatusBadRequest, w.Code)
+
+	w = createLink("bob", url.Values{"path": {"/photos/2024/a.jpg"}, "ttl": {"1h"}})
+	r.Equal(http.StatusBadRequest, w.Code)
+	r.Contains(w.Body.String(), "invalid mod_time")
+
+	w = createLink("bob", url.Values{"path": {"/photos/2024/"}, "ttl": {"1h"}, "password": {"qwerty"}})
+	r.Equal(http.StatusOK, w.Code)
+
+	var resp ShareLinkResponse
+	r.NoError(json.NewDecoder(w.Body).Decode(&resp))
+	r.True(strings.HasPrefix(resp.URL, "/s/"))
+	r.WithinDuration(time.Now().Add(time.Hour), resp.ExpiresAt, time.Minute)
+
+	token := strings.TrimSuffix(strings.TrimPrefix(resp.URL, "/s/"), "/")
+
+	req := httptest.NewRequest("GET", "/api/shares", nil)
+	req = req.WithContext(withSession(req.Context(), auth.Session{Username: "bob"}))
+	w = httptest.NewRecorder()
+	s.handleGetShareLinks(w, req)
+	r.Equal(http.StatusOK, w.Code)
+
+	var links []share.LinkInfo
+	r.NoError(json.NewDecoder(w.Body).Decode(&links))
+	r.Len(links, 1)
+	r.Equal("/photos/2024/", links[0].Path)
+
+	// The link is protected with a password.
+	openPage := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", resp.URL, nil)
+		req.SetPathValue("token", token)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		s.handleSharePage(w, req)
+		return w
+	}
+	w = openPage()
+	r.Equal(http.StatusOK, w.Code)
+	r.Contains(w.Body.String(), "This link is protected with a password")
+
+	unlock := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", resp.URL+"unlock", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetPathValue("token", token)
+		w := httptest.NewRecorder()
+		s.handleUnlockShare(w, req)
+		return w
+	}
+	w = unlock("123")
+	r.Equal(http.StatusUnauthorized, w.Code)
+	r.Contains(w.Body.String(), "Invalid password")
+
+	w = unlock("qwerty")
+	r.Equal(http.StatusSeeOther, w.Code)
+	r.Equal(resp.URL, w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	r.Len(cookies, 1)
+	r.True(strings.HasPrefix(cookies[0].Name, shareCookiePrefix))
+
+	// Files are available only after unlock.
+	getFileID := func(path string, cookies ...*http.Cookie) (rview.FileID, int) {
+		req := httptest.NewRequest("GET", "/api/share/"+token+"/file"+path+"?mod_time=1&size=2", nil)
+		req.SetPathValue("token", token)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		_, id, ok := s.getSharedFileID(w, req, "file")
+		if !ok {
+			return rview.FileID{}, w.Code
+		}
+		return id, http.StatusOK
+	}
+
+	_, code := getFileID("/a.jpg")
+	r.Equal(http.StatusUnauthorized, code)
+
+	id, code := getFileID("/dir/a.jpg", cookies...)
+	r.Equal(http.StatusOK, code)
+	r.Equal(rview.NewFileID("/photos/2024/dir/a.jpg", 1, 2), id)
+
+	// Paths are cleaned before joining with the shared directory.
+	id, code = getFileID("/../dir/../b.jpg", cookies...)
+	r.Equal(http.StatusOK, code)
+	r.Equal(rview.NewFileID("/photos/2024/b.jpg", 1, 2), id)
+
+	// Files denied to the creator of the link are not available.
+	_, code = getFileID("/private/a.jpg", cookies...)
+	r.Equal(http.StatusNotFound, code)
+
+	// Invalid links.
+	req = httptest.NewRequest("GET", "/s/abc/", nil)
+	req.SetPathValue("token", "abc")
+	w = httptest.NewRecorder()
+	s.handleSharePage(w, req)
+	r.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestServer_handleSharePage_ACL(t *testing.T) {
+	r := require.New(t)
+
+	rcloneServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Path has format "/[/data]/photos/2024/".
+		_, dir, _ := strings.Cut(req.URL.Path, "]")
+		switch dir {
+		case "/photos/2024/":
+			fmt.Fprint(w, `{"dir": "/photos/2024/", "breadcrumbs": [{"text": "/"}], "entries": [
+				{"leaf": "a.jpg", "size": 1, "mod_time": 1},
+				{"leaf": "private", "is_dir": true, "size": 0, "mod_time": 1}
+			]}`)
+		case "/photos/2024/private/":
+			fmt.Fprint(w, `{"dir": "/photos/2024/private/", "breadcrumbs": [{"text": "/"}], "entries": [
+				{"leaf": "secret.jpg", "size": 1, "mod_time": 1}
+			]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer rcloneServer.Close()
+
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+	rcloneInstance, err := rclone.NewRclone(rview.RcloneConfig{
+		URL:     rcloneServer.URL,
+		Targets: rview.RcloneTargets{{Target: "/data"}},
+	}, dirRoot)
+	r.NoError(err)
+	shareService, err := share.NewService(dirRoot)
+	r.NoError(err)
+
+	s := &Server{
+		cfg:              rview.Config{ShareLinks: true},
+		rclone:           rcloneInstance,
+		thumbnailService: thumbnails.NewNoopThumbnailService(),
+		aclService:       newTestACLService(t),
+		shareService:     shareService,
+		iconsFS:          static.NewIconsFS(false),
+		templatesFS:      static.NewTemplatesFS(false),
+	}
+
+	openPage := func(token, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/s/"+token+"/"+path, nil)
+		req.SetPathValue("token", token)
+		req.SetPathValue("path", path)
+		w := httptest.NewRecorder()
+		s.handleSharePage(w, req)
+		return w
+	}
+
+	// Bob has access to "/photos/2024/" except "/photos/2024/private/".
+	token, _, err := shareService.CreateLink(share.CreateOptions{Path: "/photos/2024/", TTL: time.Hour, CreatedBy: "bob"})
+	r.NoError(err)
+
+	w := openPage(token, "")
+	r.Equal(http.StatusOK, w.Code)
+	r.Contains(w.Body.String(), "a.jpg")
+	r.NotContains(w.Body.String(), "private")
+
+	w = openPage(token, "private/")
+	r.Equal(http.StatusNotFound, w.Code)
+	r.NotContains(w.Body.String(), "secret.jpg")
+
+	// Access rules are checked on every request: links of users without access don't work.
+	token, _, err = shareService.CreateLink(share.CreateOptions{Path: "/photos/2024/", TTL: time.Hour, CreatedBy: "alice"})
+	r.NoError(err)
+
+	w = openPage(token, "")
+	r.Equal(http.StatusNotFound, w.Code)
+
+	token, _, err = shareService.CreateLink(share.CreateOptions{
+		Path: "/photos/2024/a.jpg", ModTime: 1, Size: 1, TTL: time.Hour, CreatedBy: "alice",
+	})
+	r.NoError(err)
+
+	w = openPage(token, "")
+	r.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestAuthMiddleware_ShareLinks(t *testing.T) {
+	r := require.New(t)
+
+	handler := authMiddleware(testAuthService{}, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for path, wantCode := range map[string]int{
+		"/s/token/":                      http.StatusOK,
+		"/s/token/dir/":                  http.StatusOK,
+		"/api/share/token/file/a.jpg":    http.StatusOK,
+		"/api/share/token/thumbnail/a.j": http.StatusOK,
+		"/api/shares":                    http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		r.Equal(wantCode, w.Code, path)
+	}
+}
@@ -0,0 +1,152 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ShoshinNikita/rview/archive"
+	"github.com/ShoshinNikita/rview/pkg/highlight"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+)
+
+// textPreviewChunkSize is the max size of a part of a text file returned by [Server.handleTextPreview].
+const textPreviewChunkSize = 256 << 10
+
+// handleTextPreview returns a highlighted part of a text file. Large files are split into
+// parts at line breaks, the next part can be requested with "offset", "line" and "encoding"
+// query parameters from the response.
+func (s *Server) handleTextPreview(w http.ResponseWriter, r *http.Request) {
+	id, err := fileIDFromRequest(r, "/api/text-preview")
+	if err != nil {
+		writeBadRequestError(w, "invalid file id: %s", err.Error())
+		return
+	}
+	if !s.checkFileAccess(w, r, id) {
+		return
+	}
+	if rview.GetFileType(id.GetExt()) != rview.FileTypeText {
+		writeBadRequestError(w, "%q is not a text file", id.GetName())
+		return
+	}
+
+	var (
+		offset   int64
+		line     = 1
+		encoding = r.FormValue("encoding")
+	)
+	if v := r.FormValue("offset"); v != "" {
+		offset, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || offset < 0 || offset > id.GetSize() {
+			writeBadRequestError(w, "invalid offset %q", v)
+			return
+		}
+	}
+	if v := r.FormValue("line"); v != "" {
+		line, err = strconv.Atoi(v)
+		if err != nil || line < 1 {
+			writeBadRequestError(w, "invalid line %q", v)
+			return
+		}
+	}
+	if encoding != "" && !highlight.IsSupportedEncoding(encoding) {
+		writeBadRequestError(w, "unsupported encoding %q", encoding)
+		return
+	}
+
+	data, err := s.readTextChunk(r.Context(), id, offset, textPreviewChunkSize)
+	if err != nil {
+		if rclone.IsNotFoundError(err) || errors.Is(err, archive.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "%s", err)
+			return
+		}
+		writeInternalServerError(w, "couldn't read file: %s", err)
+		return
+	}
+	end := offset + int64(len(data))
+
+	if encoding == "" {
+		var bomSize int
+		encoding, bomSize = highlight.DetectEncoding(data)
+		if offset == 0 {
+			data = data[bomSize:]
+		}
+	}
+
+	hasMore := end < id.GetSize()
+	if hasMore {
+		// Don't split lines. Very long lines can be split anyway.
+		if n := highlight.LastLineEnd(data, encoding); n > 0 {
+			end -= int64(len(data) - n)
+			data = data[:n]
+		}
+	}
+
+	text, n := highlight.Decode(data, encoding)
+	end -= int64(len(data) - n)
+
+	language := rview.GetLanguage(id.GetExt())
+	res := TextPreview{
+		HTML:     highlight.Highlight(text, language, line),
+		Language: language,
+		Encoding: encoding,
+	}
+	if hasMore {
+		res.NextOffset = end
+		res.NextLine = line + strings.Count(text, "\n")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// readTextChunk reads a part of the file, the file can be inside an archive.
+func (s *Server) readTextChunk(ctx context.Context, id rview.FileID, offset, limit int64) ([]byte, error) {
+	limit = min(limit, id.GetSize()-offset)
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	archiveID, memberPath, ok, err := s.archiveService.SplitPath(ctx, id.GetPath())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't check path: %w", err)
+	}
+
+	var rc io.ReadCloser
+	if ok {
+		var member archive.Member
+		rc, member, err = s.archiveService.OpenMember(ctx, archiveID, memberPath)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open file in archive: %w", err)
+		}
+		defer rc.Close()
+
+		if member.Size != id.GetSize() || member.ModTime.Unix() != id.GetModTime() {
+			return nil, fmt.Errorf("%w: file %q was changed", archive.ErrNotFound, memberPath)
+		}
+		// Compressed files can't be seeked.
+		_, err = io.CopyN(io.Discard, rc, offset)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't skip %d bytes: %w", offset, err)
+		}
+
+	} else {
+		rc, err = s.rclone.RequestFileRange(ctx, id, int(offset), int(offset+limit-1))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't request file range: %w", err)
+		}
+		defer rc.Close()
+	}
+
+	data, err := io.ReadAll(io.LimitReader(rc, limit))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read file: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ShoshinNikita/rview/pkg/highlight"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_handleTextPreview(t *testing.T) {
+	r := require.New(t)
+
+	// 7 bytes per line, the file doesn't fit into a single part.
+	content := strings.Repeat("x := 1\n", textPreviewChunkSize/7+100)
+	s := &Server{
+		aclService: newTestACLService(t),
+		archiveService: &testArchiveService{
+			archiveID: rview.NewFileID("/public/a.zip", 1700000000, 100),
+			members: map[string]string{
+				"main.go":  content,
+				"utf16.go": "\xFF\xFEv\x00a\x00r\x00",
+			},
+		},
+	}
+
+	getPreview := func(path string, size int, query string) (TextPreview, int) {
+		url := fileIDToURL("/api/text-preview", rview.NewFileID(path, 1700000000, int64(size))) + query
+		w := httptest.NewRecorder()
+		s.handleTextPreview(w, httptest.NewRequest("GET", url, nil))
+
+		var res TextPreview
+		if w.Code == http.StatusOK {
+			r.NoError(json.NewDecoder(w.Body).Decode(&res))
+		}
+		return res, w.Code
+	}
+
+	res, code := getPreview("/public/a.zip/main.go", len(content), "")
+	r.Equal(http.StatusOK, code)
+	r.Equal("go", res.Language)
+	r.Equal(highlight.EncodingUTF8, res.Encoding)
+	// Parts must end at line breaks.
+	r.Equal(int64(textPreviewChunkSize/7*7), res.NextOffset)
+	r.Equal(textPreviewChunkSize/7+1, res.NextLine)
+	r.True(strings.HasPrefix(res.HTML, `<span class="line" data-line="1">x := <span class="hl-number">1</span></span>`))
+
+	res, code = getPreview(
+		"/public/a.zip/main.go", len(content),
+		fmt.Sprintf("&offset=%d&line=%d&encoding=%s", res.NextOffset, res.NextLine, res.Encoding),
+	)
+	r.Equal(http.StatusOK, code)
+	r.Zero(res.NextOffset)
+	r.Equal(100, strings.Count(res.HTML, "\n"))
+	r.True(strings.HasPrefix(res.HTML, fmt.Sprintf(`<span class="line" data-line="%d">`, textPreviewChunkSize/7+1)))
+
+	res, code = getPreview("/public/a.zip/utf16.go", 8, "")
+	r.Equal(http.StatusOK, code)
+	r.Equal(highlight.EncodingUTF16LE, res.Encoding)
+	r.Equal(`<span class="line" data-line="1"><span class="hl-keyword">var</span></span>`+"\n", res.HTML)
+
+	_, code = getPreview("/public/a.zip/main.go", len(content), "&offset=-1")
+	r.Equal(http.StatusBadRequest, code)
+	_, code = getPreview("/public/a.zip/main.go", len(content), "&encoding=koi8-r")
+	r.Equal(http.StatusBadRequest, code)
+	_, code = getPreview("/public/a.zip/a.jpg", 3, "")
+	r.Equal(http.StatusBadRequest, code)
+	// Outdated ids.
+	_, code = getPreview("/public/a.zip/main.go", len(content)+1, "")
+	r.Equal(http.StatusNotFound, code)
+}
@@ -0,0 +1,233 @@
+package web
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	pkgPath "path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ShoshinNikita/rview/acl"
+	"github.com/ShoshinNikita/rview/metadata"
+	"github.com/ShoshinNikita/rview/rclone"
+	"github.com/ShoshinNikita/rview/rview"
+	"github.com/ShoshinNikita/rview/search"
+)
+
+const (
+	defaultTimelineLimit = 100
+	maxTimelineLimit     = 500
+
+	// timelineTTL defines how long the timeline is reused. Capture times and GPS coordinates
+	// are extracted in the background, so the timeline has to be rebuilt from time to time.
+	timelineTTL = time.Minute
+)
+
+// timeline contains all images and videos of the remote sorted by capture time (or mod time
+// if capture time is unknown) in reverse chronological order.
+type timeline struct {
+	entries []timelineEntry
+	years   []TimelineYear
+
+	createdAt time.Time
+}
+
+type timelineEntry struct {
+	search.Hit
+
+	date     time.Time
+	location *metadata.GPS
+}
+
+type timelineCache struct {
+	mu       sync.Mutex
+	timeline *timeline
+}
+
+// getTimeline returns the cached timeline or builds a new one from the search index.
+func (s *Server) getTimeline(ctx context.Context) (*timeline, error) {
+	s.timelineCache.mu.Lock()
+	defer s.timelineCache.mu.Unlock()
+
+	if t := s.timelineCache.timeline; t != nil && time.Since(t.createdAt) < timelineTTL {
+		return t, nil
+	}
+
+	files, err := s.searchService.GetFiles(ctx, func(path string) bool {
+		switch rview.GetFileType(rview.GetFileExt(path)) {
+		case rview.FileTypeImage, rview.FileTypeRawImage, rview.FileTypeVideo:
+			return true
+		default:
+			return false
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get files: %w", err)
+	}
+
+	ids := make([]rview.FileID, 0, len(files))
+	for _, f := range files {
+		ids = append(ids, rview.NewFileID(f.Path, f.ModTime, f.Size))
+	}
+	captureTimes := s.metadataService.GetCaptureTimes(ids)
+	locations := s.searchService.GetLocations()
+
+	t := buildTimeline(files, captureTimes, locations)
+	s.timelineCache.timeline = t
+
+	return t, nil
+}
+
+func buildTimeline(
+	files []search.Hit, captureTimes map[rview.FileID]time.Time, locations map[rview.FileID]metadata.GPS,
+) *timeline {
+
+	entries := make([]timelineEntry, 0, len(files))
+	for _, f := range files {
+		id := rview.NewFileID(f.Path, f.ModTime, f.Size)
+
+		date, ok := captureTimes[id]
+		if !ok {
+			date = time.Unix(f.ModTime, 0).UTC()
+		}
+		var location *metadata.GPS
+		if v, ok := locations[id]; ok {
+			location = &v
+		}
+		entries = append(entries, timelineEntry{Hit: f, date: date, location: location})
+	}
+	slices.SortFunc(entries, func(a, b timelineEntry) int {
+		if v := b.date.Compare(a.date); v != 0 {
+			return v
+		}
+		return cmp.Compare(a.Path, b.Path)
+	})
+
+	return newTimeline(entries, time.Now())
+}
+
+// filterByLocation returns a new timeline with files located within the bounding box.
+func (t *timeline) filterByLocation(bbox boundingBox) *timeline {
+	var entries []timelineEntry
+	for _, e := range t.entries {
+		if e.location != nil && bbox.contains(*e.location) {
+			entries = append(entries, e)
+		}
+	}
+	return newTimeline(entries, t.createdAt)
+}
+
+// filterByAccess returns a timeline with files available to the user. If all files are
+// available, it returns the same timeline.
+func (t *timeline) filterByAccess(access acl.Access) *timeline {
+	if access.IsFull() {
+		return t
+	}
+
+	var entries []timelineEntry
+	for _, e := range t.entries {
+		if access.IsAllowed(e.Path) {
+			entries = append(entries, e)
+		}
+	}
+	return newTimeline(entries, t.createdAt)
+}
+
+// newTimeline prepares a timeline from entries sorted in reverse chronological order.
+func newTimeline(entries []timelineEntry, createdAt time.Time) *timeline {
+	var years []TimelineYear
+	for i, e := range entries {
+		year, month := e.date.Year(), e.date.Month()
+
+		if len(years) == 0 || years[len(years)-1].Year != year {
+			years = append(years, TimelineYear{Year: year, Offset: i})
+		}
+		y := &years[len(years)-1]
+		y.Count++
+
+		if len(y.Months) == 0 || y.Months[len(y.Months)-1].Month != int(month) {
+			y.Months = append(y.Months, TimelineMonth{
+				Month:  int(month),
+				Title:  e.date.Format("January 2006"),
+				Offset: i,
+			})
+		}
+		y.Months[len(y.Months)-1].Count++
+	}
+
+	return &timeline{
+		entries:   entries,
+		years:     years,
+		createdAt: createdAt,
+	}
+}
+
+// getTimelinePage converts the requested part of the timeline. The group title is set
+// for the first entry of every month.
+func (s *Server) getTimelinePage(t *timeline, offset, limit int) TimelineResponse {
+	resp := TimelineResponse{
+		Entries: []TimelineEntry{},
+		Years:   t.years,
+		Offset:  offset,
+		Total:   len(t.entries),
+	}
+	if offset >= len(t.entries) {
+		return resp
+	}
+
+	end := min(offset+limit, len(t.entries))
+	page := t.entries[offset:end]
+	if end < len(t.entries) {
+		resp.NextOffset = end
+	}
+
+	rcloneEntries := make([]rclone.DirEntry, 0, len(page))
+	for _, e := range page {
+		rcloneEntries = append(rcloneEntries, rclone.DirEntry{
+			URL:     e.Path,
+			Leaf:    pkgPath.Base(e.Path),
+			Size:    e.Size,
+			ModTime: e.ModTime,
+		})
+	}
+	info := s.convertRcloneInfo(&rclone.DirInfo{Entries: rcloneEntries})
+
+	for i, e := range page {
+		entry := TimelineEntry{
+			DirEntry: info.Entries[i],
+			Path:     e.Path,
+			Date:     e.date,
+			WebURL:   getWebURL(e.Path, false),
+		}
+
+		title := e.date.Format("January 2006")
+		if offset+i == 0 || t.entries[offset+i-1].date.Format("January 2006") != title {
+			entry.GroupTitle = title
+		}
+		resp.Entries = append(resp.Entries, entry)
+	}
+	return resp
+}
+
+func parseTimelineParams(offsetValue, limitValue string) (offset, limit int, err error) {
+	if offsetValue != "" {
+		offset, err = strconv.Atoi(offsetValue)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset value: %q", offsetValue)
+		}
+	}
+
+	limit = defaultTimelineLimit
+	if limitValue != "" {
+		limit, err = strconv.Atoi(limitValue)
+		if err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit value: %q", limitValue)
+		}
+	}
+	limit = min(limit, maxTimelineLimit)
+
+	return offset, limit, nil
+}
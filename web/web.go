@@ -5,10 +5,12 @@ import (
 	"cmp"
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"html/template"
 	"io"
 	"io/fs"
@@ -21,12 +23,18 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ShoshinNikita/rview/acl"
+	"github.com/ShoshinNikita/rview/archive"
+	"github.com/ShoshinNikita/rview/auth"
+	"github.com/ShoshinNikita/rview/duplicates"
+	"github.com/ShoshinNikita/rview/metadata"
 	"github.com/ShoshinNikita/rview/pkg/cache"
 	"github.com/ShoshinNikita/rview/pkg/misc"
 	"github.com/ShoshinNikita/rview/pkg/rlog"
 	"github.com/ShoshinNikita/rview/rclone"
 	"github.com/ShoshinNikita/rview/rview"
 	"github.com/ShoshinNikita/rview/search"
+	"github.com/ShoshinNikita/rview/share"
 	"github.com/ShoshinNikita/rview/static"
 	"github.com/ShoshinNikita/rview/thumbnails"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -37,9 +45,18 @@ type Server struct {
 
 	httpServer *http.Server
 
-	rclone           *rclone.Rclone
-	thumbnailService ThumbnailService
-	searchService    *search.Service
+	rclone             *rclone.Rclone
+	thumbnailService   ThumbnailService
+	transcodingService TranscodingService
+	metadataService    MetadataService
+	searchService      *search.Service
+	duplicatesService  DuplicatesService
+	authService        AuthService
+	aclService         ACLService
+	shareService       ShareService
+	archiveService     ArchiveService
+
+	timelineCache timelineCache
 
 	iconsFS     fs.FS
 	templatesFS fs.FS
@@ -48,9 +65,76 @@ type Server struct {
 type ThumbnailService interface {
 	CanGenerateThumbnail(rview.FileID) bool
 	OpenThumbnail(context.Context, rview.FileID, thumbnails.ThumbnailSize) (rc io.ReadCloser, contentType string, err error)
+
+	CanGenerateStoryboard(rview.FileID) bool
+	OpenStoryboard(context.Context, rview.FileID) (io.ReadCloser, error)
+	GetStoryboardIndex(context.Context, rview.FileID) (thumbnails.StoryboardIndex, error)
+}
+
+type TranscodingService interface {
+	CanTranscode(rview.FileID) bool
+	Open(context.Context, rview.FileID) (rc io.ReadCloser, isComplete bool, err error)
+}
+
+type MetadataService interface {
+	CanExtractMetadata(rview.FileID) bool
+	GetMetadata(context.Context, rview.FileID) (metadata.Metadata, error)
+	GetCaptureTimes([]rview.FileID) map[rview.FileID]time.Time
+}
+
+type DuplicatesService interface {
+	GetDuplicates() (duplicates.Result, error)
+}
+
+type AuthService interface {
+	Login(username, password string) (token string, _ auth.Session, _ error)
+	GetSession(token string) (auth.Session, error)
+	Logout(token string) error
+
+	StartOIDCLogin(ctx context.Context, next string) (authURL, stateToken string, _ error)
+	FinishOIDCLogin(ctx context.Context, stateToken, state, code string) (token string, _ auth.Session, next string, _ error)
+
+	GetProxySession(remoteAddr string, header http.Header) (auth.Session, error)
+}
+
+type ACLService interface {
+	GetAccess(username string) acl.Access
+}
+
+type ShareService interface {
+	CreateLink(opts share.CreateOptions) (token string, _ share.Link, _ error)
+	GetLink(token string) (share.Link, error)
+	GetLinks(username string) []share.LinkInfo
+
+	Unlock(link share.Link, password, clientIP string) (unlockToken string, _ error)
+	IsUnlocked(link share.Link, unlockToken string) bool
+
+	AddDownload(link share.Link)
 }
 
-func NewServer(cfg rview.Config, rclone *rclone.Rclone, thumbnailService ThumbnailService, searchService *search.Service) (s *Server) {
+type ArchiveService interface {
+	Write(ctx context.Context, w io.Writer, opts archive.Options) error
+
+	SplitPath(ctx context.Context, path string) (archiveID rview.FileID, memberPath string, ok bool, err error)
+	GetDirInfo(ctx context.Context, archiveID rview.FileID, dir string, sort, order string) (*rclone.DirInfo, error)
+	OpenMember(ctx context.Context, archiveID rview.FileID, path string) (io.ReadCloser, archive.Member, error)
+}
+
+// Services contains dependencies of [Server].
+type Services struct {
+	Rclone             *rclone.Rclone
+	ThumbnailService   ThumbnailService
+	TranscodingService TranscodingService
+	MetadataService    MetadataService
+	SearchService      *search.Service
+	DuplicatesService  DuplicatesService
+	AuthService        AuthService
+	ACLService         ACLService
+	ShareService       ShareService
+	ArchiveService     ArchiveService
+}
+
+func NewServer(cfg rview.Config, services Services) (s *Server) {
 	if cfg.ReadStaticFilesFromDisk {
 		rlog.Info("static files will be read from disk")
 	}
@@ -58,9 +142,16 @@ func NewServer(cfg rview.Config, rclone *rclone.Rclone, thumbnailService Thumbna
 	s = &Server{
 		cfg: cfg,
 		//
-		rclone:           rclone,
-		thumbnailService: thumbnailService,
-		searchService:    searchService,
+		rclone:             services.Rclone,
+		thumbnailService:   services.ThumbnailService,
+		transcodingService: services.TranscodingService,
+		metadataService:    services.MetadataService,
+		searchService:      services.SearchService,
+		duplicatesService:  services.DuplicatesService,
+		authService:        services.AuthService,
+		aclService:         services.ACLService,
+		shareService:       services.ShareService,
+		archiveService:     services.ArchiveService,
 		//
 		iconsFS:     static.NewIconsFS(cfg.ReadStaticFilesFromDisk),
 		templatesFS: static.NewTemplatesFS(cfg.ReadStaticFilesFromDisk),
@@ -78,6 +169,22 @@ func NewServer(cfg rview.Config, rclone *rclone.Rclone, thumbnailService Thumbna
 	})
 	mux.HandleFunc("GET /ui/", s.handleUI)
 	mux.HandleFunc("GET /ui-search", s.handlePageWithSearchResults)
+	mux.HandleFunc("GET /ui-timeline", s.handlePageWithTimeline)
+	mux.HandleFunc("GET /ui-map", s.handlePageWithMap)
+	mux.HandleFunc("GET /ui-duplicates", s.handlePageWithDuplicates)
+
+	// Auth
+	mux.HandleFunc("GET /login", s.handlePageWithLogin)
+	mux.HandleFunc("POST /login", s.handleLogin)
+	mux.HandleFunc("POST /logout", s.handleLogout)
+	mux.HandleFunc("GET /auth/oidc/login", s.handleOIDCLogin)
+	mux.HandleFunc("GET /auth/oidc/callback", s.handleOIDCCallback)
+
+	// Share Links
+	mux.HandleFunc("GET /s/{token}/{path...}", s.handleSharePage)
+	mux.HandleFunc("POST /s/{token}/unlock", s.handleUnlockShare)
+	mux.HandleFunc("GET /api/share/{token}/file/", s.handleShareFile)
+	mux.HandleFunc("GET /api/share/{token}/thumbnail/", s.handleShareThumbnail)
 
 	// Static
 	for pattern, fs := range map[string]fs.FS{
@@ -97,9 +204,21 @@ func NewServer(cfg rview.Config, rclone *rclone.Rclone, thumbnailService Thumbna
 	// API
 	mux.HandleFunc("GET /api/dir/", s.handleDir)
 	mux.HandleFunc("GET /api/file/", s.handleFile)
+	mux.HandleFunc("GET /api/archive/", s.handleArchive)
 	mux.HandleFunc("GET /api/thumbnail/", s.handleThumbnail)
+	mux.HandleFunc("GET /api/storyboard/", s.handleStoryboard)
+	mux.HandleFunc("GET /api/transcoded-video/", s.handleTranscodedVideo)
+	mux.HandleFunc("GET /api/metadata/", s.handleMetadata)
+	mux.HandleFunc("GET /api/text-preview/", s.handleTextPreview)
+	mux.HandleFunc("GET /api/markdown/", s.handleMarkdown)
 	mux.HandleFunc("GET /api/search", s.handleSearch)
 	mux.HandleFunc("POST /api/search/refresh-index", s.handleRefreshIndex)
+	mux.HandleFunc("POST /api/invalidate", s.handleInvalidate)
+	mux.HandleFunc("GET /api/timeline", s.handleTimeline)
+	mux.HandleFunc("GET /api/map/clusters", s.handleMapClusters)
+	mux.HandleFunc("GET /api/duplicates", s.handleDuplicates)
+	mux.HandleFunc("GET /api/shares", s.handleGetShareLinks)
+	mux.HandleFunc("POST /api/shares", s.handleCreateShareLink)
 
 	// Prometheus Metrics
 	mux.Handle("GET /debug/metrics", promhttp.Handler())
@@ -111,7 +230,7 @@ func NewServer(cfg rview.Config, rclone *rclone.Rclone, thumbnailService Thumbna
 	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
 	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
 
-	handler := loggingMiddleware(mux)
+	handler := loggingMiddleware(authMiddleware(s.authService, mux))
 
 	s.httpServer = &http.Server{
 		Addr:              ":" + strconv.Itoa(cfg.ServerPort),
@@ -139,7 +258,7 @@ func (s *Server) Shutdown(ctx context.Context) error {
 func (s *Server) handleDir(w http.ResponseWriter, r *http.Request) {
 	dir := strings.TrimPrefix(r.URL.Path, "/api/dir/")
 
-	info, err := s.getDirInfo(r.Context(), dir, r.URL.Query())
+	info, err := s.getDirInfo(r.Context(), s.getAccess(r), dir, r.URL.Query())
 	if err != nil {
 		writeInternalServerError(w, "couldn't get dir info: %s", err)
 		return
@@ -157,16 +276,22 @@ func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
 	dir := strings.TrimPrefix(r.URL.Path, "/ui")
 	dir = misc.EnsureSuffix(dir, "/")
 
-	info, err := s.getDirInfo(r.Context(), dir, r.URL.Query())
+	access := s.getAccess(r)
+	info, err := s.getDirInfo(r.Context(), access, dir, r.URL.Query())
 	if err != nil {
 		writeInternalServerError(w, "couldn't get dir info: %s", err)
 		return
 	}
+	if !info.IsNotFound {
+		info.Readme = s.renderReadme(r.Context(), access, info)
+	}
 
-	s.executeTemplate(w, "index.html", info)
+	s.executeTemplate(w, r, "index.html", info)
 }
 
-func (s *Server) executeTemplate(w http.ResponseWriter, name string, data any) {
+func (s *Server) executeTemplate(w http.ResponseWriter, r *http.Request, name string, data any) {
+	session := getSession(r.Context())
+
 	// Parse templates every time because it doesn't affect performance but
 	// significantly simplifies the development process.
 	template, err := template.New("base").
@@ -249,8 +374,23 @@ func (s *Server) executeTemplate(w http.ResponseWriter, name string, data any) {
 			},
 			"formatSize":    misc.FormatFileSize,
 			"formatModTime": misc.FormatModTime,
+			"currentUser": func() string {
+				return session.Username
+			},
+			"csrfToken": func() string {
+				return session.CSRFToken
+			},
+			"shareLinksEnabled": func() bool {
+				return s.cfg.ShareLinks
+			},
+			"canLogout": func() bool {
+				// Users authenticated by a proxy have to log out on the proxy side.
+				return session.Username != "" && session.Method != auth.MethodProxy
+			},
 		}).
-		ParseFS(s.templatesFS, "index.html", "preview.html", "footer.html", "search-results.html", "entry.html")
+		ParseFS(s.templatesFS, "index.html", "preview.html", "footer.html", "search-results.html", "entry.html",
+			"timeline.html", "timeline-entries.html", "map.html", "duplicates.html", "login.html",
+			"share.html", "share-dialog.html")
 	if err != nil {
 		writeInternalServerError(w, "couldn't parse templates: %s", err)
 		return
@@ -286,7 +426,8 @@ func (s *Server) embedIcon(pack static.IconPack, name string) (template.HTML, er
 
 // getDirInfo requests the directory information from Rclone and converts it into
 // the appropriate format. It also sends tasks to generate thumbnail for the images.
-func (s *Server) getDirInfo(ctx context.Context, dir string, query url.Values) (DirInfo, error) {
+// Entries that are not visible with the passed access are skipped.
+func (s *Server) getDirInfo(ctx context.Context, access acl.Access, dir string, query url.Values) (DirInfo, error) {
 	dir = pkgPath.Clean(dir)
 	if dir == "." {
 		dir = "/"
@@ -298,12 +439,29 @@ func (s *Server) getDirInfo(ctx context.Context, dir string, query url.Values) (
 
 	var isNotFound bool
 
-	rcloneInfo, err := s.rclone.GetDirInfo(ctx, dir, query.Get("sort"), query.Get("order"))
-	if rclone.IsNotFoundError(err) {
+	sort, order := query.Get("sort"), query.Get("order")
+	sortByCaptureTime := sort == "taken"
+	if sortByCaptureTime {
+		// Files without capture time are sorted by mod time.
+		sort, order = "time", "asc"
+	}
+
+	var (
+		rcloneInfo  *rclone.DirInfo
+		archivePath string
+		err         error
+	)
+	if access.IsVisible(dir, true) {
+		rcloneInfo, archivePath, err = s.readDir(ctx, dir, sort, order)
+		isNotFound = rclone.IsNotFoundError(err) || errors.Is(err, archive.ErrNotFound)
+	} else {
+		// Don't reveal the existence of hidden directories.
+		isNotFound = true
+	}
+	if isNotFound {
 		// It's hard to replicate the logic of "DirInfo" preparation. Therefore, just
 		// set error to nil and init RcloneDirInfo with the predefined values.
 		err = nil
-		isNotFound = true
 
 		rcloneInfo = &rclone.DirInfo{
 			Dir: dir,
@@ -316,15 +474,134 @@ func (s *Server) getDirInfo(ctx context.Context, dir string, query url.Values) (
 	if err != nil {
 		return DirInfo{}, fmt.Errorf("couldn't get rclone info: %w", err)
 	}
+	if !access.IsFull() {
+		rcloneInfo.Entries = filterDirEntries(access, rcloneInfo.Entries)
+	}
 
-	info := s.convertRcloneInfo(rcloneInfo)
+	var captureTimes []time.Time
+	if sortByCaptureTime && !isNotFound {
+		captureTimes = s.sortByCaptureTime(rcloneInfo, query.Get("order"))
+	}
+
+	info := s.convertDirInfo(rcloneInfo, archivePath != "")
 
 	info.IsNotFound = isNotFound
+	info.ArchivePath = archivePath
+	if captureTimes != nil {
+		setGroupTitles(info.Entries, captureTimes)
+	}
+
+	if remotes := s.cfg.Rclone.Targets.GetNames(); remotes != nil {
+		info.Remotes = slices.DeleteFunc(slices.Clone(remotes), func(remote string) bool {
+			return !access.IsVisible("/"+remote+"/", true)
+		})
+		if remote, _, _ := strings.Cut(strings.TrimPrefix(dir, "/"), "/"); slices.Contains(remotes, remote) {
+			info.Remote = remote
+		}
+	}
 
 	return info, nil
 }
 
+// sortByCaptureTime sorts entries by capture time of files. Directories are always placed
+// before files and sorted by name. Files without capture time (not extracted yet or missing)
+// are sorted by mod time. It returns capture times with fallback to mod time, in the order
+// of the sorted entries.
+func (s *Server) sortByCaptureTime(info *rclone.DirInfo, order string) []time.Time {
+	ids := make([]rview.FileID, 0, len(info.Entries))
+	for _, entry := range info.Entries {
+		if !entry.IsDir {
+			ids = append(ids, rview.NewFileID(entry.URL, entry.ModTime, entry.Size))
+		}
+	}
+	captureTimes := s.metadataService.GetCaptureTimes(ids)
+
+	type sortEntry struct {
+		entry rclone.DirEntry
+		time  time.Time
+	}
+	entries := make([]sortEntry, 0, len(info.Entries))
+	for _, entry := range info.Entries {
+		t := time.Unix(entry.ModTime, 0).UTC()
+		if !entry.IsDir {
+			if captureTime, ok := captureTimes[rview.NewFileID(entry.URL, entry.ModTime, entry.Size)]; ok {
+				t = captureTime
+			}
+		}
+		entries = append(entries, sortEntry{entry: entry, time: t})
+	}
+
+	// Use stable sort to preserve the mod time order of files with the same capture time.
+	slices.SortStableFunc(entries, func(a, b sortEntry) int {
+		if a.entry.IsDir || b.entry.IsDir {
+			return rclone.CompareDirEntryByName(a.entry, b.entry)
+		}
+		return a.time.Compare(b.time)
+	})
+	if order == "desc" {
+		// Keep directories first and in ascending order.
+		dirCount := 0
+		for dirCount < len(entries) && entries[dirCount].entry.IsDir {
+			dirCount++
+		}
+		slices.Reverse(entries[dirCount:])
+	} else {
+		order = "asc"
+	}
+
+	res := make([]time.Time, 0, len(entries))
+	for i, e := range entries {
+		info.Entries[i] = e.entry
+		res = append(res, e.time)
+	}
+	info.Sort = "taken"
+	info.Order = order
+
+	return res
+}
+
+// setGroupTitles sets group titles for files sorted by capture time. Files are grouped
+// by day if all of them were taken within a month, and by month otherwise.
+func setGroupTitles(entries []DirEntry, captureTimes []time.Time) {
+	var minTime, maxTime time.Time
+	for i, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		t := captureTimes[i]
+		if minTime.IsZero() || t.Before(minTime) {
+			minTime = t
+		}
+		if maxTime.IsZero() || t.After(maxTime) {
+			maxTime = t
+		}
+	}
+
+	layout := "January 2006"
+	if maxTime.Sub(minTime) <= 31*24*time.Hour {
+		layout = "Monday, 2 January 2006"
+	}
+
+	var prevTitle string
+	for i := range entries {
+		if entries[i].IsDir {
+			continue
+		}
+		title := captureTimes[i].UTC().Format(layout)
+		if title != prevTitle {
+			entries[i].GroupTitle = title
+			prevTitle = title
+		}
+	}
+}
+
 func (s *Server) convertRcloneInfo(rcloneInfo *rclone.DirInfo) DirInfo {
+	return s.convertDirInfo(rcloneInfo, false)
+}
+
+// convertDirInfo converts the directory info. Files inside archives don't have thumbnails
+// and other generated content, they can be previewed only in the original form.
+func (s *Server) convertDirInfo(rcloneInfo *rclone.DirInfo, inArchive bool) DirInfo {
 	info := DirInfo{
 		BuildInfo: s.cfg.BuildInfo,
 		//
@@ -334,6 +611,10 @@ func (s *Server) convertRcloneInfo(rcloneInfo *rclone.DirInfo) DirInfo {
 		// Always encode entries as a slice.
 		Entries: []DirEntry{},
 	}
+	if !rcloneInfo.CachedAt.IsZero() {
+		info.CachedAt = rcloneInfo.CachedAt
+		info.HumanReadableCacheAge = misc.FormatAge(time.Since(rcloneInfo.CachedAt))
+	}
 
 	breadcrumbURL := mustParseURL("/ui").JoinPath(rcloneInfo.Dir)
 	for i := len(rcloneInfo.Breadcrumbs) - 1; i >= 0; i-- {
@@ -355,6 +636,11 @@ func (s *Server) convertRcloneInfo(rcloneInfo *rclone.DirInfo) DirInfo {
 			dirURL, webDirURL string
 			//
 			originalFileURL, thumbnailURL string
+			storyboardURL                 string
+			transcodedVideoURL            string
+			metadataURL                   string
+			textPreviewURL                string
+			markdownURL                   string
 			humanReadableSize             string
 			fileType                      rview.FileType
 			canPreview                    bool
@@ -363,15 +649,53 @@ func (s *Server) convertRcloneInfo(rcloneInfo *rclone.DirInfo) DirInfo {
 			dirURL = mustParseURL("/api/dir").JoinPath(entry.URL, "/").String()
 			webDirURL = mustParseURL("/ui").JoinPath(entry.URL, "/").String()
 
+		} else if inArchive {
+			id := rview.NewFileID(entry.URL, entry.ModTime, entry.Size)
+
+			originalFileURL = fileIDToURL("/api/file", id)
+			humanReadableSize = misc.FormatFileSize(entry.Size)
+			fileType = rview.GetFileType(id.GetExt())
+
+			switch fileType {
+			case rview.FileTypeText:
+				textPreviewURL = fileIDToURL("/api/text-preview", id)
+				if isMarkdownFile(id.GetExt()) {
+					markdownURL = fileIDToURL("/api/markdown", id)
+				}
+				canPreview = true
+			case rview.FileTypeImage:
+				if s.cfg.ImagePreviewMode != rview.ImagePreviewModeNone {
+					thumbnailURL = originalFileURL
+					canPreview = true
+				}
+			case rview.FileTypeAudio:
+				canPreview = slices.Contains([]string{".mp3", ".ogg", ".wav"}, id.GetExt())
+			case rview.FileTypeVideo:
+				canPreview = slices.Contains([]string{".mp4", ".webm"}, id.GetExt())
+			}
+
 		} else {
 			id := rview.NewFileID(entry.URL, entry.ModTime, entry.Size)
 
+			if archive.CanBrowse(entry.URL) {
+				// Archives can be opened as directories.
+				webDirURL = mustParseURL("/ui").JoinPath(entry.URL, "/").String()
+			}
+
 			originalFileURL = fileIDToURL("/api/file", id)
 			humanReadableSize = misc.FormatFileSize(entry.Size)
 			fileType = rview.GetFileType(id.GetExt())
 
+			if s.metadataService.CanExtractMetadata(id) {
+				metadataURL = fileIDToURL("/api/metadata", id)
+			}
+
 			switch fileType {
 			case rview.FileTypeText:
+				textPreviewURL = fileIDToURL("/api/text-preview", id)
+				if isMarkdownFile(id.GetExt()) {
+					markdownURL = fileIDToURL("/api/markdown", id)
+				}
 				canPreview = true
 
 			case rview.FileTypeImage, rview.FileTypeRawImage:
@@ -392,16 +716,37 @@ func (s *Server) convertRcloneInfo(rcloneInfo *rclone.DirInfo) DirInfo {
 					canPreview = true
 				}
 
+			case rview.FileTypeDocument:
+				if s.cfg.ImagePreviewMode == rview.ImagePreviewModeThumbnails && s.thumbnailService.CanGenerateThumbnail(id) {
+					thumbnailURL = fileIDToURL("/api/thumbnail", id)
+				}
+
 			case rview.FileTypeAudio:
 				switch id.GetExt() {
 				case ".mp3", ".ogg", ".wav":
 					canPreview = true
 				}
+				if s.cfg.ImagePreviewMode == rview.ImagePreviewModeThumbnails && s.thumbnailService.CanGenerateThumbnail(id) {
+					thumbnailURL = fileIDToURL("/api/thumbnail", id)
+				}
 
 			case rview.FileTypeVideo:
 				switch id.GetExt() {
 				case ".mp4", ".webm":
 					canPreview = true
+				default:
+					if s.transcodingService.CanTranscode(id) {
+						transcodedVideoURL = fileIDToURL("/api/transcoded-video", id)
+						canPreview = true
+					}
+				}
+				if s.cfg.ImagePreviewMode == rview.ImagePreviewModeThumbnails {
+					if s.thumbnailService.CanGenerateThumbnail(id) {
+						thumbnailURL = fileIDToURL("/api/thumbnail", id)
+					}
+					if s.thumbnailService.CanGenerateStoryboard(id) {
+						storyboardURL = fileIDToURL("/api/storyboard", id)
+					}
 				}
 			}
 		}
@@ -418,11 +763,16 @@ func (s *Server) convertRcloneInfo(rcloneInfo *rclone.DirInfo) DirInfo {
 			FileType:             fileType,
 			CanPreview:           canPreview,
 			//
-			DirURL:          dirURL,
-			WebDirURL:       webDirURL,
-			OriginalFileURL: originalFileURL,
-			ThumbnailURL:    thumbnailURL,
-			IconName:        static.GetFileIcon(filename, entry.IsDir),
+			DirURL:             dirURL,
+			WebDirURL:          webDirURL,
+			OriginalFileURL:    originalFileURL,
+			ThumbnailURL:       thumbnailURL,
+			StoryboardURL:      storyboardURL,
+			TranscodedVideoURL: transcodedVideoURL,
+			MetadataURL:        metadataURL,
+			TextPreviewURL:     textPreviewURL,
+			MarkdownURL:        markdownURL,
+			IconName:           static.GetFileIcon(filename, entry.IsDir),
 		})
 		if entry.IsDir {
 			info.DirCount++
@@ -441,6 +791,19 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 		writeBadRequestError(w, "invalid file id: %s", err.Error())
 		return
 	}
+	if !s.checkFileAccess(w, r, fileID) {
+		return
+	}
+
+	archiveID, memberPath, ok, err := s.archiveService.SplitPath(r.Context(), fileID.GetPath())
+	if err != nil {
+		writeInternalServerError(w, "couldn't check path: %s", err)
+		return
+	}
+	if ok {
+		s.serveArchiveMember(w, r, fileID, archiveID, memberPath)
+		return
+	}
 
 	s.rclone.ProxyFileRequest(fileID, w, r)
 }
@@ -452,7 +815,15 @@ func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
 		writeBadRequestError(w, "invalid file id: %s", err.Error())
 		return
 	}
+	if !s.checkFileAccess(w, r, id) {
+		return
+	}
 
+	s.writeThumbnail(w, r, id)
+}
+
+// writeThumbnail writes the thumbnail of the requested size ("thumbnail_size" query parameter).
+func (s *Server) writeThumbnail(w http.ResponseWriter, r *http.Request, id rview.FileID) {
 	var size thumbnails.ThumbnailSize
 	switch v := r.FormValue("thumbnail_size"); v {
 	case "small":
@@ -487,6 +858,129 @@ func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, rc)
 }
 
+// handleStoryboard returns the storyboard image or its index if "format=json" is passed.
+func (s *Server) handleStoryboard(w http.ResponseWriter, r *http.Request) {
+	id, err := fileIDFromRequest(r, "/api/storyboard")
+	if err != nil {
+		writeBadRequestError(w, "invalid file id: %s", err.Error())
+		return
+	}
+	if !s.checkFileAccess(w, r, id) {
+		return
+	}
+	if !s.thumbnailService.CanGenerateStoryboard(id) {
+		writeBadRequestError(w, "can't generate storyboard for %q", id.GetPath())
+		return
+	}
+
+	// Use mod time as a value for ETag.
+	etag := strconv.Itoa(int(id.GetModTime()))
+
+	switch v := r.FormValue("format"); v {
+	case "json":
+		index, err := s.thumbnailService.GetStoryboardIndex(r.Context(), id)
+		if err != nil {
+			writeStoryboardError(w, id, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		setCacheHeaders(w, 30*24*time.Hour, etag)
+		json.NewEncoder(w).Encode(index)
+
+	case "image", "":
+		rc, err := s.thumbnailService.OpenStoryboard(r.Context(), id)
+		if err != nil {
+			writeStoryboardError(w, id, err)
+			return
+		}
+		defer rc.Close()
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		setCacheHeaders(w, 30*24*time.Hour, etag)
+		io.Copy(w, rc)
+
+	default:
+		writeBadRequestError(w, "invalid format: %q", v)
+	}
+}
+
+func writeStoryboardError(w http.ResponseWriter, id rview.FileID, err error) {
+	if errors.Is(err, cache.ErrCacheMiss) {
+		writeError(w, http.StatusNotFound, "no storyboard for %q, size %d, mod time %d", id.GetPath(), id.GetSize(), id.GetModTime())
+		return
+	}
+	writeBadRequestError(w, "couldn't open storyboard: %s", err)
+}
+
+// handleTranscodedVideo returns a video transcoded into MP4. Seeking is available only after
+// the end of transcoding.
+func (s *Server) handleTranscodedVideo(w http.ResponseWriter, r *http.Request) {
+	id, err := fileIDFromRequest(r, "/api/transcoded-video")
+	if err != nil {
+		writeBadRequestError(w, "invalid file id: %s", err.Error())
+		return
+	}
+	if !s.checkFileAccess(w, r, id) {
+		return
+	}
+	if !s.transcodingService.CanTranscode(id) {
+		writeBadRequestError(w, "can't transcode %q", id.GetPath())
+		return
+	}
+
+	rc, isComplete, err := s.transcodingService.Open(r.Context(), id)
+	if err != nil {
+		writeInternalServerError(w, "couldn't open transcoded video: %s", err)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "video/mp4")
+
+	if rs, ok := rc.(io.ReadSeeker); ok && isComplete {
+		// Use mod time as a value for ETag.
+		etag := strconv.Itoa(int(id.GetModTime()))
+		setCacheHeaders(w, 30*24*time.Hour, etag)
+
+		http.ServeContent(w, r, "", time.Time{}, rs)
+		return
+	}
+
+	// Don't cache the response because it is incomplete until the end of transcoding.
+	w.Header().Set("Cache-Control", "no-store")
+	io.Copy(w, rc)
+}
+
+// handleMetadata returns the metadata of an image: camera, lens, exposure, GPS and etc.
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	id, err := fileIDFromRequest(r, "/api/metadata")
+	if err != nil {
+		writeBadRequestError(w, "invalid file id: %s", err.Error())
+		return
+	}
+	if !s.checkFileAccess(w, r, id) {
+		return
+	}
+	if !s.metadataService.CanExtractMetadata(id) {
+		writeBadRequestError(w, "can't extract metadata from %q", id.GetPath())
+		return
+	}
+
+	m, err := s.metadataService.GetMetadata(r.Context(), id)
+	if err != nil {
+		writeInternalServerError(w, "couldn't get metadata: %s", err)
+		return
+	}
+
+	// Use mod time as a value for ETag.
+	etag := strconv.Itoa(int(id.GetModTime()))
+	setCacheHeaders(w, 30*24*time.Hour, etag)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	searchValue, err := s.extractSearch(r)
 	if err != nil {
@@ -500,8 +994,12 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 	isUI := r.FormValue("ui") != ""
 
-	hits, total, err := s.searchService.Search(r.Context(), searchValue, limit)
+	hits, total, err := s.searchService.Search(r.Context(), searchValue, limit, getSearchFilter(s.getAccess(r)))
 	if err != nil {
+		if errors.Is(err, search.ErrContentSearchDisabled) {
+			writeBadRequestError(w, "%s", err)
+			return
+		}
 		writeInternalServerError(w, "search failed: %s", err)
 		return
 	}
@@ -512,20 +1010,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		Total:  total,
 	}
 	for _, hit := range hits {
-		var webURL string
-		if hit.IsDir {
-			webURL = mustParseURL("/ui").JoinPath(hit.Path, "/").String()
-
-		} else {
-			dir := pkgPath.Dir(hit.Path)
-			filename := pkgPath.Base(hit.Path)
-
-			u := mustParseURL("/ui").JoinPath(dir, "/")
-			u.RawQuery = url.Values{
-				"preview": []string{filename},
-			}.Encode()
-			webURL = u.String()
-		}
+		webURL := getWebURL(hit.Path, hit.IsDir)
 
 		resp.Hits = append(resp.Hits, SearchHit{
 			Path:    hit.Path,
@@ -535,6 +1020,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 			Score:   hit.Score,
 			WebURL:  webURL,
 			Icon:    static.GetFileIcon(hit.Path, hit.IsDir),
+			Snippet: renderSnippet(hit.Snippet),
 		})
 	}
 
@@ -543,7 +1029,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		s.executeTemplate(w, "search-results.html", resp)
+		s.executeTemplate(w, r, "search-results.html", resp)
 		return
 	}
 
@@ -552,6 +1038,44 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// renderSnippet escapes the snippet text and highlights matches.
+func renderSnippet(snippet *search.Snippet) template.HTML {
+	if snippet == nil {
+		return ""
+	}
+
+	var (
+		b    strings.Builder
+		last int
+	)
+	for _, m := range snippet.Matches {
+		b.WriteString(html.EscapeString(snippet.Text[last:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(snippet.Text[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(snippet.Text[last:]))
+
+	return template.HTML(b.String())
+}
+
+// getWebURL returns an url to the web page of the directory or to the preview of the file.
+func getWebURL(path string, isDir bool) string {
+	if isDir {
+		return mustParseURL("/ui").JoinPath(path, "/").String()
+	}
+
+	dir := pkgPath.Dir(path)
+	filename := pkgPath.Base(path)
+
+	u := mustParseURL("/ui").JoinPath(dir, "/")
+	u.RawQuery = url.Values{
+		"preview": []string{filename},
+	}.Encode()
+	return u.String()
+}
+
 func (s *Server) handlePageWithSearchResults(w http.ResponseWriter, r *http.Request) {
 	searchValue, err := s.extractSearch(r)
 	if err != nil {
@@ -561,8 +1085,12 @@ func (s *Server) handlePageWithSearchResults(w http.ResponseWriter, r *http.Requ
 	limit, _ := strconv.Atoi(r.FormValue("limit"))
 	limit = cmp.Or(limit, 100)
 
-	hits, _, err := s.searchService.Search(r.Context(), searchValue, limit)
+	hits, _, err := s.searchService.Search(r.Context(), searchValue, limit, getSearchFilter(s.getAccess(r)))
 	if err != nil {
+		if errors.Is(err, search.ErrContentSearchDisabled) {
+			writeBadRequestError(w, "%s", err)
+			return
+		}
 		writeInternalServerError(w, "search failed: %s", err)
 		return
 	}
@@ -589,7 +1117,7 @@ func (s *Server) handlePageWithSearchResults(w http.ResponseWriter, r *http.Requ
 	info := s.convertRcloneInfo(dirInfo)
 	info.Search = searchValue
 
-	s.executeTemplate(w, "index.html", info)
+	s.executeTemplate(w, r, "index.html", info)
 }
 
 func (s *Server) extractSearch(r *http.Request) (string, error) {
@@ -613,6 +1141,192 @@ func (s *Server) handleRefreshIndex(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// handleInvalidate drops the cached listing of the directory (or the directory of a file) and
+// applies its changes to the search index. It is useful for upload scripts: new files become
+// visible immediately. Scripts authenticate with the token from the config, see [getBearerToken].
+// Otherwise, only users with full access can invalidate directories.
+func (s *Server) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	path := r.PostFormValue("path")
+	if path == "" {
+		writeBadRequestError(w, "path is required")
+		return
+	}
+	if token, ok := getBearerToken(r); ok {
+		if s.cfg.InvalidateToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.InvalidateToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+	} else if !s.getAccess(r).IsFull() {
+		writeError(w, http.StatusForbidden, "only users with full access can invalidate directories")
+		return
+	}
+
+	err := s.rclone.InvalidateDir(r.Context(), path)
+	if err != nil {
+		writeInternalServerError(w, "couldn't invalidate %q: %s", path, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleTimeline returns a part of the timeline: images and videos of the whole remote sorted
+// by capture time in reverse chronological order. If "ui" is passed, the entries are rendered
+// as HTML.
+func (s *Server) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := parseTimelineParams(r.FormValue("offset"), r.FormValue("limit"))
+	if err != nil {
+		writeBadRequestError(w, "invalid request: %s", err)
+		return
+	}
+	isUI := r.FormValue("ui") != ""
+
+	bbox, err := parseBoundingBox(r.FormValue("bbox"))
+	if err != nil {
+		writeBadRequestError(w, "invalid request: %s", err)
+		return
+	}
+
+	t, err := s.getTimeline(r.Context())
+	if err != nil {
+		writeInternalServerError(w, "couldn't get timeline: %s", err)
+		return
+	}
+	t = t.filterByAccess(s.getAccess(r))
+	if bbox != nil {
+		t = t.filterByLocation(*bbox)
+	}
+	resp := s.getTimelinePage(t, offset, limit)
+
+	if isUI {
+		s.executeTemplate(w, r, "timeline-entries.html", resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handlePageWithTimeline(w http.ResponseWriter, r *http.Request) {
+	offset, _, err := parseTimelineParams(r.FormValue("offset"), "")
+	if err != nil {
+		writeBadRequestError(w, "invalid request: %s", err)
+		return
+	}
+
+	bbox, err := parseBoundingBox(r.FormValue("bbox"))
+	if err != nil {
+		writeBadRequestError(w, "invalid request: %s", err)
+		return
+	}
+
+	t, err := s.getTimeline(r.Context())
+	if err != nil {
+		writeInternalServerError(w, "couldn't get timeline: %s", err)
+		return
+	}
+	t = t.filterByAccess(s.getAccess(r))
+	if bbox != nil {
+		t = t.filterByLocation(*bbox)
+	}
+
+	s.executeTemplate(w, r, "timeline.html", TimelinePage{
+		BuildInfo: s.cfg.BuildInfo,
+		Years:     t.years,
+		Total:     len(t.entries),
+		Offset:    offset,
+		Filtered:  bbox != nil,
+	})
+}
+
+// handleMapClusters returns clusters of geotagged files within the bounding box.
+func (s *Server) handleMapClusters(w http.ResponseWriter, r *http.Request) {
+	bbox, err := parseBoundingBox(r.FormValue("bbox"))
+	if err != nil {
+		writeBadRequestError(w, "invalid request: %s", err)
+		return
+	}
+	if bbox == nil {
+		writeBadRequestError(w, "invalid request: bbox is required")
+		return
+	}
+	zoom, err := parseMapZoom(r.FormValue("zoom"))
+	if err != nil {
+		writeBadRequestError(w, "invalid request: %s", err)
+		return
+	}
+
+	t, err := s.getTimeline(r.Context())
+	if err != nil {
+		writeInternalServerError(w, "couldn't get timeline: %s", err)
+		return
+	}
+	t = t.filterByAccess(s.getAccess(r))
+	resp := s.getMapClusters(t, *bbox, zoom)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handlePageWithMap(w http.ResponseWriter, r *http.Request) {
+	t, err := s.getTimeline(r.Context())
+	if err != nil {
+		writeInternalServerError(w, "couldn't get timeline: %s", err)
+		return
+	}
+	t = t.filterByAccess(s.getAccess(r))
+
+	page := MapPage{
+		BuildInfo:       s.cfg.BuildInfo,
+		TileURL:         s.cfg.MapTileURL,
+		MetadataEnabled: s.cfg.ImageMetadata,
+	}
+	if bbox, ok := getLocationsBoundingBox(t); ok {
+		page.BBox = bbox.String()
+	}
+
+	s.executeTemplate(w, r, "map.html", page)
+}
+
+// handleDuplicates returns groups of identical files and similar images.
+func (s *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	res, err := s.duplicatesService.GetDuplicates()
+	switch {
+	case errors.Is(err, duplicates.ErrNoopDuplicatesService):
+		writeBadRequestError(w, "duplicates detection is disabled")
+		return
+	case errors.Is(err, duplicates.ErrNotReady):
+		writeError(w, http.StatusServiceUnavailable, "duplicates are not detected yet, try again later")
+		return
+	case err != nil:
+		writeInternalServerError(w, "couldn't get duplicates: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.convertDuplicates(filterDuplicates(res, s.getAccess(r))))
+}
+
+func (s *Server) handlePageWithDuplicates(w http.ResponseWriter, r *http.Request) {
+	page := DuplicatesPage{
+		BuildInfo: s.cfg.BuildInfo,
+		Enabled:   s.cfg.DuplicatesDetection,
+	}
+
+	res, err := s.duplicatesService.GetDuplicates()
+	switch {
+	case err == nil:
+		page.Ready = true
+		page.DuplicatesResponse = s.convertDuplicates(filterDuplicates(res, s.getAccess(r)))
+	case errors.Is(err, duplicates.ErrNoopDuplicatesService), errors.Is(err, duplicates.ErrNotReady):
+		// Show a message.
+	default:
+		writeInternalServerError(w, "couldn't get duplicates: %s", err)
+		return
+	}
+
+	s.executeTemplate(w, r, "duplicates.html", page)
+}
+
 func fileIDToURL(prefix string, id rview.FileID) string {
 	fileURL := mustParseURL(prefix).JoinPath(id.GetEscapedPath())
 
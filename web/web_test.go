@@ -1,12 +1,22 @@
 package web
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ShoshinNikita/rview/duplicates"
+	"github.com/ShoshinNikita/rview/metadata"
 	"github.com/ShoshinNikita/rview/rclone"
 	"github.com/ShoshinNikita/rview/rview"
+	"github.com/ShoshinNikita/rview/search"
 	"github.com/ShoshinNikita/rview/thumbnails"
+	"github.com/ShoshinNikita/rview/transcoding"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,6 +32,8 @@ func TestServer_convertRcloneInfo(t *testing.T) {
 				{URL: "/c.png", Leaf: "c.png"},
 				{URL: "/c.bmp", Leaf: "c.bmp"},
 				{URL: "/d.zip", Leaf: "d.zip"},
+				{URL: "/e.mkv", Leaf: "e.mkv"},
+				{URL: "/f.pdf", Leaf: "f.pdf"},
 			},
 		}
 	}
@@ -38,8 +50,20 @@ func TestServer_convertRcloneInfo(t *testing.T) {
 	t.Run("thumbnails mode", func(t *testing.T) {
 		r := require.New(t)
 
-		thumbnailService := thumbnails.NewThumbnailService(nil, nil, nil, 0, rview.JpegThumbnails, true)
-		s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeThumbnails}, nil, thumbnailService, nil)
+		thumbnailService := thumbnails.NewThumbnailService(nil, nil, nil, thumbnails.Options{
+			ThumbnailsFormat: rview.JpegThumbnails, ProcessRawImages: true, ProcessVideos: true, ProcessDocuments: true,
+		})
+		root, err := os.OpenRoot(t.TempDir())
+		r.NoError(err)
+		metadataService, err := metadata.NewService(nil, nil, root)
+		r.NoError(err)
+		t.Cleanup(func() { _ = metadataService.Shutdown(context.Background()) })
+
+		s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeThumbnails}, Services{
+			ThumbnailService:   thumbnailService,
+			TranscodingService: transcoding.NewNoopService(),
+			MetadataService:    metadataService,
+		})
 
 		gotInfo := s.convertRcloneInfo(getTestRcloneInfo())
 		resetUnnecessaryFields(&gotInfo)
@@ -47,23 +71,38 @@ func TestServer_convertRcloneInfo(t *testing.T) {
 			[]DirEntry{
 				{
 					Filename: "a.txt", FileType: rview.FileTypeText, CanPreview: true,
-					ThumbnailURL: "", // no thumbnail: text file
+					ThumbnailURL:   "", // no thumbnail: text file
+					TextPreviewURL: "/api/text-preview/a.txt?mod_time=0&size=0",
 				},
 				{
 					Filename: "b.jpg", FileType: rview.FileTypeImage,
 					ThumbnailURL: "/api/thumbnail/b.jpg?mod_time=0&size=0", CanPreview: true,
+					MetadataURL: "/api/metadata/b.jpg?mod_time=0&size=0",
 				},
 				{
 					Filename: "c.png", FileType: rview.FileTypeImage,
 					ThumbnailURL: "/api/thumbnail/c.png?mod_time=0&size=0", CanPreview: true,
+					MetadataURL: "/api/metadata/c.png?mod_time=0&size=0",
 				},
 				{
 					Filename: "c.bmp", FileType: rview.FileTypeImage,
 					ThumbnailURL: "", // no thumbnail: unsupported image
+					MetadataURL:  "/api/metadata/c.bmp?mod_time=0&size=0",
 				},
 				{
 					Filename: "d.zip", FileType: rview.FileTypeUnknown,
 					ThumbnailURL: "", // no thumbnail: archive
+					WebDirURL:    "/ui/d.zip/",
+				},
+				{
+					Filename: "e.mkv", FileType: rview.FileTypeVideo,
+					ThumbnailURL:  "/api/thumbnail/e.mkv?mod_time=0&size=0",
+					StoryboardURL: "/api/storyboard/e.mkv?mod_time=0&size=0",
+					CanPreview:    false, // browsers can't play .mkv
+				},
+				{
+					Filename: "f.pdf", FileType: rview.FileTypeDocument,
+					ThumbnailURL: "/api/thumbnail/f.pdf?mod_time=0&size=0",
 				},
 			},
 			gotInfo.Entries,
@@ -73,7 +112,11 @@ func TestServer_convertRcloneInfo(t *testing.T) {
 	t.Run("original mode", func(t *testing.T) {
 		r := require.New(t)
 
-		s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeOriginal}, nil, nil, nil)
+		transcodingService := transcoding.NewService(nil, nil, 1)
+		s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeOriginal}, Services{
+			TranscodingService: transcodingService,
+			MetadataService:    metadata.NewNoopService(),
+		})
 
 		gotInfo := s.convertRcloneInfo(getTestRcloneInfo())
 		resetUnnecessaryFields(&gotInfo)
@@ -81,6 +124,7 @@ func TestServer_convertRcloneInfo(t *testing.T) {
 			[]DirEntry{
 				{
 					Filename: "a.txt", FileType: rview.FileTypeText, CanPreview: true,
+					TextPreviewURL: "/api/text-preview/a.txt?mod_time=0&size=0",
 				},
 				{
 					Filename: "b.jpg", FileType: rview.FileTypeImage,
@@ -96,6 +140,17 @@ func TestServer_convertRcloneInfo(t *testing.T) {
 				},
 				{
 					Filename: "d.zip", FileType: rview.FileTypeUnknown,
+					WebDirURL: "/ui/d.zip/",
+				},
+				{
+					Filename: "e.mkv", FileType: rview.FileTypeVideo,
+					ThumbnailURL:       "", // can't use the original file as a thumbnail
+					TranscodedVideoURL: "/api/transcoded-video/e.mkv?mod_time=0&size=0",
+					CanPreview:         true,
+				},
+				{
+					Filename: "f.pdf", FileType: rview.FileTypeDocument,
+					ThumbnailURL: "", // can't use the original file as a thumbnail
 				},
 			},
 			gotInfo.Entries,
@@ -105,19 +160,413 @@ func TestServer_convertRcloneInfo(t *testing.T) {
 	t.Run("no preview mode", func(t *testing.T) {
 		r := require.New(t)
 
-		s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeNone}, nil, nil, nil)
+		s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeNone}, Services{
+			TranscodingService: transcoding.NewNoopService(),
+			MetadataService:    metadata.NewNoopService(),
+		})
 
 		gotInfo := s.convertRcloneInfo(getTestRcloneInfo())
 		resetUnnecessaryFields(&gotInfo)
 		r.Equal(
 			[]DirEntry{
-				{Filename: "a.txt", FileType: rview.FileTypeText, CanPreview: true},
+				{Filename: "a.txt", FileType: rview.FileTypeText, CanPreview: true, TextPreviewURL: "/api/text-preview/a.txt?mod_time=0&size=0"},
 				{Filename: "b.jpg", FileType: rview.FileTypeImage},
 				{Filename: "c.png", FileType: rview.FileTypeImage},
 				{Filename: "c.bmp", FileType: rview.FileTypeImage},
-				{Filename: "d.zip", FileType: rview.FileTypeUnknown},
+				{Filename: "d.zip", FileType: rview.FileTypeUnknown, WebDirURL: "/ui/d.zip/"},
+				{Filename: "e.mkv", FileType: rview.FileTypeVideo},
+				{Filename: "f.pdf", FileType: rview.FileTypeDocument},
 			},
 			gotInfo.Entries,
 		)
 	})
+
+	t.Run("in archive", func(t *testing.T) {
+		r := require.New(t)
+
+		s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeThumbnails}, Services{
+			TranscodingService: transcoding.NewNoopService(),
+			MetadataService:    metadata.NewNoopService(),
+		})
+
+		// Files inside archives are previewed only in the original form.
+		gotInfo := s.convertDirInfo(getTestRcloneInfo(), true)
+		resetUnnecessaryFields(&gotInfo)
+		r.Equal(
+			[]DirEntry{
+				{Filename: "a.txt", FileType: rview.FileTypeText, CanPreview: true, TextPreviewURL: "/api/text-preview/a.txt?mod_time=0&size=0"},
+				{Filename: "b.jpg", FileType: rview.FileTypeImage, ThumbnailURL: "/api/file/b.jpg?mod_time=0&size=0", CanPreview: true},
+				{Filename: "c.png", FileType: rview.FileTypeImage, ThumbnailURL: "/api/file/c.png?mod_time=0&size=0", CanPreview: true},
+				{Filename: "c.bmp", FileType: rview.FileTypeImage, ThumbnailURL: "/api/file/c.bmp?mod_time=0&size=0", CanPreview: true},
+				{Filename: "d.zip", FileType: rview.FileTypeUnknown}, // nested archives can't be browsed
+				{Filename: "e.mkv", FileType: rview.FileTypeVideo},
+				{Filename: "f.pdf", FileType: rview.FileTypeDocument},
+			},
+			gotInfo.Entries,
+		)
+	})
+}
+
+type captureTimesMetadataService struct {
+	metadata.NoopService
+
+	captureTimes map[string]time.Time
+}
+
+func (s captureTimesMetadataService) GetCaptureTimes(ids []rview.FileID) map[rview.FileID]time.Time {
+	res := make(map[rview.FileID]time.Time)
+	for _, id := range ids {
+		if t, ok := s.captureTimes[id.GetPath()]; ok {
+			res[id] = t
+		}
+	}
+	return res
+}
+
+func TestServer_sortByCaptureTime(t *testing.T) {
+	t.Parallel()
+
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2024, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	getTestRcloneInfo := func() *rclone.DirInfo {
+		return &rclone.DirInfo{
+			Dir: "/",
+			Entries: []rclone.DirEntry{
+				{URL: "/b.jpg", Leaf: "b.jpg", ModTime: date(5, 1).Unix()},
+				{URL: "/dir/", Leaf: "dir/", IsDir: true, ModTime: date(1, 1).Unix()},
+				{URL: "/a.jpg", Leaf: "a.jpg", ModTime: date(5, 2).Unix()},
+				{URL: "/c.txt", Leaf: "c.txt", ModTime: date(5, 3).Unix()},
+				{URL: "/d.jpg", Leaf: "d.jpg", ModTime: date(5, 4).Unix()},
+				{URL: "/albums/", Leaf: "albums/", IsDir: true, ModTime: date(2, 1).Unix()},
+			},
+		}
+	}
+	getFilenames := func(info DirInfo) (res []string) {
+		for _, e := range info.Entries {
+			res = append(res, e.Filename)
+		}
+		return res
+	}
+	getGroupTitles := func(info DirInfo) (res []string) {
+		for _, e := range info.Entries {
+			res = append(res, e.GroupTitle)
+		}
+		return res
+	}
+
+	for _, tt := range []struct {
+		name            string
+		captureTimes    map[string]time.Time
+		order           string
+		wantFilenames   []string
+		wantGroupTitles []string
+	}{
+		{
+			name: "by day",
+			captureTimes: map[string]time.Time{
+				"/a.jpg": date(5, 1),
+				"/b.jpg": date(5, 2),
+				// "d.jpg" has no capture time - use mod time
+			},
+			order:           "asc",
+			wantFilenames:   []string{"albums", "dir", "a.jpg", "b.jpg", "c.txt", "d.jpg"},
+			wantGroupTitles: []string{"", "", "Wednesday, 1 May 2024", "Thursday, 2 May 2024", "Friday, 3 May 2024", "Saturday, 4 May 2024"},
+		},
+		{
+			name: "by month",
+			captureTimes: map[string]time.Time{
+				"/a.jpg": date(1, 10),
+				"/b.jpg": date(1, 20),
+				"/d.jpg": date(3, 1),
+			},
+			order:           "desc",
+			wantFilenames:   []string{"albums", "dir", "c.txt", "d.jpg", "b.jpg", "a.jpg"},
+			wantGroupTitles: []string{"", "", "May 2024", "March 2024", "January 2024", ""},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := require.New(t)
+
+			s := NewServer(rview.Config{}, Services{
+				TranscodingService: transcoding.NewNoopService(),
+				MetadataService:    captureTimesMetadataService{captureTimes: tt.captureTimes},
+			})
+
+			rcloneInfo := getTestRcloneInfo()
+			captureTimes := s.sortByCaptureTime(rcloneInfo, tt.order)
+			r.Equal("taken", rcloneInfo.Sort)
+			r.Equal(tt.order, rcloneInfo.Order)
+
+			info := s.convertRcloneInfo(rcloneInfo)
+			setGroupTitles(info.Entries, captureTimes)
+
+			r.Equal(tt.wantFilenames, getFilenames(info))
+			r.Equal(tt.wantGroupTitles, getGroupTitles(info))
+		})
+	}
+}
+
+func TestServer_getTimelinePage(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	files := []search.Hit{
+		{Path: "/a/1.jpg", ModTime: date(2024, 5, 1).Unix()},
+		{Path: "/a/2.jpg", ModTime: date(2024, 5, 2).Unix()},
+		{Path: "/b/3.mp4", ModTime: date(2023, 12, 31).Unix()},
+		{Path: "/b/4.jpg", ModTime: date(2020, 1, 1).Unix()},
+	}
+	captureTimes := map[rview.FileID]time.Time{
+		// Capture time has priority over mod time.
+		rview.NewFileID("/b/4.jpg", date(2020, 1, 1).Unix(), 0): date(2024, 3, 1),
+	}
+	timeline := buildTimeline(files, captureTimes, nil)
+
+	r.Equal(
+		[]TimelineYear{
+			{
+				Year: 2024, Offset: 0, Count: 3,
+				Months: []TimelineMonth{
+					{Month: 5, Title: "May 2024", Offset: 0, Count: 2},
+					{Month: 3, Title: "March 2024", Offset: 2, Count: 1},
+				},
+			},
+			{
+				Year: 2023, Offset: 3, Count: 1,
+				Months: []TimelineMonth{
+					{Month: 12, Title: "December 2023", Offset: 3, Count: 1},
+				},
+			},
+		},
+		timeline.years,
+	)
+
+	s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeNone}, Services{
+		TranscodingService: transcoding.NewNoopService(),
+		MetadataService:    metadata.NewNoopService(),
+	})
+
+	type entry struct {
+		Path, GroupTitle, WebURL string
+	}
+	getEntries := func(resp TimelineResponse) (res []entry) {
+		for _, e := range resp.Entries {
+			res = append(res, entry{Path: e.Path, GroupTitle: e.GroupTitle, WebURL: e.WebURL})
+		}
+		return res
+	}
+
+	resp := s.getTimelinePage(timeline, 0, 2)
+	r.Equal(4, resp.Total)
+	r.Equal(2, resp.NextOffset)
+	r.Equal(
+		[]entry{
+			{Path: "/a/2.jpg", GroupTitle: "May 2024", WebURL: "/ui/a/?preview=2.jpg"},
+			{Path: "/a/1.jpg", WebURL: "/ui/a/?preview=1.jpg"},
+		},
+		getEntries(resp),
+	)
+	r.Equal("2.jpg", resp.Entries[0].Filename)
+
+	resp = s.getTimelinePage(timeline, 1, 10)
+	r.Equal(0, resp.NextOffset)
+	r.Equal(
+		[]entry{
+			{Path: "/a/1.jpg", WebURL: "/ui/a/?preview=1.jpg"},
+			{Path: "/b/4.jpg", GroupTitle: "March 2024", WebURL: "/ui/b/?preview=4.jpg"},
+			{Path: "/b/3.mp4", GroupTitle: "December 2023", WebURL: "/ui/b/?preview=3.mp4"},
+		},
+		getEntries(resp),
+	)
+
+	resp = s.getTimelinePage(timeline, 10, 10)
+	r.Empty(resp.Entries)
+}
+
+func TestServer_getMapClusters(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	files := []search.Hit{
+		{Path: "/paris/1.jpg", ModTime: 1},
+		{Path: "/paris/2.jpg", ModTime: 2},
+		{Path: "/amsterdam/3.jpg", ModTime: 3},
+		{Path: "/no-gps/4.jpg", ModTime: 4},
+	}
+	locations := map[rview.FileID]metadata.GPS{
+		rview.NewFileID("/paris/1.jpg", 1, 0):     {Latitude: 48.85, Longitude: 2.29},
+		rview.NewFileID("/paris/2.jpg", 2, 0):     {Latitude: 48.86, Longitude: 2.35},
+		rview.NewFileID("/amsterdam/3.jpg", 3, 0): {Latitude: 52.37, Longitude: 4.9},
+	}
+	timeline := buildTimeline(files, nil, locations)
+
+	bbox, ok := getLocationsBoundingBox(timeline)
+	r.True(ok)
+	r.Equal(boundingBox{West: 2.29, South: 48.85, East: 4.9, North: 52.37}, bbox)
+
+	s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeThumbnails}, Services{
+		ThumbnailService:   thumbnails.NewThumbnailService(nil, nil, nil, thumbnails.Options{}),
+		TranscodingService: transcoding.NewNoopService(),
+		MetadataService:    metadata.NewNoopService(),
+	})
+
+	world := boundingBox{West: -180, South: -90, East: 180, North: 90}
+
+	// Zoom out: Paris and Amsterdam are in the same cluster.
+	resp := s.getMapClusters(timeline, world, 1)
+	r.Equal(3, resp.Total)
+	r.Len(resp.Clusters, 1)
+	r.Equal("/api/thumbnail/amsterdam/3.jpg?mod_time=3&size=0", resp.Clusters[0].ThumbnailURL)
+
+	// Zoom in: Paris and Amsterdam are in different clusters.
+	resp = s.getMapClusters(timeline, world, 8)
+	r.Equal(3, resp.Total)
+	r.Len(resp.Clusters, 2)
+	r.Equal(1, resp.Clusters[0].Count)
+	r.Equal("4.9,52.37,4.9,52.37", resp.Clusters[0].BBox)
+	r.Equal(2, resp.Clusters[1].Count)
+	r.Equal("2.29,48.85,2.35,48.86", resp.Clusters[1].BBox)
+	r.Equal("/ui-timeline?bbox=2.29,48.85,2.35,48.86", resp.Clusters[1].TimelineURL)
+	r.InDelta(48.855, resp.Clusters[1].Latitude, 1e-9)
+	r.InDelta(2.32, resp.Clusters[1].Longitude, 1e-9)
+
+	// Only files within the bounding box.
+	paris, err := parseBoundingBox(resp.Clusters[1].BBox)
+	r.NoError(err)
+	resp = s.getMapClusters(timeline, *paris, 8)
+	r.Equal(2, resp.Total)
+
+	filtered := timeline.filterByLocation(*paris)
+	r.Len(filtered.entries, 2)
+	r.Equal("/paris/2.jpg", filtered.entries[0].Path)
+
+	// The bounding box crosses the antimeridian.
+	pacific, err := parseBoundingBox("170,-20,-170,20")
+	r.NoError(err)
+	r.True(pacific.contains(metadata.GPS{Latitude: 0, Longitude: 175}))
+	r.True(pacific.contains(metadata.GPS{Latitude: 0, Longitude: -175}))
+	r.False(pacific.contains(metadata.GPS{Latitude: 0, Longitude: 0}))
+	r.False(pacific.contains(metadata.GPS{Latitude: 30, Longitude: 175}))
+
+	fiji := buildTimeline(
+		[]search.Hit{{Path: "/fiji/1.jpg", ModTime: 1}, {Path: "/samoa/2.jpg", ModTime: 2}},
+		nil,
+		map[rview.FileID]metadata.GPS{
+			rview.NewFileID("/fiji/1.jpg", 1, 0):  {Latitude: -17.7, Longitude: 178.1},
+			rview.NewFileID("/samoa/2.jpg", 2, 0): {Latitude: -13.8, Longitude: -172.1},
+		},
+	)
+	r.Len(fiji.filterByLocation(*pacific).entries, 2)
+	r.Equal(2, s.getMapClusters(fiji, *pacific, 1).Total)
+
+	// Invalid bounding boxes.
+	for _, v := range []string{"1,2,3", "a,b,c,d", "0,10,10,0", "NaN,0,1,1", "-190,0,0,10", "0,-91,10,10"} {
+		_, err := parseBoundingBox(v)
+		r.Error(err, v)
+	}
+	bboxPtr, err := parseBoundingBox("")
+	r.NoError(err)
+	r.Nil(bboxPtr)
+}
+
+func TestServer_convertDuplicates(t *testing.T) {
+	t.Parallel()
+
+	r := require.New(t)
+
+	s := NewServer(rview.Config{ImagePreviewMode: rview.ImagePreviewModeThumbnails}, Services{
+		ThumbnailService:   thumbnails.NewThumbnailService(nil, nil, nil, thumbnails.Options{}),
+		TranscodingService: transcoding.NewNoopService(),
+		MetadataService:    metadata.NewNoopService(),
+	})
+
+	resp := s.convertDuplicates(duplicates.Result{
+		Groups: []duplicates.Group{
+			{
+				Kind: duplicates.GroupKindSimilar,
+				Files: []duplicates.File{
+					{Path: "/photos/a b.jpg", Size: 2048, ModTime: 1},
+					{Path: "/backup/a b.jpg", Size: 1024, ModTime: 2},
+				},
+			},
+		},
+		HashType: "md5",
+	})
+	r.Equal("md5", resp.HashType)
+	r.Len(resp.Groups, 1)
+
+	group := resp.Groups[0]
+	r.Equal("similar", group.Kind)
+	r.Equal(int64(1024), group.WastedSize)
+	r.Equal("1024 B", group.HumanReadableWastedSize)
+	r.Len(group.Files, 2)
+	r.Equal("/backup/a b.jpg", group.Files[1].Path)
+	r.Equal("a b.jpg", group.Files[1].Filename)
+	r.Equal("/ui/backup/?preview=a+b.jpg", group.Files[1].WebURL)
+	r.Equal("/api/thumbnail/backup/a%20b.jpg?mod_time=2&size=1024", group.Files[1].ThumbnailURL)
+}
+
+func TestRenderSnippet(t *testing.T) {
+	r := require.New(t)
+
+	r.Empty(renderSnippet(nil))
+
+	res := renderSnippet(&search.Snippet{
+		Text:    "…<b>Hello</b>, World & hello",
+		Matches: [][2]int{{6, 11}, {25, 30}},
+	})
+	r.Equal("…&lt;b&gt;<mark>Hello</mark>&lt;/b&gt;, World &amp; <mark>hello</mark>", string(res))
+}
+
+func TestServer_handleInvalidate(t *testing.T) {
+	r := require.New(t)
+
+	rcloneServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"dir": "/public/", "breadcrumbs": [{"text": "/"}], "entries": []}`))
+	}))
+	defer rcloneServer.Close()
+
+	dirRoot, err := os.OpenRoot(t.TempDir())
+	r.NoError(err)
+	rcloneInstance, err := rclone.NewRclone(rview.RcloneConfig{
+		URL:     rcloneServer.URL,
+		Targets: rview.RcloneTargets{{Target: "/data"}},
+	}, dirRoot)
+	r.NoError(err)
+
+	s := &Server{
+		cfg:        rview.Config{InvalidateToken: "secret"},
+		rclone:     rcloneInstance,
+		aclService: newTestACLService(t),
+	}
+
+	invalidate := func(path, token string) int {
+		req := httptest.NewRequest("POST", "/api/invalidate", strings.NewReader(url.Values{"path": {path}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.handleInvalidate(w, req)
+		return w.Code
+	}
+
+	r.Equal(http.StatusBadRequest, invalidate("", ""))
+	// Only users with full access can invalidate directories, even the public ones.
+	r.Equal(http.StatusForbidden, invalidate("/public/", ""))
+
+	// Scripts can use the token.
+	r.Equal(http.StatusOK, invalidate("/public/", "secret"))
+	r.Equal(http.StatusUnauthorized, invalidate("/public/", "qwerty"))
+
+	s.cfg.InvalidateToken = ""
+	r.Equal(http.StatusUnauthorized, invalidate("/public/", "secret"))
 }